@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+	"github.com/bunnydevv/reverse-proxy/proxy"
+)
+
+// benchWorker holds what one client of the bench subcommand measured
+type benchWorker struct {
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    int
+}
+
+// runBench implements the bench subcommand: it serves the configuration's
+// routing on a loopback listener, sends it requests from concurrent clients
+// and reports throughput and latency percentiles. With -stub the backends
+// are replaced by an in-process server, so only the proxy is measured.
+func runBench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	requestsPath := flags.String("requests", "", "Path to sample requests to send in turn, as for test (default GET / to localhost)")
+	total := flags.Int("n", 10000, "Number of requests to send")
+	duration := flags.Duration("duration", 0, "Send requests for this long instead of -n")
+	concurrency := flags.Int("c", 50, "Number of concurrent clients")
+	stub := flags.Bool("stub", false, "Replace all backends with an in-process stub server")
+	stubLatency := flags.Duration("stub-latency", 0, "Delay before the stub answers")
+	stubSize := flags.Int("stub-size", 1024, "Size of the stub's response bodies in bytes")
+	cfg, path, ok := commandConfig(flags, args)
+	if !ok {
+		return 1
+	}
+	defer cfg.Close()
+	if *concurrency < 1 || (*total < 1 && *duration <= 0) {
+		fmt.Fprintln(os.Stderr, "-c and -n or -duration must be positive")
+		return 1
+	}
+
+	requests := []testRequest{{}}
+	if *requestsPath != "" {
+		var err error
+		if requests, err = loadTestRequests(*requestsPath); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if len(requests) == 0 {
+			fmt.Fprintf(os.Stderr, "%s: no requests\n", *requestsPath)
+			return 1
+		}
+	}
+
+	if *stub {
+		addr, stop, err := startStubBackend(*stubLatency, *stubSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "stub backend: %v\n", err)
+			return 1
+		}
+		defer stop()
+		stubBackends(cfg, "http://"+addr)
+	}
+
+	rp, err := proxy.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	server := &http.Server{Handler: rp}
+	go server.Serve(ln)
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: *concurrency,
+			DisableCompression:  true,
+		},
+		// Redirects are the proxy's answer, not something to follow
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	var next atomic.Int64
+	var deadline time.Time
+	if *duration > 0 {
+		deadline = time.Now().Add(*duration)
+	}
+	workers := make([]*benchWorker, *concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range workers {
+		w := &benchWorker{statuses: make(map[int]int)}
+		workers[i] = w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n := next.Add(1) - 1
+				if deadline.IsZero() && n >= int64(*total) || !deadline.IsZero() && time.Now().After(deadline) {
+					return
+				}
+				w.send(client, ln.Addr().String(), requests[n%int64(len(requests))])
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	printBenchReport(workers, elapsed)
+	return 0
+}
+
+// send makes one request to the proxy listening on addr and records the
+// outcome
+func (w *benchWorker) send(client *http.Client, addr string, tr testRequest) {
+	r, _ := tr.request()
+	r.URL.Host = addr
+	r.RequestURI = ""
+	r.RemoteAddr = ""
+	r.TLS = nil
+
+	start := time.Now()
+	resp, err := client.Do(r)
+	if err != nil {
+		w.errors++
+		return
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		w.errors++
+		return
+	}
+	w.latencies = append(w.latencies, time.Since(start))
+	w.statuses[resp.StatusCode]++
+}
+
+func printBenchReport(workers []*benchWorker, elapsed time.Duration) {
+	var latencies []time.Duration
+	statuses := make(map[int]int)
+	errors := 0
+	for _, w := range workers {
+		latencies = append(latencies, w.latencies...)
+		for code, n := range w.statuses {
+			statuses[code] += n
+		}
+		errors += w.errors
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	completed := len(latencies)
+	fmt.Printf("Requests:     %d in %s (%.1f req/s)\n", completed+errors, elapsed.Round(time.Millisecond),
+		float64(completed)/elapsed.Seconds())
+	fmt.Printf("Errors:       %d\n", errors)
+
+	codes := make([]int, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	counts := make([]string, 0, len(codes))
+	for _, code := range codes {
+		counts = append(counts, fmt.Sprintf("%d: %d", code, statuses[code]))
+	}
+	fmt.Printf("Status codes: %s\n", strings.Join(counts, ", "))
+
+	if completed == 0 {
+		return
+	}
+	fmt.Printf("Latency:      p50 %s  p90 %s  p99 %s  max %s\n",
+		percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99),
+		latencies[completed-1].Round(time.Microsecond))
+}
+
+// percentile returns the p-th percentile of sorted, which must not be empty
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	return sorted[max(i, 0)].Round(time.Microsecond)
+}
+
+// startStubBackend serves a fixed response of size bytes after latency on a
+// loopback port and returns its address
+func startStubBackend(latency time.Duration, size int) (string, func(), error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	body := []byte(strings.Repeat("x", size))
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if latency > 0 {
+			time.Sleep(latency)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(body)
+	})}
+	go server.Serve(ln)
+	return ln.Addr().String(), func() { server.Close() }, nil
+}
+
+// stubBackends points every HTTP backend of cfg at url, dropping what only
+// applies to the real servers
+func stubBackends(cfg *config.Config, url string) {
+	stubBackendList(cfg.Backends, url)
+	for name, pool := range cfg.Pools {
+		stubBackendList(pool.Backends, url)
+		cfg.Pools[name] = pool
+	}
+	for i := range cfg.VHosts {
+		stubBackendList(cfg.VHosts[i].Backends, url)
+	}
+}
+
+func stubBackendList(backends []config.Backend, url string) {
+	for i := range backends {
+		b := &backends[i]
+		b.URL = url
+		b.Resolve, b.SRV, b.Discovery = false, "", ""
+		b.TLS, b.EgressProxy, b.Dial, b.HealthCheck = nil, nil, nil, nil
+		b.Protocol = ""
+		b.Maintenance = nil
+	}
+}
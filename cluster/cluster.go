@@ -0,0 +1,555 @@
+// Package cluster replicates small pieces of runtime state between proxy
+// instances so a horizontally scaled fleet behaves like one logical proxy.
+//
+// State is held in a last-writer-wins map that every node pushes to its
+// peers over HTTP. Only entries changed since the last successful push to
+// a peer are sent, and accepted remote changes are relayed onwards, so
+// partial peer lists still converge.
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+const (
+	signatureHeader = "X-Cluster-Signature"
+	timestampHeader = "X-Cluster-Timestamp"
+	nonceHeader     = "X-Cluster-Nonce"
+)
+
+// maxClockSkew is how far a request's timestamp may be from the receiver's
+// clock. Nonces are remembered for twice as long, so a captured request
+// can't be replayed while its timestamp is still accepted.
+const maxClockSkew = time.Minute
+
+// maxMessageSize bounds the size of a single sync payload
+const maxMessageSize = 32 << 20
+
+// Entry is a single replicated value
+type Entry struct {
+	Key     string `json:"k"`
+	Value   string `json:"v,omitempty"`
+	Version int64  `json:"ver"` // wall clock of the write in nanoseconds
+	Node    string `json:"n"`   // node that performed the write, breaks ties
+	Expires int64  `json:"exp,omitempty"`
+	Deleted bool   `json:"del,omitempty"`
+
+	seq uint64
+}
+
+// newer reports whether e supersedes other
+func (e *Entry) newer(other *Entry) bool {
+	if e.Version != other.Version {
+		return e.Version > other.Version
+	}
+	return e.Node > other.Node
+}
+
+func (e *Entry) expired(now int64) bool {
+	return e.Expires != 0 && now >= e.Expires
+}
+
+type message struct {
+	Node    string   `json:"node"`
+	Entries []*Entry `json:"entries"`
+}
+
+type subscription struct {
+	prefix string
+	fn     func(key, value string, deleted bool)
+}
+
+// Node is a member of the cluster
+type Node struct {
+	config  config.ClusterConfig
+	logger  *slog.Logger
+	server  *http.Server
+	client  *http.Client
+	stop    chan struct{}
+	stopped sync.WaitGroup
+
+	mu       sync.RWMutex
+	entries  map[string]*Entry
+	seq      uint64
+	sentSeq  map[string]uint64
+	subs     []subscription
+	counters map[string]map[string]int64 // counter key -> node -> count
+
+	nonceMu sync.Mutex
+	nonces  map[string]int64 // nonces of accepted requests -> when they can be forgotten
+}
+
+// New creates a cluster node logging to logger; it does not serve peers
+// until Start
+func New(cfg config.ClusterConfig, logger *slog.Logger) *Node {
+	n := &Node{
+		config:   cfg,
+		logger:   logger,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		stop:     make(chan struct{}),
+		entries:  make(map[string]*Entry),
+		sentSeq:  make(map[string]uint64),
+		counters: make(map[string]map[string]int64),
+		nonces:   make(map[string]int64),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cluster/sync", n.handleSync)
+	mux.HandleFunc("/cluster/state", n.handleState)
+	n.server = &http.Server{
+		Addr:              cfg.BindAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	return n
+}
+
+// Name returns the node's name
+func (n *Node) Name() string {
+	return n.config.NodeName
+}
+
+// Start begins serving peer traffic on ln, which should be bound to the
+// configured bind address, and gossiping local changes
+func (n *Node) Start(ln net.Listener) {
+	n.stopped.Add(2)
+	go func() {
+		defer n.stopped.Done()
+		if err := n.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			n.logger.Error("Cluster listener failed", "error", err)
+		}
+	}()
+
+	go func() {
+		defer n.stopped.Done()
+		n.bootstrap()
+
+		ticker := time.NewTicker(n.config.GossipInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n.gossip()
+				n.sweep()
+			case <-n.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop shuts down the peer listener and gossip loop
+func (n *Node) Stop() {
+	close(n.stop)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = n.server.Shutdown(ctx)
+	n.stopped.Wait()
+}
+
+// Get returns the current value of key
+func (n *Node) Get(key string) (string, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	e, ok := n.entries[key]
+	if !ok || e.Deleted || e.expired(time.Now().UnixNano()) {
+		return "", false
+	}
+	return e.Value, true
+}
+
+// Set stores value under key for the whole cluster. A zero ttl never expires.
+func (n *Node) Set(key, value string, ttl time.Duration) {
+	n.write(key, value, ttl, false)
+}
+
+// Delete removes key for the whole cluster
+func (n *Node) Delete(key string) {
+	n.write(key, "", time.Minute, true)
+}
+
+// Subscribe registers fn to be called when a peer changes a key with the given prefix
+func (n *Node) Subscribe(prefix string, fn func(key, value string, deleted bool)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.subs = append(n.subs, subscription{prefix: prefix, fn: fn})
+}
+
+// AddCounter adds delta to this node's share of a windowed counter and
+// returns the cluster-wide total for the current window
+func (n *Node) AddCounter(name string, delta int64, window time.Duration) int64 {
+	now := time.Now()
+	ckey := counterKey(name, now, window)
+
+	n.mu.Lock()
+	perNode := n.counters[ckey]
+	if perNode == nil {
+		perNode = make(map[string]int64)
+		n.counters[ckey] = perNode
+	}
+	perNode[n.config.NodeName] += delta
+	local := perNode[n.config.NodeName]
+	var total int64
+	for _, v := range perNode {
+		total += v
+	}
+	n.mu.Unlock()
+
+	// Keep the counter around for two windows so late peers still count it
+	n.write(ckey+"/"+n.config.NodeName, fmt.Sprint(local), 2*window, false)
+	return total
+}
+
+// Counter returns the cluster-wide total of a windowed counter
+func (n *Node) Counter(name string, window time.Duration) int64 {
+	ckey := counterKey(name, time.Now(), window)
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	var total int64
+	for _, v := range n.counters[ckey] {
+		total += v
+	}
+	return total
+}
+
+func counterKey(name string, now time.Time, window time.Duration) string {
+	epoch := int64(0)
+	if window > 0 {
+		epoch = now.UnixNano() / int64(window)
+	}
+	return fmt.Sprintf("ctr/%s/%d", name, epoch)
+}
+
+func (n *Node) write(key, value string, ttl time.Duration, deleted bool) {
+	now := time.Now().UnixNano()
+	e := &Entry{
+		Key:     key,
+		Value:   value,
+		Version: now,
+		Node:    n.config.NodeName,
+		Deleted: deleted,
+	}
+	if ttl > 0 {
+		e.Expires = now + int64(ttl)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// Never go backwards if a peer's clock is ahead of ours
+	if prev, ok := n.entries[key]; ok && prev.Version >= e.Version {
+		e.Version = prev.Version + 1
+	}
+	n.seq++
+	e.seq = n.seq
+	n.entries[key] = e
+}
+
+// merge applies remote entries and returns the ones that were accepted
+func (n *Node) merge(entries []*Entry) []*Entry {
+	now := time.Now().UnixNano()
+
+	n.mu.Lock()
+	var accepted []*Entry
+	for _, e := range entries {
+		if e == nil || e.Key == "" || e.expired(now) {
+			continue
+		}
+		if prev, ok := n.entries[e.Key]; ok && !e.newer(prev) {
+			continue
+		}
+		n.seq++
+		e.seq = n.seq
+		n.entries[e.Key] = e
+		n.applyCounter(e)
+		accepted = append(accepted, e)
+	}
+	subs := n.subs
+	n.mu.Unlock()
+
+	for _, e := range accepted {
+		for _, sub := range subs {
+			if strings.HasPrefix(e.Key, sub.prefix) {
+				sub.fn(e.Key, e.Value, e.Deleted)
+			}
+		}
+	}
+	return accepted
+}
+
+// applyCounter folds a peer's counter entry into the totals; callers hold mu
+func (n *Node) applyCounter(e *Entry) {
+	if !strings.HasPrefix(e.Key, "ctr/") {
+		return
+	}
+	i := strings.LastIndex(e.Key, "/")
+	ckey, node := e.Key[:i], e.Key[i+1:]
+	if node == n.config.NodeName {
+		return
+	}
+
+	var v int64
+	if _, err := fmt.Sscan(e.Value, &v); err != nil && !e.Deleted {
+		return
+	}
+	perNode := n.counters[ckey]
+	if perNode == nil {
+		perNode = make(map[string]int64)
+		n.counters[ckey] = perNode
+	}
+	perNode[node] = v
+}
+
+// sweep drops expired entries and counters
+func (n *Node) sweep() {
+	now := time.Now().UnixNano()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for key, e := range n.entries {
+		if e.expired(now) {
+			delete(n.entries, key)
+		}
+	}
+	n.nonceMu.Lock()
+	for nonce, forget := range n.nonces {
+		if now >= forget {
+			delete(n.nonces, nonce)
+		}
+	}
+	n.nonceMu.Unlock()
+
+	for ckey := range n.counters {
+		if _, ok := n.entries[ckey+"/"+n.config.NodeName]; ok {
+			continue
+		}
+		live := false
+		for node := range n.counters[ckey] {
+			if _, ok := n.entries[ckey+"/"+node]; ok {
+				live = true
+				break
+			}
+		}
+		if !live {
+			delete(n.counters, ckey)
+		}
+	}
+}
+
+// gossip pushes entries changed since the last successful push to each peer
+func (n *Node) gossip() {
+	for _, peer := range n.config.Peers {
+		n.mu.RLock()
+		since := n.sentSeq[peer]
+		upTo := n.seq
+		var changed []*Entry
+		for _, e := range n.entries {
+			if e.seq > since {
+				changed = append(changed, e)
+			}
+		}
+		n.mu.RUnlock()
+
+		if len(changed) == 0 {
+			continue
+		}
+		if err := n.push(peer, changed); err != nil {
+			n.logger.Warn("Cluster sync failed", "peer", peer, "error", err)
+			continue
+		}
+
+		n.mu.Lock()
+		if n.sentSeq[peer] < upTo {
+			n.sentSeq[peer] = upTo
+		}
+		n.mu.Unlock()
+	}
+}
+
+func (n *Node) push(peer string, entries []*Entry) error {
+	body, err := json.Marshal(message{Node: n.config.NodeName, Entries: entries})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+peer+"/cluster/sync", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	n.signRequest(req, body)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// bootstrap pulls the full state from the first reachable peer
+func (n *Node) bootstrap() {
+	for _, peer := range n.config.Peers {
+		req, err := http.NewRequest(http.MethodGet, "http://"+peer+"/cluster/state", nil)
+		if err != nil {
+			continue
+		}
+		nonce := n.signRequest(req, nil)
+
+		resp, err := n.client.Do(req)
+		if err != nil {
+			continue
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxMessageSize))
+		resp.Body.Close()
+		// The response is signed with our nonce, so an old one can't be
+		// replayed to us
+		if err != nil || resp.StatusCode != http.StatusOK || !n.verify(resp.Header.Get(signatureHeader), "response", nonce, string(body)) {
+			continue
+		}
+
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			continue
+		}
+		accepted := n.merge(msg.Entries)
+		n.logger.Info("Cluster state bootstrapped", "peer", peer, "entries", len(accepted))
+		return
+	}
+}
+
+func (n *Node) handleSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if !n.verifyRequest(r, body) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	n.merge(msg.Entries)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (n *Node) handleState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !n.verifyRequest(r, nil) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now().UnixNano()
+	n.mu.RLock()
+	entries := make([]*Entry, 0, len(n.entries))
+	for _, e := range n.entries {
+		if !e.expired(now) {
+			entries = append(entries, e)
+		}
+	}
+	body, err := json.Marshal(message{Node: n.config.NodeName, Entries: entries})
+	n.mu.RUnlock()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(signatureHeader, n.sign("response", r.Header.Get(nonceHeader), string(body)))
+	_, _ = w.Write(body)
+}
+
+// signRequest signs the method, path, body and a fresh timestamp and nonce
+// of req, and returns the nonce
+func (n *Node) signRequest(req *http.Request, body []byte) string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	nonce := hex.EncodeToString(b[:])
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(nonceHeader, nonce)
+	req.Header.Set(signatureHeader, n.sign(req.Method, req.URL.Path, timestamp, nonce, string(body)))
+	return nonce
+}
+
+// verifyRequest checks the signature of a peer's request and that it is
+// recent and hasn't been seen before
+func (n *Node) verifyRequest(r *http.Request, body []byte) bool {
+	timestamp, nonce := r.Header.Get(timestampHeader), r.Header.Get(nonceHeader)
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || nonce == "" {
+		return false
+	}
+	now := time.Now()
+	if skew := now.Sub(time.Unix(sec, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return false
+	}
+	if !n.verify(r.Header.Get(signatureHeader), r.Method, r.URL.Path, timestamp, nonce, string(body)) {
+		return false
+	}
+
+	n.nonceMu.Lock()
+	defer n.nonceMu.Unlock()
+	if _, seen := n.nonces[nonce]; seen {
+		return false
+	}
+	n.nonces[nonce] = now.Add(2 * maxClockSkew).UnixNano()
+	return true
+}
+
+// sign returns the MAC of parts, each ended by a newline
+func (n *Node) sign(parts ...string) string {
+	mac := hmac.New(sha256.New, []byte(n.config.SecretKey))
+	for _, p := range parts {
+		mac.Write([]byte(p))
+		mac.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *Node) verify(signature string, parts ...string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	actual, _ := hex.DecodeString(n.sign(parts...))
+	return hmac.Equal(actual, expected)
+}
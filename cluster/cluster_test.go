@@ -0,0 +1,109 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+func newTestNode(name, key string) *Node {
+	return New(config.ClusterConfig{Enabled: true, NodeName: name, SecretKey: key, GossipInterval: time.Second}, slog.Default())
+}
+
+func syncRequest(t *testing.T, from *Node, entries ...*Entry) *http.Request {
+	body, err := json.Marshal(message{Node: from.Name(), Entries: entries})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/cluster/sync", bytes.NewReader(body))
+	from.signRequest(req, body)
+	return req
+}
+
+func TestSyncRejectsReplaysAndForgeries(t *testing.T) {
+	a, b := newTestNode("a", "key"), newTestNode("b", "key")
+	entry := &Entry{Key: "k", Value: "v", Version: time.Now().UnixNano(), Node: "a"}
+
+	req := syncRequest(t, a, entry)
+	captured := req.Header.Clone()
+	rec := httptest.NewRecorder()
+	b.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("signed sync: status %d", rec.Code)
+	}
+	if v, _ := b.Get("k"); v != "v" {
+		t.Fatalf("Get = %q after sync", v)
+	}
+
+	body, _ := json.Marshal(message{Node: "a", Entries: []*Entry{entry}})
+	tests := []struct {
+		name   string
+		header func(h http.Header)
+	}{
+		{"replayed", func(h http.Header) {}},
+		{"stale timestamp", func(h http.Header) {
+			h.Set(timestampHeader, strconv.FormatInt(time.Now().Add(-2*maxClockSkew).Unix(), 10))
+			h.Set(nonceHeader, "fresh")
+			h.Set(signatureHeader, a.sign(http.MethodPost, "/cluster/sync", h.Get(timestampHeader), "fresh", string(body)))
+		}},
+		{"future timestamp", func(h http.Header) {
+			h.Set(timestampHeader, strconv.FormatInt(time.Now().Add(2*maxClockSkew).Unix(), 10))
+			h.Set(nonceHeader, "fresh")
+			h.Set(signatureHeader, a.sign(http.MethodPost, "/cluster/sync", h.Get(timestampHeader), "fresh", string(body)))
+		}},
+		{"new nonce under the old signature", func(h http.Header) { h.Set(nonceHeader, "fresh") }},
+		{"wrong key", func(h http.Header) {
+			h.Set(nonceHeader, "fresh")
+			h.Set(signatureHeader, newTestNode("x", "other").sign(http.MethodPost, "/cluster/sync", h.Get(timestampHeader), "fresh", string(body)))
+		}},
+		{"unsigned", func(h http.Header) { h.Del(signatureHeader) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/cluster/sync", bytes.NewReader(body))
+			req.Header = captured.Clone()
+			tt.header(req.Header)
+			rec := httptest.NewRecorder()
+			b.server.Handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("status %d, want 403", rec.Code)
+			}
+		})
+	}
+}
+
+func TestStateRequiresASignedRequest(t *testing.T) {
+	a, b := newTestNode("a", "key"), newTestNode("b", "key")
+	b.Set("k", "v", 0)
+
+	// A signed sync can't be replayed as a state request
+	sync := syncRequest(t, a)
+	req := httptest.NewRequest(http.MethodGet, "/cluster/state", nil)
+	req.Header = sync.Header.Clone()
+	rec := httptest.NewRecorder()
+	b.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("state request signed as a sync: status %d, want 403", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/cluster/state", nil)
+	nonce := a.signRequest(req, nil)
+	rec = httptest.NewRecorder()
+	b.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signed state request: status %d", rec.Code)
+	}
+	if !a.verify(rec.Header().Get(signatureHeader), "response", nonce, rec.Body.String()) {
+		t.Error("state response isn't signed for the request's nonce")
+	}
+	if a.verify(rec.Header().Get(signatureHeader), "response", "other", rec.Body.String()) {
+		t.Error("state response verifies for another request")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// loadConfig loads the configuration in the given format, or in the format
+// of its file extension when format is empty
+func loadConfig(path, format string) (*config.Config, error) {
+	if format == "" {
+		format = config.DetectFormat(path)
+	}
+	return config.LoadFormat(path, format)
+}
+
+// commandConfig parses the flags of a subcommand, adding the config flags
+// to its own, and loads the configuration they name, reporting failures on
+// stderr
+func commandConfig(flags *flag.FlagSet, args []string) (*config.Config, string, bool) {
+	configPath := flags.String("config", "config.yaml", "Path to configuration file")
+	configFormat := flags.String("config-format", "", "Configuration file format: yaml, json or toml (default by file extension)")
+	flags.Parse(args)
+
+	cfg, err := loadConfig(*configPath, *configFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *configPath, err)
+		return nil, *configPath, false
+	}
+	return cfg, *configPath, true
+}
+
+// runValidate implements the validate subcommand: it loads and checks the
+// configuration without serving and returns the exit status
+func runValidate(args []string) int {
+	cfg, path, ok := commandConfig(flag.NewFlagSet("validate", flag.ExitOnError), args)
+	if !ok {
+		return 1
+	}
+	defer cfg.Close()
+	if err := cfg.Check(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: invalid configuration:\n%v\n", path, err)
+		return 1
+	}
+	fmt.Printf("%s: configuration is valid\n", path)
+	return 0
+}
+
+// runPrintConfig implements the print-config subcommand: it writes the
+// effective configuration, with defaults applied and secrets redacted
+func runPrintConfig(args []string) int {
+	cfg, path, ok := commandConfig(flag.NewFlagSet("print-config", flag.ExitOnError), args)
+	if !ok {
+		return 1
+	}
+	defer cfg.Close()
+	out, err := cfg.Dump()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	os.Stdout.Write(out)
+	return 0
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// AccessConfig restricts which client addresses may use the proxy or a
+// route. Denied addresses are always refused; when Allow is non-empty only
+// the addresses it covers are admitted.
+type AccessConfig struct {
+	Allow []string `yaml:"allow"` // addresses or CIDRs
+	Deny  []string `yaml:"deny"`  // addresses or CIDRs
+}
+
+func (a *AccessConfig) validate() error {
+	for _, entry := range append(append([]string{}, a.Allow...), a.Deny...) {
+		if !validAddrOrPrefix(entry) {
+			return fmt.Errorf("access: invalid address or CIDR %q", entry)
+		}
+	}
+	return nil
+}
+
+// validAddrOrPrefix reports whether entry is an IP address or CIDR prefix
+func validAddrOrPrefix(entry string) bool {
+	var err error
+	if strings.Contains(entry, "/") {
+		_, err = netip.ParsePrefix(entry)
+	} else {
+		_, err = netip.ParseAddr(entry)
+	}
+	return err == nil
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// ACMEConfig obtains and renews certificates for Domains automatically from
+// an ACME certificate authority such as Let's Encrypt
+type ACMEConfig struct {
+	Domains      []string       `yaml:"domains"`
+	Email        string         `yaml:"email"`         // contact for expiry and account notices
+	CacheDir     string         `yaml:"cache_dir"`     // where account keys and certificates are kept
+	DirectoryURL string         `yaml:"directory_url"` // defaults to Let's Encrypt production
+	HTTPAddress  string         `yaml:"http_address"`  // listener for HTTP-01 challenges; empty leaves only TLS-ALPN-01
+	DNS          *ACMEDNSConfig `yaml:"dns,omitempty"` // answers DNS-01 challenges instead, which allows wildcards
+}
+
+// ACMEDNSConfig proves control of the ACME domains with DNS-01 challenges,
+// publishing their TXT records through a DNS provider
+type ACMEDNSConfig struct {
+	Provider         string        `yaml:"provider"`          // cloudflare, route53, rfc2136 or exec
+	PropagationDelay time.Duration `yaml:"propagation_delay"` // wait for new records to reach every name server
+
+	// cloudflare
+	APIToken string `yaml:"api_token"` // token with Zone.DNS edit permission
+
+	// route53; the keys default to AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+	// and AWS_SESSION_TOKEN
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"`
+	HostedZoneID    string `yaml:"hosted_zone_id"` // found by the record's name when empty
+
+	// rfc2136
+	Nameserver    string `yaml:"nameserver"`     // host:port of the primary name server
+	Zone          string `yaml:"zone"`           // found with an SOA query when empty
+	TSIGKey       string `yaml:"tsig_key"`       // name of the TSIG key
+	TSIGSecret    string `yaml:"tsig_secret"`    // base64 TSIG secret
+	TSIGAlgorithm string `yaml:"tsig_algorithm"` // hmac-sha256 (default) or hmac-sha512
+
+	// exec
+	Command []string `yaml:"command"` // run with present|cleanup, the record name and value
+}
+
+func (a *ACMEConfig) setDefaults() {
+	if a.CacheDir == "" {
+		a.CacheDir = "acme-cache"
+	}
+	if a.DNS != nil {
+		if a.DNS.PropagationDelay == 0 {
+			a.DNS.PropagationDelay = 30 * time.Second
+		}
+		if a.DNS.Provider == "rfc2136" && a.DNS.TSIGAlgorithm == "" {
+			a.DNS.TSIGAlgorithm = "hmac-sha256"
+		}
+		// DNS-01 needs no challenge listener
+		return
+	}
+	if a.HTTPAddress == "" {
+		a.HTTPAddress = ":80"
+	}
+}
+
+func (a *ACMEConfig) validate() error {
+	if len(a.Domains) == 0 {
+		return fmt.Errorf("acme requires at least one domain")
+	}
+	for _, d := range a.Domains {
+		if d == "" || strings.Contains(strings.TrimPrefix(d, "*."), "*") {
+			return fmt.Errorf("invalid acme domain %q", d)
+		}
+		if strings.HasPrefix(d, "*.") && a.DNS == nil {
+			return fmt.Errorf("invalid acme domain %q: wildcards need dns challenges", d)
+		}
+	}
+	if a.DNS == nil {
+		return nil
+	}
+	switch a.DNS.Provider {
+	case "cloudflare":
+		if a.DNS.APIToken == "" {
+			return fmt.Errorf("acme dns provider cloudflare requires api_token")
+		}
+	case "route53":
+		if (a.DNS.AccessKeyID == "") != (a.DNS.SecretAccessKey == "") {
+			return fmt.Errorf("acme dns provider route53 requires both access_key_id and secret_access_key, or neither")
+		}
+	case "rfc2136":
+		if _, _, err := net.SplitHostPort(a.DNS.Nameserver); err != nil {
+			return fmt.Errorf("acme dns provider rfc2136 requires nameserver as host:port")
+		}
+		if (a.DNS.TSIGKey == "") != (a.DNS.TSIGSecret == "") {
+			return fmt.Errorf("acme dns provider rfc2136 requires both tsig_key and tsig_secret, or neither")
+		}
+		if _, err := base64.StdEncoding.DecodeString(a.DNS.TSIGSecret); err != nil {
+			return fmt.Errorf("acme dns tsig_secret must be base64")
+		}
+		if a.DNS.TSIGAlgorithm != "hmac-sha256" && a.DNS.TSIGAlgorithm != "hmac-sha512" {
+			return fmt.Errorf("invalid acme dns tsig_algorithm %q (must be hmac-sha256 or hmac-sha512)", a.DNS.TSIGAlgorithm)
+		}
+	case "exec":
+		if len(a.DNS.Command) == 0 {
+			return fmt.Errorf("acme dns provider exec requires command")
+		}
+	default:
+		return fmt.Errorf("invalid acme dns provider %q (must be cloudflare, route53, rfc2136 or exec)", a.DNS.Provider)
+	}
+	if a.DNS.PropagationDelay < 0 {
+		return fmt.Errorf("acme dns propagation_delay must be non-negative")
+	}
+	return nil
+}
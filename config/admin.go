@@ -0,0 +1,69 @@
+package config
+
+import "fmt"
+
+// AdminConfig configures the administrative API listener. It should only be
+// bound to a loopback or otherwise trusted address unless it requires a
+// token or client certificates.
+type AdminConfig struct {
+	Enabled    bool            `yaml:"enabled"`
+	Address    string          `yaml:"address"`
+	Debug      bool            `yaml:"debug"`       // serves pprof profiles and runtime statistics under /debug/
+	Dashboard  bool            `yaml:"dashboard"`   // serves a live status page at /dashboard
+	Token      string          `yaml:"token"`       // required as "Authorization: Bearer <token>"
+	AllowedIPs []string        `yaml:"allowed_ips"` // addresses or CIDRs; empty admits all
+	TLS        *AdminTLSConfig `yaml:"tls,omitempty"`
+
+	// AuditLog records every request that changes the proxy's state
+	AuditLog *AuditLogConfig `yaml:"audit_log,omitempty"`
+}
+
+// AdminTLSConfig serves the admin API over HTTPS. With ClientCAFile set,
+// clients must present a certificate signed by one of its CAs.
+type AdminTLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+func (a *AdminConfig) setDefaults() {
+	if a.Address == "" {
+		a.Address = "127.0.0.1:9901"
+	}
+	if a.AuditLog != nil {
+		a.AuditLog.setDefaults()
+	}
+}
+
+func (a *AdminConfig) validate(c *Config) error {
+	if !a.Enabled {
+		return nil
+	}
+	if a.Address == c.Server.Address {
+		return fmt.Errorf("admin address must differ from the server address")
+	}
+	for _, l := range c.Server.Listeners {
+		if a.Address == l.Address {
+			return fmt.Errorf("admin address must differ from the listener addresses")
+		}
+	}
+	for _, s := range c.Streams {
+		if a.Address == s.Address {
+			return fmt.Errorf("admin address must differ from the stream addresses")
+		}
+	}
+	for _, entry := range a.AllowedIPs {
+		if !validAddrOrPrefix(entry) {
+			return fmt.Errorf("admin allowed_ips: invalid address or CIDR %q", entry)
+		}
+	}
+	if a.TLS != nil && (a.TLS.CertFile == "" || a.TLS.KeyFile == "") {
+		return fmt.Errorf("admin tls requires cert_file and key_file")
+	}
+	if a.AuditLog != nil {
+		if err := a.AuditLog.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// APIKeyConfig requires requests to carry an API key known to a key store:
+// a file of hashed keys or an HTTP lookup service
+type APIKeyConfig struct {
+	Enabled        bool               `yaml:"enabled"`
+	Header         string             `yaml:"header"`          // request header carrying the key
+	QueryParam     string             `yaml:"query_param"`     // query parameter also accepted, if set
+	KeysFile       string             `yaml:"keys_file"`       // lines of "<id> <sha256 hex of the key>"
+	Lookup         APIKeyLookupConfig `yaml:"lookup"`          // used instead of keys_file
+	IdentityHeader string             `yaml:"identity_header"` // request header sent to backends with the key's ID
+	ExcludePaths   []string           `yaml:"exclude_paths"`   // path prefixes served without a key
+}
+
+// APIKeyLookupConfig asks an HTTP service about keys. It receives a GET with
+// the key in the configured header and accepts it with a 2xx answer whose
+// JSON body may name the key's {"id"}; 401, 403 and 404 reject it.
+type APIKeyLookupConfig struct {
+	URL      string        `yaml:"url"`
+	Timeout  time.Duration `yaml:"timeout"`
+	CacheTTL time.Duration `yaml:"cache_ttl"` // how long answers are remembered
+}
+
+func (a *APIKeyConfig) setDefaults() {
+	if a.Header == "" {
+		a.Header = "X-API-Key"
+	}
+	if a.Lookup.Timeout == 0 {
+		a.Lookup.Timeout = 5 * time.Second
+	}
+	if a.Lookup.CacheTTL == 0 {
+		a.Lookup.CacheTTL = time.Minute
+	}
+}
+
+func (a *APIKeyConfig) validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	if (a.KeysFile == "") == (a.Lookup.URL == "") {
+		return fmt.Errorf("api_keys requires exactly one of keys_file and lookup url")
+	}
+	if a.Lookup.URL != "" {
+		u, err := url.Parse(a.Lookup.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("api_keys lookup url must be an http or https URL")
+		}
+	}
+	if a.Lookup.Timeout < 0 || a.Lookup.CacheTTL < 0 {
+		return fmt.Errorf("api_keys lookup timeout and cache_ttl must be non-negative")
+	}
+	if !validHeaderName(a.Header) {
+		return fmt.Errorf("api_keys: invalid header name %q", a.Header)
+	}
+	if a.IdentityHeader != "" {
+		if !validHeaderName(a.IdentityHeader) {
+			return fmt.Errorf("api_keys: invalid identity_header %q", a.IdentityHeader)
+		}
+		if http.CanonicalHeaderKey(a.IdentityHeader) == http.CanonicalHeaderKey(a.Header) {
+			return fmt.Errorf("api_keys: identity_header cannot be the key header")
+		}
+	}
+	for _, p := range a.ExcludePaths {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("api_keys exclude_paths: %q must start with /", p)
+		}
+	}
+	return nil
+}
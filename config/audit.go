@@ -0,0 +1,41 @@
+package config
+
+import "fmt"
+
+// AuditLogConfig records the admin API requests that change the proxy's
+// state, as JSON lines in a destination of their own
+type AuditLogConfig struct {
+	Output   string            `yaml:"output"` // stdout, stderr, syslog or a file path
+	Rotation LogRotationConfig `yaml:"rotation"`
+	Syslog   SyslogConfig      `yaml:"syslog"`
+}
+
+func (a *AuditLogConfig) setDefaults() {
+	a.Syslog.setDefaults()
+}
+
+func (a *AuditLogConfig) validate() error {
+	if a.Output == "" {
+		return fmt.Errorf("admin audit_log output is required")
+	}
+	if err := a.Rotation.validate(); err != nil {
+		return fmt.Errorf("admin audit_log: %w", err)
+	}
+	if a.Output == LogOutputSyslog {
+		if err := a.Syslog.validate(); err != nil {
+			return fmt.Errorf("admin audit_log: %w", err)
+		}
+	}
+	return nil
+}
+
+// Logging returns the audit log's destination as a JSON logging config
+func (a *AuditLogConfig) Logging() LoggingConfig {
+	return LoggingConfig{
+		Level:    "info",
+		Format:   "json",
+		Output:   a.Output,
+		Rotation: a.Rotation,
+		Syslog:   a.Syslog,
+	}
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BasicAuthConfig protects a route with HTTP basic authentication. Users
+// come from an htpasswd file, inline bcrypt hashes, or both.
+type BasicAuthConfig struct {
+	Realm        string            `yaml:"realm"`
+	HtpasswdFile string            `yaml:"htpasswd_file"` // bcrypt entries only, as written by htpasswd -B
+	Users        map[string]string `yaml:"users"`         // user -> bcrypt hash
+}
+
+func (b *BasicAuthConfig) setDefaults() {
+	if b.Realm == "" {
+		b.Realm = "Restricted"
+	}
+}
+
+func (b *BasicAuthConfig) validate() error {
+	if b.HtpasswdFile == "" && len(b.Users) == 0 {
+		return fmt.Errorf("basic_auth requires htpasswd_file or users")
+	}
+	if strings.Contains(b.Realm, `"`) {
+		return fmt.Errorf("basic_auth realm must not contain quotes")
+	}
+	for user, hash := range b.Users {
+		if user == "" || strings.Contains(user, ":") {
+			return fmt.Errorf("basic_auth: invalid user name %q", user)
+		}
+		if !strings.HasPrefix(hash, "$2") {
+			return fmt.Errorf("basic_auth: password for %q must be a bcrypt hash", user)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// BlocklistFeed is a remote list of CIDRs or addresses, one per line, whose
+// clients are denied access. Feeds are refreshed periodically.
+type BlocklistFeed struct {
+	URL             string        `yaml:"url"`
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	Timeout         time.Duration `yaml:"timeout"`
+}
+
+func (f *BlocklistFeed) setDefaults() {
+	if f.RefreshInterval == 0 {
+		f.RefreshInterval = time.Hour
+	}
+	if f.Timeout == 0 {
+		f.Timeout = 30 * time.Second
+	}
+}
+
+func (f *BlocklistFeed) validate() error {
+	u, err := url.Parse(f.URL)
+	if err != nil {
+		return fmt.Errorf("blocklist: invalid url %s: %w", f.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("blocklist: url %s must use http or https", f.URL)
+	}
+	if f.RefreshInterval < 0 {
+		return fmt.Errorf("blocklist %s: refresh_interval must be non-negative", f.URL)
+	}
+	if f.Timeout < 0 {
+		return fmt.Errorf("blocklist %s: timeout must be non-negative", f.URL)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BodyCaptureConfig logs the headers and bodies of requests and responses
+// matching its rules, to troubleshoot production issues without packet
+// captures. It can also be switched on and its rules replaced at runtime
+// through the admin API.
+type BodyCaptureConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Rules         []CaptureRule `yaml:"rules"`
+	MaxBodySize   int           `yaml:"max_body_size"`  // bytes of each body logged; the rest is counted
+	RedactHeaders []string      `yaml:"redact_headers"` // header values logged as REDACTED
+}
+
+// CaptureRule selects the requests whose exchanges are captured. Every
+// condition that is set must hold.
+type CaptureRule struct {
+	Name       string            `yaml:"name"`
+	Route      string            `yaml:"route"` // a route's path_prefix or path_regex
+	PathPrefix string            `yaml:"path_prefix"`
+	Methods    []string          `yaml:"methods"`
+	Match      *RouteMatchConfig `yaml:"match,omitempty"` // headers and cookies, as for routes
+}
+
+// SetDefaults fills in unset fields
+func (b *BodyCaptureConfig) SetDefaults() {
+	if b.MaxBodySize == 0 {
+		b.MaxBodySize = 4096
+	}
+	if b.RedactHeaders == nil {
+		b.RedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-API-Key"}
+	}
+}
+
+// Validate checks that the configuration and its rules are well formed
+func (b *BodyCaptureConfig) Validate() error {
+	if b.MaxBodySize < 0 {
+		return fmt.Errorf("body_capture max_body_size must be non-negative")
+	}
+	for _, name := range b.RedactHeaders {
+		if !validHeaderName(name) {
+			return fmt.Errorf("body_capture: invalid redact header name %q", name)
+		}
+	}
+	for i, r := range b.Rules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("body_capture rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (r *CaptureRule) validate() error {
+	if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+		return fmt.Errorf("path_prefix must start with /")
+	}
+	for _, m := range r.Methods {
+		if !validHeaderName(m) {
+			return fmt.Errorf("invalid method %q", m)
+		}
+	}
+	if r.Match != nil {
+		if err := r.Match.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CacheConfig enables the in-memory response cache
+type CacheConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	MaxEntries    int           `yaml:"max_entries"`
+	MaxSize       int64         `yaml:"max_size"`        // total bytes of cached bodies
+	MaxObjectSize int64         `yaml:"max_object_size"` // larger responses are not stored
+	DefaultTTL    time.Duration `yaml:"default_ttl"`     // for cacheable responses without Cache-Control or Expires; 0 leaves them uncached
+	TagHeader     string        `yaml:"tag_header"`      // response header listing space-separated surrogate keys
+
+	// Coalesce makes concurrent misses for the same response wait for the
+	// first one's fetch instead of each reaching a backend, for at most
+	// CoalesceTimeout
+	Coalesce        bool          `yaml:"coalesce"`
+	CoalesceTimeout time.Duration `yaml:"coalesce_timeout"`
+}
+
+func (c *CacheConfig) setDefaults() {
+	if c.TagHeader == "" {
+		c.TagHeader = "Surrogate-Key"
+	}
+	if c.MaxEntries == 0 {
+		c.MaxEntries = 10000
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = 64 * 1024 * 1024 // 64MB
+	}
+	if c.MaxObjectSize == 0 {
+		c.MaxObjectSize = 1024 * 1024 // 1MB
+	}
+	if c.CoalesceTimeout == 0 {
+		c.CoalesceTimeout = 5 * time.Second
+	}
+}
+
+func (c *CacheConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.MaxEntries < 0 {
+		return fmt.Errorf("cache max_entries must be non-negative")
+	}
+	if c.MaxSize < 0 || c.MaxObjectSize < 0 {
+		return fmt.Errorf("cache max_size and max_object_size must be non-negative")
+	}
+	if c.MaxObjectSize > c.MaxSize {
+		return fmt.Errorf("cache max_object_size must not exceed max_size")
+	}
+	if c.DefaultTTL < 0 {
+		return fmt.Errorf("cache default_ttl must be non-negative")
+	}
+	if c.CoalesceTimeout < 0 {
+		return fmt.Errorf("cache coalesce_timeout must be non-negative")
+	}
+	return nil
+}
+
+// RouteCacheConfig adjusts how a route's responses are cached. Key fields
+// add request details to the cache key, besides the URL and the headers the
+// response Varies by, so responses that depend on them aren't served to the
+// wrong clients.
+type RouteCacheConfig struct {
+	KeyHeaders []string `yaml:"key_headers"` // request headers whose values are part of the key
+	KeyCookies []string `yaml:"key_cookies"` // cookies whose values are part of the key
+
+	// KeyQuery, when set, limits the query parameters in the key to those
+	// listed, so e.g. tracking parameters don't split the cache
+	KeyQuery []string `yaml:"key_query"`
+
+	// StaleWhileRevalidate serves an expired response for this long after
+	// it expired while it is refreshed in the background; StaleIfError
+	// serves it for this long when the backends fail. Both override the
+	// backend's Cache-Control directives of the same names.
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate"`
+	StaleIfError         time.Duration `yaml:"stale_if_error"`
+}
+
+func (c *RouteCacheConfig) validate() error {
+	for _, name := range c.KeyHeaders {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid cache key_headers name %q", name)
+		}
+	}
+	for _, name := range c.KeyCookies {
+		if name == "" || strings.ContainsAny(name, "=; \t") {
+			return fmt.Errorf("invalid cache key_cookies name %q", name)
+		}
+	}
+	for _, name := range c.KeyQuery {
+		if name == "" {
+			return fmt.Errorf("cache key_query names must not be empty")
+		}
+	}
+	if c.StaleWhileRevalidate < 0 || c.StaleIfError < 0 {
+		return fmt.Errorf("cache stale_while_revalidate and stale_if_error must be non-negative")
+	}
+	return nil
+}
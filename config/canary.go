@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// CanaryConfig splits traffic between the regular backends and the backends
+// marked as canary, ramping the canary share up automatically while its
+// error rate and latency stay within bounds of the baseline
+type CanaryConfig struct {
+	Enabled              bool          `yaml:"enabled"`
+	InitialPercent       float64       `yaml:"initial_percent"`
+	StepPercent          float64       `yaml:"step_percent"`
+	StepInterval         time.Duration `yaml:"step_interval"`
+	MaxPercent           float64       `yaml:"max_percent"`
+	MinRequests          int           `yaml:"min_requests"`            // canary requests needed per step before judging it
+	MaxErrorRateIncrease float64       `yaml:"max_error_rate_increase"` // allowed canary error rate above baseline, 0.05 = 5 points
+	MaxLatencyRatio      float64       `yaml:"max_latency_ratio"`       // allowed canary mean latency relative to baseline
+}
+
+func (c *CanaryConfig) setDefaults() {
+	if c.InitialPercent == 0 {
+		c.InitialPercent = 5
+	}
+	if c.StepPercent == 0 {
+		c.StepPercent = 10
+	}
+	if c.StepInterval == 0 {
+		c.StepInterval = 5 * time.Minute
+	}
+	if c.MaxPercent == 0 {
+		c.MaxPercent = 100
+	}
+	if c.MinRequests == 0 {
+		c.MinRequests = 20
+	}
+	if c.MaxErrorRateIncrease == 0 {
+		c.MaxErrorRateIncrease = 0.05
+	}
+	if c.MaxLatencyRatio == 0 {
+		c.MaxLatencyRatio = 1.5
+	}
+}
+
+func (c *CanaryConfig) validate(backends []Backend) error {
+	if !c.Enabled {
+		return nil
+	}
+
+	canaries := 0
+	for _, b := range backends {
+		if b.Canary {
+			canaries++
+		}
+	}
+	if canaries == 0 {
+		return fmt.Errorf("canary is enabled but no backend is marked canary")
+	}
+	if canaries == len(backends) {
+		return fmt.Errorf("canary requires at least one non-canary backend")
+	}
+
+	if c.InitialPercent < 0 || c.InitialPercent > 100 {
+		return fmt.Errorf("canary initial_percent must be between 0 and 100")
+	}
+	if c.MaxPercent <= 0 || c.MaxPercent > 100 {
+		return fmt.Errorf("canary max_percent must be between 0 and 100")
+	}
+	if c.InitialPercent > c.MaxPercent {
+		return fmt.Errorf("canary initial_percent must not exceed max_percent")
+	}
+	if c.StepPercent < 0 {
+		return fmt.Errorf("canary step_percent must be non-negative")
+	}
+	if c.StepInterval <= 0 {
+		return fmt.Errorf("canary step_interval must be positive")
+	}
+	if c.MinRequests < 0 {
+		return fmt.Errorf("canary min_requests must be non-negative")
+	}
+	if c.MaxErrorRateIncrease < 0 || c.MaxLatencyRatio < 0 {
+		return fmt.Errorf("canary thresholds must be non-negative")
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// Check runs the checks Validate leaves to startup: that the files the
+// configuration names can be read and that backend URLs are absolute http
+// or https URLs. Unlike Validate it reports every problem it finds.
+func (c *Config) Check() error {
+	var errs []error
+	file := func(field, path string) {
+		if path == "" {
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+			return
+		}
+		f.Close()
+	}
+	backends := func(field string, list []Backend) {
+		for i := range list {
+			b := &list[i]
+			name := fmt.Sprintf("%s[%d]", field, i)
+			if err := b.checkURL(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+			if b.TLS != nil {
+				b.TLS.check(name+".tls", file)
+			}
+		}
+	}
+	routes := func(field string, list []RouteConfig) {
+		for i := range list {
+			r := &list[i]
+			name := fmt.Sprintf("%s[%d]", field, i)
+			if r.BasicAuth != nil {
+				file(name+".basic_auth.htpasswd_file", r.BasicAuth.HtpasswdFile)
+			}
+			if r.Signature != nil {
+				file(name+".signature.secrets_file", r.Signature.SecretsFile)
+			}
+			if r.OpenAPI != nil {
+				file(name+".openapi.spec", r.OpenAPI.Spec)
+			}
+			if r.Mirror != nil && r.Mirror.TLS != nil {
+				r.Mirror.TLS.check(name+".mirror.tls", file)
+			}
+		}
+	}
+
+	if c.TLS != nil && c.TLS.Enabled {
+		file("tls.cert_file", c.TLS.CertFile)
+		file("tls.key_file", c.TLS.KeyFile)
+		file("tls.client_ca_file", c.TLS.ClientCAFile)
+	}
+	backends("backends", c.Backends)
+	for name, pool := range c.Pools {
+		backends("pools."+name+".backends", pool.Backends)
+		file("pools."+name+".upstreams_file", pool.UpstreamsFile)
+	}
+	routes("routes", c.Routes)
+	for i := range c.VHosts {
+		vh := &c.VHosts[i]
+		name := fmt.Sprintf("vhosts[%d]", i)
+		backends(name+".backends", vh.Backends)
+		routes(name+".routes", vh.Routes)
+		if vh.TLS != nil {
+			file(name+".tls.cert_file", vh.TLS.CertFile)
+			file(name+".tls.key_file", vh.TLS.KeyFile)
+		}
+	}
+	for status, page := range c.ErrorPages.Pages {
+		file(fmt.Sprintf("error_pages.pages[%d]", status), page)
+	}
+	file("error_pages.default", c.ErrorPages.Default)
+	if c.Admin.Enabled && c.Admin.TLS != nil {
+		file("admin.tls.cert_file", c.Admin.TLS.CertFile)
+		file("admin.tls.key_file", c.Admin.TLS.KeyFile)
+		file("admin.tls.client_ca_file", c.Admin.TLS.ClientCAFile)
+	}
+	if c.GeoIP.Enabled {
+		file("geoip.database", c.GeoIP.Database)
+	}
+	if c.APIKeys.Enabled {
+		file("api_keys.keys_file", c.APIKeys.KeysFile)
+	}
+	for i, p := range c.Plugins {
+		file(fmt.Sprintf("plugins[%d].path", i), p.Path)
+	}
+	return errors.Join(errs...)
+}
+
+// checkURL requires an absolute http or https URL. Discovered backends may
+// leave out the host, or the URL altogether.
+func (b *Backend) checkURL() error {
+	if b.URL == "" && b.Discovered() {
+		return nil
+	}
+	u, err := url.Parse(b.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %s: %w", b.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("URL %s must start with http:// or https://", b.URL)
+	}
+	if u.Host == "" && !b.Discovered() {
+		return fmt.Errorf("URL %s has no host", b.URL)
+	}
+	return nil
+}
+
+func (t *BackendTLSConfig) check(field string, file func(field, path string)) {
+	file(field+".ca_file", t.CAFile)
+	file(field+".cert_file", t.CertFile)
+	file(field+".key_file", t.KeyFile)
+}
@@ -0,0 +1,18 @@
+package config
+
+import "fmt"
+
+// ClientLimitConfig caps the requests a single client has in flight so one
+// client can't take the capacity meant for all. Clients are told apart by
+// IP address, or by the value of Header when they send it.
+type ClientLimitConfig struct {
+	MaxRequests int    `yaml:"max_requests"` // 0 is unlimited
+	Header      string `yaml:"header"`       // e.g. X-API-Key
+}
+
+func (c *ClientLimitConfig) validate() error {
+	if c.MaxRequests < 0 {
+		return fmt.Errorf("per_client max_requests must be non-negative")
+	}
+	return nil
+}
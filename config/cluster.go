@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// ClusterConfig enables sharing runtime state (sticky sessions, rate-limit
+// counters, health observations) between a fleet of proxy instances
+type ClusterConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	NodeName       string        `yaml:"node_name"`
+	BindAddress    string        `yaml:"bind_address"`
+	Peers          []string      `yaml:"peers"` // host:port of the other nodes' bind addresses
+	GossipInterval time.Duration `yaml:"gossip_interval"`
+	SecretKey      string        `yaml:"secret_key"` // shared key authenticating peer traffic
+}
+
+func (c *ClusterConfig) setDefaults() {
+	if !c.Enabled {
+		return
+	}
+	if c.NodeName == "" {
+		c.NodeName, _ = os.Hostname()
+	}
+	if c.BindAddress == "" {
+		c.BindAddress = ":7946"
+	}
+	if c.GossipInterval == 0 {
+		c.GossipInterval = time.Second
+	}
+}
+
+func (c *ClusterConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.NodeName == "" {
+		return fmt.Errorf("cluster node_name is required")
+	}
+	if c.GossipInterval < 0 {
+		return fmt.Errorf("cluster gossip_interval must be non-negative")
+	}
+	if c.SecretKey == "" {
+		return fmt.Errorf("cluster secret_key is required when clustering is enabled")
+	}
+	for _, peer := range c.Peers {
+		if peer == "" {
+			return fmt.Errorf("cluster peers: empty peer address")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package config
+
+import "fmt"
+
+// CompressionConfig compresses responses for clients that accept it
+type CompressionConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Encodings []string `yaml:"encodings"` // in order of preference: br, gzip
+	Level     int      `yaml:"level"`     // 1 (fastest) to 9 (smallest); 0 uses each encoder's default
+	MinSize   int      `yaml:"min_size"`  // smaller responses are sent uncompressed
+	MIMETypes []string `yaml:"mime_types"`
+}
+
+func (c *CompressionConfig) setDefaults() {
+	if len(c.Encodings) == 0 {
+		c.Encodings = []string{"br", "gzip"}
+	}
+	if c.MinSize == 0 {
+		c.MinSize = 1024
+	}
+	if len(c.MIMETypes) == 0 {
+		c.MIMETypes = []string{
+			"text/html", "text/css", "text/plain", "text/xml", "text/javascript",
+			"application/javascript", "application/json", "application/xml",
+			"application/wasm", "image/svg+xml",
+		}
+	}
+}
+
+func (c *CompressionConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	for _, e := range c.Encodings {
+		if e != "br" && e != "gzip" {
+			return fmt.Errorf("invalid compression encoding: %s (must be one of: br, gzip)", e)
+		}
+	}
+	if c.Level < 0 || c.Level > 9 {
+		return fmt.Errorf("compression level must be between 1 and 9")
+	}
+	if c.MinSize < 0 {
+		return fmt.Errorf("compression min_size must be non-negative")
+	}
+	return nil
+}
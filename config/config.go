@@ -2,83 +2,265 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
 	"time"
-
-	"gopkg.in/yaml.v3"
 )
 
 // Config represents the main configuration structure
 type Config struct {
-	Server       ServerConfig       `yaml:"server"`
-	Backends     []Backend          `yaml:"backends"`
-	LoadBalancer LoadBalancerConfig `yaml:"load_balancer"`
-	HealthCheck  HealthCheckConfig  `yaml:"health_check"`
-	Logging      LoggingConfig      `yaml:"logging"`
-	TLS          *TLSConfig         `yaml:"tls,omitempty"`
-	Limits       LimitsConfig       `yaml:"limits"`
+	Server       ServerConfig          `yaml:"server"`
+	Backends     []Backend             `yaml:"backends"`
+	Pools        map[string]PoolConfig `yaml:"pools"`
+	Routes       []RouteConfig         `yaml:"routes"`
+	DefaultPool  string                `yaml:"default_pool"` // serves requests matching no route, in place of backends
+	VHosts       []VHostConfig         `yaml:"vhosts"`
+	UnknownHost  UnknownHostConfig     `yaml:"unknown_host"`
+	Redirects    []RedirectRule        `yaml:"redirects"`
+	ErrorPages   ErrorPagesConfig      `yaml:"error_pages"`
+	Maintenance  MaintenanceModeConfig `yaml:"maintenance_mode"`
+	Headers      HeaderRulesConfig     `yaml:"headers"`
+	Forwarded    ForwardedConfig       `yaml:"forwarded"`
+	RequestID    RequestIDConfig       `yaml:"request_id"`
+	Tracing      TracingConfig         `yaml:"tracing"`
+	Access       AccessConfig          `yaml:"access"`
+	GeoIP        GeoIPConfig           `yaml:"geoip"`
+	JWT          JWTConfig             `yaml:"jwt"`
+	ForwardAuth  ForwardAuthConfig     `yaml:"forward_auth"`
+	APIKeys      APIKeyConfig          `yaml:"api_keys"`
+	CORS         CORSConfig            `yaml:"cors"`
+	Security     SecurityHeadersConfig `yaml:"security_headers"`
+	Compression  CompressionConfig     `yaml:"compression"`
+	Cache        CacheConfig           `yaml:"cache"`
+	LoadBalancer LoadBalancerConfig    `yaml:"load_balancer"`
+	HealthCheck  HealthCheckConfig     `yaml:"health_check"`
+	Retry        RetryConfig           `yaml:"retry"`
+	Logging      LoggingConfig         `yaml:"logging"`
+	TLS          *TLSConfig            `yaml:"tls,omitempty"`
+	Limits       LimitsConfig          `yaml:"limits"`
+	Egress       EgressConfig          `yaml:"egress"`
+	Transport    TransportConfig       `yaml:"transport"`
+	DNS          DNSConfig             `yaml:"dns"`
+	Consul       ConsulConfig          `yaml:"consul"`
+	Kubernetes   KubernetesConfig      `yaml:"kubernetes"`
+	Docker       DockerConfig          `yaml:"docker"`
+	Cluster      ClusterConfig         `yaml:"cluster"`
+	Idempotency  IdempotencyConfig     `yaml:"idempotency"`
+	Canary       CanaryConfig          `yaml:"canary"`
+	Blocklists   []BlocklistFeed       `yaml:"blocklists"`
+	UserAgents   UserAgentConfig       `yaml:"user_agents"`
+	Streams      []StreamConfig        `yaml:"streams"`
+	Admin        AdminConfig           `yaml:"admin"`
+	Metrics      MetricsConfig         `yaml:"metrics"`
+	Faults       FaultConfig           `yaml:"faults"`
+
+	// ConnectFailover tries another backend when connecting fails, for
+	// requests that retry doesn't cover
+	ConnectFailover ConnectFailoverConfig `yaml:"connect_failover"`
+
+	// BodyCapture logs the bodies of selected exchanges for debugging
+	BodyCapture BodyCaptureConfig `yaml:"body_capture"`
+
+	// ConnectionHeaders pass details of the client's connection, such as
+	// its TLS version or certificate, to backends
+	ConnectionHeaders ConnectionHeadersConfig `yaml:"connection_headers"`
+
+	// Tenants split a shared proxy between teams, each with its own rate
+	// and concurrency limits and metrics
+	Tenants []TenantConfig `yaml:"tenants"`
+
+	// Plugins are WebAssembly modules that inspect and change requests
+	// and responses
+	Plugins []PluginConfig `yaml:"plugins"`
+
+	// cleanup removes the temporary files holding secrets read from Vault
+	cleanup func()
 }
 
 // ServerConfig contains HTTP server configuration
 type ServerConfig struct {
-	Address      string        `yaml:"address"`
-	ReadTimeout  time.Duration `yaml:"read_timeout"`
-	WriteTimeout time.Duration `yaml:"write_timeout"`
-	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	Address       string              `yaml:"address"`
+	ReadTimeout   time.Duration       `yaml:"read_timeout"`
+	WriteTimeout  time.Duration       `yaml:"write_timeout"`
+	IdleTimeout   time.Duration       `yaml:"idle_timeout"`
+	ProxyProtocol ProxyProtocolConfig `yaml:"proxy_protocol"`
+	HTTP3         HTTP3Config         `yaml:"http3"`
+	Listeners     []ListenerConfig    `yaml:"listeners"` // served in addition to Address
 }
 
 // Backend represents a backend server configuration
 type Backend struct {
-	URL    string `yaml:"url"`
-	Weight int    `yaml:"weight"`
+	URL         string                    `yaml:"url"`
+	Weight      *int                      `yaml:"weight,omitempty"` // 1 if unset; 0 drains the backend
+	EgressProxy *EgressProxyConfig        `yaml:"egress_proxy,omitempty"`
+	Dial        *DialConfig               `yaml:"dial,omitempty"`
+	Maintenance []MaintenanceWindow       `yaml:"maintenance,omitempty"`
+	Canary      bool                      `yaml:"canary"`
+	Priority    int                       `yaml:"priority"` // 0 is primary; higher values are backups
+	HealthCheck *BackendHealthCheckConfig `yaml:"health_check,omitempty"`
+	TLS         *BackendTLSConfig         `yaml:"tls,omitempty"`
+	Protocol    string                    `yaml:"protocol"` // http1, h2 or h2c; empty negotiates h2 over TLS when offered
+
+	// MaxConnections caps the requests in flight to the backend; the load
+	// balancer skips a backend at its cap. 0 is unlimited.
+	MaxConnections int `yaml:"max_connections"`
+
+	// HostHeader is the Host sent to the backend: preserve, backend or an
+	// explicit value
+	HostHeader string `yaml:"host_header"`
+
+	// Resolve expands the URL's hostname into one backend per address it
+	// resolves to, re-resolved every dns.refresh_interval
+	Resolve bool `yaml:"resolve"`
+
+	// SRV names a DNS SRV record whose targets become the backends, with
+	// ports, priorities and weights taken from the record. The URL is
+	// optional and only supplies the scheme and the TLS server name.
+	SRV string `yaml:"srv"`
+
+	// Discovery consul makes the healthy instances of Service, optionally
+	// only those with Tag, the backends. Discovery kubernetes makes the
+	// ready endpoints of Service in Namespace the backends, on the port
+	// named Port. As with SRV the URL is optional.
+	Discovery string `yaml:"discovery"`
+	Service   string `yaml:"service"`
+	Tag       string `yaml:"tag"`
+	Namespace string `yaml:"namespace"`
+	Port      string `yaml:"port"` // port name; the first port by default
+}
+
+// Discovered reports whether the backend's members are discovered at
+// runtime rather than configured
+func (b *Backend) Discovered() bool {
+	return b.Resolve || b.SRV != "" || b.Discovery != ""
+}
+
+// Protocols spoken to backends
+const (
+	ProtocolHTTP1 = "http1"
+	ProtocolH2    = "h2"
+	ProtocolH2C   = "h2c"
+)
+
+// validAlgorithms are the supported load balancing algorithms
+var validAlgorithms = map[string]bool{
+	"round-robin":         true,
+	"least-connections":   true,
+	"weighted":            true,
+	"ip-hash":             true,
+	"consistent-hash":     true,
+	"least-response-time": true,
 }
 
 // LoadBalancerConfig contains load balancing algorithm configuration
 type LoadBalancerConfig struct {
-	Algorithm string `yaml:"algorithm"` // round-robin, least-connections, weighted
+	Algorithm         string                  `yaml:"algorithm"` // round-robin, least-connections, weighted, ip-hash, consistent-hash, least-response-time
+	ConsistentHash    ConsistentHashConfig    `yaml:"consistent_hash"`
+	LeastResponseTime LeastResponseTimeConfig `yaml:"least_response_time"`
+	SessionStore      SessionStoreConfig      `yaml:"session_store"`
+	Sticky            StickyConfig            `yaml:"sticky"`
+	SlowStart         SlowStartConfig         `yaml:"slow_start"`
 }
 
 // HealthCheckConfig contains health check configuration
 type HealthCheckConfig struct {
-	Enabled  bool          `yaml:"enabled"`
-	Interval time.Duration `yaml:"interval"`
-	Timeout  time.Duration `yaml:"timeout"`
-	Path     string        `yaml:"path"`
+	Enabled            bool                     `yaml:"enabled"`
+	Interval           time.Duration            `yaml:"interval"`
+	Timeout            time.Duration            `yaml:"timeout"`
+	Path               string                   `yaml:"path"`
+	HealthyThreshold   int                      `yaml:"healthy_threshold"`   // consecutive successes to mark a backend up
+	UnhealthyThreshold int                      `yaml:"unhealthy_threshold"` // consecutive failures to mark a backend down
+	Jitter             float64                  `yaml:"jitter"`              // random spread of the interval, 0.1 = ±10%
+	Passive            PassiveHealthCheckConfig `yaml:"passive"`
+	Notify             HealthNotifyConfig       `yaml:"notify"`
+
+	// Type is how backends are probed: http requests over the backend's
+	// scheme, https requests over TLS whatever the scheme, or tcp connects.
+	// Method, Headers and ExpectedBody apply to http and https probes.
+	Type         string            `yaml:"type"`
+	Method       string            `yaml:"method"`
+	Headers      map[string]string `yaml:"headers"`       // a Host entry sets the request's Host
+	ExpectedBody string            `yaml:"expected_body"` // text the response body must contain
+
+	// InitialDelay postpones the first probe of each backend. With
+	// StartUnhealthy backends take no traffic until a probe succeeds;
+	// the first success is enough, whatever healthy_threshold says.
+	InitialDelay   time.Duration `yaml:"initial_delay"`
+	StartUnhealthy bool          `yaml:"start_unhealthy"`
+
+	// MaxConcurrent bounds the probes in flight across all backends; the
+	// others wait for a slot. 0 means no limit.
+	MaxConcurrent int `yaml:"max_concurrent"`
 }
 
 // LoggingConfig contains logging configuration
 type LoggingConfig struct {
-	Level  string `yaml:"level"`  // debug, info, warn, error
-	Format string `yaml:"format"` // json, text
+	Level    string            `yaml:"level"`  // debug, info, warn, error
+	Format   string            `yaml:"format"` // json, text
+	Output   string            `yaml:"output"` // stdout, stderr, syslog or a file path
+	Rotation LogRotationConfig `yaml:"rotation"`
+	Syslog   SyslogConfig      `yaml:"syslog"`
 }
 
 // TLSConfig contains TLS/HTTPS configuration
 type TLSConfig struct {
-	Enabled  bool   `yaml:"enabled"`
-	CertFile string `yaml:"cert_file"`
-	KeyFile  string `yaml:"key_file"`
+	Enabled      bool        `yaml:"enabled"`
+	CertFile     string      `yaml:"cert_file"`
+	KeyFile      string      `yaml:"key_file"`
+	ACME         *ACMEConfig `yaml:"acme,omitempty"` // when set, cert_file and key_file are optional
+	MinVersion   string      `yaml:"min_version"`    // "1.0" to "1.3"
+	MaxVersion   string      `yaml:"max_version"`    // empty allows the newest supported
+	CipherSuites []string    `yaml:"cipher_suites"`  // TLS 1.0-1.2 only; empty uses Go's defaults
+
+	// ClientAuth asks clients for a certificate: request, require (any
+	// certificate), verify_if_given or require_and_verify, the last two
+	// against the CAs of ClientCAFile. Empty or none asks for none.
+	ClientAuth   string `yaml:"client_auth"`
+	ClientCAFile string `yaml:"client_ca_file"`
 }
 
 // LimitsConfig contains connection and request limits
 type LimitsConfig struct {
-	MaxConnections    int           `yaml:"max_connections"`
-	MaxIdleConns      int           `yaml:"max_idle_conns"`
-	MaxConnsPerHost   int           `yaml:"max_conns_per_host"`
-	RequestTimeout    time.Duration `yaml:"request_timeout"`
-	MaxRequestBodySize int64        `yaml:"max_request_body_size"`
+	MaxConnections     int           `yaml:"max_connections"` // requests in flight across all clients
+	MaxQueue           int           `yaml:"max_queue"`       // requests waiting for a slot before shedding
+	QueueTimeout       time.Duration `yaml:"queue_timeout"`
+	MaxIdleConns       int           `yaml:"max_idle_conns"`        // idle connections kept per backend
+	MaxConnsPerHost    int           `yaml:"max_conns_per_host"`    // connections per backend; requests beyond wait for one
+	RequestTimeout     time.Duration `yaml:"request_timeout"`       // total time for each attempt to reach a backend
+	MaxRequestBodySize int64         `yaml:"max_request_body_size"` // larger requests are refused with 413
+
+	// BackendQueueTimeout is how long a request waits for a backend below
+	// its max_connections when all are at their cap; 0 sheds it right away.
+	// At most BackendQueue requests wait per pool, served in arrival order.
+	BackendQueueTimeout time.Duration `yaml:"backend_queue_timeout"`
+	BackendQueue        int           `yaml:"backend_queue"`
+
+	// MaxClientConnections caps the client connections open at once across
+	// all listeners; further connections wait in the accept backlog.
+	// 0 is unlimited.
+	MaxClientConnections int `yaml:"max_client_connections"`
+
+	// PerClient caps the requests each client has in flight
+	PerClient ClientLimitConfig `yaml:"per_client"`
 }
 
 // Load reads and parses the configuration file
 func Load(path string) (*Config, error) {
+	return LoadFormat(path, DetectFormat(path))
+}
+
+// LoadFormat reads a config file in the given format: yaml, json or toml
+func LoadFormat(path, format string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	if err := decode(data, format, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
@@ -87,12 +269,23 @@ func Load(path string) (*Config, error) {
 
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
+		cfg.Close()
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
 	return &cfg, nil
 }
 
+// Close removes the temporary files that file fields read from Vault were
+// written to. The configuration's users must be done with those files, as
+// they are read again e.g. when watched files change.
+func (c *Config) Close() {
+	if c.cleanup != nil {
+		c.cleanup()
+		c.cleanup = nil
+	}
+}
+
 func setDefaults(cfg *Config) {
 	if cfg.Server.ReadTimeout == 0 {
 		cfg.Server.ReadTimeout = 10 * time.Second
@@ -103,9 +296,21 @@ func setDefaults(cfg *Config) {
 	if cfg.Server.IdleTimeout == 0 {
 		cfg.Server.IdleTimeout = 120 * time.Second
 	}
+	cfg.Server.ProxyProtocol.setDefaults()
+	cfg.Server.HTTP3.setDefaults(cfg.Server.Address)
+	for i := range cfg.Server.Listeners {
+		cfg.Server.Listeners[i].setDefaults()
+	}
 	if cfg.LoadBalancer.Algorithm == "" {
 		cfg.LoadBalancer.Algorithm = "round-robin"
 	}
+	for i := range cfg.Streams {
+		cfg.Streams[i].setDefaults(cfg.LoadBalancer.Algorithm)
+	}
+	for name, p := range cfg.Pools {
+		p.setDefaults(cfg.LoadBalancer.Algorithm)
+		cfg.Pools[name] = p
+	}
 	if cfg.HealthCheck.Interval == 0 {
 		cfg.HealthCheck.Interval = 10 * time.Second
 	}
@@ -115,15 +320,39 @@ func setDefaults(cfg *Config) {
 	if cfg.HealthCheck.Path == "" {
 		cfg.HealthCheck.Path = "/health"
 	}
+	if cfg.HealthCheck.HealthyThreshold == 0 {
+		cfg.HealthCheck.HealthyThreshold = 2
+	}
+	if cfg.HealthCheck.UnhealthyThreshold == 0 {
+		cfg.HealthCheck.UnhealthyThreshold = 3
+	}
+	if cfg.HealthCheck.Jitter == 0 {
+		cfg.HealthCheck.Jitter = 0.1
+	}
+	if cfg.HealthCheck.Type == "" {
+		cfg.HealthCheck.Type = HealthCheckHTTP
+	}
+	if cfg.HealthCheck.Method == "" {
+		cfg.HealthCheck.Method = http.MethodGet
+	}
+	cfg.HealthCheck.Passive.setDefaults()
+	cfg.HealthCheck.Notify.setDefaults()
 	if cfg.Logging.Level == "" {
 		cfg.Logging.Level = "info"
 	}
 	if cfg.Logging.Format == "" {
 		cfg.Logging.Format = "text"
 	}
+	if cfg.Logging.Output == "" {
+		cfg.Logging.Output = LogOutputStderr
+	}
+	cfg.Logging.Syslog.setDefaults()
 	if cfg.Limits.MaxConnections == 0 {
 		cfg.Limits.MaxConnections = 10000
 	}
+	if cfg.Limits.QueueTimeout == 0 {
+		cfg.Limits.QueueTimeout = time.Second
+	}
 	if cfg.Limits.MaxIdleConns == 0 {
 		cfg.Limits.MaxIdleConns = 100
 	}
@@ -133,9 +362,74 @@ func setDefaults(cfg *Config) {
 	if cfg.Limits.RequestTimeout == 0 {
 		cfg.Limits.RequestTimeout = 30 * time.Second
 	}
+	if cfg.Limits.BackendQueue == 0 {
+		cfg.Limits.BackendQueue = 100
+	}
 	if cfg.Limits.MaxRequestBodySize == 0 {
 		cfg.Limits.MaxRequestBodySize = 10 * 1024 * 1024 // 10MB
 	}
+	cfg.Transport.setDefaults()
+	cfg.DNS.setDefaults()
+	cfg.Consul.setDefaults()
+	cfg.Docker.setDefaults()
+	cfg.Cluster.setDefaults()
+	cfg.LoadBalancer.SessionStore.setDefaults()
+	cfg.LoadBalancer.Sticky.setDefaults()
+	cfg.LoadBalancer.ConsistentHash.setDefaults()
+	cfg.LoadBalancer.LeastResponseTime.setDefaults()
+	cfg.LoadBalancer.SlowStart.setDefaults()
+	cfg.Idempotency.setDefaults()
+	cfg.Canary.setDefaults()
+	for i := range cfg.Blocklists {
+		cfg.Blocklists[i].setDefaults()
+	}
+	cfg.Admin.setDefaults()
+	cfg.Metrics.setDefaults()
+	cfg.Faults.setDefaults()
+	cfg.BodyCapture.SetDefaults()
+	cfg.Retry.setDefaults()
+	cfg.ConnectFailover.setDefaults()
+	cfg.GeoIP.setDefaults()
+	cfg.UserAgents.setDefaults()
+	cfg.JWT.setDefaults()
+	cfg.ForwardAuth.setDefaults()
+	cfg.APIKeys.setDefaults()
+	for i := range cfg.Tenants {
+		cfg.Tenants[i].setDefaults()
+	}
+	for i := range cfg.Plugins {
+		cfg.Plugins[i].setDefaults()
+	}
+	cfg.CORS.setDefaults()
+	cfg.Security.setDefaults()
+	cfg.Compression.setDefaults()
+	cfg.Cache.setDefaults()
+	cfg.UnknownHost.setDefaults()
+	for i := range cfg.Redirects {
+		cfg.Redirects[i].setDefaults()
+	}
+	cfg.Maintenance.setDefaults()
+	cfg.RequestID.setDefaults()
+	cfg.Tracing.setDefaults()
+	for i := range cfg.Routes {
+		cfg.Routes[i].setDefaults()
+	}
+	for i := range cfg.VHosts {
+		if cfg.VHosts[i].Algorithm == "" {
+			cfg.VHosts[i].Algorithm = cfg.LoadBalancer.Algorithm
+		}
+		for j := range cfg.VHosts[i].Routes {
+			cfg.VHosts[i].Routes[j].setDefaults()
+		}
+	}
+	if cfg.TLS != nil {
+		if cfg.TLS.MinVersion == "" {
+			cfg.TLS.MinVersion = "1.2"
+		}
+		if cfg.TLS.ACME != nil {
+			cfg.TLS.ACME.setDefaults()
+		}
+	}
 }
 
 // Validate checks if the configuration is valid
@@ -146,35 +440,173 @@ func (c *Config) Validate() error {
 	}
 
 	// Validate backends
-	if len(c.Backends) == 0 {
+	if len(c.Backends) == 0 && len(c.Pools) == 0 && len(c.Routes) == 0 && len(c.VHosts) == 0 && len(c.Streams) == 0 && !c.Docker.Enabled {
 		return fmt.Errorf("at least one backend is required")
 	}
 
 	for i, backend := range c.Backends {
-		if backend.URL == "" {
-			return fmt.Errorf("backend %d: URL is required", i)
+		if err := backend.validate(); err != nil {
+			return fmt.Errorf("backend %d: %w", i, err)
 		}
-		
-		// Validate URL format
-		_, err := url.Parse(backend.URL)
-		if err != nil {
-			return fmt.Errorf("backend %d: invalid URL %s: %w", i, backend.URL, err)
+	}
+
+	// Validate backend pools
+	for name, pool := range c.Pools {
+		if err := pool.validate(); err != nil {
+			return fmt.Errorf("pool %s: %w", name, err)
 		}
+	}
 
-		// Validate weight
-		if backend.Weight < 0 {
-			return fmt.Errorf("backend %d: weight must be non-negative", i)
+	// Validate routes
+	if err := validateDefaultPool(c.DefaultPool, c.Backends, c.Pools); err != nil {
+		return err
+	}
+	for i := range c.Routes {
+		if err := c.Routes[i].validate(c.Pools); err != nil {
+			return fmt.Errorf("route %d: %w", i, err)
 		}
 	}
 
-	// Validate load balancer algorithm
-	validAlgorithms := map[string]bool{
-		"round-robin":      true,
-		"least-connections": true,
-		"weighted":         true,
+	// Validate virtual hosts
+	seenHosts := make(map[string]bool)
+	for i := range c.VHosts {
+		if err := c.VHosts[i].validate(c, seenHosts); err != nil {
+			return fmt.Errorf("vhost %d: %w", i, err)
+		}
+	}
+	if err := c.UnknownHost.validate(); err != nil {
+		return err
+	}
+	if c.UnknownHost.Enabled() && len(c.VHosts) == 0 {
+		return fmt.Errorf("unknown_host requires at least one vhost")
 	}
+
+	// Validate error pages
+	if err := c.ErrorPages.validate(); err != nil {
+		return err
+	}
+
+	// Validate maintenance mode
+	if err := c.Maintenance.validate(); err != nil {
+		return err
+	}
+
+	// Validate header rules
+	if err := c.Headers.validate(); err != nil {
+		return err
+	}
+
+	// Validate trusted proxies
+	if err := c.Forwarded.validate(); err != nil {
+		return err
+	}
+	if err := c.ConnectionHeaders.validate(); err != nil {
+		return err
+	}
+
+	// Validate request IDs
+	if err := c.RequestID.validate(); err != nil {
+		return err
+	}
+	if err := c.Tracing.validate(); err != nil {
+		return err
+	}
+
+	// Validate access control lists
+	if err := c.Access.validate(); err != nil {
+		return err
+	}
+
+	// Validate GeoIP
+	if err := c.GeoIP.validate(); err != nil {
+		return err
+	}
+
+	// Validate JWT authentication
+	if err := c.JWT.validate(); err != nil {
+		return err
+	}
+
+	// Validate forward authentication
+	if err := c.ForwardAuth.validate(); err != nil {
+		return err
+	}
+
+	// Validate redirects
+	for i, rule := range c.Redirects {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("redirect %d: %w", i, err)
+		}
+	}
+
+	// Validate user agent rules
+	if err := c.UserAgents.validate(); err != nil {
+		return err
+	}
+
+	// Validate API keys
+	if err := c.APIKeys.validate(); err != nil {
+		return err
+	}
+
+	// Validate tenants, which may be told apart by API key
+	if err := validateTenants(c.Tenants, c.APIKeys.Enabled); err != nil {
+		return err
+	}
+
+	// Validate plugins
+	if err := validatePlugins(c.Plugins); err != nil {
+		return err
+	}
+
+	// Validate CORS
+	if err := c.CORS.validate(); err != nil {
+		return err
+	}
+
+	// Validate security headers
+	if err := c.Security.validate(); err != nil {
+		return err
+	}
+
+	// Validate compression
+	if err := c.Compression.validate(); err != nil {
+		return err
+	}
+
+	// Validate response cache
+	if err := c.Cache.validate(); err != nil {
+		return err
+	}
+
+	// Validate load balancer algorithm
 	if !validAlgorithms[c.LoadBalancer.Algorithm] {
-		return fmt.Errorf("invalid load balancer algorithm: %s (must be one of: round-robin, least-connections, weighted)", c.LoadBalancer.Algorithm)
+		return fmt.Errorf("invalid load balancer algorithm: %s (must be one of: round-robin, least-connections, weighted, ip-hash, consistent-hash, least-response-time)", c.LoadBalancer.Algorithm)
+	}
+
+	// Validate consistent hashing
+	if err := c.LoadBalancer.ConsistentHash.validate(); err != nil {
+		return err
+	}
+
+	// Validate least response time
+	if err := c.LoadBalancer.LeastResponseTime.validate(); err != nil {
+		return err
+	}
+
+	// Validate slow start
+	if err := c.LoadBalancer.SlowStart.validate(); err != nil {
+		return err
+	}
+
+	// Validate session store
+	if err := c.LoadBalancer.SessionStore.validate(c); err != nil {
+		return err
+	}
+
+	// Validate sticky sessions
+	if err := c.LoadBalancer.Sticky.validate(); err != nil {
+		return err
 	}
 
 	// Validate timeouts
@@ -187,12 +619,78 @@ func (c *Config) Validate() error {
 	if c.Server.IdleTimeout < 0 {
 		return fmt.Errorf("server idle_timeout must be non-negative")
 	}
+	if err := c.Server.ProxyProtocol.validate(); err != nil {
+		return err
+	}
+	if err := c.Server.HTTP3.validate(c.TLS); err != nil {
+		return err
+	}
+
+	// Validate additional listeners
+	addresses := map[string]bool{c.Server.Address: true}
+	for i := range c.Server.Listeners {
+		l := &c.Server.Listeners[i]
+		if err := l.validate(c); err != nil {
+			return fmt.Errorf("listener %d: %w", i, err)
+		}
+		if addresses[l.Address] {
+			return fmt.Errorf("listener %d: address %s is already in use", i, l.Address)
+		}
+		addresses[l.Address] = true
+	}
+
+	// Validate TCP streams
+	streamNames := make(map[string]bool)
+	for i := range c.Streams {
+		s := &c.Streams[i]
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("stream %d: %w", i, err)
+		}
+		if streamNames[s.Name] {
+			return fmt.Errorf("stream %d: duplicate name %q", i, s.Name)
+		}
+		streamNames[s.Name] = true
+		if addresses[s.Address] {
+			return fmt.Errorf("stream %s: address %s is already in use", s.Name, s.Address)
+		}
+		addresses[s.Address] = true
+	}
+
 	if c.HealthCheck.Enabled && c.HealthCheck.Interval < 0 {
 		return fmt.Errorf("health_check interval must be non-negative")
 	}
 	if c.HealthCheck.Enabled && c.HealthCheck.Timeout < 0 {
 		return fmt.Errorf("health_check timeout must be non-negative")
 	}
+	if c.HealthCheck.HealthyThreshold < 1 || c.HealthCheck.UnhealthyThreshold < 1 {
+		return fmt.Errorf("health_check thresholds must be positive")
+	}
+	if c.HealthCheck.Jitter < 0 || c.HealthCheck.Jitter > 1 {
+		return fmt.Errorf("health_check jitter must be between 0 and 1")
+	}
+	if err := validateProbe(c.HealthCheck.Type, c.HealthCheck.Method, c.HealthCheck.Headers); err != nil {
+		return err
+	}
+	if c.HealthCheck.InitialDelay < 0 {
+		return fmt.Errorf("health_check initial_delay must be non-negative")
+	}
+	if c.HealthCheck.MaxConcurrent < 0 {
+		return fmt.Errorf("health_check max_concurrent must be non-negative")
+	}
+	if err := c.HealthCheck.Passive.validate(); err != nil {
+		return err
+	}
+	if err := c.HealthCheck.Notify.validate(); err != nil {
+		return err
+	}
+
+	// Validate retry policy
+	if err := c.Retry.validate(); err != nil {
+		return err
+	}
+	if err := c.ConnectFailover.validate(); err != nil {
+		return err
+	}
 
 	// Validate logging
 	validLevels := map[string]bool{
@@ -212,21 +710,122 @@ func (c *Config) Validate() error {
 	if !validFormats[strings.ToLower(c.Logging.Format)] {
 		return fmt.Errorf("invalid logging format: %s (must be one of: json, text)", c.Logging.Format)
 	}
+	if err := c.Logging.Rotation.validate(); err != nil {
+		return err
+	}
+	if c.Logging.Output == LogOutputSyslog {
+		if err := c.Logging.Syslog.validate(); err != nil {
+			return err
+		}
+	}
 
 	// Validate TLS configuration
 	if c.TLS != nil && c.TLS.Enabled {
-		if c.TLS.CertFile == "" {
-			return fmt.Errorf("TLS cert_file is required when TLS is enabled")
+		if c.TLS.ACME != nil {
+			if err := c.TLS.ACME.validate(); err != nil {
+				return err
+			}
+			if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+				return fmt.Errorf("TLS cert_file and key_file must be set together")
+			}
+		} else {
+			if c.TLS.CertFile == "" {
+				return fmt.Errorf("TLS cert_file is required when TLS is enabled")
+			}
+			if c.TLS.KeyFile == "" {
+				return fmt.Errorf("TLS key_file is required when TLS is enabled")
+			}
 		}
-		if c.TLS.KeyFile == "" {
-			return fmt.Errorf("TLS key_file is required when TLS is enabled")
+		if err := c.TLS.validateProtocol(); err != nil {
+			return err
 		}
+		if err := c.TLS.validateClientAuth(); err != nil {
+			return err
+		}
+	}
+
+	// Validate egress policy
+	if err := c.Egress.validate(); err != nil {
+		return err
+	}
+
+	// Validate backend transport
+	if err := c.Transport.validate(); err != nil {
+		return err
+	}
+
+	// Validate DNS resolver
+	if err := c.DNS.validate(); err != nil {
+		return err
+	}
+
+	// Validate Consul discovery
+	if err := c.Consul.validate(); err != nil {
+		return err
+	}
+
+	// Validate Kubernetes discovery
+	if err := c.Kubernetes.validate(); err != nil {
+		return err
+	}
+
+	// Validate Docker discovery
+	if err := c.Docker.validate(); err != nil {
+		return err
+	}
+
+	// Validate clustering
+	if err := c.Cluster.validate(); err != nil {
+		return err
+	}
+
+	// Validate idempotency
+	if err := c.Idempotency.validate(); err != nil {
+		return err
+	}
+
+	// Validate canary rollout
+	if err := c.Canary.validate(c.Backends); err != nil {
+		return err
+	}
+
+	// Validate blocklist feeds
+	for i := range c.Blocklists {
+		if err := c.Blocklists[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate admin API
+	if err := c.Admin.validate(c); err != nil {
+		return err
+	}
+
+	// Validate metrics
+	if err := c.Metrics.validate(); err != nil {
+		return err
+	}
+
+	// Validate fault injection
+	if err := c.Faults.validate(); err != nil {
+		return err
+	}
+
+	// Validate body capture
+	if err := c.BodyCapture.Validate(); err != nil {
+		return err
 	}
 
 	// Validate limits
 	if c.Limits.MaxConnections < 0 {
 		return fmt.Errorf("max_connections must be non-negative")
 	}
+	if c.Limits.MaxQueue < 0 {
+		return fmt.Errorf("max_queue must be non-negative")
+	}
+	if c.Limits.QueueTimeout < 0 {
+		return fmt.Errorf("queue_timeout must be non-negative")
+	}
 	if c.Limits.MaxIdleConns < 0 {
 		return fmt.Errorf("max_idle_conns must be non-negative")
 	}
@@ -239,6 +838,157 @@ func (c *Config) Validate() error {
 	if c.Limits.MaxRequestBodySize < 0 {
 		return fmt.Errorf("max_request_body_size must be non-negative")
 	}
+	if c.Limits.BackendQueueTimeout < 0 {
+		return fmt.Errorf("backend_queue_timeout must be non-negative")
+	}
+	if c.Limits.BackendQueue < 0 {
+		return fmt.Errorf("backend_queue must be non-negative")
+	}
+	if c.Limits.MaxClientConnections < 0 {
+		return fmt.Errorf("max_client_connections must be non-negative")
+	}
+	if err := c.Limits.PerClient.validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validate checks a single backend definition
+func (b *Backend) validate() error {
+	if b.URL == "" && b.SRV == "" && b.Discovery == "" {
+		return fmt.Errorf("URL is required")
+	}
+
+	// Validate URL format
+	if _, err := url.Parse(b.URL); err != nil {
+		return fmt.Errorf("invalid URL %s: %w", b.URL, err)
+	}
+
+	// Validate weight
+	if b.Weight != nil && *b.Weight < 0 {
+		return fmt.Errorf("weight must be non-negative")
+	}
+
+	// Validate failover priority
+	if b.Priority < 0 {
+		return fmt.Errorf("priority must be non-negative")
+	}
+
+	// Validate concurrency cap
+	if b.MaxConnections < 0 {
+		return fmt.Errorf("max_connections must be non-negative")
+	}
+
+	// Validate Host header
+	if err := validateHostHeader(b.HostHeader); err != nil {
+		return err
+	}
+
+	// Validate DNS expansion
+	if b.Resolve {
+		u, _ := url.Parse(b.URL)
+		if u.Hostname() == "" || net.ParseIP(u.Hostname()) != nil {
+			return fmt.Errorf("resolve requires a hostname in the URL")
+		}
+		if b.EgressProxy != nil {
+			return fmt.Errorf("resolve cannot be combined with an egress proxy")
+		}
+	}
+
+	// Validate SRV discovery
+	if b.SRV != "" {
+		if b.Resolve {
+			return fmt.Errorf("srv cannot be combined with resolve")
+		}
+		if b.EgressProxy != nil {
+			return fmt.Errorf("srv cannot be combined with an egress proxy")
+		}
+		if b.Weight != nil || b.Priority != 0 {
+			return fmt.Errorf("weight and priority of srv backends come from the SRV record")
+		}
+	}
+
+	// Validate service discovery
+	switch b.Discovery {
+	case "":
+		if b.Service != "" || b.Tag != "" || b.Namespace != "" || b.Port != "" {
+			return fmt.Errorf("service, tag, namespace and port require discovery")
+		}
+	case DiscoveryConsul, DiscoveryKubernetes:
+		if b.Service == "" {
+			return fmt.Errorf("discovery %s requires a service", b.Discovery)
+		}
+		if b.Resolve || b.SRV != "" {
+			return fmt.Errorf("discovery %s cannot be combined with resolve or srv", b.Discovery)
+		}
+		if b.EgressProxy != nil {
+			return fmt.Errorf("discovery %s cannot be combined with an egress proxy", b.Discovery)
+		}
+		if b.Discovery == DiscoveryConsul {
+			if b.Weight != nil {
+				return fmt.Errorf("weight of consul backends comes from the service's weights")
+			}
+			if b.Namespace != "" || b.Port != "" {
+				return fmt.Errorf("namespace and port require discovery kubernetes")
+			}
+		} else if b.Tag != "" {
+			return fmt.Errorf("tag requires discovery consul")
+		}
+	default:
+		return fmt.Errorf("invalid discovery: %s (must be one of: consul, kubernetes)", b.Discovery)
+	}
+
+	// Validate dialing preferences
+	if b.Dial != nil {
+		if err := b.Dial.validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate maintenance windows
+	for i := range b.Maintenance {
+		if err := b.Maintenance[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate upstream protocol
+	u, _ := url.Parse(b.URL)
+	switch b.Protocol {
+	case "", ProtocolHTTP1:
+	case ProtocolH2:
+		if u.Scheme != "https" {
+			return fmt.Errorf("protocol h2 requires an https backend URL (use h2c for cleartext)")
+		}
+	case ProtocolH2C:
+		if u.Scheme != "http" {
+			return fmt.Errorf("protocol h2c requires an http backend URL")
+		}
+	default:
+		return fmt.Errorf("invalid protocol: %s (must be one of: http1, h2, h2c)", b.Protocol)
+	}
+
+	// Validate upstream TLS
+	if b.TLS != nil {
+		if err := b.TLS.validate(b.URL); err != nil {
+			return err
+		}
+	}
+
+	// Validate egress proxy
+	if b.EgressProxy != nil {
+		if err := b.EgressProxy.validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate health check overrides
+	if b.HealthCheck != nil {
+		if err := b.HealthCheck.validate(); err != nil {
+			return err
+		}
+	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,24 @@
+package config
+
+import "fmt"
+
+// ConnectFailoverConfig sends a request on to another backend when
+// connecting to the one picked for it fails. The request never reached a
+// backend, so unlike retry this is safe for every method.
+type ConnectFailoverConfig struct {
+	Enabled     bool `yaml:"enabled"`
+	MaxAttempts int  `yaml:"max_attempts"` // backends tried per request, including the first
+}
+
+func (f *ConnectFailoverConfig) setDefaults() {
+	if f.MaxAttempts == 0 {
+		f.MaxAttempts = 3
+	}
+}
+
+func (f *ConnectFailoverConfig) validate() error {
+	if f.Enabled && f.MaxAttempts < 1 {
+		return fmt.Errorf("connect_failover max_attempts must be at least 1")
+	}
+	return nil
+}
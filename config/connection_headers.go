@@ -0,0 +1,35 @@
+package config
+
+import "fmt"
+
+// ConnectionHeadersConfig names request headers that tell backends about
+// the client's connection, so they can decide on it without terminating
+// TLS themselves. Headers left empty aren't sent. Each header is removed
+// from client requests and only set when its value is known.
+type ConnectionHeadersConfig struct {
+	TLSVersion            string `yaml:"tls_version"`             // e.g. "TLS 1.3"
+	TLSCipher             string `yaml:"tls_cipher"`              // the cipher suite's IANA name
+	TLSALPN               string `yaml:"tls_alpn"`                // the negotiated protocol, e.g. "h2"
+	ClientCertFingerprint string `yaml:"client_cert_fingerprint"` // hex SHA-256 of the client's certificate
+	ClientPort            string `yaml:"client_port"`             // the source port of the client's connection
+}
+
+// Names returns the configured header names
+func (c *ConnectionHeadersConfig) Names() []string {
+	var names []string
+	for _, name := range []string{c.TLSVersion, c.TLSCipher, c.TLSALPN, c.ClientCertFingerprint, c.ClientPort} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (c *ConnectionHeadersConfig) validate() error {
+	for _, name := range c.Names() {
+		if !validHeaderName(name) {
+			return fmt.Errorf("connection_headers: invalid header name %q", name)
+		}
+	}
+	return nil
+}
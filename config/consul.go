@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// DiscoveryConsul makes a backend follow the healthy instances of a Consul
+// service
+const DiscoveryConsul = "consul"
+
+// ConsulConfig locates the Consul agent that backends with discovery
+// consul are read from
+type ConsulConfig struct {
+	Address    string        `yaml:"address"` // HTTP API of the agent
+	Token      string        `yaml:"token"`   // ACL token, sent as X-Consul-Token
+	Datacenter string        `yaml:"datacenter"`
+	WaitTime   time.Duration `yaml:"wait_time"` // how long a blocking query waits for changes
+}
+
+func (c *ConsulConfig) setDefaults() {
+	if c.Address == "" {
+		c.Address = "http://127.0.0.1:8500"
+	}
+	if c.WaitTime == 0 {
+		c.WaitTime = 5 * time.Minute
+	}
+}
+
+func (c *ConsulConfig) validate() error {
+	u, err := url.Parse(c.Address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("consul address must be an http or https URL")
+	}
+	if c.WaitTime < 0 {
+		return fmt.Errorf("consul wait_time must be non-negative")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// CORSConfig answers cross-origin requests at the proxy so backends don't
+// have to. Origins are exact ("https://app.example.com"), a subdomain
+// wildcard ("https://*.example.com") or "*" for any origin.
+type CORSConfig struct {
+	Enabled          bool     `yaml:"enabled"`
+	AllowOrigins     []string `yaml:"allow_origins"`
+	AllowMethods     []string `yaml:"allow_methods"`
+	AllowHeaders     []string `yaml:"allow_headers"` // empty allows whatever the preflight asks for
+	ExposeHeaders    []string `yaml:"expose_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	MaxAge           int      `yaml:"max_age"` // seconds browsers may cache a preflight; 0 omits the header
+}
+
+func (c *CORSConfig) setDefaults() {
+	if len(c.AllowMethods) == 0 {
+		c.AllowMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	}
+}
+
+func (c *CORSConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.AllowOrigins) == 0 {
+		return fmt.Errorf("cors: allow_origins is required")
+	}
+	for _, origin := range c.AllowOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return fmt.Errorf("cors: allow_origins \"*\" cannot be combined with allow_credentials")
+			}
+			continue
+		}
+		if !validOriginPattern(origin) {
+			return fmt.Errorf("cors: invalid origin %q", origin)
+		}
+	}
+	for _, method := range c.AllowMethods {
+		if !validHeaderName(method) {
+			return fmt.Errorf("cors: invalid method %q", method)
+		}
+	}
+	for _, name := range append(append([]string{}, c.AllowHeaders...), c.ExposeHeaders...) {
+		if !validHeaderName(name) {
+			return fmt.Errorf("cors: invalid header name %q", name)
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("cors: max_age must not be negative")
+	}
+	return nil
+}
+
+// validOriginPattern reports whether origin is scheme://host[:port], where
+// the host may start with a "*." wildcard label
+func validOriginPattern(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	if u.Host == "" || u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return false
+	}
+	host := strings.TrimPrefix(u.Hostname(), "*.")
+	return host != "" && !strings.Contains(host, "*")
+}
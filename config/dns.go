@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNSConfig configures the resolver used for backend hostnames. When no
+// servers are listed the system resolver is used.
+type DNSConfig struct {
+	Servers       []string      `yaml:"servers"` // host or host:port
+	Timeout       time.Duration `yaml:"timeout"`
+	SearchDomains []string      `yaml:"search_domains"`
+	TLS           bool          `yaml:"tls"`             // DNS-over-TLS (port 853 by default)
+	TLSServerName string        `yaml:"tls_server_name"` // certificate name of the DoT servers
+
+	// RefreshInterval is how often hostnames of backends with resolve are
+	// looked up again
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// DialConfig controls dual-stack dialing to a single backend
+type DialConfig struct {
+	AddressFamily string        `yaml:"address_family"` // any, ipv4, ipv6
+	Prefer        string        `yaml:"prefer"`         // ipv4 or ipv6; empty keeps resolver order
+	FallbackDelay time.Duration `yaml:"fallback_delay"` // Happy Eyeballs delay; negative dials addresses one by one
+}
+
+func (d *DNSConfig) setDefaults() {
+	if len(d.Servers) > 0 && d.Timeout == 0 {
+		d.Timeout = 5 * time.Second
+	}
+	if d.RefreshInterval == 0 {
+		d.RefreshInterval = 30 * time.Second
+	}
+}
+
+func (d *DNSConfig) validate() error {
+	for _, server := range d.Servers {
+		host := server
+		if h, _, err := net.SplitHostPort(server); err == nil {
+			host = h
+		}
+		if host == "" {
+			return fmt.Errorf("dns: invalid server address %q", server)
+		}
+	}
+	if d.Timeout < 0 {
+		return fmt.Errorf("dns timeout must be non-negative")
+	}
+	if d.RefreshInterval < 0 {
+		return fmt.Errorf("dns refresh_interval must be non-negative")
+	}
+	for _, domain := range d.SearchDomains {
+		if domain == "" || strings.HasPrefix(domain, ".") {
+			return fmt.Errorf("dns: invalid search domain %q", domain)
+		}
+	}
+	if d.TLS && len(d.Servers) == 0 {
+		return fmt.Errorf("dns: tls requires at least one server")
+	}
+	return nil
+}
+
+func (d *DialConfig) validate() error {
+	switch d.AddressFamily {
+	case "", "any", "ipv4", "ipv6":
+	default:
+		return fmt.Errorf("invalid dial address_family: %s (must be one of: any, ipv4, ipv6)", d.AddressFamily)
+	}
+	switch d.Prefer {
+	case "", "ipv4", "ipv6":
+	default:
+		return fmt.Errorf("invalid dial prefer: %s (must be one of: ipv4, ipv6)", d.Prefer)
+	}
+	return nil
+}
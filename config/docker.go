@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// DockerConfig registers running containers as backends from their labels,
+// routing requests for the host named by <label_prefix>.host to the
+// container's <label_prefix>.port
+type DockerConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	Endpoint    string `yaml:"endpoint"`     // unix:// socket or tcp://host:port of the daemon
+	LabelPrefix string `yaml:"label_prefix"` // prefix of the labels read from containers
+	Network     string `yaml:"network"`      // network whose address is used; the first one by default
+}
+
+func (d *DockerConfig) setDefaults() {
+	if d.Endpoint == "" {
+		d.Endpoint = "unix:///var/run/docker.sock"
+	}
+	if d.LabelPrefix == "" {
+		d.LabelPrefix = "reverseproxy"
+	}
+}
+
+func (d *DockerConfig) validate() error {
+	if !d.Enabled {
+		return nil
+	}
+	u, err := url.Parse(d.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid docker endpoint %s: %w", d.Endpoint, err)
+	}
+	switch {
+	case u.Scheme == "unix" && u.Path != "":
+	case u.Scheme == "tcp" && u.Host != "":
+	default:
+		return fmt.Errorf("docker endpoint must be unix:///path or tcp://host:port")
+	}
+	return nil
+}
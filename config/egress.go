@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// EgressConfig restricts which destinations the proxy may open connections to.
+// Link-local and cloud metadata ranges are always denied unless AllowLinkLocal is set.
+type EgressConfig struct {
+	AllowedCIDRs   []string `yaml:"allowed_cidrs"`
+	AllowedHosts   []string `yaml:"allowed_hosts"` // exact names or wildcards like *.internal.example.com
+	DeniedCIDRs    []string `yaml:"denied_cidrs"`
+	AllowLinkLocal bool     `yaml:"allow_link_local"`
+}
+
+// EgressProxyConfig routes connections to a backend through an upstream
+// SOCKS5 or HTTP CONNECT proxy
+type EgressProxyConfig struct {
+	URL      string `yaml:"url"` // socks5://host:port, http://host:port or https://host:port
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+func (e *EgressConfig) validate() error {
+	for _, cidr := range e.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("egress allowed_cidrs: invalid CIDR %s: %w", cidr, err)
+		}
+	}
+	for _, cidr := range e.DeniedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("egress denied_cidrs: invalid CIDR %s: %w", cidr, err)
+		}
+	}
+	for _, host := range e.AllowedHosts {
+		if host == "" || strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+			return fmt.Errorf("egress allowed_hosts: invalid host pattern %q", host)
+		}
+	}
+	return nil
+}
+
+func (e *EgressProxyConfig) validate() error {
+	if e.URL == "" {
+		return fmt.Errorf("egress_proxy url is required")
+	}
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return fmt.Errorf("invalid egress_proxy url %s: %w", e.URL, err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http", "https":
+	default:
+		return fmt.Errorf("invalid egress_proxy scheme: %s (must be one of: socks5, socks5h, http, https)", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("egress_proxy url %s has no host", e.URL)
+	}
+	return nil
+}
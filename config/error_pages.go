@@ -0,0 +1,33 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ErrorPagesConfig replaces the plain-text bodies of errors the proxy
+// generates itself when it cannot get a response from a backend. Pages are
+// Go templates; their Content-Type follows the file extension.
+type ErrorPagesConfig struct {
+	Pages   map[int]string `yaml:"pages"`   // status -> template file
+	Default string         `yaml:"default"` // template for statuses without their own page
+}
+
+// ErrorPageStatuses are the statuses served from error page templates
+var ErrorPageStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+func (e *ErrorPagesConfig) validate() error {
+	for status, file := range e.Pages {
+		supported := false
+		for _, s := range ErrorPageStatuses {
+			supported = supported || s == status
+		}
+		if !supported {
+			return fmt.Errorf("error_pages: unsupported status %d (supported: %v)", status, ErrorPageStatuses)
+		}
+		if file == "" {
+			return fmt.Errorf("error_pages: page for status %d requires a file", status)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// FaultConfig injects artificial failures into proxied requests so clients'
+// retry and timeout behavior can be tested. Rules can also be replaced at
+// runtime through the admin API.
+type FaultConfig struct {
+	Enabled bool        `yaml:"enabled"`
+	Rules   []FaultRule `yaml:"rules"`
+}
+
+// FaultRule applies faults to requests whose path starts with PathPrefix.
+// Percentages are independent: a request may be both delayed and aborted.
+type FaultRule struct {
+	Name         string        `yaml:"name"`
+	PathPrefix   string        `yaml:"path_prefix"`
+	DelayPercent float64       `yaml:"delay_percent"`
+	Delay        time.Duration `yaml:"delay"`
+	AbortPercent float64       `yaml:"abort_percent"` // respond with AbortStatus instead of proxying
+	AbortStatus  int           `yaml:"abort_status"`
+	ResetPercent float64       `yaml:"reset_percent"` // drop the client connection without a response
+}
+
+func (f *FaultConfig) setDefaults() {
+	for i := range f.Rules {
+		f.Rules[i].SetDefaults()
+	}
+}
+
+func (f *FaultConfig) validate() error {
+	for i := range f.Rules {
+		if err := f.Rules[i].Validate(); err != nil {
+			return fmt.Errorf("fault rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// SetDefaults fills in unset fields of the rule
+func (r *FaultRule) SetDefaults() {
+	if r.PathPrefix == "" {
+		r.PathPrefix = "/"
+	}
+	if r.AbortStatus == 0 {
+		r.AbortStatus = http.StatusServiceUnavailable
+	}
+}
+
+// Validate checks that the rule is well formed
+func (r *FaultRule) Validate() error {
+	if !strings.HasPrefix(r.PathPrefix, "/") {
+		return fmt.Errorf("path_prefix must start with /")
+	}
+	for _, p := range []float64{r.DelayPercent, r.AbortPercent, r.ResetPercent} {
+		if p < 0 || p > 100 {
+			return fmt.Errorf("percentages must be between 0 and 100")
+		}
+	}
+	if r.Delay < 0 {
+		return fmt.Errorf("delay must be non-negative")
+	}
+	if r.DelayPercent > 0 && r.Delay == 0 {
+		return fmt.Errorf("delay is required when delay_percent is set")
+	}
+	if r.AbortStatus < 100 || r.AbortStatus > 599 {
+		return fmt.Errorf("invalid abort_status %d", r.AbortStatus)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FlushInterval is how often a response is flushed to the client while it
+// is copied from the backend. A negative value, such as -1, flushes after
+// every write; 0 keeps the default of flushing streamed responses at once
+// and buffering the others.
+type FlushInterval time.Duration
+
+// UnmarshalYAML accepts -1 and 0 as plain numbers besides durations
+func (f *FlushInterval) UnmarshalYAML(value *yaml.Node) error {
+	var n int
+	if err := value.Decode(&n); err == nil {
+		*f = FlushInterval(n)
+		return nil
+	}
+	var d time.Duration
+	if err := value.Decode(&d); err != nil {
+		return err
+	}
+	*f = FlushInterval(d)
+	return nil
+}
+
+// MarshalYAML writes the interval the way UnmarshalYAML reads it
+func (f FlushInterval) MarshalYAML() (interface{}, error) {
+	if f <= 0 {
+		return int(f), nil
+	}
+	return time.Duration(f).String(), nil
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file formats
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+// DetectFormat returns the format of a config file by its extension. Files
+// without a known extension are read as YAML.
+func DetectFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatYAML
+}
+
+// decode parses a config document into cfg. JSON and TOML documents are
+// converted to YAML first, so the yaml field names and custom decoders
+// apply to every format.
+func decode(data []byte, format string, cfg *Config) error {
+	switch format {
+	case FormatYAML:
+	case FormatJSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var doc any
+		if err := dec.Decode(&doc); err != nil {
+			return err
+		}
+		if dec.More() {
+			return fmt.Errorf("unexpected data after the JSON document")
+		}
+		var err error
+		if data, err = yaml.Marshal(jsonNumbers(doc)); err != nil {
+			return err
+		}
+	case FormatTOML:
+		var doc map[string]any
+		if _, err := toml.Decode(string(data), &doc); err != nil {
+			return err
+		}
+		var err error
+		if data, err = yaml.Marshal(doc); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported config format %q", format)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	cleanup, err := resolveSecrets(&doc)
+	if err != nil {
+		return err
+	}
+	if err := doc.Decode(cfg); err != nil {
+		cleanup()
+		return err
+	}
+	cfg.cleanup = cleanup
+	return nil
+}
+
+// jsonNumbers replaces the json.Number values of a decoded document with
+// integers or floats, which YAML writes as plain numbers
+func jsonNumbers(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = jsonNumbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = jsonNumbers(e)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
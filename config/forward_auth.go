@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ForwardAuthConfig delegates the decision to proxy a request to an external
+// authentication service such as oauth2-proxy or Authelia
+type ForwardAuthConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	URL             string        `yaml:"url"`
+	Timeout         time.Duration `yaml:"timeout"`
+	RequestHeaders  []string      `yaml:"request_headers"`  // client headers sent to the service; empty sends all
+	ResponseHeaders []string      `yaml:"response_headers"` // service headers copied onto the proxied request
+	ExcludePaths    []string      `yaml:"exclude_paths"`    // path prefixes proxied without asking the service
+}
+
+func (f *ForwardAuthConfig) setDefaults() {
+	if f.Timeout == 0 {
+		f.Timeout = 5 * time.Second
+	}
+}
+
+func (f *ForwardAuthConfig) validate() error {
+	if !f.Enabled {
+		return nil
+	}
+	u, err := url.Parse(f.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("forward_auth url must be an http or https URL")
+	}
+	if f.Timeout < 0 {
+		return fmt.Errorf("forward_auth timeout must be non-negative")
+	}
+	for _, h := range append(append([]string{}, f.RequestHeaders...), f.ResponseHeaders...) {
+		if !validHeaderName(h) {
+			return fmt.Errorf("forward_auth: invalid header name %q", h)
+		}
+	}
+	for _, p := range f.ExcludePaths {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("forward_auth exclude_paths: %q must start with /", p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package config
+
+import "fmt"
+
+// ForwardedConfig controls how X-Forwarded-* headers from clients are
+// treated. They are only believed when the connection comes from one of the
+// trusted proxies; otherwise they are replaced.
+type ForwardedConfig struct {
+	TrustedProxies []string `yaml:"trusted_proxies"` // addresses or CIDRs
+}
+
+func (f *ForwardedConfig) validate() error {
+	for _, entry := range f.TrustedProxies {
+		if !validAddrOrPrefix(entry) {
+			return fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GeoIPConfig looks up each client's country in a MaxMind GeoLite2 or
+// GeoIP2 Country/City database to control access and inform backends
+type GeoIPConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	Database       string        `yaml:"database"`        // path to the .mmdb file
+	ReloadInterval time.Duration `yaml:"reload_interval"` // how often to check the file for updates
+	Header         string        `yaml:"header"`          // request header carrying the ISO country code; "-" disables it
+	AllowCountries []string      `yaml:"allow_countries"` // ISO 3166-1 alpha-2 codes; empty admits all
+	DenyCountries  []string      `yaml:"deny_countries"`
+	AllowUnknown   bool          `yaml:"allow_unknown"` // admit clients without a country (e.g. private addresses) despite allow_countries
+}
+
+func (g *GeoIPConfig) setDefaults() {
+	if g.ReloadInterval == 0 {
+		g.ReloadInterval = time.Hour
+	}
+	if g.Header == "" {
+		g.Header = "X-Client-Country"
+	}
+}
+
+func (g *GeoIPConfig) validate() error {
+	if !g.Enabled {
+		return nil
+	}
+	if g.Database == "" {
+		return fmt.Errorf("geoip database is required")
+	}
+	if g.ReloadInterval < 0 {
+		return fmt.Errorf("geoip reload_interval must be non-negative")
+	}
+	if g.Header != "-" && !validHeaderName(g.Header) {
+		return fmt.Errorf("geoip: invalid header name %q", g.Header)
+	}
+	for _, c := range append(append([]string{}, g.AllowCountries...), g.DenyCountries...) {
+		if len(c) != 2 || strings.ToUpper(c) != c {
+			return fmt.Errorf("geoip: invalid country code %q (use ISO 3166-1 alpha-2, e.g. DE)", c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package config
+
+import "fmt"
+
+// ConsistentHashConfig configures the consistent-hash algorithm. Requests
+// with the same key go to the same backend, and adding or removing a backend
+// only moves the keys that backend owned.
+type ConsistentHashConfig struct {
+	Key          string  `yaml:"key"`           // path, header or cookie
+	Name         string  `yaml:"name"`          // header or cookie name
+	VirtualNodes int     `yaml:"virtual_nodes"` // ring points per unit of backend weight
+	LoadFactor   float64 `yaml:"load_factor"`   // max in-flight requests per backend relative to the average
+}
+
+func (h *ConsistentHashConfig) setDefaults() {
+	if h.Key == "" {
+		h.Key = "path"
+	}
+	if h.VirtualNodes == 0 {
+		h.VirtualNodes = 160
+	}
+	if h.LoadFactor == 0 {
+		h.LoadFactor = 1.25
+	}
+}
+
+func (h *ConsistentHashConfig) validate() error {
+	switch h.Key {
+	case "path":
+	case "header", "cookie":
+		if h.Name == "" {
+			return fmt.Errorf("consistent_hash name is required for key %s", h.Key)
+		}
+	default:
+		return fmt.Errorf("invalid consistent_hash key: %s (must be one of: path, header, cookie)", h.Key)
+	}
+	if h.VirtualNodes < 1 {
+		return fmt.Errorf("consistent_hash virtual_nodes must be positive")
+	}
+	if h.LoadFactor < 1 {
+		return fmt.Errorf("consistent_hash load_factor must be at least 1")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// HeaderRulesConfig rewrites headers of requests sent to backends and of
+// responses sent to clients. Values may reference request variables such as
+// $remote_addr, $host, $scheme, $method, $uri, $request_uri and $query_string.
+type HeaderRulesConfig struct {
+	Request  HeaderOpsConfig `yaml:"request"`
+	Response HeaderOpsConfig `yaml:"response"`
+}
+
+// HeaderOpsConfig lists header operations, applied as remove, set, then add
+type HeaderOpsConfig struct {
+	Set    map[string]string `yaml:"set"`    // replace any existing values
+	Add    map[string]string `yaml:"add"`    // append to existing values
+	Remove []string          `yaml:"remove"` // delete the header
+}
+
+// headerVariables are the names usable as $name or ${name} in header values
+var headerVariables = map[string]bool{
+	"remote_addr":  true,
+	"host":         true,
+	"scheme":       true,
+	"method":       true,
+	"uri":          true,
+	"request_uri":  true,
+	"query_string": true,
+}
+
+func (h *HeaderRulesConfig) validate() error {
+	if err := h.Request.validate(); err != nil {
+		return fmt.Errorf("request headers: %w", err)
+	}
+	if err := h.Response.validate(); err != nil {
+		return fmt.Errorf("response headers: %w", err)
+	}
+	return nil
+}
+
+func (o *HeaderOpsConfig) validate() error {
+	for _, name := range o.Remove {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	for _, values := range []map[string]string{o.Set, o.Add} {
+		for name, value := range values {
+			if !validHeaderName(name) {
+				return fmt.Errorf("invalid header name %q", name)
+			}
+			if strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("header %s: value must not contain line breaks", name)
+			}
+			var unknown string
+			os.Expand(value, func(v string) string {
+				if !headerVariables[v] && unknown == "" {
+					unknown = v
+				}
+				return ""
+			})
+			if unknown != "" {
+				return fmt.Errorf("header %s: unknown variable $%s", name, unknown)
+			}
+		}
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is an RFC 7230 token
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Health check types
+const (
+	HealthCheckHTTP  = "http"
+	HealthCheckHTTPS = "https"
+	HealthCheckTCP   = "tcp"
+)
+
+// BackendHealthCheckConfig overrides the global health check settings for
+// one backend. Unset fields fall back to the health_check section.
+type BackendHealthCheckConfig struct {
+	Type           string            `yaml:"type"`
+	Path           string            `yaml:"path"`
+	Method         string            `yaml:"method"`
+	Headers        map[string]string `yaml:"headers"` // a Host entry sets the request's Host
+	ExpectedStatus []int             `yaml:"expected_status"`
+	Timeout        time.Duration     `yaml:"timeout"`
+	ExpectedBody   string            `yaml:"expected_body"`
+	Interval       time.Duration     `yaml:"interval"`
+	InitialDelay   time.Duration     `yaml:"initial_delay"`
+	StartUnhealthy *bool             `yaml:"start_unhealthy,omitempty"`
+}
+
+func (h *BackendHealthCheckConfig) validate() error {
+	if h.Path != "" && !strings.HasPrefix(h.Path, "/") {
+		return fmt.Errorf("health_check path must start with /")
+	}
+	if err := validateProbe(h.Type, h.Method, h.Headers); err != nil {
+		return err
+	}
+	for _, code := range h.ExpectedStatus {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid health_check expected_status %d", code)
+		}
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("health_check timeout must be non-negative")
+	}
+	if h.Interval < 0 {
+		return fmt.Errorf("health_check interval must be non-negative")
+	}
+	if h.InitialDelay < 0 {
+		return fmt.Errorf("health_check initial_delay must be non-negative")
+	}
+	return nil
+}
+
+// validateProbe checks the probe settings shared by the global and the
+// per-backend health checks; empty values are inherited
+func validateProbe(typ, method string, headers map[string]string) error {
+	switch typ {
+	case "", HealthCheckHTTP, HealthCheckHTTPS, HealthCheckTCP:
+	default:
+		return fmt.Errorf("invalid health_check type: %s (must be one of: http, https, tcp)", typ)
+	}
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPost:
+	default:
+		return fmt.Errorf("invalid health_check method: %s (must be one of: GET, HEAD, OPTIONS, POST)", method)
+	}
+	for name := range headers {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid health_check header name %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Health notification payload formats
+const (
+	HealthNotifyJSON  = "json"
+	HealthNotifySlack = "slack"
+)
+
+// HealthNotifyConfig posts an event to WebhookURL whenever a backend
+// becomes healthy or unhealthy, by active probes or passive ejection
+type HealthNotifyConfig struct {
+	WebhookURL string            `yaml:"webhook_url"` // empty disables notifications
+	Format     string            `yaml:"format"`      // json, or slack for a Slack-compatible text message
+	Headers    map[string]string `yaml:"headers"`     // sent with every notification, e.g. Authorization
+	Timeout    time.Duration     `yaml:"timeout"`
+}
+
+func (n *HealthNotifyConfig) setDefaults() {
+	if n.Format == "" {
+		n.Format = HealthNotifyJSON
+	}
+	if n.Timeout == 0 {
+		n.Timeout = 5 * time.Second
+	}
+}
+
+func (n *HealthNotifyConfig) validate() error {
+	if n.WebhookURL == "" {
+		return nil
+	}
+	u, err := url.Parse(n.WebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("health_check notify webhook_url must be an http or https URL")
+	}
+	switch n.Format {
+	case HealthNotifyJSON, HealthNotifySlack:
+	default:
+		return fmt.Errorf("invalid health_check notify format: %s (must be one of: json, slack)", n.Format)
+	}
+	for name := range n.Headers {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid health_check notify header name %q", name)
+		}
+	}
+	if n.Timeout <= 0 {
+		return fmt.Errorf("health_check notify timeout must be positive")
+	}
+	return nil
+}
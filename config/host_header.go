@@ -0,0 +1,20 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Host header modes of backends and routes. Any other value is sent to the
+// backend as the Host header.
+const (
+	HostHeaderPreserve = "preserve" // the client's Host, the default
+	HostHeaderBackend  = "backend"  // the host of the backend URL
+)
+
+func validateHostHeader(v string) error {
+	if strings.ContainsAny(v, " \t/\\?#@") {
+		return fmt.Errorf("invalid host_header %q", v)
+	}
+	return nil
+}
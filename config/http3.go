@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// HTTP3Config serves HTTP/3 over QUIC next to the TCP listener. Clients
+// discover it through the Alt-Svc header on TLS responses.
+type HTTP3Config struct {
+	Enabled      bool          `yaml:"enabled"`
+	Address      string        `yaml:"address"`         // UDP address; defaults to the server address
+	AltSvcMaxAge time.Duration `yaml:"alt_svc_max_age"` // how long clients may remember the advertisement
+}
+
+func (h *HTTP3Config) setDefaults(serverAddr string) {
+	if h.Address == "" {
+		h.Address = serverAddr
+	}
+	if h.AltSvcMaxAge == 0 {
+		h.AltSvcMaxAge = 24 * time.Hour
+	}
+}
+
+func (h *HTTP3Config) validate(tls *TLSConfig) error {
+	if !h.Enabled {
+		return nil
+	}
+	if tls == nil || !tls.Enabled {
+		return fmt.Errorf("http3 requires TLS to be enabled")
+	}
+	if tls.MaxVersion != "" && tls.MaxVersion != "1.3" {
+		return fmt.Errorf("http3 requires TLS 1.3 (max_version is %s)", tls.MaxVersion)
+	}
+	if _, _, err := net.SplitHostPort(h.Address); err != nil {
+		return fmt.Errorf("invalid http3 address %q: %w", h.Address, err)
+	}
+	if h.AltSvcMaxAge < 0 {
+		return fmt.Errorf("http3 alt_svc_max_age must be non-negative")
+	}
+	return nil
+}
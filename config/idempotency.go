@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// IdempotencyConfig enables de-duplication of retried requests that carry
+// an idempotency key
+type IdempotencyConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	Header          string        `yaml:"header"`
+	Window          time.Duration `yaml:"window"`
+	Methods         []string      `yaml:"methods"`
+	MaxEntries      int           `yaml:"max_entries"`
+	MaxResponseSize int64         `yaml:"max_response_size"` // larger responses are not stored
+}
+
+func (i *IdempotencyConfig) setDefaults() {
+	if i.Header == "" {
+		i.Header = "Idempotency-Key"
+	}
+	if i.Window == 0 {
+		i.Window = 24 * time.Hour
+	}
+	if len(i.Methods) == 0 {
+		i.Methods = []string{http.MethodPost, http.MethodPatch}
+	}
+	if i.MaxEntries == 0 {
+		i.MaxEntries = 10000
+	}
+	if i.MaxResponseSize == 0 {
+		i.MaxResponseSize = 1024 * 1024 // 1MB
+	}
+}
+
+func (i *IdempotencyConfig) validate() error {
+	if !i.Enabled {
+		return nil
+	}
+	if i.Window < 0 {
+		return fmt.Errorf("idempotency window must be non-negative")
+	}
+	if i.MaxEntries < 0 {
+		return fmt.Errorf("idempotency max_entries must be non-negative")
+	}
+	if i.MaxResponseSize < 0 {
+		return fmt.Errorf("idempotency max_response_size must be non-negative")
+	}
+	for _, m := range i.Methods {
+		if m == "" || strings.ToUpper(m) != m {
+			return fmt.Errorf("idempotency methods: invalid method %q", m)
+		}
+	}
+	return nil
+}
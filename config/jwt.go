@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// JWTConfig requires requests to carry a valid bearer token signed by one
+// of the keys published at JWKSURL
+type JWTConfig struct {
+	Enabled         bool              `yaml:"enabled"`
+	JWKSURL         string            `yaml:"jwks_url"`
+	Issuer          string            `yaml:"issuer"`   // required iss claim, if set
+	Audience        string            `yaml:"audience"` // required aud entry, if set
+	Leeway          time.Duration     `yaml:"leeway"`   // clock skew tolerated for exp and nbf
+	RefreshInterval time.Duration     `yaml:"refresh_interval"`
+	Timeout         time.Duration     `yaml:"timeout"`
+	ExcludePaths    []string          `yaml:"exclude_paths"`     // path prefixes served without a token
+	ClaimsToHeaders map[string]string `yaml:"claims_to_headers"` // claim name -> request header sent to backends
+}
+
+func (j *JWTConfig) setDefaults() {
+	if j.Leeway == 0 {
+		j.Leeway = time.Minute
+	}
+	if j.RefreshInterval == 0 {
+		j.RefreshInterval = time.Hour
+	}
+	if j.Timeout == 0 {
+		j.Timeout = 10 * time.Second
+	}
+}
+
+func (j *JWTConfig) validate() error {
+	if !j.Enabled {
+		return nil
+	}
+	u, err := url.Parse(j.JWKSURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("jwt jwks_url must be an http or https URL")
+	}
+	if j.Leeway < 0 || j.RefreshInterval < 0 || j.Timeout < 0 {
+		return fmt.Errorf("jwt leeway, refresh_interval and timeout must be non-negative")
+	}
+	for _, p := range j.ExcludePaths {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("jwt exclude_paths: %q must start with /", p)
+		}
+	}
+	for claim, header := range j.ClaimsToHeaders {
+		if claim == "" || !validHeaderName(header) {
+			return fmt.Errorf("jwt claims_to_headers: invalid mapping %q -> %q", claim, header)
+		}
+		if http.CanonicalHeaderKey(header) == "Authorization" {
+			return fmt.Errorf("jwt claims_to_headers: cannot overwrite Authorization")
+		}
+	}
+	return nil
+}
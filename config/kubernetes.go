@@ -0,0 +1,24 @@
+package config
+
+import (
+	"fmt"
+)
+
+// DiscoveryKubernetes makes a backend follow the ready endpoints of a
+// Kubernetes Service
+const DiscoveryKubernetes = "kubernetes"
+
+// KubernetesConfig locates the API server that backends with discovery
+// kubernetes are read from. Without a kubeconfig the in-cluster service
+// account is used.
+type KubernetesConfig struct {
+	Kubeconfig string `yaml:"kubeconfig"`
+	Context    string `yaml:"context"` // kubeconfig context; the current context by default
+}
+
+func (k *KubernetesConfig) validate() error {
+	if k.Context != "" && k.Kubeconfig == "" {
+		return fmt.Errorf("kubernetes context requires a kubeconfig")
+	}
+	return nil
+}
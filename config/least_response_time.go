@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// LeastResponseTimeConfig configures the least-response-time algorithm,
+// which prefers the backend with the lowest moving average response time
+// weighted by its outstanding requests
+type LeastResponseTimeConfig struct {
+	DecayTime time.Duration `yaml:"decay_time"` // how quickly older response times lose influence
+}
+
+func (l *LeastResponseTimeConfig) setDefaults() {
+	if l.DecayTime == 0 {
+		l.DecayTime = 10 * time.Second
+	}
+}
+
+func (l *LeastResponseTimeConfig) validate() error {
+	if l.DecayTime < 0 {
+		return fmt.Errorf("least_response_time decay_time must be non-negative")
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import "fmt"
+
+// ListenerConfig is an additional address the proxy serves next to
+// server.address, sharing its timeouts, virtual hosts and middleware
+type ListenerConfig struct {
+	Address       string              `yaml:"address"`
+	TLS           bool                `yaml:"tls"`            // serve HTTPS with the certificates of the tls section
+	RedirectHTTPS bool                `yaml:"redirect_https"` // redirect every request to HTTPS on server.address
+	ProxyProtocol ProxyProtocolConfig `yaml:"proxy_protocol"`
+	Routes        []RouteConfig       `yaml:"routes"` // replace the top-level routes on this listener
+}
+
+func (l *ListenerConfig) setDefaults() {
+	l.ProxyProtocol.setDefaults()
+	for i := range l.Routes {
+		l.Routes[i].setDefaults()
+	}
+}
+
+func (l *ListenerConfig) validate(c *Config) error {
+	if l.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+	tlsEnabled := c.TLS != nil && c.TLS.Enabled
+	if l.TLS && !tlsEnabled {
+		return fmt.Errorf("tls requires the tls section to be enabled")
+	}
+	if l.RedirectHTTPS {
+		if !tlsEnabled {
+			return fmt.Errorf("redirect_https requires TLS on the server address")
+		}
+		if l.TLS || len(l.Routes) > 0 {
+			return fmt.Errorf("redirect_https cannot be combined with tls or routes")
+		}
+	}
+	if len(l.Routes) > 0 && c.UnknownHost.Enabled() {
+		return fmt.Errorf("routes cannot be combined with unknown_host")
+	}
+	if err := l.ProxyProtocol.validate(); err != nil {
+		return err
+	}
+	for i := range l.Routes {
+		if err := l.Routes[i].validate(c.Pools); err != nil {
+			return fmt.Errorf("route %d: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Log outputs other than a file path
+const (
+	LogOutputStdout = "stdout"
+	LogOutputStderr = "stderr"
+)
+
+// LogRotationConfig rotates a log file by size or age. Rotated files are
+// renamed with a timestamp next to the log file.
+type LogRotationConfig struct {
+	MaxSize    int64         `yaml:"max_size"`    // bytes; 0 doesn't rotate by size
+	MaxAge     time.Duration `yaml:"max_age"`     // 0 doesn't rotate by age
+	MaxBackups int           `yaml:"max_backups"` // rotated files kept; 0 keeps all
+	Compress   bool          `yaml:"compress"`    // gzip rotated files
+}
+
+func (r *LogRotationConfig) validate() error {
+	if r.MaxSize < 0 || r.MaxAge < 0 || r.MaxBackups < 0 {
+		return fmt.Errorf("logging rotation settings must be non-negative")
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/schedule"
+)
+
+// MaintenanceWindow describes a recurring window during which a backend is
+// taken out of rotation. Schedule is a cron expression for the window start.
+type MaintenanceWindow struct {
+	Schedule    string        `yaml:"schedule"`
+	Duration    time.Duration `yaml:"duration"`
+	DrainBefore time.Duration `yaml:"drain_before"` // stop new traffic this long before the window
+	Timezone    string        `yaml:"timezone"`     // IANA name; defaults to local time
+}
+
+func (m *MaintenanceWindow) validate() error {
+	if _, err := schedule.Parse(m.Schedule); err != nil {
+		return fmt.Errorf("maintenance schedule: %w", err)
+	}
+	if m.Duration <= 0 {
+		return fmt.Errorf("maintenance duration must be positive")
+	}
+	if m.DrainBefore < 0 {
+		return fmt.Errorf("maintenance drain_before must be non-negative")
+	}
+	if m.Timezone != "" {
+		if _, err := time.LoadLocation(m.Timezone); err != nil {
+			return fmt.Errorf("maintenance timezone: %w", err)
+		}
+	}
+	return nil
+}
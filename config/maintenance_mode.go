@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaintenanceModeConfig takes the whole proxy out of service, answering
+// 503 with a maintenance page. Routes can be put into maintenance on their
+// own with their maintenance flag, and both can be toggled through the
+// admin API.
+type MaintenanceModeConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	RetryAfter time.Duration `yaml:"retry_after"` // sent as Retry-After; rounded to seconds
+	Page       string        `yaml:"page"`        // template file; defaults to the 503 error page
+	Allow      []string      `yaml:"allow"`       // addresses or CIDRs still let through, e.g. for testing
+}
+
+func (m *MaintenanceModeConfig) setDefaults() {
+	if m.RetryAfter == 0 {
+		m.RetryAfter = 5 * time.Minute
+	}
+}
+
+func (m *MaintenanceModeConfig) validate() error {
+	if m.RetryAfter < time.Second {
+		return fmt.Errorf("maintenance_mode: retry_after must be at least 1s")
+	}
+	for _, entry := range m.Allow {
+		if !validAddrOrPrefix(entry) {
+			return fmt.Errorf("maintenance_mode: invalid address or CIDR %q", entry)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// MetricsConfig shapes the metrics served on the admin API and pushed to
+// StatsD
+type MetricsConfig struct {
+	LatencyBuckets []time.Duration `yaml:"latency_buckets"` // upper bounds of the upstream latency histograms
+
+	// UpstreamDurationHeader adds an X-Upstream-Duration header with the
+	// backend's connect time and time to first byte to responses, for
+	// debugging
+	UpstreamDurationHeader bool `yaml:"upstream_duration_header"`
+
+	// StatsD pushes the metrics to a StatsD agent besides serving them
+	StatsD StatsDConfig `yaml:"statsd"`
+}
+
+func (m *MetricsConfig) setDefaults() {
+	if len(m.LatencyBuckets) == 0 {
+		m.LatencyBuckets = []time.Duration{
+			5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+			50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+			500 * time.Millisecond, time.Second, 2500 * time.Millisecond,
+			5 * time.Second, 10 * time.Second,
+		}
+	}
+	m.StatsD.setDefaults()
+}
+
+func (m *MetricsConfig) validate() error {
+	for i, b := range m.LatencyBuckets {
+		if b <= 0 || (i > 0 && b <= m.LatencyBuckets[i-1]) {
+			return fmt.Errorf("metrics latency_buckets must be positive and increasing")
+		}
+	}
+	return m.StatsD.validate()
+}
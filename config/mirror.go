@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// MirrorConfig sends a copy of a share of a route's requests to a shadow
+// backend in the background. The shadow's responses are discarded, so a new
+// service version can be tried with production traffic without affecting
+// clients.
+type MirrorConfig struct {
+	URL         string            `yaml:"url"`
+	TLS         *BackendTLSConfig `yaml:"tls,omitempty"`
+	Percent     float64           `yaml:"percent"`
+	Timeout     time.Duration     `yaml:"timeout"`
+	MaxBodySize int64             `yaml:"max_body_size"` // requests with larger bodies are not mirrored
+}
+
+func (m *MirrorConfig) setDefaults() {
+	if m.Percent == 0 {
+		m.Percent = 100
+	}
+	if m.Timeout == 0 {
+		m.Timeout = 5 * time.Second
+	}
+	if m.MaxBodySize == 0 {
+		m.MaxBodySize = 1024 * 1024 // 1MB
+	}
+}
+
+func (m *MirrorConfig) validate() error {
+	u, err := url.Parse(m.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("mirror url must be an http or https URL")
+	}
+	if m.Percent < 0 || m.Percent > 100 {
+		return fmt.Errorf("mirror percent must be between 0 and 100")
+	}
+	if m.Timeout < 0 {
+		return fmt.Errorf("mirror timeout must be non-negative")
+	}
+	if m.MaxBodySize < 0 {
+		return fmt.Errorf("mirror max_body_size must be non-negative")
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OpenAPIConfig validates the requests of a route against an OpenAPI 3
+// document before they are proxied
+type OpenAPIConfig struct {
+	Spec     string `yaml:"spec"`      // path of the YAML or JSON document
+	BasePath string `yaml:"base_path"` // prefix of request paths not part of the document's paths
+}
+
+func (o *OpenAPIConfig) validate() error {
+	if o.Spec == "" {
+		return fmt.Errorf("openapi spec is required")
+	}
+	if o.BasePath != "" && !strings.HasPrefix(o.BasePath, "/") {
+		return fmt.Errorf("openapi base_path must start with /")
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// PassiveHealthCheckConfig ejects backends whose live traffic fails too
+// often, without waiting for the next active probe
+type PassiveHealthCheckConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Window       time.Duration `yaml:"window"`        // period over which the failure rate is measured
+	MinRequests  int           `yaml:"min_requests"`  // requests needed in a window before judging it
+	FailureRate  float64       `yaml:"failure_rate"`  // share of connection errors and 5xx responses, 0.5 = 50%
+	EjectionTime time.Duration `yaml:"ejection_time"` // how long an ejected backend receives no traffic
+}
+
+func (p *PassiveHealthCheckConfig) setDefaults() {
+	if p.Window == 0 {
+		p.Window = 10 * time.Second
+	}
+	if p.MinRequests == 0 {
+		p.MinRequests = 10
+	}
+	if p.FailureRate == 0 {
+		p.FailureRate = 0.5
+	}
+	if p.EjectionTime == 0 {
+		p.EjectionTime = 30 * time.Second
+	}
+}
+
+func (p *PassiveHealthCheckConfig) validate() error {
+	if !p.Enabled {
+		return nil
+	}
+	if p.Window <= 0 {
+		return fmt.Errorf("passive health_check window must be positive")
+	}
+	if p.MinRequests < 1 {
+		return fmt.Errorf("passive health_check min_requests must be positive")
+	}
+	if p.FailureRate <= 0 || p.FailureRate > 1 {
+		return fmt.Errorf("passive health_check failure_rate must be between 0 and 1")
+	}
+	if p.EjectionTime <= 0 {
+		return fmt.Errorf("passive health_check ejection_time must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// PluginConfig loads a WebAssembly module that runs custom logic on every
+// request and response, so bespoke rules don't require forking the proxy.
+// Plugins run in the order they are listed.
+type PluginConfig struct {
+	Name    string        `yaml:"name"`
+	Path    string        `yaml:"path"`    // the .wasm module
+	Config  string        `yaml:"config"`  // handed to the module as is
+	Timeout time.Duration `yaml:"timeout"` // for each call into the module
+
+	// MaxInstances bounds the module instances running at once, each
+	// serving one call at a time; 0 is one per CPU
+	MaxInstances int `yaml:"max_instances"`
+
+	// FailOpen lets requests through when the plugin fails or times out;
+	// by default they are answered with 500
+	FailOpen bool `yaml:"fail_open"`
+}
+
+func (p *PluginConfig) setDefaults() {
+	if p.Timeout == 0 {
+		p.Timeout = 100 * time.Millisecond
+	}
+}
+
+func (p *PluginConfig) validate() error {
+	if p.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if p.Timeout < 0 {
+		return fmt.Errorf("timeout must be non-negative")
+	}
+	if p.MaxInstances < 0 {
+		return fmt.Errorf("max_instances must be non-negative")
+	}
+	return nil
+}
+
+func validatePlugins(plugins []PluginConfig) error {
+	seen := make(map[string]bool, len(plugins))
+	for i := range plugins {
+		p := &plugins[i]
+		if p.Name == "" {
+			return fmt.Errorf("plugin %d: name is required", i)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("duplicate plugin %q", p.Name)
+		}
+		seen[p.Name] = true
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("plugin %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
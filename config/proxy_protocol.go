@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProxyProtocolConfig accepts PROXY protocol (v1 or v2) headers from L4
+// load balancers so the original client address is known
+type ProxyProtocolConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	AllowedSources []string      `yaml:"allowed_sources"` // peers that must send a header; empty means all
+	HeaderTimeout  time.Duration `yaml:"header_timeout"`
+}
+
+func (p *ProxyProtocolConfig) setDefaults() {
+	if p.HeaderTimeout == 0 {
+		p.HeaderTimeout = 5 * time.Second
+	}
+}
+
+func (p *ProxyProtocolConfig) validate() error {
+	if !p.Enabled {
+		return nil
+	}
+	for _, entry := range p.AllowedSources {
+		if !validAddrOrPrefix(entry) {
+			return fmt.Errorf("invalid proxy_protocol allowed source %q", entry)
+		}
+	}
+	if p.HeaderTimeout < 0 {
+		return fmt.Errorf("proxy_protocol header_timeout must be non-negative")
+	}
+	return nil
+}
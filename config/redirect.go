@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RedirectRule answers matching requests with a redirect instead of proxying
+// them. Exactly one of HTTPS, StripWWW and To says where to.
+type RedirectRule struct {
+	Host     string `yaml:"host"`      // only requests for this host; empty matches any
+	Path     string `yaml:"path"`      // regular expression the path must match
+	HTTPS    bool   `yaml:"https"`     // plain HTTP requests go to the same URL over HTTPS
+	StripWWW bool   `yaml:"strip_www"` // requests for www.<domain> go to <domain>
+	To       string `yaml:"to"`        // absolute URL or path; may use the path's $1 captures
+	Status   int    `yaml:"status"`    // 301 (default), 302, 307 or 308
+}
+
+var validRedirectStatus = map[int]bool{
+	301: true,
+	302: true,
+	307: true,
+	308: true,
+}
+
+func (r *RedirectRule) setDefaults() {
+	if r.Status == 0 {
+		r.Status = 301
+	}
+}
+
+func (r *RedirectRule) validate() error {
+	actions := 0
+	for _, set := range []bool{r.HTTPS, r.StripWWW, r.To != ""} {
+		if set {
+			actions++
+		}
+	}
+	if actions != 1 {
+		return fmt.Errorf("exactly one of https, strip_www and to is required")
+	}
+	if !validRedirectStatus[r.Status] {
+		return fmt.Errorf("invalid status %d, must be 301, 302, 307 or 308", r.Status)
+	}
+	if r.Path != "" {
+		if _, err := regexp.Compile(r.Path); err != nil {
+			return fmt.Errorf("invalid path: %w", err)
+		}
+	}
+	if r.To != "" && !strings.HasPrefix(r.To, "/") {
+		u, err := url.Parse(r.To)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("to must be a path or an absolute http(s) URL")
+		}
+	}
+	return nil
+}
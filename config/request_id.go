@@ -0,0 +1,23 @@
+package config
+
+import "fmt"
+
+// RequestIDConfig tags every request with a unique ID, or the one the
+// client sent, so it can be correlated across the proxy and backends
+type RequestIDConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Header  string `yaml:"header"` // carries the ID in requests to backends and in responses
+}
+
+func (r *RequestIDConfig) setDefaults() {
+	if r.Header == "" {
+		r.Header = "X-Request-ID"
+	}
+}
+
+func (r *RequestIDConfig) validate() error {
+	if !validHeaderName(r.Header) {
+		return fmt.Errorf("invalid request_id header %q", r.Header)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// RetryConfig re-dispatches failed requests with idempotent methods, or
+// carrying an idempotency key, to another backend
+type RetryConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	MaxAttempts    int           `yaml:"max_attempts"` // total attempts including the first
+	Backoff        time.Duration `yaml:"backoff"`      // delay before the first retry, doubled for each further one
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	StatusCodes    []int         `yaml:"status_codes"`    // backend responses that are retried
+	Methods        []string      `yaml:"methods"`         // GET, HEAD and OPTIONS unless set
+	IdempotencyKey string        `yaml:"idempotency_key"` // header making any request retryable; "-" disables
+	MaxBodySize    int64         `yaml:"max_body_size"`   // larger request bodies are not buffered for retry
+}
+
+func (r *RetryConfig) setDefaults() {
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = 3
+	}
+	if r.Backoff == 0 {
+		r.Backoff = 50 * time.Millisecond
+	}
+	if r.MaxBackoff == 0 {
+		r.MaxBackoff = time.Second
+	}
+	if r.StatusCodes == nil {
+		r.StatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	}
+	if len(r.Methods) == 0 {
+		r.Methods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+	}
+	if r.IdempotencyKey == "" {
+		r.IdempotencyKey = "Idempotency-Key"
+	}
+	if r.MaxBodySize == 0 {
+		r.MaxBodySize = 1024 * 1024 // 1MB
+	}
+}
+
+func (r *RetryConfig) validate() error {
+	if !r.Enabled {
+		return nil
+	}
+	if r.MaxAttempts < 1 {
+		return fmt.Errorf("retry max_attempts must be at least 1")
+	}
+	if r.Backoff < 0 || r.MaxBackoff < 0 {
+		return fmt.Errorf("retry backoff must be non-negative")
+	}
+	for _, code := range r.StatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid retry status code %d", code)
+		}
+	}
+	for _, m := range r.Methods {
+		if m == http.MethodPost || m == http.MethodPatch {
+			return fmt.Errorf("retry method %s is not idempotent; send an %s header instead", m, r.IdempotencyKey)
+		}
+	}
+	if r.MaxBodySize < 0 {
+		return fmt.Errorf("retry max_body_size must be non-negative")
+	}
+	return nil
+}
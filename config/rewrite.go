@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RewriteRule changes the request URL before it is proxied. Match is a
+// regular expression tested against the path, followed by ? and the query
+// when there is one. Replace may refer to capture groups as $1 or ${name},
+// and a ? in the result starts the new query.
+type RewriteRule struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+	Last    bool   `yaml:"last"` // skip the remaining rules when this one matches
+}
+
+func (r *RewriteRule) validate() error {
+	if r.Match == "" {
+		return fmt.Errorf("rewrite match is required")
+	}
+	if _, err := regexp.Compile(r.Match); err != nil {
+		return fmt.Errorf("invalid rewrite match %q: %w", r.Match, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RouteMatchConfig limits a route to requests carrying the listed headers
+// and cookies. A value matches exactly, "*" matches any non-empty value and
+// a value starting with ~ is a regular expression.
+type RouteMatchConfig struct {
+	Headers map[string]string `yaml:"headers"`
+	Cookies map[string]string `yaml:"cookies"`
+}
+
+func (m *RouteMatchConfig) validate() error {
+	if len(m.Headers) == 0 && len(m.Cookies) == 0 {
+		return fmt.Errorf("match requires headers or cookies")
+	}
+	for name, value := range m.Headers {
+		if !validHeaderName(name) {
+			return fmt.Errorf("match: invalid header name %q", name)
+		}
+		if err := validateMatchValue(value); err != nil {
+			return fmt.Errorf("match header %s: %w", name, err)
+		}
+	}
+	for name, value := range m.Cookies {
+		if name == "" {
+			return fmt.Errorf("match: empty cookie name")
+		}
+		if err := validateMatchValue(value); err != nil {
+			return fmt.Errorf("match cookie %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func validateMatchValue(value string) error {
+	if expr, ok := strings.CutPrefix(value, "~"); ok {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid regular expression: %w", err)
+		}
+	}
+	return nil
+}
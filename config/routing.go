@@ -0,0 +1,302 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PoolConfig is a named group of backends that routes can send traffic to,
+// balanced with its own algorithm
+type PoolConfig struct {
+	Backends    []Backend                 `yaml:"backends"`
+	Algorithm   string                    `yaml:"algorithm"`              // defaults to load_balancer.algorithm
+	HealthCheck *BackendHealthCheckConfig `yaml:"health_check,omitempty"` // for backends without their own
+	Timeouts    *TimeoutsConfig           `yaml:"timeouts,omitempty"`
+
+	// UpstreamsFile lists further backend URLs, one per line, and is
+	// watched so changes apply without a restart
+	UpstreamsFile string `yaml:"upstreams_file"`
+
+	// ConsistentHash replaces load_balancer.consistent_hash for this pool,
+	// so e.g. a cache tier can hash on the path while another pool hashes
+	// on a header
+	ConsistentHash *ConsistentHashConfig `yaml:"consistent_hash,omitempty"`
+}
+
+// LoadBalancer is global with the pool's algorithm and settings in place of
+// the global ones
+func (p *PoolConfig) LoadBalancer(global LoadBalancerConfig) LoadBalancerConfig {
+	global.Algorithm = p.Algorithm
+	if p.ConsistentHash != nil {
+		global.ConsistentHash = *p.ConsistentHash
+	}
+	return global
+}
+
+func (p *PoolConfig) setDefaults(algorithm string) {
+	if p.Algorithm == "" {
+		p.Algorithm = algorithm
+	}
+	if p.ConsistentHash != nil {
+		p.ConsistentHash.setDefaults()
+	}
+	if p.HealthCheck == nil {
+		return
+	}
+	for i := range p.Backends {
+		if p.Backends[i].HealthCheck == nil {
+			p.Backends[i].HealthCheck = p.HealthCheck
+		}
+	}
+}
+
+func (p *PoolConfig) validate() error {
+	if len(p.Backends) == 0 && p.UpstreamsFile == "" {
+		return fmt.Errorf("at least one backend or an upstreams_file is required")
+	}
+	if !validAlgorithms[p.Algorithm] {
+		return fmt.Errorf("invalid algorithm %q", p.Algorithm)
+	}
+	if p.ConsistentHash != nil {
+		if err := p.ConsistentHash.validate(); err != nil {
+			return err
+		}
+	}
+	if p.HealthCheck != nil {
+		if err := p.HealthCheck.validate(); err != nil {
+			return err
+		}
+	}
+	if p.Timeouts != nil {
+		if err := p.Timeouts.validate(); err != nil {
+			return err
+		}
+	}
+	for i, backend := range p.Backends {
+		if err := backend.validate(); err != nil {
+			return fmt.Errorf("backend %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// RouteConfig sends requests whose path matches PathPrefix or PathRegex to
+// the named pool. Routes are evaluated in order and the first match wins;
+// unmatched requests go to the top-level backends.
+type RouteConfig struct {
+	PathPrefix string             `yaml:"path_prefix"`
+	PathRegex  string             `yaml:"path_regex"`
+	Pool       string             `yaml:"pool"`
+	Headers    *HeaderRulesConfig `yaml:"headers,omitempty"` // applied after the global header rules
+	CacheTTL   time.Duration      `yaml:"cache_ttl"`         // overrides the backend's freshness lifetime when caching
+	BasicAuth  *BasicAuthConfig   `yaml:"basic_auth,omitempty"`
+	Access     *AccessConfig      `yaml:"access,omitempty"` // checked after the global access lists
+
+	// SecurityHeaders turns the global security headers on or off for
+	// this route; unset follows security_headers.enabled
+	SecurityHeaders *bool `yaml:"security_headers,omitempty"`
+
+	// Maintenance answers the route's requests with the maintenance page,
+	// as if maintenance_mode were enabled for this route only
+	Maintenance bool `yaml:"maintenance"`
+
+	// Mirror copies requests to a shadow backend, discarding its responses
+	Mirror *MirrorConfig `yaml:"mirror,omitempty"`
+
+	// Timeouts override those of the route's pool
+	Timeouts *TimeoutsConfig `yaml:"timeouts,omitempty"`
+
+	// MaxRequestBodySize overrides limits.max_request_body_size
+	MaxRequestBodySize int64 `yaml:"max_request_body_size"`
+
+	// FlushInterval controls how responses are flushed to clients, e.g.
+	// -1 for server-sent events behind a backend that doesn't mark them
+	FlushInterval FlushInterval `yaml:"flush_interval"`
+
+	// StripPrefix is removed from and AddPrefix put in front of the path
+	// sent to the backend, before any rewrite rules apply
+	StripPrefix string `yaml:"strip_prefix"`
+	AddPrefix   string `yaml:"add_prefix"`
+
+	// Rewrite rules apply in order to the URL sent to the backend
+	Rewrite []RewriteRule `yaml:"rewrite,omitempty"`
+
+	// HostHeader overrides the host_header of the route's backends
+	HostHeader string `yaml:"host_header"`
+
+	// Signature requires HMAC-signed requests, for machine-to-machine APIs
+	Signature *SignatureConfig `yaml:"signature,omitempty"`
+
+	// OpenAPI rejects requests that don't conform to the route's API
+	// description with 400
+	OpenAPI *OpenAPIConfig `yaml:"openapi,omitempty"`
+
+	// Static serves the route from a local directory in place of a pool
+	Static *StaticConfig `yaml:"static,omitempty"`
+
+	// Match further limits the route to requests with certain headers or
+	// cookies, e.g. to steer a test group to another pool
+	Match *RouteMatchConfig `yaml:"match,omitempty"`
+
+	// Tracing turns B3 tracing on or off for this route; unset follows
+	// tracing.enabled
+	Tracing *bool `yaml:"tracing,omitempty"`
+
+	// UpstreamEncoding controls the encodings backends may respond with:
+	// identity so the proxy gets bodies it can inspect, or decompress to
+	// gunzip responses for clients that can't take them
+	UpstreamEncoding string `yaml:"upstream_encoding"`
+
+	// Cache adds request headers, cookies or selected query parameters to
+	// the cache key of the route's responses
+	Cache *RouteCacheConfig `yaml:"cache,omitempty"`
+
+	// EarlyHints are Link header values, e.g. "</app.css>; rel=preload;
+	// as=style", sent in a 103 Early Hints response to GET requests while
+	// the backend prepares its own
+	EarlyHints []string `yaml:"early_hints,omitempty"`
+
+	// Stream suits large downloads and video: the route's responses
+	// bypass the response cache, so they are never held in memory, and
+	// are copied from backends in large chunks
+	Stream bool `yaml:"stream"`
+
+	// SLO counts the route's requests that miss its objective in the
+	// metrics, for tracking a service's edge SLO
+	SLO *SLOConfig `yaml:"slo,omitempty"`
+}
+
+func (r *RouteConfig) setDefaults() {
+	if r.BasicAuth != nil {
+		r.BasicAuth.setDefaults()
+	}
+	if r.Mirror != nil {
+		r.Mirror.setDefaults()
+	}
+	if r.Signature != nil {
+		r.Signature.setDefaults()
+	}
+	if r.Static != nil {
+		r.Static.setDefaults()
+	}
+}
+
+func (r *RouteConfig) validate(pools map[string]PoolConfig) error {
+	if (r.PathPrefix == "") == (r.PathRegex == "") {
+		return fmt.Errorf("exactly one of path_prefix or path_regex is required")
+	}
+	if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+		return fmt.Errorf("path_prefix must start with /")
+	}
+	if r.PathRegex != "" {
+		if _, err := regexp.Compile(r.PathRegex); err != nil {
+			return fmt.Errorf("invalid path_regex %q: %w", r.PathRegex, err)
+		}
+	}
+	if r.Static != nil {
+		if r.Pool != "" {
+			return fmt.Errorf("pool and static cannot be combined")
+		}
+		if err := r.Static.validate(); err != nil {
+			return err
+		}
+	} else if _, ok := pools[r.Pool]; !ok {
+		return fmt.Errorf("unknown pool %q", r.Pool)
+	}
+	if r.CacheTTL < 0 {
+		return fmt.Errorf("cache_ttl must be non-negative")
+	}
+	if r.Cache != nil {
+		if err := r.Cache.validate(); err != nil {
+			return err
+		}
+	}
+	if r.MaxRequestBodySize < 0 {
+		return fmt.Errorf("max_request_body_size must be non-negative")
+	}
+	if r.Headers != nil {
+		if err := r.Headers.validate(); err != nil {
+			return err
+		}
+	}
+	if r.Access != nil {
+		if err := r.Access.validate(); err != nil {
+			return err
+		}
+	}
+	if r.BasicAuth != nil {
+		if err := r.BasicAuth.validate(); err != nil {
+			return err
+		}
+	}
+	if r.Mirror != nil {
+		if err := r.Mirror.validate(); err != nil {
+			return err
+		}
+	}
+	if r.Signature != nil {
+		if err := r.Signature.validate(); err != nil {
+			return err
+		}
+	}
+	if r.OpenAPI != nil {
+		if err := r.OpenAPI.validate(); err != nil {
+			return err
+		}
+	}
+	if r.Match != nil {
+		if err := r.Match.validate(); err != nil {
+			return err
+		}
+	}
+	if r.Timeouts != nil {
+		if err := r.Timeouts.validate(); err != nil {
+			return err
+		}
+	}
+	if r.StripPrefix != "" && !strings.HasPrefix(r.StripPrefix, "/") {
+		return fmt.Errorf("strip_prefix must start with /")
+	}
+	if r.AddPrefix != "" && !strings.HasPrefix(r.AddPrefix, "/") {
+		return fmt.Errorf("add_prefix must start with /")
+	}
+	if err := validateHostHeader(r.HostHeader); err != nil {
+		return err
+	}
+	if err := validateUpstreamEncoding(r.UpstreamEncoding); err != nil {
+		return err
+	}
+	if r.SLO != nil {
+		if err := r.SLO.validate(); err != nil {
+			return err
+		}
+	}
+	for _, link := range r.EarlyHints {
+		if !strings.HasPrefix(link, "<") || !strings.Contains(link, ">") || strings.ContainsAny(link, "\r\n") {
+			return fmt.Errorf("invalid early_hints link %q", link)
+		}
+	}
+	for i := range r.Rewrite {
+		if err := r.Rewrite[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateDefaultPool checks the default_pool of the top level or a virtual
+// host, which replaces its backends
+func validateDefaultPool(name string, backends []Backend, pools map[string]PoolConfig) error {
+	if name == "" {
+		return nil
+	}
+	if len(backends) > 0 {
+		return fmt.Errorf("default_pool and backends cannot be combined")
+	}
+	if _, ok := pools[name]; !ok {
+		return fmt.Errorf("unknown default_pool %q", name)
+	}
+	return nil
+}
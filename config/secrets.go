@@ -0,0 +1,211 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Secret references, usable in place of the value of a secret field or of
+// the path of a file field
+const (
+	fileSecretPrefix  = "file://"
+	vaultSecretPrefix = "vault://"
+)
+
+// vaultTimeout bounds each request to Vault while the config loads
+const vaultTimeout = 10 * time.Second
+
+// secretResolver replaces secret references in a config document. Vault is
+// reached at VAULT_ADDR with VAULT_TOKEN, as the vault CLI does.
+type secretResolver struct {
+	client  *http.Client
+	vault   map[string]map[string]interface{} // secrets read so far, by path
+	tempDir string                            // holds file fields read from Vault
+}
+
+// resolveSecrets replaces file:// and vault:// references below the
+// secret fields of doc with the values they name. File fields such as
+// key_file take a path instead: file:// is a plain path and a value read
+// from Vault is written to a private temporary file, which cleanup removes.
+func resolveSecrets(doc *yaml.Node) (cleanup func(), err error) {
+	sr := &secretResolver{
+		client: &http.Client{Timeout: vaultTimeout},
+		vault:  make(map[string]map[string]interface{}),
+	}
+	if err := sr.walk(doc); err != nil {
+		sr.cleanup()
+		return nil, err
+	}
+	return sr.cleanup, nil
+}
+
+// cleanup removes the temporary files written for file fields
+func (sr *secretResolver) cleanup() {
+	if sr.tempDir != "" {
+		_ = os.RemoveAll(sr.tempDir)
+	}
+}
+
+func (sr *secretResolver) walk(n *yaml.Node) error {
+	if n.Kind != yaml.MappingNode {
+		for _, c := range n.Content {
+			if err := sr.walk(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := strings.ToLower(n.Content[i].Value)
+		var err error
+		switch {
+		case secretFields[key]:
+			err = sr.resolveAll(n.Content[i+1], false)
+		case strings.HasSuffix(key, "_file"):
+			err = sr.resolveAll(n.Content[i+1], true)
+		default:
+			err = sr.walk(n.Content[i+1])
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// resolveAll resolves every scalar below n, e.g. each value of a secrets map
+func (sr *secretResolver) resolveAll(n *yaml.Node, isFile bool) error {
+	if n.Kind == yaml.ScalarNode {
+		value, err := sr.resolve(n.Value, isFile)
+		n.Value = value
+		return err
+	}
+	for i, c := range n.Content {
+		// Only values of maps are secrets
+		if n.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		if err := sr.resolveAll(c, isFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve returns the value a reference names, or ref itself when it is
+// not a reference. For file fields the result is a path.
+func (sr *secretResolver) resolve(ref string, isFile bool) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, fileSecretPrefix):
+		path := strings.TrimPrefix(ref, fileSecretPrefix)
+		if isFile {
+			return path, nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(ref, vaultSecretPrefix):
+		value, err := sr.readVault(strings.TrimPrefix(ref, vaultSecretPrefix))
+		if err != nil || !isFile {
+			return value, err
+		}
+		return sr.writeTemp(value)
+	}
+	return ref, nil
+}
+
+// readVault returns one key of a Vault secret, given as path#key. The path
+// is the API path below /v1/, so KV version 2 paths include data/.
+func (sr *secretResolver) readVault(ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("vault reference %q must have the form vault://path#key", ref)
+	}
+	data, ok := sr.vault[path]
+	if !ok {
+		var err error
+		if data, err = sr.fetchVault(path); err != nil {
+			return "", fmt.Errorf("failed to read vault secret %s: %w", path, err)
+		}
+		sr.vault[path] = data
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no key %s", path, key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+func (sr *secretResolver) fetchVault(path string) (map[string]interface{}, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is not set")
+	}
+	u, err := url.Parse(strings.TrimSuffix(addr, "/") + "/v1/" + strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	resp, err := sr.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid vault response: %w", err)
+	}
+	// KV version 2 nests the secret next to its metadata
+	if inner, ok := body.Data["data"].(map[string]interface{}); ok {
+		if _, ok := body.Data["metadata"]; ok {
+			return inner, nil
+		}
+	}
+	return body.Data, nil
+}
+
+// writeTemp stores a secret read from Vault in a file only the proxy's user
+// can read and returns its path
+func (sr *secretResolver) writeTemp(value string) (string, error) {
+	if sr.tempDir == "" {
+		dir, err := os.MkdirTemp("", "reverse-proxy-secrets-")
+		if err != nil {
+			return "", err
+		}
+		sr.tempDir = dir
+	}
+	f, err := os.CreateTemp(sr.tempDir, "secret-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(value); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
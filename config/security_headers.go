@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SecurityHeadersConfig adds common security headers to responses that
+// don't already carry them. Header values of "-" omit that header.
+type SecurityHeadersConfig struct {
+	Enabled               bool          `yaml:"enabled"`
+	HSTSMaxAge            time.Duration `yaml:"hsts_max_age"` // 0 omits Strict-Transport-Security; only sent over HTTPS
+	HSTSIncludeSubdomains bool          `yaml:"hsts_include_subdomains"`
+	HSTSPreload           bool          `yaml:"hsts_preload"`
+	ContentTypeOptions    string        `yaml:"content_type_options"`    // X-Content-Type-Options
+	FrameOptions          string        `yaml:"frame_options"`           // X-Frame-Options
+	ReferrerPolicy        string        `yaml:"referrer_policy"`         // Referrer-Policy
+	ContentSecurityPolicy string        `yaml:"content_security_policy"` // omitted when empty
+}
+
+func (s *SecurityHeadersConfig) setDefaults() {
+	if s.ContentTypeOptions == "" {
+		s.ContentTypeOptions = "nosniff"
+	}
+	if s.FrameOptions == "" {
+		s.FrameOptions = "DENY"
+	}
+	if s.ReferrerPolicy == "" {
+		s.ReferrerPolicy = "strict-origin-when-cross-origin"
+	}
+}
+
+func (s *SecurityHeadersConfig) validate() error {
+	if s.HSTSMaxAge < 0 {
+		return fmt.Errorf("security_headers: hsts_max_age must be non-negative")
+	}
+	if s.HSTSPreload && (s.HSTSMaxAge < 365*24*time.Hour || !s.HSTSIncludeSubdomains) {
+		return fmt.Errorf("security_headers: hsts_preload requires hsts_max_age of at least 1 year and hsts_include_subdomains")
+	}
+	for name, value := range map[string]string{
+		"content_type_options":    s.ContentTypeOptions,
+		"frame_options":           s.FrameOptions,
+		"referrer_policy":         s.ReferrerPolicy,
+		"content_security_policy": s.ContentSecurityPolicy,
+	} {
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("security_headers: %s must not contain line breaks", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// SessionStoreConfig controls where session-affinity mappings
+// (affinity key -> backend) are kept
+type SessionStoreConfig struct {
+	Type          string        `yaml:"type"` // memory, file, redis, cluster
+	TTL           time.Duration `yaml:"ttl"`  // idle time after which a mapping is forgotten
+	Path          string        `yaml:"path"` // file store location
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	Redis         RedisConfig   `yaml:"redis"`
+}
+
+// RedisConfig contains connection settings for a Redis server
+type RedisConfig struct {
+	Address   string        `yaml:"address"`
+	Password  string        `yaml:"password"`
+	DB        int           `yaml:"db"`
+	KeyPrefix string        `yaml:"key_prefix"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+func (s *SessionStoreConfig) setDefaults() {
+	if s.Type == "" {
+		s.Type = "memory"
+	}
+	if s.TTL == 0 {
+		s.TTL = 24 * time.Hour
+	}
+	if s.FlushInterval == 0 {
+		s.FlushInterval = 30 * time.Second
+	}
+	if s.Redis.KeyPrefix == "" {
+		s.Redis.KeyPrefix = "reverse-proxy:session:"
+	}
+	if s.Redis.Timeout == 0 {
+		s.Redis.Timeout = 2 * time.Second
+	}
+}
+
+func (s *SessionStoreConfig) validate(c *Config) error {
+	switch s.Type {
+	case "memory":
+	case "file":
+		if s.Path == "" {
+			return fmt.Errorf("session_store path is required for the file store")
+		}
+	case "redis":
+		if s.Redis.Address == "" {
+			return fmt.Errorf("session_store redis address is required for the redis store")
+		}
+		if s.Redis.DB < 0 {
+			return fmt.Errorf("session_store redis db must be non-negative")
+		}
+	case "cluster":
+		if !c.Cluster.Enabled {
+			return fmt.Errorf("session_store type cluster requires cluster mode to be enabled")
+		}
+	default:
+		return fmt.Errorf("invalid session_store type: %s (must be one of: memory, file, redis, cluster)", s.Type)
+	}
+	if s.TTL < 0 {
+		return fmt.Errorf("session_store ttl must be non-negative")
+	}
+	if s.FlushInterval < 0 {
+		return fmt.Errorf("session_store flush_interval must be non-negative")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SignatureConfig requires requests to a route to be signed with HMAC-SHA256
+// using the shared secret of the client they name. Secrets come from a file
+// of "<client> <secret>" lines, inline entries, or both.
+type SignatureConfig struct {
+	Secrets        map[string]string `yaml:"secrets"`         // client -> secret
+	SecretsFile    string            `yaml:"secrets_file"`    // inline secrets take precedence
+	DateHeader     string            `yaml:"date_header"`     // header carrying the signing time
+	ClockSkew      time.Duration     `yaml:"clock_skew"`      // tolerated difference to the proxy's clock
+	IdentityHeader string            `yaml:"identity_header"` // request header sent to backends with the client
+}
+
+func (s *SignatureConfig) setDefaults() {
+	if s.DateHeader == "" {
+		s.DateHeader = "X-Date"
+	}
+	if s.ClockSkew == 0 {
+		s.ClockSkew = 5 * time.Minute
+	}
+}
+
+func (s *SignatureConfig) validate() error {
+	if s.SecretsFile == "" && len(s.Secrets) == 0 {
+		return fmt.Errorf("signature requires secrets_file or secrets")
+	}
+	for client, secret := range s.Secrets {
+		if client == "" || strings.ContainsAny(client, " ,=") {
+			return fmt.Errorf("signature: invalid client name %q", client)
+		}
+		if secret == "" {
+			return fmt.Errorf("signature: empty secret for %q", client)
+		}
+	}
+	if !validHeaderName(s.DateHeader) {
+		return fmt.Errorf("signature: invalid date_header %q", s.DateHeader)
+	}
+	if s.ClockSkew < 0 {
+		return fmt.Errorf("signature clock_skew must be non-negative")
+	}
+	if s.IdentityHeader != "" {
+		if !validHeaderName(s.IdentityHeader) {
+			return fmt.Errorf("signature: invalid identity_header %q", s.IdentityHeader)
+		}
+		if h := http.CanonicalHeaderKey(s.IdentityHeader); h == "Authorization" || h == http.CanonicalHeaderKey(s.DateHeader) {
+			return fmt.Errorf("signature: identity_header cannot be a signed header")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// SLOConfig sets a route's service level objective. A request misses it
+// when its response is a 5xx, takes longer than Latency, or its request or
+// response body is larger than allowed; ErrorBudget is the percent of
+// requests that may miss it, e.g. 0.1 for a 99.9% objective.
+type SLOConfig struct {
+	Name            string        `yaml:"name"` // metrics label; defaults to the route's path
+	Latency         time.Duration `yaml:"latency"`
+	MaxRequestSize  int64         `yaml:"max_request_size"`
+	MaxResponseSize int64         `yaml:"max_response_size"`
+	ErrorBudget     float64       `yaml:"error_budget"`
+	Log             bool          `yaml:"log"` // log each request that misses the objective
+}
+
+func (s *SLOConfig) validate() error {
+	if s.Latency < 0 {
+		return fmt.Errorf("slo latency must be non-negative")
+	}
+	if s.MaxRequestSize < 0 {
+		return fmt.Errorf("slo max_request_size must be non-negative")
+	}
+	if s.MaxResponseSize < 0 {
+		return fmt.Errorf("slo max_response_size must be non-negative")
+	}
+	if s.ErrorBudget < 0 || s.ErrorBudget >= 100 {
+		return fmt.Errorf("slo error_budget must be at least 0 and below 100")
+	}
+	return nil
+}
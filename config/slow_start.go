@@ -0,0 +1,29 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// SlowStartConfig ramps up the traffic share of a backend that recovered or
+// joined a pool, so its caches warm up before it takes a full share
+type SlowStartConfig struct {
+	Window   time.Duration `yaml:"window"`    // 0 disables slow start
+	MinShare float64       `yaml:"min_share"` // share of a full load at the start of the window
+}
+
+func (s *SlowStartConfig) setDefaults() {
+	if s.MinShare == 0 {
+		s.MinShare = 0.1
+	}
+}
+
+func (s *SlowStartConfig) validate() error {
+	if s.Window < 0 {
+		return fmt.Errorf("slow_start window must be non-negative")
+	}
+	if s.MinShare <= 0 || s.MinShare > 1 {
+		return fmt.Errorf("slow_start min_share must be between 0 and 1")
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// StaticConfig serves a route from files in a local directory instead of a
+// backend pool
+type StaticConfig struct {
+	Root     string        `yaml:"root"`
+	Index    []string      `yaml:"index"`    // files served for a directory, in order of preference
+	MaxAge   time.Duration `yaml:"max_age"`  // Cache-Control max-age; 0 makes clients revalidate
+	Fallback string        `yaml:"fallback"` // file served for missing paths, e.g. index.html for single-page apps
+}
+
+func (s *StaticConfig) setDefaults() {
+	if s.Index == nil {
+		s.Index = []string{"index.html"}
+	}
+}
+
+func (s *StaticConfig) validate() error {
+	info, err := os.Stat(s.Root)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("static root %q must be a directory", s.Root)
+	}
+	if s.MaxAge < 0 {
+		return fmt.Errorf("static max_age must be non-negative")
+	}
+	for _, name := range append(append([]string{}, s.Index...), s.Fallback) {
+		if strings.Contains(name, "..") {
+			return fmt.Errorf("static: %q must not leave the root", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// StatsD formats
+const (
+	StatsDFormatDogStatsD = "dogstatsd"
+	StatsDFormatStatsD    = "statsd"
+)
+
+// StatsDConfig pushes metrics to a StatsD or DogStatsD agent over UDP.
+// DogStatsD receives the backend and Tags as tags; plain StatsD, which has
+// no tags, gets the backend as the last part of the metric name.
+type StatsDConfig struct {
+	Enabled       bool              `yaml:"enabled"`
+	Address       string            `yaml:"address"` // host:port of the agent
+	Format        string            `yaml:"format"`  // dogstatsd or statsd
+	Prefix        string            `yaml:"prefix"`  // prepended to every metric name
+	Tags          map[string]string `yaml:"tags"`    // sent with every metric
+	FlushInterval time.Duration     `yaml:"flush_interval"`
+}
+
+func (s *StatsDConfig) setDefaults() {
+	if s.Address == "" {
+		s.Address = "127.0.0.1:8125"
+	}
+	if s.Format == "" {
+		s.Format = StatsDFormatDogStatsD
+	}
+	if s.Prefix == "" {
+		s.Prefix = "reverse_proxy."
+	}
+	if s.FlushInterval == 0 {
+		s.FlushInterval = 10 * time.Second
+	}
+}
+
+func (s *StatsDConfig) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(s.Address); err != nil {
+		return fmt.Errorf("metrics statsd address must be host:port: %w", err)
+	}
+	if s.Format != StatsDFormatDogStatsD && s.Format != StatsDFormatStatsD {
+		return fmt.Errorf("invalid metrics statsd format: %s (must be one of: dogstatsd, statsd)", s.Format)
+	}
+	if s.FlushInterval < 0 {
+		return fmt.Errorf("metrics statsd flush_interval must be non-negative")
+	}
+	for k, v := range s.Tags {
+		if k == "" || strings.ContainsAny(k+v, ",|#\n") {
+			return fmt.Errorf("invalid metrics statsd tag %q", k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// StickyConfig pins each client to one backend with an affinity cookie.
+// The cookie carries an opaque key that the session store maps to the
+// backend serving the client.
+type StickyConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	CookieName string `yaml:"cookie_name"`
+	Secure     bool   `yaml:"secure"` // only send the cookie over HTTPS
+}
+
+func (s *StickyConfig) setDefaults() {
+	if s.CookieName == "" {
+		s.CookieName = "proxy_affinity"
+	}
+}
+
+func (s *StickyConfig) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	if err := (&http.Cookie{Name: s.CookieName, Value: "x"}).Valid(); err != nil {
+		return fmt.Errorf("invalid sticky cookie_name %q", s.CookieName)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// StreamConfig forwards raw TCP connections accepted on Address to its
+// backends, so databases and other non-HTTP services can be fronted with
+// the same load balancing and health checks as HTTP backends
+type StreamConfig struct {
+	Name           string              `yaml:"name"`
+	Address        string              `yaml:"address"`
+	Backends       []Backend           `yaml:"backends"`  // tcp://host:port
+	Algorithm      string              `yaml:"algorithm"` // defaults to load_balancer.algorithm
+	ConnectTimeout time.Duration       `yaml:"connect_timeout"`
+	IdleTimeout    time.Duration       `yaml:"idle_timeout"` // 0 keeps idle connections open
+	ProxyProtocol  ProxyProtocolConfig `yaml:"proxy_protocol"`
+	Access         *AccessConfig       `yaml:"access,omitempty"`
+
+	// SNIRoutes pass TLS connections through to backends chosen by the
+	// server name of the ClientHello, without terminating TLS. Connections
+	// matching no route go to Backends, or are closed if there are none.
+	SNIRoutes []SNIRouteConfig `yaml:"sni_routes"`
+}
+
+// SNIRouteConfig sends TLS connections for the listed server names to its
+// backends
+type SNIRouteConfig struct {
+	Hosts    []string  `yaml:"hosts"` // exact names or wildcards such as *.example.com
+	Backends []Backend `yaml:"backends"`
+}
+
+func (s *StreamConfig) setDefaults(algorithm string) {
+	if s.Algorithm == "" {
+		s.Algorithm = algorithm
+	}
+	if s.ConnectTimeout == 0 {
+		s.ConnectTimeout = 5 * time.Second
+	}
+	s.ProxyProtocol.setDefaults()
+}
+
+func (s *StreamConfig) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if s.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+	if len(s.Backends) == 0 && len(s.SNIRoutes) == 0 {
+		return fmt.Errorf("backends or sni_routes are required")
+	}
+	for i := range s.Backends {
+		if err := s.Backends[i].validateStream(); err != nil {
+			return fmt.Errorf("backend %d: %w", i, err)
+		}
+	}
+	seen := make(map[string]bool)
+	for i := range s.SNIRoutes {
+		if err := s.SNIRoutes[i].validate(seen); err != nil {
+			return fmt.Errorf("sni_route %d: %w", i, err)
+		}
+	}
+	if !validAlgorithms[s.Algorithm] {
+		return fmt.Errorf("invalid algorithm %q", s.Algorithm)
+	}
+	if s.ConnectTimeout < 0 || s.IdleTimeout < 0 {
+		return fmt.Errorf("timeouts must be non-negative")
+	}
+	if err := s.ProxyProtocol.validate(); err != nil {
+		return err
+	}
+	if s.Access != nil {
+		if err := s.Access.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateStream checks a backend of a stream, which is reached over plain
+// TCP and so has no use for the HTTP-specific settings
+func (b *Backend) validateStream() error {
+	if err := b.validate(); err != nil {
+		return err
+	}
+	u, _ := url.Parse(b.URL)
+	if b.SRV != "" || b.Discovery != "" {
+		// Targets and ports come from service discovery
+		if b.URL != "" && (u.Scheme != "tcp" || u.Path != "") {
+			return fmt.Errorf("stream backend URL must be tcp://host:port")
+		}
+	} else {
+		if u.Scheme != "tcp" || u.Path != "" {
+			return fmt.Errorf("stream backend URL must be tcp://host:port")
+		}
+		if _, port, err := net.SplitHostPort(u.Host); err != nil || port == "" {
+			return fmt.Errorf("stream backend URL must include a port")
+		}
+	}
+	if b.TLS != nil || b.Protocol != "" || b.Canary {
+		return fmt.Errorf("tls, protocol and canary do not apply to stream backends")
+	}
+	return nil
+}
+
+func (r *SNIRouteConfig) validate(seen map[string]bool) error {
+	if len(r.Hosts) == 0 {
+		return fmt.Errorf("at least one host is required")
+	}
+	for _, h := range r.Hosts {
+		name := NormalizeHost(h)
+		if name == "" || name == "*" || strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+			return fmt.Errorf("invalid host %q", h)
+		}
+		if seen[name] {
+			return fmt.Errorf("host %q is listed by more than one route", h)
+		}
+		seen[name] = true
+	}
+	if len(r.Backends) == 0 {
+		return fmt.Errorf("at least one backend is required")
+	}
+	for i := range r.Backends {
+		if err := r.Backends[i].validateStream(); err != nil {
+			return fmt.Errorf("backend %d: %w", i, err)
+		}
+	}
+	return nil
+}
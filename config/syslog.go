@@ -0,0 +1,57 @@
+package config
+
+import "fmt"
+
+// LogOutputSyslog sends logs to a syslog server instead of a stream or file
+const LogOutputSyslog = "syslog"
+
+// syslogFacilities maps facility names to their codes from RFC 5424
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogConfig selects the syslog server logs are sent to with output:
+// syslog. Without a network, the local daemon is used through its unix
+// socket, which journald also listens on.
+type SyslogConfig struct {
+	Network  string `yaml:"network"` // udp, tcp or unix; empty for the local daemon
+	Address  string `yaml:"address"` // host:port, or a socket path for unix
+	Facility string `yaml:"facility"`
+	Tag      string `yaml:"tag"`
+}
+
+func (s *SyslogConfig) setDefaults() {
+	if s.Facility == "" {
+		s.Facility = "local0"
+	}
+	if s.Tag == "" {
+		s.Tag = "reverse-proxy"
+	}
+}
+
+func (s *SyslogConfig) validate() error {
+	switch s.Network {
+	case "":
+		if s.Address != "" {
+			return fmt.Errorf("syslog address requires a network")
+		}
+	case "udp", "tcp", "unix":
+		if s.Address == "" {
+			return fmt.Errorf("syslog address is required for network %s", s.Network)
+		}
+	default:
+		return fmt.Errorf("invalid syslog network %q (must be one of: udp, tcp, unix)", s.Network)
+	}
+	if _, ok := syslogFacilities[s.Facility]; !ok {
+		return fmt.Errorf("invalid syslog facility %q", s.Facility)
+	}
+	return nil
+}
+
+// FacilityCode returns the facility shifted into place for a syslog priority
+func (s *SyslogConfig) FacilityCode() int {
+	return syslogFacilities[s.Facility] << 3
+}
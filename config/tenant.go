@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TenantConfig describes one team sharing the proxy. A request belongs to
+// the first tenant with a host, path prefix or API key ID matching it, and
+// counts against that tenant's limits; requests of no tenant are only
+// subject to the global ones.
+type TenantConfig struct {
+	Name         string   `yaml:"name"`
+	Hosts        []string `yaml:"hosts"` // exact names or wildcards such as *.example.com
+	PathPrefixes []string `yaml:"path_prefixes"`
+	APIKeys      []string `yaml:"api_keys"` // IDs of keys accepted by api_keys
+
+	// RequestsPerSecond is the tenant's sustained request rate, with
+	// bursts of up to Burst requests; 0 is unlimited. In cluster mode it
+	// holds for the whole cluster.
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+
+	// MaxRequests caps the tenant's requests in flight on each node; 0 is
+	// unlimited
+	MaxRequests int `yaml:"max_requests"`
+}
+
+func (t *TenantConfig) setDefaults() {
+	if t.Burst == 0 && t.RequestsPerSecond > 0 {
+		t.Burst = max(1, int(t.RequestsPerSecond))
+	}
+}
+
+func (t *TenantConfig) validate(apiKeys bool) error {
+	if len(t.Hosts) == 0 && len(t.PathPrefixes) == 0 && len(t.APIKeys) == 0 {
+		return fmt.Errorf("hosts, path_prefixes or api_keys are required")
+	}
+	for _, h := range t.Hosts {
+		name := NormalizeHost(h)
+		if name == "" || name == "*" || strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+			return fmt.Errorf("invalid host %q", h)
+		}
+	}
+	for _, p := range t.PathPrefixes {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("path prefix %q must start with /", p)
+		}
+	}
+	if len(t.APIKeys) > 0 && !apiKeys {
+		return fmt.Errorf("api_keys requires api_keys.enabled")
+	}
+	if t.RequestsPerSecond < 0 {
+		return fmt.Errorf("requests_per_second must be non-negative")
+	}
+	if t.Burst < 0 {
+		return fmt.Errorf("burst must be non-negative")
+	}
+	if t.MaxRequests < 0 {
+		return fmt.Errorf("max_requests must be non-negative")
+	}
+	return nil
+}
+
+func validateTenants(tenants []TenantConfig, apiKeys bool) error {
+	seen := make(map[string]bool, len(tenants))
+	for i := range tenants {
+		t := &tenants[i]
+		if t.Name == "" {
+			return fmt.Errorf("tenant %d: name is required", i)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("duplicate tenant %q", t.Name)
+		}
+		seen[t.Name] = true
+		if err := t.validate(apiKeys); err != nil {
+			return fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
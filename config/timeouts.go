@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeoutsConfig bounds each attempt to reach a backend of a pool or route.
+// Unset values inherit from the pool, and the total from
+// limits.request_timeout.
+type TimeoutsConfig struct {
+	Read  time.Duration `yaml:"read"`  // wait for the response headers and between reads of the body
+	Write time.Duration `yaml:"write"` // sending the request, including its body
+	Total time.Duration `yaml:"total"` // the whole exchange
+
+	// Streaming stops all timeouts once the response headers arrive, so
+	// long-lived responses such as server-sent events are never cut off
+	Streaming *bool `yaml:"streaming,omitempty"`
+}
+
+func (t *TimeoutsConfig) validate() error {
+	if t.Read < 0 || t.Write < 0 || t.Total < 0 {
+		return fmt.Errorf("timeouts must be non-negative")
+	}
+	return nil
+}
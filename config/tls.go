@@ -0,0 +1,123 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsClientAuthTypes = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// ClientAuthType returns how the configured client_auth treats client
+// certificates
+func (t *TLSConfig) ClientAuthType() tls.ClientAuthType {
+	return tlsClientAuthTypes[t.ClientAuth]
+}
+
+// Versions returns the configured protocol version bounds; a zero maximum
+// means the newest version Go supports
+func (t *TLSConfig) Versions() (minVersion, maxVersion uint16) {
+	return tlsVersions[t.MinVersion], tlsVersions[t.MaxVersion]
+}
+
+// CipherSuiteIDs returns the IDs of the configured cipher suites, or nil to
+// use Go's defaults
+func (t *TLSConfig) CipherSuiteIDs() []uint16 {
+	if len(t.CipherSuites) == 0 {
+		return nil
+	}
+	byName := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		byName[cs.Name] = cs.ID
+	}
+	ids := make([]uint16, 0, len(t.CipherSuites))
+	for _, name := range t.CipherSuites {
+		ids = append(ids, byName[name])
+	}
+	return ids
+}
+
+func (t *TLSConfig) validateProtocol() error {
+	minVersion, ok := tlsVersions[t.MinVersion]
+	if !ok {
+		return fmt.Errorf("invalid TLS min_version: %s (must be one of: 1.0, 1.1, 1.2, 1.3)", t.MinVersion)
+	}
+	if t.MaxVersion != "" {
+		maxVersion, ok := tlsVersions[t.MaxVersion]
+		if !ok {
+			return fmt.Errorf("invalid TLS max_version: %s (must be one of: 1.0, 1.1, 1.2, 1.3)", t.MaxVersion)
+		}
+		if maxVersion < minVersion {
+			return fmt.Errorf("TLS max_version %s is lower than min_version %s", t.MaxVersion, t.MinVersion)
+		}
+	}
+
+	// Only suites Go considers secure may be enabled
+	secure := make(map[string]bool)
+	for _, cs := range tls.CipherSuites() {
+		secure[cs.Name] = true
+	}
+	http2Capable := len(t.CipherSuites) == 0
+	for _, name := range t.CipherSuites {
+		if !secure[name] {
+			return fmt.Errorf("unsupported or insecure TLS cipher suite: %s", name)
+		}
+		if name == "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" || name == "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256" {
+			http2Capable = true
+		}
+	}
+	// The HTTP/2 server refuses to start without one of these
+	if !http2Capable {
+		return fmt.Errorf("TLS cipher_suites must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 for HTTP/2")
+	}
+	return nil
+}
+
+func (t *TLSConfig) validateClientAuth() error {
+	authType, ok := tlsClientAuthTypes[t.ClientAuth]
+	if !ok {
+		return fmt.Errorf("invalid TLS client_auth: %s (must be one of: none, request, require, verify_if_given, require_and_verify)", t.ClientAuth)
+	}
+	verifies := authType == tls.VerifyClientCertIfGiven || authType == tls.RequireAndVerifyClientCert
+	if verifies && t.ClientCAFile == "" {
+		return fmt.Errorf("TLS client_auth %s requires client_ca_file", t.ClientAuth)
+	}
+	if !verifies && t.ClientCAFile != "" {
+		return fmt.Errorf("TLS client_ca_file requires client_auth verify_if_given or require_and_verify")
+	}
+	return nil
+}
+
+// BackendTLSConfig controls how the proxy verifies and authenticates to an
+// https:// backend
+type BackendTLSConfig struct {
+	CAFile             string `yaml:"ca_file"`     // PEM roots trusted instead of the system pool
+	CertFile           string `yaml:"cert_file"`   // client certificate for mutual TLS
+	KeyFile            string `yaml:"key_file"`    // client certificate key
+	ServerName         string `yaml:"server_name"` // overrides the name sent via SNI and verified
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+func (t *BackendTLSConfig) validate(backendURL string) error {
+	if u, err := url.Parse(backendURL); err == nil && u.Scheme != "https" {
+		return fmt.Errorf("tls settings require an https backend URL")
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("tls cert_file and key_file must be set together")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// TracingConfig propagates B3 trace headers to backends, starting traces
+// for requests without one, and reports the proxy's spans to Zipkin
+type TracingConfig struct {
+	Enabled       bool          `yaml:"enabled"`        // routes can override it with their tracing setting
+	ZipkinURL     string        `yaml:"zipkin_url"`     // e.g. http://zipkin:9411/api/v2/spans; empty only propagates
+	ServiceName   string        `yaml:"service_name"`   // the proxy's name in reported spans
+	SampleRate    float64       `yaml:"sample_rate"`    // share of new traces that are sampled, 0 to 1
+	SingleHeader  bool          `yaml:"single_header"`  // send the b3 header instead of X-B3-*
+	BatchSize     int           `yaml:"batch_size"`     // spans per report
+	FlushInterval time.Duration `yaml:"flush_interval"` // longest a span waits to be reported
+	Timeout       time.Duration `yaml:"timeout"`        // per report
+}
+
+func (t *TracingConfig) setDefaults() {
+	if t.ServiceName == "" {
+		t.ServiceName = "reverse-proxy"
+	}
+	if t.SampleRate == 0 {
+		t.SampleRate = 1
+	}
+	if t.BatchSize == 0 {
+		t.BatchSize = 100
+	}
+	if t.FlushInterval == 0 {
+		t.FlushInterval = 5 * time.Second
+	}
+	if t.Timeout == 0 {
+		t.Timeout = 5 * time.Second
+	}
+}
+
+func (t *TracingConfig) validate() error {
+	if t.ZipkinURL != "" {
+		u, err := url.Parse(t.ZipkinURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("tracing zipkin_url must be an http or https URL")
+		}
+	}
+	if t.SampleRate < 0 || t.SampleRate > 1 {
+		return fmt.Errorf("tracing sample_rate must be between 0 and 1")
+	}
+	if t.BatchSize < 1 {
+		return fmt.Errorf("tracing batch_size must be positive")
+	}
+	if t.FlushInterval < 0 || t.Timeout < 0 {
+		return fmt.Errorf("tracing flush_interval and timeout must be non-negative")
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// TransportConfig tunes the connections opened to backends. Pool sizes are
+// set with limits.max_idle_conns and limits.max_conns_per_host.
+type TransportConfig struct {
+	DialTimeout         time.Duration `yaml:"dial_timeout"`
+	KeepAlive           time.Duration `yaml:"keep_alive"`        // TCP keep-alive probe interval
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"` // idle connections are closed after this
+	TLSHandshakeTimeout time.Duration `yaml:"tls_handshake_timeout"`
+}
+
+func (t *TransportConfig) setDefaults() {
+	if t.DialTimeout == 0 {
+		t.DialTimeout = 30 * time.Second
+	}
+	if t.KeepAlive == 0 {
+		t.KeepAlive = 30 * time.Second
+	}
+	if t.IdleConnTimeout == 0 {
+		t.IdleConnTimeout = 90 * time.Second
+	}
+	if t.TLSHandshakeTimeout == 0 {
+		t.TLSHandshakeTimeout = 10 * time.Second
+	}
+}
+
+func (t *TransportConfig) validate() error {
+	if t.DialTimeout < 0 || t.KeepAlive < 0 || t.IdleConnTimeout < 0 || t.TLSHandshakeTimeout < 0 {
+		return fmt.Errorf("transport timeouts must be non-negative")
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package config
+
+import "fmt"
+
+// Upstream encoding modes of routes. Without one the client's
+// Accept-Encoding is passed to the backend unchanged.
+const (
+	UpstreamEncodingIdentity   = "identity"   // ask backends for uncompressed responses
+	UpstreamEncodingDecompress = "decompress" // gunzip responses for clients that don't accept gzip
+)
+
+func validateUpstreamEncoding(v string) error {
+	switch v {
+	case "", UpstreamEncodingIdentity, UpstreamEncodingDecompress:
+		return nil
+	}
+	return fmt.Errorf("invalid upstream_encoding %q (must be one of: identity, decompress)", v)
+}
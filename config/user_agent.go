@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// UserAgentConfig sorts requests by their User-Agent header so scrapers and
+// other bots can be blocked, slowed down or marked for the backends
+type UserAgentConfig struct {
+	Rules     []UserAgentRule `yaml:"rules"`      // the first matching rule applies
+	TagHeader string          `yaml:"tag_header"` // request header carrying the tag of tag rules
+}
+
+// UserAgentRule matches User-Agent headers against patterns, the built-in
+// list of known bots, or both
+type UserAgentRule struct {
+	Patterns  []string `yaml:"patterns"`   // regular expressions, case-insensitive
+	KnownBots bool     `yaml:"known_bots"` // also match well-known crawlers and HTTP libraries
+	Action    string   `yaml:"action"`     // block, rate_limit or tag
+	Rate      int      `yaml:"rate"`       // requests per minute per client IP for rate_limit, across a cluster
+	Tag       string   `yaml:"tag"`        // value of tag_header for tag
+}
+
+var validUserAgentActions = map[string]bool{
+	"block":      true,
+	"rate_limit": true,
+	"tag":        true,
+}
+
+func (u *UserAgentConfig) setDefaults() {
+	if u.TagHeader == "" {
+		u.TagHeader = "X-Bot"
+	}
+	for i := range u.Rules {
+		if u.Rules[i].Tag == "" {
+			u.Rules[i].Tag = "bot"
+		}
+	}
+}
+
+func (u *UserAgentConfig) validate() error {
+	if !validHeaderName(u.TagHeader) {
+		return fmt.Errorf("user_agents: invalid tag_header %q", u.TagHeader)
+	}
+	for i, rule := range u.Rules {
+		if len(rule.Patterns) == 0 && !rule.KnownBots {
+			return fmt.Errorf("user_agents rule %d: patterns or known_bots is required", i)
+		}
+		for _, p := range rule.Patterns {
+			if _, err := regexp.Compile("(?i)" + p); err != nil {
+				return fmt.Errorf("user_agents rule %d: invalid pattern %q: %w", i, p, err)
+			}
+		}
+		if !validUserAgentActions[rule.Action] {
+			return fmt.Errorf("user_agents rule %d: invalid action %q", i, rule.Action)
+		}
+		if rule.Action == "rate_limit" && rule.Rate <= 0 {
+			return fmt.Errorf("user_agents rule %d: rate_limit requires a positive rate", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// VHostConfig serves requests for the listed host names from their own
+// backends and routes
+type VHostConfig struct {
+	Hosts       []string        `yaml:"hosts"` // exact names or wildcards such as *.example.com
+	Backends    []Backend       `yaml:"backends"`
+	Routes      []RouteConfig   `yaml:"routes"`
+	DefaultPool string          `yaml:"default_pool"` // serves requests matching no route, in place of backends
+	TLS         *VHostTLSConfig `yaml:"tls,omitempty"`
+
+	// Algorithm balances the virtual host's own backends; it defaults to
+	// load_balancer.algorithm
+	Algorithm string `yaml:"algorithm"`
+}
+
+// VHostTLSConfig is the certificate presented to clients requesting one of
+// the virtual host's names via SNI
+type VHostTLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// UnknownHostConfig controls the response to requests whose Host matches no
+// virtual host. When unset such requests use the top-level routes and backends.
+type UnknownHostConfig struct {
+	Status   int    `yaml:"status"`   // e.g. 404, or a 3xx code with redirect
+	Redirect string `yaml:"redirect"` // base URL; the request URI is appended
+}
+
+// Enabled reports whether unknown hosts are answered directly
+func (u *UnknownHostConfig) Enabled() bool {
+	return u.Status != 0 || u.Redirect != ""
+}
+
+func (u *UnknownHostConfig) setDefaults() {
+	if u.Redirect != "" && u.Status == 0 {
+		u.Status = http.StatusFound
+	}
+}
+
+func (u *UnknownHostConfig) validate() error {
+	if !u.Enabled() {
+		return nil
+	}
+	if u.Redirect != "" {
+		target, err := url.Parse(u.Redirect)
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return fmt.Errorf("unknown_host redirect must be an absolute URL")
+		}
+		if u.Status < 300 || u.Status > 399 {
+			return fmt.Errorf("unknown_host status must be a redirect (3xx) when redirect is set")
+		}
+		return nil
+	}
+	if u.Status < 400 || u.Status > 599 {
+		return fmt.Errorf("unknown_host status must be an error (4xx or 5xx) without redirect")
+	}
+	return nil
+}
+
+// NormalizeHost lowercases a host name and strips any port and trailing dot
+func NormalizeHost(host string) string {
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.Contains(host[i:], "]") {
+		host = host[:i]
+	}
+	host = strings.TrimSuffix(host, ".")
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+func (v *VHostConfig) validate(c *Config, seen map[string]bool) error {
+	if len(v.Hosts) == 0 {
+		return fmt.Errorf("at least one host is required")
+	}
+	for _, h := range v.Hosts {
+		name := NormalizeHost(h)
+		if name == "" || name == "*" || strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+			return fmt.Errorf("invalid host %q", h)
+		}
+		if seen[name] {
+			return fmt.Errorf("host %q is listed by more than one vhost", h)
+		}
+		seen[name] = true
+	}
+
+	if len(v.Backends) == 0 && len(v.Routes) == 0 && v.DefaultPool == "" {
+		return fmt.Errorf("backends, routes or default_pool are required")
+	}
+	if err := validateDefaultPool(v.DefaultPool, v.Backends, c.Pools); err != nil {
+		return err
+	}
+	if !validAlgorithms[v.Algorithm] {
+		return fmt.Errorf("invalid algorithm %q", v.Algorithm)
+	}
+	for i, backend := range v.Backends {
+		if err := backend.validate(); err != nil {
+			return fmt.Errorf("backend %d: %w", i, err)
+		}
+	}
+	for i := range v.Routes {
+		if err := v.Routes[i].validate(c.Pools); err != nil {
+			return fmt.Errorf("route %d: %w", i, err)
+		}
+	}
+
+	if v.TLS != nil {
+		if c.TLS == nil || !c.TLS.Enabled {
+			return fmt.Errorf("tls certificates require TLS to be enabled")
+		}
+		if v.TLS.CertFile == "" || v.TLS.KeyFile == "" {
+			return fmt.Errorf("tls cert_file and key_file are required")
+		}
+	}
+	return nil
+}
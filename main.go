@@ -3,34 +3,58 @@ package main
 import (
 	"flag"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/bunnydevv/reverse-proxy/config"
 	"github.com/bunnydevv/reverse-proxy/proxy"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "print-config":
+			os.Exit(runPrintConfig(os.Args[2:]))
+		case "test":
+			os.Exit(runTest(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		}
+	}
+
 	configPath := flag.String("config", "config.yaml", "Path to configuration file")
+	configFormat := flag.String("config-format", "", "Configuration file format: yaml, json or toml (default by file extension)")
 	flag.Parse()
 
 	// Load configuration
-	cfg, err := config.Load(*configPath)
+	cfg, err := loadConfig(*configPath, *configFormat)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Log at the configured level and format from here on
+	logOutput, err := proxy.NewLogOutput(cfg.Logging)
+	if err != nil {
+		log.Fatalf("Failed to open log output: %v", err)
+	}
+	defer logOutput.Close()
+	slog.SetDefault(proxy.NewLogger(cfg.Logging, logOutput))
+
 	// Create and start the reverse proxy
 	rp, err := proxy.New(cfg)
 	if err != nil {
+		cfg.Close()
 		log.Fatalf("Failed to create reverse proxy: %v", err)
 	}
 
 	// Start the proxy server
 	go func() {
 		log.Printf("Starting reverse proxy on %s", cfg.Server.Address)
-		if err := rp.Start(); err != nil {
+		if err := rp.Start(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start reverse proxy: %v", err)
 		}
 	}()
@@ -38,10 +62,31 @@ func main() {
 	// Wait for interrupt signal to gracefully shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+
+	// On the upgrade signal, hand the sockets to a new binary and drain
+	upgrade := make(chan os.Signal, 1)
+	if proxy.UpgradeSignal != nil {
+		signal.Notify(upgrade, proxy.UpgradeSignal)
+	}
+wait:
+	for {
+		select {
+		case <-quit:
+			break wait
+		case <-upgrade:
+			log.Println("Upgrading reverse proxy...")
+			if err := rp.Upgrade(); err != nil {
+				log.Printf("Upgrade failed, continuing to serve: %v", err)
+				continue
+			}
+			break wait
+		}
+	}
 
 	log.Println("Shutting down reverse proxy...")
-	if err := rp.Shutdown(); err != nil {
+	err = rp.Shutdown()
+	cfg.Close()
+	if err != nil {
 		log.Fatalf("Failed to shutdown reverse proxy: %v", err)
 	}
 	log.Println("Reverse proxy stopped")
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net/http"
+	"net/netip"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// accessList admits or refuses clients by address. Deny entries always win;
+// a non-empty allow list admits only the clients it covers.
+type accessList struct {
+	allow *ipSet
+	deny  *ipSet
+}
+
+// newAccessList returns nil when no lists are configured
+func newAccessList(cfg *config.AccessConfig) (*accessList, error) {
+	if cfg == nil || (len(cfg.Allow) == 0 && len(cfg.Deny) == 0) {
+		return nil, nil
+	}
+
+	allow, err := newIPSet(cfg.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := newIPSet(cfg.Deny)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Allow) == 0 {
+		allow = nil
+	}
+	return &accessList{allow: allow, deny: deny}, nil
+}
+
+// authorize reports whether the client of r is admitted, and otherwise
+// answers with 403
+func (al *accessList) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if al == nil {
+		return true
+	}
+
+	if !al.admits(clientAddr(r)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// admits reports whether the lists let addr through
+func (al *accessList) admits(addr netip.Addr) bool {
+	if al == nil {
+		return true
+	}
+	return !al.deny.Contains(addr) && (al.allow == nil || al.allow.Contains(addr))
+}
+
+func (al *accessList) middleware(next http.Handler) http.Handler {
+	if al == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if al.authorize(w, r) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
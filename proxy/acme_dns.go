@@ -0,0 +1,534 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	// acmeRenewBefore is how long before expiry a certificate is renewed,
+	// matching autocert
+	acmeRenewBefore = 30 * 24 * time.Hour
+
+	// acmeCheckInterval is how often certificates are checked for renewal,
+	// and how soon a failed order is retried
+	acmeCheckInterval = time.Hour
+)
+
+// dnsProvider publishes the TXT records of DNS-01 challenges
+type dnsProvider interface {
+	present(ctx context.Context, fqdn, value string) error
+	cleanup(ctx context.Context, fqdn, value string) error
+}
+
+func newDNSProvider(cfg config.ACMEDNSConfig) dnsProvider {
+	switch cfg.Provider {
+	case "cloudflare":
+		return newCloudflareDNS(cfg.APIToken)
+	case "route53":
+		return newRoute53DNS(cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken, cfg.HostedZoneID)
+	case "rfc2136":
+		return newRFC2136DNS(cfg.Nameserver, cfg.Zone, cfg.TSIGKey, cfg.TSIGSecret, cfg.TSIGAlgorithm)
+	default:
+		return &execDNS{command: cfg.Command}
+	}
+}
+
+// acmeDNSIssuer obtains and renews a certificate for each ACME domain,
+// proving control of the domains with DNS-01 challenges. Unlike HTTP-01 and
+// TLS-ALPN-01 it doesn't need the domains to resolve to the proxy and can
+// issue wildcard certificates.
+type acmeDNSIssuer struct {
+	config   config.ACMEConfig
+	provider dnsProvider
+	client   *acme.Client
+	certs    *hostTable[*acmeCertificate]
+	list     []*acmeCertificate
+	logger   *slog.Logger
+
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// acmeCertificate is the current certificate of one domain, nil until it
+// has been issued
+type acmeCertificate struct {
+	domain string
+	cert   atomic.Pointer[tls.Certificate]
+}
+
+func newACMEDNSIssuer(cfg config.ACMEConfig, logger *slog.Logger) *acmeDNSIssuer {
+	ai := &acmeDNSIssuer{
+		config:   cfg,
+		provider: newDNSProvider(*cfg.DNS),
+		client:   &acme.Client{DirectoryURL: cfg.DirectoryURL},
+		certs:    newHostTable[*acmeCertificate](),
+		logger:   logger,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if ai.client.DirectoryURL == "" {
+		ai.client.DirectoryURL = acme.LetsEncryptURL
+	}
+	for _, d := range cfg.Domains {
+		c := &acmeCertificate{domain: config.NormalizeHost(d)}
+		ai.certs.add(c.domain, c)
+		ai.list = append(ai.list, c)
+	}
+	return ai
+}
+
+// getCertificate returns the certificate for the handshake's server name.
+// ok is false for names outside the ACME domains.
+func (ai *acmeDNSIssuer) getCertificate(hello *tls.ClientHelloInfo) (cert *tls.Certificate, ok bool, err error) {
+	c, ok := ai.certs.lookup(hello.ServerName)
+	if !ok {
+		return nil, false, nil
+	}
+	if cert = c.cert.Load(); cert == nil {
+		return nil, true, fmt.Errorf("certificate for %q has not been issued yet", c.domain)
+	}
+	return cert, true, nil
+}
+
+// Start loads the cached certificates and keeps them issued and renewed in
+// the background
+func (ai *acmeDNSIssuer) Start() {
+	ai.started = true
+	for _, c := range ai.list {
+		if cert, err := ai.load(c.domain); err == nil {
+			c.cert.Store(cert)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			ai.logger.Warn("Ignoring cached ACME certificate", "domain", c.domain, "error", err)
+		}
+	}
+
+	go func() {
+		defer close(ai.done)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-ai.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		ticker := time.NewTicker(acmeCheckInterval)
+		defer ticker.Stop()
+		for {
+			ai.renew(ctx)
+			select {
+			case <-ticker.C:
+			case <-ai.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (ai *acmeDNSIssuer) Stop() {
+	close(ai.stop)
+	if ai.started {
+		<-ai.done
+	}
+}
+
+// renew orders a certificate for each domain without one or whose
+// certificate expires soon
+func (ai *acmeDNSIssuer) renew(ctx context.Context) {
+	for _, c := range ai.list {
+		if cert := c.cert.Load(); cert != nil && time.Until(cert.Leaf.NotAfter) > acmeRenewBefore {
+			continue
+		}
+		cert, err := ai.issue(ctx, c.domain)
+		if err != nil {
+			if ctx.Err() == nil {
+				ai.logger.Error("Failed to obtain ACME certificate", "domain", c.domain, "error", err)
+			}
+			continue
+		}
+		c.cert.Store(cert)
+		ai.logger.Info("Obtained ACME certificate", "domain", c.domain, "expires", cert.Leaf.NotAfter)
+	}
+}
+
+// issue orders a certificate for domain and caches it
+func (ai *acmeDNSIssuer) issue(ctx context.Context, domain string) (*tls.Certificate, error) {
+	if err := ai.register(ctx); err != nil {
+		return nil, err
+	}
+
+	order, err := ai.client.AuthorizeOrder(ctx, acme.DomainIDs(domain))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order: %w", err)
+	}
+	for _, u := range order.AuthzURLs {
+		if err := ai.authorize(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = ai.client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("order not ready: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	der, _, err := ai.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize order: %w", err)
+	}
+
+	cert, err := acmeCertificateFrom(der, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := ai.save(domain, der, key); err != nil {
+		ai.logger.Warn("Failed to cache ACME certificate", "domain", domain, "error", err)
+	}
+	return cert, nil
+}
+
+// authorize completes the DNS-01 challenge of one authorization
+func (ai *acmeDNSIssuer) authorize(ctx context.Context, authzURL string) error {
+	authz, err := ai.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("failed to get authorization: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("CA offers no dns-01 challenge for %s", authz.Identifier.Value)
+	}
+
+	value, err := ai.client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	// Wildcard identifiers come without the "*." and share the record of
+	// their base domain
+	fqdn := "_acme-challenge." + authz.Identifier.Value + "."
+	if err := ai.provider.present(ctx, fqdn, value); err != nil {
+		return fmt.Errorf("failed to publish %s: %w", fqdn, err)
+	}
+	defer func() {
+		// Clean up even when the order was cancelled by a shutdown
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		if err := ai.provider.cleanup(cleanupCtx, fqdn, value); err != nil {
+			ai.logger.Warn("Failed to remove ACME challenge record", "record", fqdn, "error", err)
+		}
+	}()
+
+	select {
+	case <-time.After(ai.config.DNS.PropagationDelay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if _, err := ai.client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("failed to accept challenge: %w", err)
+	}
+	if _, err := ai.client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("authorization for %s failed: %w", authz.Identifier.Value, err)
+	}
+	return nil
+}
+
+// register loads or creates the account key and registers it with the CA.
+// The key is stored where autocert keeps its own, so switching between
+// challenge types keeps the account.
+func (ai *acmeDNSIssuer) register(ctx context.Context) error {
+	if ai.client.Key != nil {
+		return nil
+	}
+	path := filepath.Join(ai.config.CacheDir, "acme_account+key")
+	var key crypto.Signer
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("invalid ACME account key in %s", path)
+		}
+		if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return fmt.Errorf("invalid ACME account key in %s: %w", path, err)
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		der, err := x509.MarshalECPrivateKey(ecKey)
+		if err != nil {
+			return err
+		}
+		if err := writeCacheFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+			return fmt.Errorf("failed to store ACME account key: %w", err)
+		}
+		key = ecKey
+	} else {
+		return err
+	}
+
+	ai.client.Key = key
+	account := &acme.Account{}
+	if ai.config.Email != "" {
+		account.Contact = []string{"mailto:" + ai.config.Email}
+	}
+	if _, err := ai.client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		// Registered again with the next order
+		ai.client.Key = nil
+		return fmt.Errorf("failed to register ACME account: %w", err)
+	}
+	return nil
+}
+
+// certPath is where the certificate of domain is cached, as PEM blocks of
+// the key and the chain
+func (ai *acmeDNSIssuer) certPath(domain string) string {
+	return filepath.Join(ai.config.CacheDir, "dns01+"+strings.Replace(domain, "*", "_wildcard", 1))
+}
+
+func (ai *acmeDNSIssuer) load(domain string) (*tls.Certificate, error) {
+	data, err := os.ReadFile(ai.certPath(domain))
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func (ai *acmeDNSIssuer) save(domain string, der [][]byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	_ = pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for _, b := range der {
+		_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: b})
+	}
+	return writeCacheFile(ai.certPath(domain), buf.Bytes())
+}
+
+func acmeCertificateFrom(der [][]byte, key crypto.Signer) (*tls.Certificate, error) {
+	if len(der) == 0 {
+		return nil, fmt.Errorf("CA returned no certificate")
+	}
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate from CA: %w", err)
+	}
+	return &tls.Certificate{Certificate: der, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// writeCacheFile atomically replaces a private file of the ACME cache
+func writeCacheFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// execDNS runs a command to publish and remove challenge records, as
+// "<command> present|cleanup <fqdn> <value>", so any DNS service can be
+// scripted
+type execDNS struct {
+	command []string
+}
+
+func (e *execDNS) present(ctx context.Context, fqdn, value string) error {
+	return e.run(ctx, "present", fqdn, value)
+}
+
+func (e *execDNS) cleanup(ctx context.Context, fqdn, value string) error {
+	return e.run(ctx, "cleanup", fqdn, value)
+}
+
+func (e *execDNS) run(ctx context.Context, action, fqdn, value string) error {
+	args := append(append([]string{}, e.command[1:]...), action, fqdn, value)
+	out, err := exec.CommandContext(ctx, e.command[0], args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s %s: %w: %s", e.command[0], action, err, msg)
+		}
+		return fmt.Errorf("%s %s: %w", e.command[0], action, err)
+	}
+	return nil
+}
+
+// cloudflareDNS manages challenge records through the Cloudflare API
+type cloudflareDNS struct {
+	token   string
+	baseURL string
+	client  *http.Client
+
+	mu      sync.Mutex
+	records map[string]cloudflareRecord // by fqdn and value
+}
+
+type cloudflareRecord struct {
+	zoneID, id string
+}
+
+func newCloudflareDNS(token string) *cloudflareDNS {
+	return &cloudflareDNS{
+		token:   token,
+		baseURL: "https://api.cloudflare.com/client/v4",
+		client:  &http.Client{Timeout: 30 * time.Second},
+		records: make(map[string]cloudflareRecord),
+	}
+}
+
+func (cf *cloudflareDNS) present(ctx context.Context, fqdn, value string) error {
+	name := strings.TrimSuffix(fqdn, ".")
+	zoneID, err := cf.zoneID(ctx, name)
+	if err != nil {
+		return err
+	}
+	var record struct {
+		ID string `json:"id"`
+	}
+	body := map[string]any{"type": "TXT", "name": name, "content": value, "ttl": 120}
+	if err := cf.do(ctx, http.MethodPost, "/zones/"+zoneID+"/dns_records", body, &record); err != nil {
+		return err
+	}
+	cf.mu.Lock()
+	cf.records[fqdn+" "+value] = cloudflareRecord{zoneID: zoneID, id: record.ID}
+	cf.mu.Unlock()
+	return nil
+}
+
+func (cf *cloudflareDNS) cleanup(ctx context.Context, fqdn, value string) error {
+	cf.mu.Lock()
+	record, ok := cf.records[fqdn+" "+value]
+	delete(cf.records, fqdn+" "+value)
+	cf.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return cf.do(ctx, http.MethodDelete, "/zones/"+record.zoneID+"/dns_records/"+record.id, nil, nil)
+}
+
+// zoneID finds the zone holding name, trying each parent domain
+func (cf *cloudflareDNS) zoneID(ctx context.Context, name string) (string, error) {
+	for zone := name; strings.Contains(zone, "."); zone = zone[strings.IndexByte(zone, '.')+1:] {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := cf.do(ctx, http.MethodGet, "/zones?name="+url.QueryEscape(zone), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+	}
+	return "", fmt.Errorf("no cloudflare zone holds %s", name)
+}
+
+// do calls the API and decodes the result of its response envelope into
+// result, if not nil
+func (cf *cloudflareDNS) do(ctx context.Context, method, path string, body, result any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, cf.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+cf.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := cf.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloudflare API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Success bool                       `json:"success"`
+		Errors  []struct{ Message string } `json:"errors"`
+		Result  json.RawMessage            `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&envelope); err != nil {
+		return fmt.Errorf("cloudflare API %s %s: %s", method, path, resp.Status)
+	}
+	if !envelope.Success {
+		msgs := make([]string, 0, len(envelope.Errors))
+		for _, e := range envelope.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		return fmt.Errorf("cloudflare API %s %s: %s: %s", method, path, resp.Status, strings.Join(msgs, "; "))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(envelope.Result, result)
+}
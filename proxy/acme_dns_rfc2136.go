@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	dnsOpcodeUpdate = 5
+	dnsTypeTSIG     = 250
+	dnsClassNone    = 254
+	dnsClassAny     = 255
+
+	// tsigFudge is how far the signing time may be from the server's clock
+	tsigFudge = 300
+)
+
+// rfc2136DNS publishes challenge records with RFC 2136 dynamic updates,
+// signed with a TSIG key (RFC 8945) when one is configured
+type rfc2136DNS struct {
+	nameserver string
+	zone       string
+	keyName    string
+	secret     []byte
+	algorithm  string
+	timeout    time.Duration
+	now        func() time.Time
+}
+
+func newRFC2136DNS(nameserver, zone, keyName, secret, algorithm string) *rfc2136DNS {
+	key, _ := base64.StdEncoding.DecodeString(secret)
+	return &rfc2136DNS{
+		nameserver: nameserver,
+		zone:       fqdnOf(zone),
+		keyName:    fqdnOf(keyName),
+		secret:     key,
+		algorithm:  algorithm,
+		timeout:    10 * time.Second,
+		now:        time.Now,
+	}
+}
+
+// fqdnOf lowercases name and adds the root label, keeping "" empty
+func fqdnOf(name string) string {
+	if name == "" || strings.HasSuffix(name, ".") {
+		return strings.ToLower(name)
+	}
+	return strings.ToLower(name) + "."
+}
+
+func (d *rfc2136DNS) present(ctx context.Context, fqdn, value string) error {
+	return d.update(ctx, fqdn, value, dnsmessage.ClassINET, 120)
+}
+
+func (d *rfc2136DNS) cleanup(ctx context.Context, fqdn, value string) error {
+	// Class NONE deletes the record with this value only (RFC 2136 2.5.4)
+	return d.update(ctx, fqdn, value, dnsClassNone, 0)
+}
+
+func (d *rfc2136DNS) update(ctx context.Context, fqdn, value string, class dnsmessage.Class, ttl uint32) error {
+	zone := d.zone
+	if zone == "" {
+		var err error
+		if zone, err = d.findZone(ctx, fqdn); err != nil {
+			return err
+		}
+	}
+	zoneName, err := dnsmessage.NewName(zone)
+	if err != nil {
+		return err
+	}
+	name, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return err
+	}
+
+	// The zone, prerequisite and update sections reuse the question,
+	// answer and authority sections of a query
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: uint16(rand.Uint32()), OpCode: dnsOpcodeUpdate})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return err
+	}
+	if err := b.Question(dnsmessage.Question{Name: zoneName, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}); err != nil {
+		return err
+	}
+	if err := b.StartAuthorities(); err != nil {
+		return err
+	}
+	hdr := dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeTXT, Class: class, TTL: ttl}
+	if err := b.TXTResource(hdr, dnsmessage.TXTResource{TXT: []string{value}}); err != nil {
+		return err
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.exchange(ctx, d.sign(msg))
+	if err != nil {
+		return err
+	}
+	if resp.RCode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("update of %s in zone %s refused by %s: %s", fqdn, zone, d.nameserver, resp.RCode)
+	}
+	return nil
+}
+
+// findZone asks the name server for the SOA of fqdn, which names the zone
+// holding it in the answer or, for names below the apex, the authority
+// section
+func (d *rfc2136DNS) findZone(ctx context.Context, fqdn string) (string, error) {
+	name, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return "", err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: uint16(rand.Uint32())})
+	if err := b.StartQuestions(); err != nil {
+		return "", err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}); err != nil {
+		return "", err
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return "", err
+	}
+	resp, err := d.exchange(ctx, msg)
+	if err != nil {
+		return "", err
+	}
+	for _, rrs := range [][]dnsmessage.Resource{resp.Answers, resp.Authorities} {
+		for _, rr := range rrs {
+			if rr.Header.Type == dnsmessage.TypeSOA {
+				return strings.ToLower(rr.Header.Name.String()), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%s has no SOA for %s: %s", d.nameserver, fqdn, resp.RCode)
+}
+
+// sign appends a TSIG record to msg, as the last additional record
+func (d *rfc2136DNS) sign(msg []byte) []byte {
+	if d.keyName == "" {
+		return msg
+	}
+	newHash := sha256.New
+	if d.algorithm == "hmac-sha512" {
+		newHash = sha512.New
+	}
+	algorithm := dnsWireName(d.algorithm + ".")
+	keyName := dnsWireName(d.keyName)
+	signed := uint64(d.now().Unix())
+	timers := make([]byte, 8)
+	binary.BigEndian.PutUint16(timers, uint16(signed>>32))
+	binary.BigEndian.PutUint32(timers[2:], uint32(signed))
+	binary.BigEndian.PutUint16(timers[6:], tsigFudge)
+
+	// The MAC covers the message before the TSIG record and the TSIG
+	// variables: key name, class, TTL, algorithm, timers, error and other
+	// data
+	mac := hmac.New(newHash, d.secret)
+	mac.Write(msg)
+	mac.Write(keyName)
+	mac.Write([]byte{0, dnsClassAny, 0, 0, 0, 0})
+	mac.Write(algorithm)
+	mac.Write(timers)
+	mac.Write([]byte{0, 0, 0, 0})
+	sum := mac.Sum(nil)
+
+	rdata := append([]byte{}, algorithm...)
+	rdata = append(rdata, timers...)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, msg[0], msg[1]) // original ID
+	rdata = append(rdata, 0, 0, 0, 0)     // error, other len
+
+	out := append([]byte{}, msg...)
+	out = append(out, keyName...)
+	out = append(out, 0, dnsTypeTSIG, 0, dnsClassAny, 0, 0, 0, 0)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(rdata)))
+	out = append(out, rdata...)
+	binary.BigEndian.PutUint16(out[10:], binary.BigEndian.Uint16(out[10:])+1) // ARCOUNT
+	return out
+}
+
+// dnsWireName encodes an absolute name as uncompressed labels
+func dnsWireName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(strings.ToLower(name), "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// exchange sends msg over UDP, retrying over TCP if the response was
+// truncated
+func (d *rfc2136DNS) exchange(ctx context.Context, msg []byte) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	resp, err := d.exchangeOver(ctx, "udp", msg)
+	if err == nil && resp.Truncated {
+		resp, err = d.exchangeOver(ctx, "tcp", msg)
+	}
+	return resp, err
+}
+
+func (d *rfc2136DNS) exchangeOver(ctx context.Context, network string, msg []byte) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, d.nameserver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	id := binary.BigEndian.Uint16(msg)
+	if network == "tcp" {
+		// Messages over TCP have a two byte length prefix
+		if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(msg)))); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	for {
+		var buf []byte
+		if network == "tcp" {
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil {
+				return nil, err
+			}
+			buf = make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return nil, err
+			}
+		} else {
+			buf = make([]byte, 65535)
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			buf = buf[:n]
+		}
+		var resp dnsmessage.Message
+		if err := resp.Unpack(buf); err != nil {
+			if network == "udp" {
+				continue
+			}
+			return nil, fmt.Errorf("invalid response from %s: %w", d.nameserver, err)
+		}
+		// Ignore stray UDP datagrams
+		if resp.ID != id && network == "udp" {
+			continue
+		}
+		return &resp, nil
+	}
+}
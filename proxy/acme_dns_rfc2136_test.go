@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeNameserver answers SOA queries for example.com and records the
+// updates signed with secret, refusing the others
+func fakeNameserver(t *testing.T, secret []byte) (addr string, updates chan dnsmessage.Message) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	updates = make(chan dnsmessage.Message, 10)
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil {
+				t.Errorf("invalid message: %v", err)
+				return
+			}
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: msg.ID, Response: true, OpCode: msg.OpCode})
+			switch {
+			case msg.OpCode == dnsOpcodeUpdate && !validTSIG(buf[:n], secret):
+				b = dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: msg.ID, Response: true, OpCode: msg.OpCode, RCode: dnsmessage.RCodeRefused})
+			case msg.OpCode == dnsOpcodeUpdate:
+				updates <- msg
+			default:
+				_ = b.StartAuthorities()
+				_ = b.SOAResource(dnsmessage.ResourceHeader{
+					Name:  dnsmessage.MustNewName("example.com."),
+					Class: dnsmessage.ClassINET,
+				}, dnsmessage.SOAResource{
+					NS:   dnsmessage.MustNewName("ns.example.com."),
+					MBox: dnsmessage.MustNewName("hostmaster.example.com."),
+				})
+			}
+			resp, _ := b.Finish()
+			_, _ = conn.WriteTo(resp, peer)
+		}
+	}()
+	return conn.LocalAddr().String(), updates
+}
+
+// validTSIG checks the hmac-sha256 TSIG record ending msg
+func validTSIG(msg, secret []byte) bool {
+	var tsig dnsmessage.Message
+	if err := tsig.Unpack(msg); err != nil || len(tsig.Additionals) != 1 {
+		return false
+	}
+	rr, ok := tsig.Additionals[0].Body.(*dnsmessage.UnknownResource)
+	if !ok || rr.Type != dnsTypeTSIG {
+		return false
+	}
+	keyName := dnsWireName(tsig.Additionals[0].Header.Name.String())
+	unsigned := append([]byte{}, msg[:len(msg)-len(keyName)-10-len(rr.Data)]...)
+	binary.BigEndian.PutUint16(unsigned[10:], 0)
+
+	algorithm := dnsWireName("hmac-sha256.")
+	rdata := rr.Data[len(algorithm):]
+	timers, sum := rdata[:8], rdata[10:10+binary.BigEndian.Uint16(rdata[8:])]
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(unsigned)
+	mac.Write(keyName)
+	mac.Write([]byte{0, dnsClassAny, 0, 0, 0, 0})
+	mac.Write(algorithm)
+	mac.Write(timers)
+	mac.Write([]byte{0, 0, 0, 0})
+	return hmac.Equal(mac.Sum(nil), sum)
+}
+
+func TestRFC2136DNS(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	addr, updates := fakeNameserver(t, secret)
+	d := newRFC2136DNS(addr, "", "acme-key", base64.StdEncoding.EncodeToString(secret), "hmac-sha256")
+	ctx := context.Background()
+
+	if err := d.present(ctx, "_acme-challenge.www.example.com.", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.cleanup(ctx, "_acme-challenge.www.example.com.", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []struct {
+		class dnsmessage.Class
+		ttl   uint32
+	}{{dnsmessage.ClassINET, 120}, {dnsClassNone, 0}} {
+		msg := <-updates
+		if len(msg.Questions) != 1 || msg.Questions[0].Name.String() != "example.com." || msg.Questions[0].Type != dnsmessage.TypeSOA {
+			t.Errorf("zone section %v, want the SOA of example.com.", msg.Questions)
+		}
+		if len(msg.Authorities) != 1 {
+			t.Fatalf("update section %v, want one record", msg.Authorities)
+		}
+		rr := msg.Authorities[0]
+		txt, ok := rr.Body.(*dnsmessage.TXTResource)
+		if !ok || rr.Header.Name.String() != "_acme-challenge.www.example.com." || len(txt.TXT) != 1 || txt.TXT[0] != "abc" {
+			t.Errorf("update %v", rr)
+		}
+		if rr.Header.Class != want.class || rr.Header.TTL != want.ttl {
+			t.Errorf("update class %d, TTL %d, want %d, %d", rr.Header.Class, rr.Header.TTL, want.class, want.ttl)
+		}
+	}
+
+	d.secret = []byte("wrong")
+	if err := d.present(ctx, "_acme-challenge.www.example.com.", "abc"); err == nil {
+		t.Error("update signed with the wrong key succeeded")
+	}
+}
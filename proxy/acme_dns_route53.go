@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// route53DNS manages challenge records through the Route 53 API, signing
+// its requests with AWS Signature Version 4
+type route53DNS struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	hostedZoneID    string
+	baseURL         string
+	client          *http.Client
+	pollInterval    time.Duration
+	now             func() time.Time
+}
+
+func newRoute53DNS(accessKeyID, secretAccessKey, sessionToken, hostedZoneID string) *route53DNS {
+	if accessKeyID == "" {
+		accessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		secretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	return &route53DNS{
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    sessionToken,
+		hostedZoneID:    strings.TrimPrefix(hostedZoneID, "/hostedzone/"),
+		baseURL:         "https://route53.amazonaws.com/2013-04-01",
+		client:          &http.Client{Timeout: 30 * time.Second},
+		pollInterval:    5 * time.Second,
+		now:             time.Now,
+	}
+}
+
+func (r *route53DNS) present(ctx context.Context, fqdn, value string) error {
+	return r.change(ctx, "UPSERT", fqdn, value)
+}
+
+func (r *route53DNS) cleanup(ctx context.Context, fqdn, value string) error {
+	return r.change(ctx, "DELETE", fqdn, value)
+}
+
+type route53ChangeRequest struct {
+	XMLName     xml.Name `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	ChangeBatch struct {
+		Changes []route53Change `xml:"Changes>Change"`
+	}
+}
+
+type route53Change struct {
+	Action            string
+	ResourceRecordSet struct {
+		Name            string
+		Type            string
+		TTL             int
+		ResourceRecords []string `xml:"ResourceRecords>ResourceRecord>Value"`
+	}
+}
+
+type route53ChangeInfo struct {
+	ID     string `xml:"ChangeInfo>Id"`
+	Status string `xml:"ChangeInfo>Status"`
+}
+
+// change applies one change to the TXT record of fqdn and waits until it
+// has reached every Route 53 name server
+func (r *route53DNS) change(ctx context.Context, action, fqdn, value string) error {
+	zoneID := r.hostedZoneID
+	if zoneID == "" {
+		var err error
+		if zoneID, err = r.zoneID(ctx, strings.TrimSuffix(fqdn, ".")); err != nil {
+			return err
+		}
+	}
+
+	var c route53Change
+	c.Action = action
+	c.ResourceRecordSet.Name = fqdn
+	c.ResourceRecordSet.Type = "TXT"
+	c.ResourceRecordSet.TTL = 60
+	c.ResourceRecordSet.ResourceRecords = []string{`"` + value + `"`}
+	var req route53ChangeRequest
+	req.ChangeBatch.Changes = []route53Change{c}
+	body, err := xml.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	var info route53ChangeInfo
+	if err := r.do(ctx, http.MethodPost, "/hostedzone/"+zoneID+"/rrset", body, &info); err != nil {
+		return err
+	}
+	for info.Status != "INSYNC" {
+		select {
+		case <-time.After(r.pollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := r.do(ctx, http.MethodGet, "/change/"+strings.TrimPrefix(info.ID, "/change/"), nil, &info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zoneID finds the hosted zone holding name, trying each parent domain
+func (r *route53DNS) zoneID(ctx context.Context, name string) (string, error) {
+	for zone := name; strings.Contains(zone, "."); zone = zone[strings.IndexByte(zone, '.')+1:] {
+		var zones struct {
+			HostedZones []struct {
+				ID   string `xml:"Id"`
+				Name string
+			} `xml:"HostedZones>HostedZone"`
+		}
+		query := "?dnsname=" + url.QueryEscape(zone) + "&maxitems=1"
+		if err := r.do(ctx, http.MethodGet, "/hostedzonesbyname"+query, nil, &zones); err != nil {
+			return "", err
+		}
+		// The list starts at the first zone sorted at or after dnsname
+		if len(zones.HostedZones) > 0 && strings.EqualFold(zones.HostedZones[0].Name, zone+".") {
+			return strings.TrimPrefix(zones.HostedZones[0].ID, "/hostedzone/"), nil
+		}
+	}
+	return "", fmt.Errorf("no route53 hosted zone holds %s", name)
+}
+
+// do calls the API and decodes its XML response into result
+func (r *route53DNS) do(ctx context.Context, method, path string, body []byte, result any) error {
+	req, err := http.NewRequestWithContext(ctx, method, r.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	if r.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", r.sessionToken)
+	}
+	signV4(req, body, r.accessKeyID, r.secretAccessKey, "us-east-1", "route53", r.now())
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("route53 API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("route53 API %s %s: %w", method, path, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		var apiErr struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		if xml.Unmarshal(data, &apiErr) == nil && apiErr.Code != "" {
+			return fmt.Errorf("route53 API %s %s: %s: %s: %s", method, path, resp.Status, apiErr.Code, apiErr.Message)
+		}
+		return fmt.Errorf("route53 API %s %s: %s", method, path, resp.Status)
+	}
+	if err := xml.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("route53 API %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// signV4 adds the AWS Signature Version 4 of req to its Authorization
+// header, signing the host, the X-Amz-* headers and the body
+func signV4(req *http.Request, body []byte, accessKeyID, secretAccessKey, region, service string, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	date := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	payload := sha256.Sum256(body)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") || name == "content-type" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalQuery sorts the query by name and value and escapes it as
+// SigV4 requires, with %20 for spaces
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, awsEscape(name)+"="+awsEscape(v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
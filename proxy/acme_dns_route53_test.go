@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignV4(t *testing.T) {
+	// Vectors of the AWS SigV4 test suite
+	tests := []struct {
+		name, target, want string
+	}{
+		{"get-vanilla", "/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query-order-key-case", "/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "https://example.amazonaws.com"+tt.target, nil)
+			req.Header = http.Header{}
+			signV4(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, Signature=" + tt.want
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRoute53DNS(t *testing.T) {
+	var changes []route53Change
+	polled := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`<ErrorResponse><Error><Code>InvalidClientTokenId</Code><Message>bad key</Message></Error></ErrorResponse>`))
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/hostedzonesbyname":
+			// Zones are listed from the first one sorted at or after the
+			// name, so other names list a zone that doesn't hold them
+			name := "example.org."
+			if r.URL.Query().Get("dnsname") == "example.com" {
+				name = "example.com."
+			}
+			_, _ = w.Write([]byte(`<ListHostedZonesByNameResponse><HostedZones><HostedZone><Id>/hostedzone/Z1</Id><Name>` +
+				name + `</Name></HostedZone></HostedZones></ListHostedZonesByNameResponse>`))
+		case r.Method == http.MethodPost && r.URL.Path == "/hostedzone/Z1/rrset":
+			var req route53ChangeRequest
+			body, _ := io.ReadAll(r.Body)
+			if err := xml.Unmarshal(body, &req); err != nil {
+				t.Errorf("change request %s: %v", body, err)
+			}
+			changes = append(changes, req.ChangeBatch.Changes...)
+			_, _ = w.Write([]byte(`<ChangeResourceRecordSetsResponse><ChangeInfo><Id>/change/C1</Id><Status>PENDING</Status></ChangeInfo></ChangeResourceRecordSetsResponse>`))
+		case r.Method == http.MethodGet && r.URL.Path == "/change/C1":
+			polled++
+			_, _ = w.Write([]byte(`<GetChangeResponse><ChangeInfo><Id>/change/C1</Id><Status>INSYNC</Status></ChangeInfo></GetChangeResponse>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer api.Close()
+
+	r53 := newRoute53DNS("AKID", "secret", "", "")
+	r53.baseURL = api.URL
+	r53.pollInterval = time.Millisecond
+	ctx := context.Background()
+	if err := r53.present(ctx, "_acme-challenge.www.example.com.", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r53.cleanup(ctx, "_acme-challenge.www.example.com.", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Action != "UPSERT" || changes[1].Action != "DELETE" {
+		t.Fatalf("changes %+v, want an UPSERT and a DELETE", changes)
+	}
+	set := changes[0].ResourceRecordSet
+	if set.Name != "_acme-challenge.www.example.com." || set.Type != "TXT" || len(set.ResourceRecords) != 1 || set.ResourceRecords[0] != `"abc"` {
+		t.Errorf("record set %+v", set)
+	}
+	if polled != 2 {
+		t.Errorf("polled %d changes, want each change waited for", polled)
+	}
+
+	r53.accessKeyID = "wrong"
+	if err := r53.present(ctx, "_acme-challenge.example.com.", "abc"); err == nil || !strings.Contains(err.Error(), "bad key") {
+		t.Errorf("present with a bad key: %v", err)
+	}
+}
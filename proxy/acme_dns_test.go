@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+func TestCloudflareDNS(t *testing.T) {
+	var created map[string]any
+	deleted := ""
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"success":false,"errors":[{"message":"bad token"}]}`))
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/zones":
+			// Only the registered domain is a zone
+			if r.URL.Query().Get("name") == "example.com" {
+				_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"z1"}]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/zones/z1/dns_records":
+			_ = json.NewDecoder(r.Body).Decode(&created)
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"r1"}}`))
+		case r.Method == http.MethodDelete:
+			deleted = r.URL.Path
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"r1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"success":false,"errors":[{"message":"not found"}]}`))
+		}
+	}))
+	defer api.Close()
+
+	cf := newCloudflareDNS("token")
+	cf.baseURL = api.URL
+	ctx := context.Background()
+	if err := cf.present(ctx, "_acme-challenge.www.example.com.", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if created["type"] != "TXT" || created["name"] != "_acme-challenge.www.example.com" || created["content"] != "abc" {
+		t.Errorf("created record %v", created)
+	}
+	if err := cf.cleanup(ctx, "_acme-challenge.www.example.com.", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if deleted != "/zones/z1/dns_records/r1" {
+		t.Errorf("deleted %q, want the created record", deleted)
+	}
+
+	cf.token = "wrong"
+	if err := cf.present(ctx, "_acme-challenge.example.com.", "abc"); err == nil || !strings.Contains(err.Error(), "bad token") {
+		t.Errorf("present with a bad token: %v", err)
+	}
+}
+
+func TestExecDNS(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "hook.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" >> "+out+"\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &execDNS{command: []string{"/bin/sh", script}}
+	ctx := context.Background()
+	if err := e.present(ctx, "_acme-challenge.example.com.", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.cleanup(ctx, "_acme-challenge.example.com.", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	calls, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "present _acme-challenge.example.com. abc\ncleanup _acme-challenge.example.com. abc\n"
+	if string(calls) != want {
+		t.Errorf("hook called with\n%s\nwant\n%s", calls, want)
+	}
+
+	failing := &execDNS{command: []string{"/bin/sh", "-c", "echo zone not found >&2; exit 1", "hook"}}
+	if err := failing.present(ctx, "_acme-challenge.example.com.", "abc"); err == nil || !strings.Contains(err.Error(), "zone not found") {
+		t.Errorf("failing hook: %v", err)
+	}
+}
+
+func TestACMEDNSIssuerServesCachedWildcardCertificate(t *testing.T) {
+	cfg := config.ACMEConfig{
+		Domains:  []string{"*.example.com"},
+		CacheDir: t.TempDir(),
+		DNS:      &config.ACMEDNSConfig{Provider: "exec", Command: []string{"true"}},
+	}
+	ai := newACMEDNSIssuer(cfg, slog.Default())
+
+	if _, ok, err := ai.getCertificate(&tls.ClientHelloInfo{ServerName: "app.example.com"}); !ok || err == nil {
+		t.Fatalf("before issuance: ok %v, err %v; want an error", ok, err)
+	}
+	if _, ok, _ := ai.getCertificate(&tls.ClientHelloInfo{ServerName: "example.org"}); ok {
+		t.Fatal("certificate offered for a name outside the domains")
+	}
+
+	// A cached certificate is served without ordering a new one
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"*.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}, &x509.Certificate{SerialNumber: big.NewInt(1)}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ai.save("*.example.com", [][]byte{der}, key); err != nil {
+		t.Fatal(err)
+	}
+	ai.Start()
+	defer ai.Stop()
+
+	cert, ok, err := ai.getCertificate(&tls.ClientHelloInfo{ServerName: "app.example.com"})
+	if !ok || err != nil {
+		t.Fatalf("after loading the cache: ok %v, err %v", ok, err)
+	}
+	if cert.Leaf.DNSNames[0] != "*.example.com" {
+		t.Errorf("served certificate for %v", cert.Leaf.DNSNames)
+	}
+}
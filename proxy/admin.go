@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// adminServer serves the administrative API on its own listener, separate
+// from proxied traffic
+type adminServer struct {
+	config  config.AdminConfig
+	mux     *http.ServeMux
+	server  *http.Server
+	allowed *ipSet    // nil admits all
+	audit   *auditLog // nil records nothing
+	logger  *slog.Logger
+}
+
+// newAdminServer returns nil when the admin API is disabled
+func newAdminServer(cfg config.AdminConfig, logger *slog.Logger) (*adminServer, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	a := &adminServer{config: cfg, mux: http.NewServeMux(), logger: logger}
+	if len(cfg.AllowedIPs) > 0 {
+		allowed, err := newIPSet(cfg.AllowedIPs)
+		if err != nil {
+			return nil, fmt.Errorf("admin allowed_ips: %w", err)
+		}
+		a.allowed = allowed
+	}
+	audit, err := newAuditLog(cfg.AuditLog)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open admin audit log: %w", err)
+	}
+	a.audit = audit
+	a.server = &http.Server{
+		Addr:              cfg.Address,
+		Handler:           chain(http.HandlerFunc(a.serve), withLogger(logger)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if cfg.TLS != nil {
+		tlsConfig, err := adminTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		a.server.TLSConfig = tlsConfig
+	}
+	return a, nil
+}
+
+// adminTLSConfig loads the admin API's certificate and, for mutual TLS, the
+// CAs client certificates must be signed by
+func adminTLSConfig(cfg *config.AdminTLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load admin certificate: %w", err)
+	}
+	tc := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read admin client_ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("admin client_ca_file %s contains no certificates", cfg.ClientCAFile)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
+
+// serve admits requests from allowed addresses carrying the token, if one
+// is configured; client certificates were already verified by the TLS
+// handshake
+func (a *adminServer) serve(w http.ResponseWriter, r *http.Request) {
+	if a.allowed != nil && !a.allowed.Contains(peerAddr(r)) {
+		writeJSONError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	auth := "none"
+	if a.config.Token != "" && !a.public(r) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.config.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		auth = "token"
+	} else if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		auth = "certificate"
+	}
+
+	// Reads are not audited, nor are the debug endpoints, which only read
+	// whatever the method
+	if a.audit == nil || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions || strings.HasPrefix(r.URL.Path, "/debug/") {
+		a.mux.ServeHTTP(w, r)
+		return
+	}
+	a.audit.serve(w, r, auth, a.mux)
+}
+
+// public reports whether r is for the dashboard page, which holds no data
+// and sends the token with its own requests
+func (a *adminServer) public(r *http.Request) bool {
+	return a.config.Dashboard && r.Method == http.MethodGet && r.URL.Path == "/dashboard"
+}
+
+// authenticated reports whether the API requires anything of its clients
+// besides reaching the address
+func (a *adminServer) authenticated() bool {
+	return a.config.Token != "" || a.allowed != nil || (a.config.TLS != nil && a.config.TLS.ClientCAFile != "")
+}
+
+// handle registers an admin endpoint; it is a no-op when the API is disabled
+func (a *adminServer) handle(pattern string, handler http.HandlerFunc) {
+	if a == nil {
+		return
+	}
+	a.mux.HandleFunc(pattern, handler)
+}
+
+func (a *adminServer) Start(sockets *socketRegistry) error {
+	ln, err := sockets.listen(a.server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for admin API: %w", err)
+	}
+	if a.server.TLSConfig != nil {
+		ln = tls.NewListener(ln, a.server.TLSConfig)
+	}
+	if host, _, _ := net.SplitHostPort(a.server.Addr); !a.authenticated() && !loopbackHost(host) {
+		a.logger.Warn("Admin API is reachable beyond loopback without authentication", "address", a.server.Addr)
+	}
+	go func() {
+		a.logger.Info("Starting admin API", "address", a.server.Addr)
+		if err := a.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			a.logger.Error("Admin API failed", "error", err)
+		}
+	}()
+	return nil
+}
+
+func (a *adminServer) Shutdown(ctx context.Context) error {
+	err := a.server.Shutdown(ctx)
+	if cerr := a.audit.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeJSON sends v as an indented JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// writeJSONError sends an error message as a JSON response
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// loopbackHost reports whether a listen host only accepts local connections
+func loopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
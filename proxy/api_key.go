@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+const (
+	// maxAPIKeyLookupSize bounds the answer read from the lookup service
+	maxAPIKeyLookupSize = 64 << 10
+	// maxAPIKeyCache bounds the number of remembered lookup answers
+	maxAPIKeyCache = 10000
+)
+
+type apiKeyIDKey struct{}
+
+// apiKeyID returns the ID of the API key r was authenticated with, if any
+func apiKeyID(r *http.Request) (string, bool) {
+	id, ok := r.Context().Value(apiKeyIDKey{}).(string)
+	return id, ok
+}
+
+// apiKeyStore resolves an API key to the ID of its owner. A store reports an
+// error only when it can't tell whether the key is valid.
+type apiKeyStore interface {
+	lookup(ctx context.Context, key string) (id string, ok bool, err error)
+}
+
+// apiKeyAuth rejects requests without a valid API key and attaches the key's
+// ID to the request for logs, per-client limits and backends
+type apiKeyAuth struct {
+	config config.APIKeyConfig
+	store  apiKeyStore
+}
+
+// newAPIKeyAuth returns nil when API key authentication is disabled
+func newAPIKeyAuth(cfg config.APIKeyConfig) (*apiKeyAuth, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	ka := &apiKeyAuth{config: cfg}
+	if cfg.KeysFile != "" {
+		store, err := loadAPIKeyFile(cfg.KeysFile)
+		if err != nil {
+			return nil, err
+		}
+		ka.store = store
+	} else {
+		ka.store = &httpKeyStore{
+			url:     cfg.Lookup.URL,
+			header:  cfg.Header,
+			ttl:     cfg.Lookup.CacheTTL,
+			client:  &http.Client{Timeout: cfg.Lookup.Timeout},
+			answers: make(map[[sha256.Size]byte]apiKeyAnswer),
+		}
+	}
+	return ka, nil
+}
+
+// key returns the API key sent with r, from the header or else the query
+func (ka *apiKeyAuth) key(r *http.Request) string {
+	if key := r.Header.Get(ka.config.Header); key != "" {
+		return key
+	}
+	if ka.config.QueryParam != "" {
+		return r.URL.Query().Get(ka.config.QueryParam)
+	}
+	return ""
+}
+
+func (ka *apiKeyAuth) middleware(next http.Handler) http.Handler {
+	if ka == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if excludedPath(r, ka.config.ExcludePaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The identity header only ever comes from a verified key
+		if ka.config.IdentityHeader != "" {
+			r.Header.Del(ka.config.IdentityHeader)
+		}
+
+		key := ka.key(r)
+		if key == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		id, ok, err := ka.store.lookup(r.Context(), key)
+		if err != nil {
+			logRequest(r, slog.LevelError, "API key lookup failed", "error", err)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		if !ok {
+			logRequest(r, slog.LevelDebug, "Invalid API key")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if ka.config.IdentityHeader != "" {
+			r.Header.Set(ka.config.IdentityHeader, id)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyIDKey{}, id)))
+	})
+}
+
+// fileKeyStore holds the SHA-256 digests of the keys listed in a file, so
+// the file never has to contain the keys themselves
+type fileKeyStore struct {
+	ids map[[sha256.Size]byte]string
+}
+
+// loadAPIKeyFile reads lines of "<id> <sha256 hex>"; blank lines and lines
+// starting with # are skipped
+func loadAPIKeyFile(path string) (*fileKeyStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read api_keys keys_file: %w", err)
+	}
+	defer f.Close()
+
+	store := &fileKeyStore{ids: make(map[[sha256.Size]byte]string)}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		var digest [sha256.Size]byte
+		if len(fields) != 2 || hex.DecodedLen(len(fields[1])) != sha256.Size {
+			return nil, fmt.Errorf("api_keys keys_file line %d: expected \"<id> <sha256 hex>\"", n)
+		}
+		if _, err := hex.Decode(digest[:], []byte(fields[1])); err != nil {
+			return nil, fmt.Errorf("api_keys keys_file line %d: %w", n, err)
+		}
+		store.ids[digest] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read api_keys keys_file: %w", err)
+	}
+	return store, nil
+}
+
+func (s *fileKeyStore) lookup(_ context.Context, key string) (string, bool, error) {
+	id, ok := s.ids[sha256.Sum256([]byte(key))]
+	return id, ok, nil
+}
+
+// httpKeyStore asks a lookup service about keys and remembers its answers,
+// valid and invalid alike, for ttl
+type httpKeyStore struct {
+	url    string
+	header string
+	ttl    time.Duration
+	client *http.Client
+
+	mu      sync.Mutex
+	answers map[[sha256.Size]byte]apiKeyAnswer // keyed by digest so keys aren't kept
+}
+
+type apiKeyAnswer struct {
+	id      string
+	ok      bool
+	expires time.Time
+}
+
+func (s *httpKeyStore) lookup(ctx context.Context, key string) (string, bool, error) {
+	digest := sha256.Sum256([]byte(key))
+	now := time.Now()
+	s.mu.Lock()
+	a, found := s.answers[digest]
+	s.mu.Unlock()
+	if found && now.Before(a.expires) {
+		return a.id, a.ok, nil
+	}
+
+	id, ok, err := s.ask(ctx, key)
+	if err != nil {
+		return "", false, err
+	}
+	if ok && id == "" {
+		id = "key-" + hex.EncodeToString(digest[:6])
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.answers) >= maxAPIKeyCache {
+		for d, a := range s.answers {
+			if !now.Before(a.expires) {
+				delete(s.answers, d)
+			}
+		}
+		if len(s.answers) >= maxAPIKeyCache {
+			s.answers = make(map[[sha256.Size]byte]apiKeyAnswer)
+		}
+	}
+	s.answers[digest] = apiKeyAnswer{id: id, ok: ok, expires: now.Add(s.ttl)}
+	return id, ok, nil
+}
+
+// ask sends one key to the lookup service
+func (s *httpKeyStore) ask(ctx context.Context, key string) (string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set(s.header, key)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden ||
+		resp.StatusCode == http.StatusNotFound:
+		return "", false, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return "", false, fmt.Errorf("lookup service returned %s", resp.Status)
+	}
+
+	var answer struct {
+		ID string `json:"id"`
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAPIKeyLookupSize))
+	if err != nil {
+		return "", false, err
+	}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &answer); err != nil {
+			return "", false, fmt.Errorf("invalid lookup answer: %w", err)
+		}
+	}
+	return answer.ID, true, nil
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+func TestAPIKeyExcludePaths(t *testing.T) {
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	// sha256 of "secret"
+	if err := os.WriteFile(keysFile, []byte("ci 2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ka, err := newAPIKeyAuth(config.APIKeyConfig{
+		Enabled:      true,
+		Header:       "X-API-Key",
+		KeysFile:     keysFile,
+		ExcludePaths: []string{"/healthz"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := ka.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		path string
+		key  string
+		want int
+	}{
+		{"/healthz", "", http.StatusOK},
+		{"/healthz/ready", "", http.StatusOK},
+		{"/healthzz", "", http.StatusUnauthorized},
+		{"/healthz/../admin", "", http.StatusUnauthorized},
+		{"/healthz/%2e%2e/admin", "", http.StatusUnauthorized},
+		{"/admin", "", http.StatusUnauthorized},
+		{"/admin", "wrong", http.StatusUnauthorized},
+		{"/admin", "secret", http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.key != "" {
+			r.Header.Set("X-API-Key", tc.key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s with key %q: status %d, want %d", tc.path, tc.key, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// auditLog records admin API requests that change the proxy's state: who
+// sent them, what they did and the endpoint's state before and after
+type auditLog struct {
+	logger *slog.Logger
+	output io.Closer
+}
+
+// newAuditLog returns nil when no audit log is configured
+func newAuditLog(cfg *config.AuditLogConfig) (*auditLog, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	out, err := NewLogOutput(cfg.Logging())
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{logger: NewLogger(cfg.Logging(), out), output: out}, nil
+}
+
+func (al *auditLog) Close() error {
+	if al == nil {
+		return nil
+	}
+	return al.output.Close()
+}
+
+// serve runs a state-changing admin request, snapshotting the endpoint
+// with a GET before and after it to record what changed
+func (al *auditLog) serve(w http.ResponseWriter, r *http.Request, auth string, next http.Handler) {
+	before := snapshot(next, r)
+	rw := newResponseWriter(w)
+	next.ServeHTTP(rw, r)
+	after := snapshot(next, r)
+
+	attrs := []any{
+		"method", r.Method,
+		"path", r.URL.Path,
+		"query", r.URL.RawQuery,
+		"client", peerAddr(r).String(),
+		"auth", auth,
+		"status", rw.status,
+		"before", before,
+		"after", after,
+		"changed", changedFields(before, after),
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		attrs = append(attrs, "certificate", r.TLS.PeerCertificates[0].Subject.String())
+	}
+	al.logger.Info("Admin API request", attrs...)
+}
+
+// snapshot returns what a GET of the request's URL answers, decoded from
+// JSON where possible, or nil if the endpoint doesn't answer GET
+func snapshot(h http.Handler, r *http.Request) any {
+	get := r.Clone(r.Context())
+	get.Method = http.MethodGet
+	get.Body = http.NoBody
+	get.ContentLength = 0
+	sw := &snapshotWriter{header: make(http.Header), status: http.StatusOK}
+	h.ServeHTTP(sw, get)
+	if sw.status != http.StatusOK {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(sw.body.Bytes(), &v); err != nil {
+		return sw.body.String()
+	}
+	return v
+}
+
+// snapshotWriter keeps a response in memory
+type snapshotWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (sw *snapshotWriter) Header() http.Header         { return sw.header }
+func (sw *snapshotWriter) Write(b []byte) (int, error) { return sw.body.Write(b) }
+func (sw *snapshotWriter) WriteHeader(status int)      { sw.status = status }
+
+// changedFields lists the top-level fields of two JSON objects whose
+// values differ
+func changedFields(before, after any) []string {
+	b, _ := before.(map[string]any)
+	a, _ := after.(map[string]any)
+	var changed []string
+	for k, v := range b {
+		if w, ok := a[k]; !ok || !reflect.DeepEqual(v, w) {
+			changed = append(changed, k)
+		}
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
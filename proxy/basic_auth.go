@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared against for unknown users so that response timing
+// doesn't reveal which user names exist
+var dummyHash = []byte("$2a$10$bEZmdQBcUWxYa3U/rp1aiuYMRBbfHGTm9xzcIBeqtn/RNkxnp1wfe")
+
+// basicAuth checks HTTP basic credentials against bcrypt password hashes
+type basicAuth struct {
+	challenge string
+	users     map[string][]byte
+
+	// bcrypt is deliberately slow, so credentials that verified once are
+	// remembered by digest rather than checked on every request
+	mu       sync.RWMutex
+	verified map[[sha256.Size]byte]bool
+}
+
+// newBasicAuth returns nil when the route has no basic auth
+func newBasicAuth(cfg *config.BasicAuthConfig) (*basicAuth, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	ba := &basicAuth{
+		challenge: fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, cfg.Realm),
+		users:     make(map[string][]byte),
+		verified:  make(map[[sha256.Size]byte]bool),
+	}
+	if cfg.HtpasswdFile != "" {
+		if err := ba.loadHtpasswd(cfg.HtpasswdFile); err != nil {
+			return nil, err
+		}
+	}
+	// Inline users take precedence over the file
+	for user, hash := range cfg.Users {
+		ba.users[user] = []byte(hash)
+	}
+	return ba, nil
+}
+
+// loadHtpasswd reads "user:hash" lines; only bcrypt hashes are accepted
+func (ba *basicAuth) loadHtpasswd(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open htpasswd file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return fmt.Errorf("htpasswd file %s line %d: expected user:hash", path, line)
+		}
+		if !strings.HasPrefix(hash, "$2") {
+			return fmt.Errorf("htpasswd file %s line %d: only bcrypt hashes are supported (htpasswd -B)", path, line)
+		}
+		ba.users[user] = []byte(hash)
+	}
+	return scanner.Err()
+}
+
+// authorize reports whether r carries valid credentials, and otherwise
+// answers with a 401 challenge
+func (ba *basicAuth) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if ba == nil {
+		return true
+	}
+
+	if user, pass, ok := r.BasicAuth(); ok && ba.check(user, pass) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", ba.challenge)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+func (ba *basicAuth) check(user, pass string) bool {
+	digest := sha256.Sum256([]byte(user + "\x00" + pass))
+	ba.mu.RLock()
+	ok := ba.verified[digest]
+	ba.mu.RUnlock()
+	if ok {
+		return true
+	}
+
+	hash, known := ba.users[user]
+	if !known {
+		hash = dummyHash
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(pass)) != nil || !known {
+		return false
+	}
+
+	ba.mu.Lock()
+	ba.verified[digest] = true
+	ba.mu.Unlock()
+	return true
+}
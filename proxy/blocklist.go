@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// maxBlocklistSize bounds the size of a downloaded feed
+const maxBlocklistSize = 64 << 20
+
+// blocklistFeed keeps the latest successfully fetched copy of a remote feed
+type blocklistFeed struct {
+	config config.BlocklistFeed
+	client *http.Client
+	logger *slog.Logger
+
+	mu           sync.RWMutex
+	set          *ipSet
+	etag         string
+	lastModified string
+}
+
+// blocklistManager denies clients whose address appears in any subscribed feed
+type blocklistManager struct {
+	feeds []*blocklistFeed
+	stop  chan struct{}
+}
+
+// newBlocklistManager returns nil when no feeds are configured
+func newBlocklistManager(feeds []config.BlocklistFeed, logger *slog.Logger) *blocklistManager {
+	if len(feeds) == 0 {
+		return nil
+	}
+
+	bm := &blocklistManager{stop: make(chan struct{})}
+	for _, f := range feeds {
+		bm.feeds = append(bm.feeds, &blocklistFeed{
+			config: f,
+			client: &http.Client{Timeout: f.Timeout},
+			logger: logger,
+		})
+	}
+	return bm
+}
+
+func (bm *blocklistManager) Start() {
+	for _, feed := range bm.feeds {
+		go bm.run(feed)
+	}
+}
+
+func (bm *blocklistManager) Stop() {
+	close(bm.stop)
+}
+
+func (bm *blocklistManager) run(feed *blocklistFeed) {
+	refresh := func() {
+		if err := feed.refresh(); err != nil {
+			feed.logger.Error("Failed to refresh blocklist", "url", feed.config.URL, "error", err)
+		}
+	}
+	refresh()
+
+	ticker := time.NewTicker(feed.config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			refresh()
+		case <-bm.stop:
+			return
+		}
+	}
+}
+
+// Blocked reports whether addr appears in any feed
+func (bm *blocklistManager) Blocked(addr netip.Addr) bool {
+	for _, feed := range bm.feeds {
+		feed.mu.RLock()
+		set := feed.set
+		feed.mu.RUnlock()
+		if set.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bm *blocklistManager) middleware(next http.Handler) http.Handler {
+	if bm == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if bm.Blocked(clientAddr(r)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refresh downloads the feed, keeping the previous copy if it is unchanged
+// or the download fails
+func (f *blocklistFeed) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), f.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.config.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	f.mu.RLock()
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+	if f.lastModified != "" {
+		req.Header.Set("If-Modified-Since", f.lastModified)
+	}
+	f.mu.RUnlock()
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	prefixes, skipped, err := parseBlocklist(io.LimitReader(resp.Body, maxBlocklistSize))
+	if err != nil {
+		return err
+	}
+	set := newIPSetFromPrefixes(prefixes)
+
+	f.mu.Lock()
+	f.set = set
+	f.etag = resp.Header.Get("ETag")
+	f.lastModified = resp.Header.Get("Last-Modified")
+	f.mu.Unlock()
+
+	f.logger.Info("Loaded blocklist", "url", f.config.URL, "entries", len(prefixes), "ranges", set.Len(), "skipped", skipped)
+	return nil
+}
+
+// parseBlocklist reads one address or CIDR per line. Comments introduced by
+// '#' or ';' and anything after the first field are ignored.
+func parseBlocklist(r io.Reader) (prefixes []netip.Prefix, skipped int, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		p, err := parsePrefix(fields[0])
+		if err != nil {
+			skipped++
+			continue
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, skipped, scanner.Err()
+}
@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// bodyCapture logs the headers and bodies of exchanges matching its rules,
+// which can be replaced at runtime through the admin API
+type bodyCapture struct {
+	mu     sync.RWMutex
+	config config.BodyCaptureConfig
+	rules  []captureRule
+	redact map[string]bool
+}
+
+// captureRule is a capture rule with its matchers compiled
+type captureRule struct {
+	config.CaptureRule
+	methods map[string]bool
+	match   *requestMatcher
+}
+
+func newBodyCapture(cfg config.BodyCaptureConfig) *bodyCapture {
+	bc := &bodyCapture{}
+	bc.set(cfg)
+	return bc
+}
+
+// set replaces the configuration; cfg must be valid
+func (bc *bodyCapture) set(cfg config.BodyCaptureConfig) {
+	rules := make([]captureRule, 0, len(cfg.Rules))
+	for _, r := range cfg.Rules {
+		rule := captureRule{CaptureRule: r, match: newRequestMatcher(r.Match)}
+		if len(r.Methods) > 0 {
+			rule.methods = make(map[string]bool, len(r.Methods))
+			for _, m := range r.Methods {
+				rule.methods[strings.ToUpper(m)] = true
+			}
+		}
+		rules = append(rules, rule)
+	}
+	redact := make(map[string]bool, len(cfg.RedactHeaders))
+	for _, name := range cfg.RedactHeaders {
+		redact[http.CanonicalHeaderKey(name)] = true
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.config = cfg
+	bc.rules = rules
+	bc.redact = redact
+}
+
+// match returns the first rule selecting r, which the route with pattern
+// serves
+func (bc *bodyCapture) match(r *http.Request, pattern string) (captureRule, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if !bc.config.Enabled {
+		return captureRule{}, false
+	}
+	for _, rule := range bc.rules {
+		if rule.Route != "" && rule.Route != pattern {
+			continue
+		}
+		if !strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+			continue
+		}
+		if rule.methods != nil && !rule.methods[r.Method] {
+			continue
+		}
+		if !rule.match.matches(r) {
+			continue
+		}
+		return rule, true
+	}
+	return captureRule{}, false
+}
+
+// start captures the exchange of r when a rule selects it. The returned
+// function logs the exchange and must be called once the response is
+// written.
+func (bc *bodyCapture) start(w http.ResponseWriter, r *http.Request, pattern string) (http.ResponseWriter, func()) {
+	rule, ok := bc.match(r, pattern)
+	if !ok {
+		return w, func() {}
+	}
+
+	bc.mu.RLock()
+	max, redact := bc.config.MaxBodySize, bc.redact
+	bc.mu.RUnlock()
+
+	// Request headers are kept as they reached the route, before header
+	// rules change them
+	reqHeaders := headerAttrs(r.Header, redact)
+	reqBody := &captureBuffer{max: max}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, reqBody), r.Body}
+	}
+	cw := &exchangeWriter{ResponseWriter: w, status: http.StatusOK, body: &captureBuffer{max: max}}
+
+	return cw, func() {
+		logRequest(r, slog.LevelInfo, "Captured exchange", "rule", rule.Name,
+			slog.Group("request", "headers", reqHeaders, "body", reqBody.String(), "body_size", reqBody.Size()),
+			slog.Group("response", "status", cw.status, "headers", headerAttrs(cw.Header(), redact),
+				"body", cw.body.String(), "body_size", cw.body.Size()))
+	}
+}
+
+// headerAttrs returns h as log attributes, sorted by name, with the values
+// of redacted headers replaced
+func headerAttrs(h http.Header, redact map[string]bool) slog.Value {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	attrs := make([]slog.Attr, 0, len(names))
+	for _, name := range names {
+		value := strings.Join(h[name], ", ")
+		if redact[http.CanonicalHeaderKey(name)] {
+			value = "REDACTED"
+		}
+		attrs = append(attrs, slog.String(name, value))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// captureBuffer keeps the first max bytes written to it and counts the rest
+type captureBuffer struct {
+	max int
+
+	mu   sync.Mutex // the transport may still read a request body
+	buf  []byte
+	size int64
+}
+
+func (c *captureBuffer) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size += int64(len(p))
+	if room := c.max - len(c.buf); room > 0 {
+		c.buf = append(c.buf, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+func (c *captureBuffer) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// String returns the kept bytes as text, or base64 encoded with a prefix
+// when they are not UTF-8
+func (c *captureBuffer) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if utf8.Valid(c.buf) {
+		return string(c.buf)
+	}
+	return "base64:" + base64.StdEncoding.EncodeToString(c.buf)
+}
+
+// exchangeWriter records the status and the start of the body of a response
+type exchangeWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        *captureBuffer
+}
+
+func (cw *exchangeWriter) WriteHeader(code int) {
+	// 1xx informational responses may precede the final header
+	if code >= 200 && !cw.wroteHeader {
+		cw.status = code
+		cw.wroteHeader = true
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *exchangeWriter) Write(b []byte) (int, error) {
+	cw.wroteHeader = true
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *exchangeWriter) Flush() {
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *exchangeWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+type captureRuleView struct {
+	Name       string            `json:"name,omitempty"`
+	Route      string            `json:"route,omitempty"`
+	PathPrefix string            `json:"path_prefix,omitempty"`
+	Methods    []string          `json:"methods,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Cookies    map[string]string `json:"cookies,omitempty"`
+}
+
+type bodyCaptureView struct {
+	Enabled       bool              `json:"enabled"`
+	MaxBodySize   int               `json:"max_body_size"`
+	RedactHeaders []string          `json:"redact_headers"`
+	Rules         []captureRuleView `json:"rules"`
+}
+
+func (bc *bodyCapture) view() bodyCaptureView {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	v := bodyCaptureView{
+		Enabled:       bc.config.Enabled,
+		MaxBodySize:   bc.config.MaxBodySize,
+		RedactHeaders: bc.config.RedactHeaders,
+		Rules:         make([]captureRuleView, 0, len(bc.config.Rules)),
+	}
+	for _, r := range bc.config.Rules {
+		rv := captureRuleView{
+			Name:       r.Name,
+			Route:      r.Route,
+			PathPrefix: r.PathPrefix,
+			Methods:    r.Methods,
+		}
+		if r.Match != nil {
+			rv.Headers, rv.Cookies = r.Match.Headers, r.Match.Cookies
+		}
+		v.Rules = append(v.Rules, rv)
+	}
+	return v
+}
+
+// adminHandler serves GET /captures to inspect and PUT /captures to replace
+// the body capture configuration. PUT bodies use the same fields as the
+// config file, as JSON or YAML.
+func (bc *bodyCapture) adminHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, bc.view())
+
+	case http.MethodPut:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxAdminBodySize))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var cfg config.BodyCaptureConfig
+		if err := yaml.Unmarshal(body, &cfg); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid body capture configuration: "+err.Error())
+			return
+		}
+		cfg.SetDefaults()
+		if err := cfg.Validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		bc.set(cfg)
+
+		requestLogger(r).Info("Body capture updated via admin API", "enabled", cfg.Enabled, "rules", len(cfg.Rules))
+		writeJSON(w, http.StatusOK, bc.view())
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// limitBody caps the size of the request body at limit bytes, or at
+// limits.max_request_body_size when limit is 0. A request declaring a
+// larger Content-Length is answered with 413 right away; a chunked body is
+// cut off once it exceeds the limit, failing the attempt with 413 too.
+func (rp *ReverseProxy) limitBody(w http.ResponseWriter, r *http.Request, limit int64) bool {
+	if limit == 0 {
+		limit = rp.config.Limits.MaxRequestBodySize
+	}
+	if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > limit {
+		logRequest(r, slog.LevelWarn, "Request body too large", "limit", limit, "content_length", r.ContentLength)
+		rp.serveError(w, r, http.StatusRequestEntityTooLarge, bodyTooLargeMessage(limit), nil)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
+}
+
+func bodyTooLargeMessage(limit int64) string {
+	return fmt.Sprintf("Request body exceeds the limit of %d bytes", limit)
+}
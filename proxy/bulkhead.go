@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// backendQueue holds the requests of a pool waiting for a backend below its
+// max_connections, first in first out. A request finishing on a capped
+// backend hands its connection slot straight to the oldest waiter, so
+// newcomers can't overtake the queue.
+type backendQueue struct {
+	mu      sync.Mutex
+	waiters list.List // of *queuedRequest
+}
+
+// queuedRequest receives the backend whose slot it was handed
+type queuedRequest struct {
+	backend chan *Backend
+	elem    *list.Element // nil once handed a backend
+}
+
+// join adds a waiter at the back of the queue, or returns nil when max
+// requests are already waiting
+func (q *backendQueue) join(max int) *queuedRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.waiters.Len() >= max {
+		return nil
+	}
+	w := &queuedRequest{backend: make(chan *Backend, 1)}
+	w.elem = q.waiters.PushBack(w)
+	return w
+}
+
+// leave removes a waiter that gave up. It returns the backend the waiter
+// was handed in the meantime, whose slot the caller must pass on.
+func (q *backendQueue) leave(w *queuedRequest) *Backend {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if w.elem != nil {
+		q.waiters.Remove(w.elem)
+		w.elem = nil
+		return nil
+	}
+	return <-w.backend
+}
+
+// handOff gives the slot of backend to the oldest waiter, reporting false
+// when nobody waits
+func (q *backendQueue) handOff(backend *Backend) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	front := q.waiters.Front()
+	if front == nil {
+		return false
+	}
+	w := q.waiters.Remove(front).(*queuedRequest)
+	w.elem = nil
+	w.backend <- backend
+	return true
+}
+
+// acquire takes one of the backend's connection slots, reporting false when
+// it is at its cap
+func (b *Backend) acquire() bool {
+	if b.maxConns == 0 {
+		b.connections.Add(1)
+		return true
+	}
+	for {
+		n := b.connections.Load()
+		if n >= int64(b.maxConns) {
+			return false
+		}
+		if b.connections.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// saturated reports whether a backend of the pool is usable but at its cap,
+// so that a request finding no backend may wait for one to free up
+func (p *backendPool) saturated() bool {
+	for _, b := range p.backends() {
+		if b.maxConns > 0 && b.inService() && b.GetConnections() >= b.maxConns {
+			return true
+		}
+	}
+	return false
+}
+
+// pick chooses a backend of pool and takes one of its connection slots.
+// While every usable backend is at its max_connections the request joins
+// the pool's queue of at most limits.backend_queue requests and waits up to
+// limits.backend_queue_timeout for a slot.
+func (rp *ReverseProxy) pick(w http.ResponseWriter, r *http.Request, pool *backendPool) *Backend {
+	var waiter *queuedRequest
+	var deadline <-chan time.Time
+	for {
+		var backend *Backend
+		if rp.sticky != nil {
+			backend = rp.sticky.nextBackend(w, r, pool)
+		} else {
+			backend = pool.NextBackend(r)
+		}
+		if backend != nil && backend.acquire() {
+			if waiter != nil {
+				if handed := pool.queue.leave(waiter); handed != nil {
+					rp.release(pool, handed)
+				}
+			}
+			return backend
+		}
+		if backend != nil {
+			// Another request took the last slot; pick again
+			continue
+		}
+
+		if !pool.saturated() || rp.config.Limits.BackendQueueTimeout == 0 {
+			return nil
+		}
+		if waiter == nil {
+			if waiter = pool.queue.join(rp.config.Limits.BackendQueue); waiter == nil {
+				return nil
+			}
+			timer := time.NewTimer(rp.config.Limits.BackendQueueTimeout)
+			defer timer.Stop()
+			deadline = timer.C
+
+			// A slot freed before joining went unclaimed; look again
+			continue
+		}
+
+		select {
+		case backend := <-waiter.backend:
+			return backend
+		case <-deadline:
+		case <-r.Context().Done():
+		}
+		if handed := pool.queue.leave(waiter); handed != nil {
+			rp.release(pool, handed)
+		}
+		return nil
+	}
+}
+
+// release returns a connection slot taken by pick, handing it to the oldest
+// request waiting in the pool's queue while the backend can take it and
+// belongs to the failover group requests go to
+func (rp *ReverseProxy) release(pool *backendPool, backend *Backend) {
+	if backend.maxConns > 0 && backend.inService() && pool.preferred(backend) && pool.queue.handOff(backend) {
+		return
+	}
+	backend.connections.Add(-1)
+}
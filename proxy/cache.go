@@ -0,0 +1,620 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// cacheableStatus lists the status codes a response may be stored with
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// responseCache keeps cacheable backend responses in memory so repeated
+// requests are answered without reaching a backend
+type responseCache struct {
+	config config.CacheConfig
+
+	// where requests carry API keys, which make them personal like
+	// Authorization does
+	apiKeyHeader string
+	apiKeyQuery  string
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // by key and the values of the Vary headers
+	vary    map[string]*varyIndex    // by key
+	order   *list.List               // most recently used at the front
+	size    int64
+
+	refreshing map[string]bool          // keys of stale entries being refreshed in the background
+	inflight   map[string]chan struct{} // misses being fetched, closed once stored
+
+	hits      uint64
+	misses    uint64
+	stale     uint64
+	coalesced uint64
+	stores    uint64
+	evictions uint64
+}
+
+// varyIndex names the request headers that select among the stored
+// variants of one key, as the latest stored response Varies by
+type varyIndex struct {
+	names   []string
+	entries int
+}
+
+type cachedResponse struct {
+	key     string // the entry's own, including the Vary header values
+	base    string // the key without them
+	url     string
+	status  int
+	header  http.Header
+	body    []byte
+	tags    []string // surrogate keys for purging related responses together
+	stored  time.Time
+	age     time.Duration // age the response already had when it was stored
+	expires time.Time
+
+	// how long after expires the response may still be served stale
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+}
+
+// newResponseCache returns nil when caching is disabled
+func newResponseCache(cfg config.CacheConfig, apiKeys config.APIKeyConfig) *responseCache {
+	if !cfg.Enabled {
+		return nil
+	}
+	c := &responseCache{
+		config:  cfg,
+		entries: make(map[string]*list.Element),
+		vary:    make(map[string]*varyIndex),
+		order:   list.New(),
+
+		refreshing: make(map[string]bool),
+		inflight:   make(map[string]chan struct{}),
+	}
+	if apiKeys.Enabled {
+		c.apiKeyHeader, c.apiKeyQuery = apiKeys.Header, apiKeys.QueryParam
+	}
+	return c
+}
+
+// serve answers r from the cache when a fresh response is stored, and
+// otherwise fetches it and stores the response if it is cacheable, as the
+// route's policy says. An expired response may still be served while it is
+// refreshed in the background, or in place of a server error.
+func (c *responseCache) serve(w http.ResponseWriter, r *http.Request, policy cachePolicy, fetch func(http.ResponseWriter, *http.Request)) {
+	if c == nil || !c.cacheableRequest(r) {
+		fetch(w, r)
+		return
+	}
+
+	rawURL, key := policy.key(r)
+	directives := parseCacheControl(r.Header.Get("Cache-Control"))
+	_, revalidate := directives["no-cache"]
+	if len(directives) == 0 && r.Header.Get("Pragma") == "no-cache" {
+		revalidate = true
+	}
+	if maxAge, ok := directives["max-age"]; ok && maxAge == "0" {
+		revalidate = true
+	}
+
+	var stale *cachedResponse
+	if !revalidate {
+		if entry := c.lookup(key, r); entry != nil {
+			now := time.Now()
+			switch {
+			case now.Before(entry.expires):
+				c.count(&c.hits)
+				writeCachedResponse(w, r, entry, "HIT")
+				return
+			case now.Before(entry.expires.Add(entry.staleWhileRevalidate)):
+				c.count(&c.stale)
+				writeCachedResponse(w, r, entry, "STALE")
+				c.refresh(entry, r, policy, fetch)
+				return
+			case now.Before(entry.expires.Add(entry.staleIfError)):
+				stale = entry
+			}
+		}
+	}
+
+	// Ranges are served from stored responses, but a partial response from
+	// the backend isn't stored
+	partial := r.Header.Get("Range") != ""
+
+	_, noStore := directives["no-store"]
+	if c.config.Coalesce && !revalidate && !noStore && !partial && r.Method == http.MethodGet {
+		flight, leader := c.join(key, r)
+		if !leader {
+			if entry := c.await(flight, key, r); entry != nil {
+				c.count(&c.coalesced)
+				writeCachedResponse(w, r, entry, "HIT")
+				return
+			}
+		} else {
+			defer c.leave(flight)
+		}
+	}
+
+	// A server error is held back so the stale response can replace it
+	if stale != nil {
+		sw := &staleIfErrorWriter{ResponseWriter: w, header: make(http.Header)}
+		defer func() {
+			if sw.failed {
+				c.count(&c.stale)
+				writeCachedResponse(sw.ResponseWriter, r, stale, "STALE")
+			}
+		}()
+		w = sw
+	}
+
+	c.count(&c.misses)
+	w.Header().Set("X-Cache", "MISS")
+
+	// Only full GET responses are stored; HEAD and range misses are passed
+	// through
+	if noStore || partial || r.Method != http.MethodGet {
+		fetch(w, r)
+		return
+	}
+	c.fetch(w, r, key, rawURL, policy, fetch)
+}
+
+// fetch gets a response from the backend for w and stores it if cacheable
+func (c *responseCache) fetch(w http.ResponseWriter, r *http.Request, key, rawURL string, policy cachePolicy, fetch func(http.ResponseWriter, *http.Request)) {
+	cw := &captureWriter{responseWriter: newResponseWriter(w), limit: c.config.MaxObjectSize}
+	fetch(cw, r)
+	if cw.overflow || cw.header == nil {
+		return
+	}
+	c.store(key, rawURL, r, cw.status, cw.header, cw.body, policy)
+}
+
+// count increments one of the cache's counters
+func (c *responseCache) count(counter *uint64) {
+	c.mu.Lock()
+	*counter++
+	c.mu.Unlock()
+}
+
+// cacheableRequest reports whether r may be answered from the cache.
+// Requests with credentials, in Authorization or as an API key, always go
+// to the backend. Cookies aren't taken as credentials; see key_cookies.
+func (c *responseCache) cacheableRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" || r.Header.Get("Upgrade") != "" {
+		return false
+	}
+	if c.apiKeyHeader != "" && r.Header.Get(c.apiKeyHeader) != "" {
+		return false
+	}
+	return c.apiKeyQuery == "" || !r.URL.Query().Has(c.apiKeyQuery)
+}
+
+// cachePolicy is how a route's responses are cached
+type cachePolicy struct {
+	ttl        time.Duration // replaces the lifetime the backend gave a response when set
+	keyHeaders []string
+	keyCookies []string
+	keyQuery   map[string]bool // nil keys the whole query
+
+	// replace the backend's stale-while-revalidate and stale-if-error when set
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+}
+
+func newCachePolicy(ttl time.Duration, cfg *config.RouteCacheConfig) cachePolicy {
+	p := cachePolicy{ttl: ttl}
+	if cfg == nil {
+		return p
+	}
+	for _, name := range cfg.KeyHeaders {
+		p.keyHeaders = append(p.keyHeaders, http.CanonicalHeaderKey(name))
+	}
+	p.keyCookies = cfg.KeyCookies
+	p.staleWhileRevalidate, p.staleIfError = cfg.StaleWhileRevalidate, cfg.StaleIfError
+	if len(cfg.KeyQuery) > 0 {
+		p.keyQuery = make(map[string]bool, len(cfg.KeyQuery))
+		for _, name := range cfg.KeyQuery {
+			p.keyQuery[name] = true
+		}
+	}
+	return p
+}
+
+// key returns the URL r is cached under, with the query limited to the
+// policy's parameters, and the key, which adds the policy's headers and
+// cookies to it
+func (p cachePolicy) key(r *http.Request) (string, string) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	uri := r.URL.RequestURI()
+	if p.keyQuery != nil {
+		query := r.URL.Query()
+		for name := range query {
+			if !p.keyQuery[name] {
+				delete(query, name)
+			}
+		}
+		uri = r.URL.EscapedPath()
+		if uri == "" {
+			uri = "/"
+		}
+		if len(query) > 0 {
+			uri += "?" + query.Encode()
+		}
+	}
+	rawURL := scheme + "://" + strings.ToLower(r.Host) + uri
+
+	key := rawURL
+	for _, name := range p.keyHeaders {
+		key += "\x00" + name + ": " + strings.Join(r.Header.Values(name), ", ")
+	}
+	for _, name := range p.keyCookies {
+		value := ""
+		if cookie, err := r.Cookie(name); err == nil {
+			value = cookie.Value
+		}
+		key += "\x00cookie " + name + "=" + value
+	}
+	return rawURL, key
+}
+
+// varyKey appends the values r has for the Vary headers names to key
+func varyKey(key string, names []string, r *http.Request) string {
+	for _, name := range names {
+		key += "\x01" + name + ": " + strings.Join(r.Header.Values(name), ", ")
+	}
+	return key
+}
+
+// lookup returns the entry stored for key that matches r's Vary headers
+// while it is fresh or may be served stale, dropping it once it may not
+func (c *responseCache) lookup(key string, r *http.Request) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vi, ok := c.vary[key]
+	if !ok {
+		return nil
+	}
+	el, ok := c.entries[varyKey(key, vi.names, r)]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*cachedResponse)
+	if !time.Now().Before(entry.expires.Add(max(entry.staleWhileRevalidate, entry.staleIfError))) {
+		c.remove(el)
+		return nil
+	}
+
+	c.order.MoveToFront(el)
+	return entry
+}
+
+// store saves a response to r if its status and headers allow a shared
+// cache to, as one variant of key when it Varies by request headers
+func (c *responseCache) store(key, rawURL string, r *http.Request, status int, header http.Header, body []byte, policy cachePolicy) {
+	if !cacheableStatus[status] {
+		return
+	}
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[d]; ok {
+			return
+		}
+	}
+
+	var vary []string
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return
+			}
+			if name != "" && !slices.Contains(vary, name) {
+				vary = append(vary, name)
+			}
+		}
+	}
+	sort.Strings(vary)
+
+	now := time.Now()
+	var age time.Duration
+	if secs, err := strconv.Atoi(header.Get("Age")); err == nil && secs > 0 {
+		age = time.Duration(secs) * time.Second
+	}
+	lifetime := policy.ttl
+	if lifetime == 0 {
+		lifetime = c.lifetime(header, directives, now) - age
+	}
+	if lifetime <= 0 {
+		return
+	}
+
+	// Cookies belong to the client that triggered the fetch
+	header = header.Clone()
+	header.Del("Set-Cookie")
+	header.Del("X-Cache")
+	header.Del("Age")
+
+	entry := &cachedResponse{
+		key:     varyKey(key, vary, r),
+		base:    key,
+		url:     rawURL,
+		status:  status,
+		header:  header,
+		body:    body,
+		tags:    strings.Fields(header.Get(c.config.TagHeader)),
+		stored:  now,
+		age:     age,
+		expires: now.Add(lifetime),
+	}
+	entry.staleWhileRevalidate, entry.staleIfError = staleLifetimes(directives, policy)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[entry.key]; ok {
+		c.remove(el)
+	}
+	// Responses Varying by other headers than before select the variants
+	// from now on; those stored under the old ones age out
+	vi, ok := c.vary[key]
+	if !ok {
+		vi = &varyIndex{}
+		c.vary[key] = vi
+	}
+	vi.names = vary
+	vi.entries++
+	c.entries[entry.key] = c.order.PushFront(entry)
+	c.size += int64(len(body))
+	c.stores++
+
+	for c.order.Len() > c.config.MaxEntries || c.size > c.config.MaxSize {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+// lifetime is the freshness lifetime the backend gave a response: s-maxage,
+// then max-age, then Expires, falling back to the configured default
+func (c *responseCache) lifetime(header http.Header, directives map[string]string, now time.Time) time.Duration {
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := directives[d]; ok {
+			secs, err := strconv.Atoi(v)
+			if err != nil {
+				return 0
+			}
+			return time.Duration(secs) * time.Second
+		}
+	}
+
+	if v := header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0 // an invalid Expires means already expired
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		return expires.Sub(date)
+	}
+
+	return c.config.DefaultTTL
+}
+
+// remove drops an entry; callers hold mu
+func (c *responseCache) remove(el *list.Element) {
+	entry := el.Value.(*cachedResponse)
+	c.order.Remove(el)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.body))
+	if vi := c.vary[entry.base]; vi != nil {
+		if vi.entries--; vi.entries == 0 {
+			delete(c.vary, entry.base)
+		}
+	}
+}
+
+// purge drops the entries selected by match and returns how many there were
+func (c *responseCache) purge(match func(*cachedResponse) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if match(el.Value.(*cachedResponse)) {
+			c.remove(el)
+			purged++
+		}
+		el = next
+	}
+	return purged
+}
+
+// purgeMatcher selects entries from the admin query: exact url, url prefix
+// or surrogate tag. URLs must name a host; both schemes are purged.
+// With no criteria every entry is selected.
+func purgeMatcher(q url.Values) (func(*cachedResponse) bool, error) {
+	if tag := q.Get("tag"); tag != "" {
+		return func(e *cachedResponse) bool {
+			for _, t := range e.tags {
+				if t == tag {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	for _, param := range []string{"url", "prefix"} {
+		raw := q.Get(param)
+		if raw == "" {
+			continue
+		}
+		if !strings.Contains(raw, "://") {
+			raw = "http://" + raw
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid %s %q: must include a host", param, q.Get(param))
+		}
+		target := strings.ToLower(u.Host) + u.RequestURI()
+		if param == "url" {
+			return func(e *cachedResponse) bool { return stripScheme(e.url) == target }, nil
+		}
+		return func(e *cachedResponse) bool { return strings.HasPrefix(stripScheme(e.url), target) }, nil
+	}
+
+	return func(*cachedResponse) bool { return true }, nil
+}
+
+func stripScheme(key string) string {
+	_, rest, _ := strings.Cut(key, "://")
+	return rest
+}
+
+// writeCachedResponse answers r with a stored response, or the ranges of it
+// r asks for; state is HIT, or STALE for an expired one
+func writeCachedResponse(w http.ResponseWriter, r *http.Request, entry *cachedResponse, state string) {
+	h := w.Header()
+	for k, v := range entry.header {
+		h[k] = v
+	}
+	h.Set("X-Cache", state)
+	h.Set("Age", strconv.Itoa(int((entry.age + time.Since(entry.stored)).Seconds())))
+
+	if etag := entry.header.Get("ETag"); etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if entry.status == http.StatusOK && r.Header.Get("Range") != "" {
+		// ServeContent sets the length of what it sends, applies If-Range
+		// and keeps the stored Content-Type, or its absence
+		h.Del("Content-Length")
+		if _, ok := h["Content-Type"]; !ok {
+			h["Content-Type"] = nil
+		}
+		modified, _ := http.ParseTime(entry.header.Get("Last-Modified"))
+		http.ServeContent(w, r, "", modified, bytes.NewReader(entry.body))
+		return
+	}
+
+	h.Set("Content-Length", strconv.Itoa(len(entry.body)))
+	w.WriteHeader(entry.status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(entry.body)
+	}
+}
+
+// etagMatches applies the weak comparison If-None-Match calls for
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCacheControl splits a Cache-Control header into lowercase directive
+// names and their (unquoted) values
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+	}
+	return directives
+}
+
+// CacheStats describes the contents and effectiveness of the response cache
+type CacheStats struct {
+	Entries   int    `json:"entries"`
+	Size      int64  `json:"size"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Stale     uint64 `json:"stale"`     // expired responses served while refreshing or in place of errors
+	Coalesced uint64 `json:"coalesced"` // misses answered by another request's fetch
+	Stores    uint64 `json:"stores"`
+	Evictions uint64 `json:"evictions"`
+}
+
+func (c *responseCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Entries:   c.order.Len(),
+		Size:      c.size,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Stale:     c.stale,
+		Coalesced: c.coalesced,
+		Stores:    c.stores,
+		Evictions: c.evictions,
+	}
+}
+
+// adminHandler reports cache statistics on GET. DELETE purges entries
+// matching the url, prefix or tag query parameter, or the whole cache.
+func (c *responseCache) adminHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, c.stats())
+
+	case http.MethodDelete:
+		match, err := purgeMatcher(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		purged := c.purge(match)
+		requestLogger(r).Info("Response cache purged via admin API", "entries", purged, "query", r.URL.RawQuery)
+		writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+)
+
+// join registers a miss for key. The first request to miss a response
+// becomes the leader, which fetches it and must call leave once it is
+// stored; later ones wait for it. Requests are told apart by the values of
+// the headers the key's stored variants Vary by, when known.
+func (c *responseCache) join(key string, r *http.Request) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	flight := key
+	if vi, ok := c.vary[key]; ok {
+		flight = varyKey(key, vi.names, r)
+	}
+	if _, ok := c.inflight[flight]; ok {
+		return flight, false
+	}
+	c.inflight[flight] = make(chan struct{})
+	return flight, true
+}
+
+// leave wakes the requests waiting for the leader's fetch
+func (c *responseCache) leave(flight string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	close(c.inflight[flight])
+	delete(c.inflight, flight)
+}
+
+// await waits for the leader's fetch and returns the fresh response it
+// stored for r. It returns nil when the response wasn't stored, e.g. as it
+// Varies by a header r differs in or isn't cacheable, when the leader takes
+// longer than coalesce_timeout, or when the client gives up, so that r is
+// fetched on its own.
+func (c *responseCache) await(flight, key string, r *http.Request) *cachedResponse {
+	c.mu.Lock()
+	done := c.inflight[flight]
+	c.mu.Unlock()
+	if done == nil {
+		// The leader finished in between
+		return c.lookupFresh(key, r)
+	}
+
+	timer := time.NewTimer(c.config.CoalesceTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return c.lookupFresh(key, r)
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+	return nil
+}
+
+func (c *responseCache) lookupFresh(key string, r *http.Request) *cachedResponse {
+	entry := c.lookup(key, r)
+	if entry == nil || !time.Now().Before(entry.expires) {
+		return nil
+	}
+	return entry
+}
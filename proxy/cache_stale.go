@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// staleLifetimes returns how long after expiring a response may be served
+// while it is refreshed and in place of server errors: the route's settings,
+// or else the backend's stale-while-revalidate and stale-if-error
+// directives. must-revalidate and proxy-revalidate forbid both.
+func staleLifetimes(directives map[string]string, policy cachePolicy) (time.Duration, time.Duration) {
+	for _, d := range []string{"must-revalidate", "proxy-revalidate"} {
+		if _, ok := directives[d]; ok {
+			return 0, 0
+		}
+	}
+	whileRevalidate, ifError := policy.staleWhileRevalidate, policy.staleIfError
+	if whileRevalidate == 0 {
+		whileRevalidate = directiveSeconds(directives, "stale-while-revalidate")
+	}
+	if ifError == 0 {
+		ifError = directiveSeconds(directives, "stale-if-error")
+	}
+	return whileRevalidate, ifError
+}
+
+// directiveSeconds returns the value of a delta-seconds directive, or 0 when
+// it is absent or invalid
+func directiveSeconds(directives map[string]string, name string) time.Duration {
+	secs, err := strconv.Atoi(directives[name])
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// refresh fetches a new copy of an entry that was served stale, unless one
+// is already being fetched. The request is detached from the client's,
+// which ends before the refresh does.
+func (c *responseCache) refresh(entry *cachedResponse, r *http.Request, policy cachePolicy, fetch func(http.ResponseWriter, *http.Request)) {
+	c.mu.Lock()
+	if c.refreshing[entry.key] {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing[entry.key] = true
+	c.mu.Unlock()
+
+	r = r.Clone(context.WithoutCancel(r.Context()))
+	// The client's validators are for its own copy, and neither a 304 nor
+	// a range can be stored
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "Range", "If-Range"} {
+		r.Header.Del(name)
+	}
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.refreshing, entry.key)
+			c.mu.Unlock()
+		}()
+		c.fetch(&discardWriter{header: make(http.Header)}, r, entry.base, entry.url, policy, fetch)
+	}()
+}
+
+// staleIfErrorWriter holds back a response with a server error status, so
+// that a stale response can be served in its place, and passes any other
+// response through
+type staleIfErrorWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+	failed      bool
+}
+
+func (sw *staleIfErrorWriter) Header() http.Header {
+	return sw.header
+}
+
+func (sw *staleIfErrorWriter) WriteHeader(code int) {
+	// Informational responses are dropped, as the final one isn't known yet
+	if sw.wroteHeader || sw.failed || code < 200 {
+		return
+	}
+	switch code {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		sw.failed = true
+		return
+	}
+	sw.wroteHeader = true
+	h := sw.ResponseWriter.Header()
+	for k, v := range sw.header {
+		h[k] = v
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *staleIfErrorWriter) Write(b []byte) (int, error) {
+	if !sw.wroteHeader && !sw.failed {
+		sw.WriteHeader(http.StatusOK)
+	}
+	if sw.failed {
+		return len(b), nil
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *staleIfErrorWriter) Flush() {
+	if sw.wroteHeader {
+		_ = http.NewResponseController(sw.ResponseWriter).Flush()
+	}
+}
+
+// discardWriter is where background refreshes write their responses
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
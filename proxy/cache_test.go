@@ -0,0 +1,268 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+func newTestCache(apiKeys config.APIKeyConfig) *responseCache {
+	return newResponseCache(config.CacheConfig{
+		Enabled:         true,
+		MaxEntries:      100,
+		MaxSize:         1 << 20,
+		MaxObjectSize:   1 << 20,
+		Coalesce:        true,
+		CoalesceTimeout: 5 * time.Second,
+	}, apiKeys)
+}
+
+// countingFetch answers with the number of requests it has served, as a
+// cacheable response with the given Cache-Control
+func countingFetch(cacheControl string) (func(http.ResponseWriter, *http.Request), *atomic.Int32) {
+	var calls atomic.Int32
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Cache-Control", cacheControl)
+		fmt.Fprintf(w, "response %d", n)
+	}, &calls
+}
+
+func serveCached(c *responseCache, policy cachePolicy, fetch func(http.ResponseWriter, *http.Request), target string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	c.serve(w, r, policy, fetch)
+	return w
+}
+
+func TestCacheSkipsCredentialedRequests(t *testing.T) {
+	c := newTestCache(config.APIKeyConfig{Enabled: true, Header: "X-API-Key", QueryParam: "api_key"})
+	fetch, calls := countingFetch("max-age=60")
+	policy := newCachePolicy(0, &config.RouteCacheConfig{KeyQuery: []string{"page"}})
+
+	// Stored by an anonymous request, then served to the next one
+	serveCached(c, policy, fetch, "/report", nil)
+	if w := serveCached(c, policy, fetch, "/report", nil); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("anonymous repeat: X-Cache %q", w.Header().Get("X-Cache"))
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		header map[string]string
+	}{
+		{"authorization", "/report", map[string]string{"Authorization": "Bearer token"}},
+		{"api key header", "/report", map[string]string{"X-API-Key": "k1"}},
+		// key_query leaves the key out of the cache key, so it must not be shared
+		{"api key query", "/report?api_key=k1", nil},
+		{"websocket upgrade", "/report", map[string]string{"Upgrade": "websocket"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := calls.Load()
+			w := serveCached(c, policy, fetch, tt.target, tt.header)
+			if calls.Load() != before+1 || w.Header().Get("X-Cache") != "" {
+				t.Errorf("served from the cache: X-Cache %q, body %q", w.Header().Get("X-Cache"), w.Body.String())
+			}
+		})
+	}
+
+	// Without API keys configured, the header is nothing special
+	c = newTestCache(config.APIKeyConfig{Header: "X-API-Key"})
+	serveCached(c, policy, fetch, "/report", nil)
+	if w := serveCached(c, policy, fetch, "/report", map[string]string{"X-API-Key": "k1"}); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("with API keys disabled: X-Cache %q", w.Header().Get("X-Cache"))
+	}
+}
+
+func TestCacheCoalescesMisses(t *testing.T) {
+	c := newTestCache(config.APIKeyConfig{})
+	release := make(chan struct{})
+	var calls atomic.Int32
+	fetch := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "shared")
+	}
+
+	const clients = 5
+	var wg sync.WaitGroup
+	bodies := make([]string, clients)
+	get := func(i int) {
+		defer wg.Done()
+		bodies[i] = serveCached(c, cachePolicy{}, fetch, "/slow", nil).Body.String()
+	}
+	// The leader's fetch is under way before the others miss
+	wg.Add(1)
+	go get(0)
+	for deadline := time.Now().Add(5 * time.Second); calls.Load() == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i < clients; i++ {
+		wg.Add(1)
+		go get(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("backend fetched %d times, want once", n)
+	}
+	for i, body := range bodies {
+		if body != "shared" {
+			t.Errorf("client %d got %q", i, body)
+		}
+	}
+	// Followers that arrive after the fetch finished are plain hits
+	if s := c.stats(); s.Coalesced == 0 || s.Coalesced+s.Hits != clients-1 {
+		t.Errorf("coalesced %d and hit %d requests, want %d waiting for the leader", s.Coalesced, s.Hits, clients-1)
+	}
+}
+
+// expire makes every stored entry expired by age
+func expire(c *responseCache, age time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.entries {
+		el.Value.(*cachedResponse).expires = time.Now().Add(-age)
+	}
+}
+
+func TestCacheServesStale(t *testing.T) {
+	t.Run("while revalidating", func(t *testing.T) {
+		c := newTestCache(config.APIKeyConfig{})
+		fetch, calls := countingFetch("max-age=60, stale-while-revalidate=30")
+		serveCached(c, cachePolicy{}, fetch, "/page", nil)
+		expire(c, 10*time.Second)
+
+		w := serveCached(c, cachePolicy{}, fetch, "/page", nil)
+		if w.Header().Get("X-Cache") != "STALE" || w.Body.String() != "response 1" {
+			t.Fatalf("expired entry: X-Cache %q, body %q", w.Header().Get("X-Cache"), w.Body.String())
+		}
+		// The refresh runs in the background and replaces the entry
+		for deadline := time.Now().Add(5 * time.Second); calls.Load() < 2 && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if w = serveCached(c, cachePolicy{}, fetch, "/page", nil); w.Body.String() == "response 2" {
+				break
+			}
+		}
+		if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "response 2" {
+			t.Errorf("after the refresh: X-Cache %q, body %q", w.Header().Get("X-Cache"), w.Body.String())
+		}
+
+		// Past the stale window the entry is fetched again
+		expire(c, 31*time.Second)
+		if w = serveCached(c, cachePolicy{}, fetch, "/page", nil); w.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("past stale-while-revalidate: X-Cache %q", w.Header().Get("X-Cache"))
+		}
+	})
+
+	t.Run("if error", func(t *testing.T) {
+		c := newTestCache(config.APIKeyConfig{})
+		policy := newCachePolicy(0, &config.RouteCacheConfig{StaleIfError: time.Minute})
+		failing := false
+		fetch := func(w http.ResponseWriter, r *http.Request) {
+			if failing {
+				http.Error(w, "down", http.StatusBadGateway)
+				return
+			}
+			w.Header().Set("Cache-Control", "max-age=60")
+			fmt.Fprint(w, "good")
+		}
+		serveCached(c, policy, fetch, "/page", nil)
+		expire(c, time.Second)
+		failing = true
+
+		w := serveCached(c, policy, fetch, "/page", nil)
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "STALE" || w.Body.String() != "good" {
+			t.Errorf("backend failing: %d, X-Cache %q, body %q", w.Code, w.Header().Get("X-Cache"), w.Body.String())
+		}
+
+		// A must-revalidate response may not be served stale
+		c = newTestCache(config.APIKeyConfig{})
+		failing = false
+		mustRevalidate := func(w http.ResponseWriter, r *http.Request) {
+			if failing {
+				http.Error(w, "down", http.StatusBadGateway)
+				return
+			}
+			w.Header().Set("Cache-Control", "max-age=60, must-revalidate, stale-if-error=60")
+			fmt.Fprint(w, "good")
+		}
+		serveCached(c, cachePolicy{}, mustRevalidate, "/page", nil)
+		expire(c, time.Second)
+		failing = true
+		if w := serveCached(c, cachePolicy{}, mustRevalidate, "/page", nil); w.Code != http.StatusBadGateway {
+			t.Errorf("must-revalidate: status %d, want the backend's 502", w.Code)
+		}
+	})
+}
+
+func TestCacheServesRanges(t *testing.T) {
+	c := newTestCache(config.APIKeyConfig{})
+	var calls atomic.Int32
+	fetch := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "text/plain")
+		if r.Header.Get("Range") != "" {
+			// A partial response must not be stored
+			w.Header().Set("Content-Range", "bytes 0-1/10")
+			w.WriteHeader(http.StatusPartialContent)
+			fmt.Fprint(w, "01")
+			return
+		}
+		fmt.Fprint(w, "0123456789")
+	}
+
+	// A range miss goes to the backend and isn't stored
+	w := serveCached(c, cachePolicy{}, fetch, "/file", map[string]string{"Range": "bytes=0-1"})
+	if w.Code != http.StatusPartialContent || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("range miss: %d, X-Cache %q", w.Code, w.Header().Get("X-Cache"))
+	}
+	if w = serveCached(c, cachePolicy{}, fetch, "/file", nil); w.Header().Get("X-Cache") != "MISS" || w.Body.String() != "0123456789" {
+		t.Fatalf("full request after a range miss: X-Cache %q, body %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		status int
+		body   string
+	}{
+		{"range", map[string]string{"Range": "bytes=2-4"}, http.StatusPartialContent, "234"},
+		{"suffix range", map[string]string{"Range": "bytes=-3"}, http.StatusPartialContent, "789"},
+		{"matching If-Range", map[string]string{"Range": "bytes=0-0", "If-Range": `"v1"`}, http.StatusPartialContent, "0"},
+		{"stale If-Range", map[string]string{"Range": "bytes=0-0", "If-Range": `"v0"`}, http.StatusOK, "0123456789"},
+		{"unsatisfiable", map[string]string{"Range": "bytes=20-30"}, http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := calls.Load()
+			w := serveCached(c, cachePolicy{}, fetch, "/file", tt.header)
+			if calls.Load() != before {
+				t.Error("range of a stored response reached the backend")
+			}
+			if w.Code != tt.status || w.Header().Get("X-Cache") != "HIT" {
+				t.Fatalf("status %d, X-Cache %q, want %d from the cache", w.Code, w.Header().Get("X-Cache"), tt.status)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body %q, want %q", w.Body.String(), tt.body)
+			}
+		})
+	}
+}
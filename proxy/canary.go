@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// Canary rollout states
+const (
+	CanaryRamping    = "ramping"
+	CanaryCompleted  = "completed"
+	CanaryRolledBack = "rolled-back"
+)
+
+// groupStats accumulates request outcomes for one side of the split
+type groupStats struct {
+	requests int64
+	errors   int64
+	latency  time.Duration
+}
+
+func (s groupStats) errorRate() float64 {
+	if s.requests == 0 {
+		return 0
+	}
+	return float64(s.errors) / float64(s.requests)
+}
+
+func (s groupStats) meanLatency() time.Duration {
+	if s.requests == 0 {
+		return 0
+	}
+	return s.latency / time.Duration(s.requests)
+}
+
+// canaryController routes a share of traffic to the canary backends and
+// ramps that share on a schedule, rolling back to 0% when the canary's
+// error rate or latency degrade relative to the baseline
+type canaryController struct {
+	config config.CanaryConfig
+	logger *slog.Logger
+
+	percentBits uint64 // float64 bits of the current canary percentage
+
+	mu            sync.Mutex
+	state         string
+	baselineStats groupStats
+	canaryStats   groupStats
+
+	stop chan struct{}
+}
+
+// newCanaryController returns nil when no canary split is configured
+func newCanaryController(cfg config.CanaryConfig, logger *slog.Logger) *canaryController {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	cc := &canaryController{
+		config: cfg,
+		logger: logger,
+		state:  CanaryRamping,
+		stop:   make(chan struct{}),
+	}
+	cc.setPercent(cfg.InitialPercent)
+	return cc
+}
+
+// canarySplit balances over the baseline and canary backends of a pool in
+// the proportion set by the controller
+type canarySplit struct {
+	cc       *canaryController
+	baseline LoadBalancer
+	canary   LoadBalancer
+}
+
+// split creates the load balancer that splits backends between the
+// baseline and the canary, each balanced by one newBalancer creates
+func (cc *canaryController) split(backends []*Backend, newBalancer func([]*Backend) LoadBalancer) LoadBalancer {
+	var baseline, canary []*Backend
+	for _, b := range backends {
+		if b.Canary {
+			canary = append(canary, b)
+		} else {
+			baseline = append(baseline, b)
+		}
+	}
+	return &canarySplit{
+		cc:       cc,
+		baseline: newBalancer(baseline),
+		canary:   newBalancer(canary),
+	}
+}
+
+func (cc *canaryController) percent() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&cc.percentBits))
+}
+
+func (cc *canaryController) setPercent(p float64) {
+	atomic.StoreUint64(&cc.percentBits, math.Float64bits(p))
+}
+
+// NextBackend picks the canary for the configured share of requests and
+// falls back to the baseline when no canary backend is available
+func (cs *canarySplit) NextBackend(r *http.Request) *Backend {
+	if p := cs.cc.percent(); p > 0 && rand.Float64()*100 < p {
+		if b := cs.canary.NextBackend(r); b != nil {
+			return b
+		}
+	}
+	return cs.baseline.NextBackend(r)
+}
+
+// record accounts a finished request against the side of the split that served it
+func (cc *canaryController) record(b *Backend, status int, elapsed time.Duration) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	stats := &cc.baselineStats
+	if b.Canary {
+		stats = &cc.canaryStats
+	}
+	stats.requests++
+	stats.latency += elapsed
+	if status >= 500 {
+		stats.errors++
+	}
+}
+
+func (cc *canaryController) Start() {
+	ticker := time.NewTicker(cc.config.StepInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !cc.step() {
+					return
+				}
+			case <-cc.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (cc *canaryController) Stop() {
+	close(cc.stop)
+}
+
+// step judges the last interval and advances the rollout. It returns false
+// once the rollout has finished in either direction.
+func (cc *canaryController) step() bool {
+	cc.mu.Lock()
+	base, canary := cc.baselineStats, cc.canaryStats
+	cc.baselineStats, cc.canaryStats = groupStats{}, groupStats{}
+	cc.mu.Unlock()
+
+	current := cc.percent()
+
+	// Not enough canary traffic to judge yet; hold the current share
+	if canary.requests < int64(cc.config.MinRequests) {
+		cc.logger.Info("Canary holding: too few requests this interval", "percent", current, "requests", canary.requests)
+		return true
+	}
+
+	if reason := cc.breach(base, canary); reason != "" {
+		cc.setPercent(0)
+		cc.setState(CanaryRolledBack)
+		cc.logger.Warn("Canary rolled back to 0%", "from_percent", current, "reason", reason)
+		return false
+	}
+
+	next := math.Min(current+cc.config.StepPercent, cc.config.MaxPercent)
+	cc.setPercent(next)
+	cc.logger.Info("Canary healthy, ramping",
+		"error_rate", formatPercent(canary.errorRate()), "baseline_error_rate", formatPercent(base.errorRate()),
+		"latency", canary.meanLatency(), "baseline_latency", base.meanLatency(), "from_percent", current, "to_percent", next)
+
+	if next >= cc.config.MaxPercent {
+		cc.setState(CanaryCompleted)
+		cc.logger.Info("Canary rollout completed", "percent", next)
+		return false
+	}
+	return true
+}
+
+// breach describes why the canary is worse than the baseline, or returns ""
+func (cc *canaryController) breach(base, canary groupStats) string {
+	if canary.errorRate() > base.errorRate()+cc.config.MaxErrorRateIncrease {
+		return "error rate " + formatPercent(canary.errorRate()) + " exceeds baseline " + formatPercent(base.errorRate())
+	}
+	if base.requests > 0 && cc.config.MaxLatencyRatio > 0 {
+		limit := time.Duration(float64(base.meanLatency()) * cc.config.MaxLatencyRatio)
+		if canary.meanLatency() > limit {
+			return "mean latency " + canary.meanLatency().String() + " exceeds " + limit.String()
+		}
+	}
+	return ""
+}
+
+func (cc *canaryController) setState(state string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.state = state
+}
+
+// CanaryStatus describes the progress of a canary rollout
+type CanaryStatus struct {
+	State   string  `json:"state"`
+	Percent float64 `json:"percent"`
+}
+
+func (cc *canaryController) status() CanaryStatus {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return CanaryStatus{State: cc.state, Percent: cc.percent()}
+}
+
+func formatPercent(f float64) string {
+	return strconv.FormatFloat(f*100, 'f', 2, 64) + "%"
+}
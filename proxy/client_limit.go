@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// clientLimiter caps the requests in flight per client. A client at its cap
+// is answered with 429 right away rather than queued, so it can't hold on
+// to capacity other clients are waiting for.
+type clientLimiter struct {
+	max    int
+	header string
+
+	mu       sync.Mutex
+	inFlight map[string]int
+	rejected uint64
+}
+
+// newClientLimiter returns nil when no per-client limit is configured
+func newClientLimiter(cfg config.ClientLimitConfig) *clientLimiter {
+	if cfg.MaxRequests <= 0 {
+		return nil
+	}
+	return &clientLimiter{
+		max:      cfg.MaxRequests,
+		header:   cfg.Header,
+		inFlight: make(map[string]int),
+	}
+}
+
+// client identifies the client sending r: the ID of its verified API key,
+// its key header when configured and present, otherwise its address
+func (cl *clientLimiter) client(r *http.Request) string {
+	if id, ok := apiKeyID(r); ok {
+		return "id:" + id
+	}
+	if cl.header != "" {
+		if key := r.Header.Get(cl.header); key != "" {
+			return "key:" + key
+		}
+	}
+	return clientAddr(r).String()
+}
+
+// acquire counts a request against its client; it reports false, and counts
+// nothing, when the client is at its cap
+func (cl *clientLimiter) acquire(client string) (bool, uint64) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.inFlight[client] >= cl.max {
+		cl.rejected++
+		return false, cl.rejected
+	}
+	cl.inFlight[client]++
+	return true, 0
+}
+
+func (cl *clientLimiter) release(client string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.inFlight[client]--; cl.inFlight[client] <= 0 {
+		delete(cl.inFlight, client)
+	}
+}
+
+func (cl *clientLimiter) middleware(next http.Handler) http.Handler {
+	if cl == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := cl.client(r)
+		ok, rejected := cl.acquire(client)
+		if !ok {
+			// Log the first rejected request and then every thousandth
+			if rejected%1000 == 1 {
+				logRequest(r, slog.LevelWarn, "Client over its concurrency limit", "client", clientAddr(r).String(),
+					"limit", cl.max, "rejected", rejected)
+			}
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many concurrent requests", http.StatusTooManyRequests)
+			return
+		}
+		defer cl.release(client)
+
+		next.ServeHTTP(w, r)
+	})
+}
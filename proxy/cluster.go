@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/cluster"
+)
+
+const healthKeyPrefix = "health/"
+
+// publishHealth shares a local health transition with the rest of the cluster
+func (rp *ReverseProxy) publishHealth(backend *Backend, alive bool) {
+	state := "down"
+	if alive {
+		state = "up"
+	}
+	rp.cluster.Set(healthKeyPrefix+backend.URL.String(), state, 0)
+}
+
+// activeHealthChanged passes a transition seen by the health checker on to
+// the cluster and the health webhook
+func (rp *ReverseProxy) activeHealthChanged(backend *Backend, alive bool, reason string) {
+	if rp.cluster != nil {
+		rp.publishHealth(backend, alive)
+	}
+	rp.notifier.notify(backend, alive, healthCheckActive, reason)
+}
+
+// applyPeerHealth adopts a health transition observed by another node
+func (rp *ReverseProxy) applyPeerHealth(key, value string, deleted bool) {
+	if deleted {
+		return
+	}
+	target := strings.TrimPrefix(key, healthKeyPrefix)
+	alive := value == "up"
+
+	for _, backend := range rp.backendList() {
+		if backend.URL.String() != target || backend.IsAlive() == alive {
+			continue
+		}
+		rp.logger.Info("Backend health set by cluster peer", "backend", target, "state", value)
+		backend.SetAlive(alive)
+	}
+}
+
+// clusterLimit caps events, such as the requests of one client, at limit
+// per window across the cluster, counted with its windowed counters. Peers'
+// counts arrive with their gossip, so a burst hitting several nodes at
+// once can briefly exceed the limit.
+type clusterLimit struct {
+	node   *cluster.Node
+	limit  int64
+	window time.Duration
+}
+
+// allow counts an event for key unless the cluster has reached the limit
+// in the current window, in which case it returns how long until the next
+// window starts
+func (cl *clusterLimit) allow(key string, now time.Time) (bool, time.Duration) {
+	if cl.node.Counter(key, cl.window) >= cl.limit {
+		return false, cl.window - time.Duration(now.UnixNano()%int64(cl.window))
+	}
+	cl.node.AddCounter(key, 1, cl.window)
+	return true, 0
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/cluster"
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+func TestClusterLimit(t *testing.T) {
+	node := cluster.New(config.ClusterConfig{Enabled: true, NodeName: "a"}, slog.Default())
+	cl := &clusterLimit{node: node, limit: 2, window: time.Hour}
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := cl.allow("client", now); !ok {
+			t.Fatalf("event %d refused under the limit", i+1)
+		}
+	}
+	ok, wait := cl.allow("client", now)
+	if ok {
+		t.Fatal("event over the limit allowed")
+	}
+	if wait <= 0 || wait > time.Hour {
+		t.Errorf("wait = %s, want within the window", wait)
+	}
+	if ok, _ := cl.allow("other", now); !ok {
+		t.Error("another key shares the limit")
+	}
+}
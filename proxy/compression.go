@@ -0,0 +1,294 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// encoder is the common surface of the gzip and brotli writers
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compressor compresses eligible responses with the best encoding the
+// client accepts
+type compressor struct {
+	config    config.CompressionConfig
+	mimeTypes map[string]bool
+	pools     map[string]*sync.Pool
+}
+
+// newCompressor returns nil when compression is disabled
+func newCompressor(cfg config.CompressionConfig) *compressor {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	c := &compressor{
+		config:    cfg,
+		mimeTypes: make(map[string]bool, len(cfg.MIMETypes)),
+		pools:     make(map[string]*sync.Pool, len(cfg.Encodings)),
+	}
+	for _, t := range cfg.MIMETypes {
+		c.mimeTypes[strings.ToLower(t)] = true
+	}
+
+	level := cfg.Level
+	for _, name := range cfg.Encodings {
+		switch name {
+		case "gzip":
+			c.pools[name] = &sync.Pool{New: func() any {
+				if level == 0 {
+					return gzip.NewWriter(io.Discard)
+				}
+				w, _ := gzip.NewWriterLevel(io.Discard, level)
+				return w
+			}}
+		case "br":
+			c.pools[name] = &sync.Pool{New: func() any {
+				if level == 0 {
+					return brotli.NewWriter(io.Discard)
+				}
+				return brotli.NewWriterLevel(io.Discard, level)
+			}}
+		}
+	}
+	return c
+}
+
+func (c *compressor) middleware(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Upgraded connections carry no response body to compress
+		if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, compressor: c, encoding: c.negotiate(r.Header.Get("Accept-Encoding"))}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiate picks the first configured encoding the client accepts with a
+// non-zero quality, or "" when the response must be sent as is
+func (c *compressor) negotiate(header string) string {
+	if header == "" {
+		return ""
+	}
+
+	accepted := acceptedEncodings(header)
+	for _, name := range c.config.Encodings {
+		if accepted.allows(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// encodingQualities maps the content codings of an Accept-Encoding header
+// to their quality values
+type encodingQualities map[string]float64
+
+func acceptedEncodings(header string) encodingQualities {
+	accepted := make(encodingQualities)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		accepted[name] = q
+	}
+	return accepted
+}
+
+// allows reports whether name is accepted with a non-zero quality, by name
+// or through a wildcard
+func (a encodingQualities) allows(name string) bool {
+	q, ok := a[name]
+	if !ok {
+		q, ok = a["*"]
+	}
+	return ok && q > 0
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows name.
+// Clients that send none are not assumed to accept any encoding.
+func acceptsEncoding(header, name string) bool {
+	return header != "" && acceptedEncodings(header).allows(name)
+}
+
+// compressible reports whether a response with these headers may be encoded
+func (c *compressor) compressible(status int, h http.Header) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if strings.Contains(strings.ToLower(h.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+	mediaType, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	return c.mimeTypes[strings.ToLower(strings.TrimSpace(mediaType))]
+}
+
+// compressWriter holds back the response header until it knows whether the
+// body will be compressed: immediately when Content-Length is set, otherwise
+// once min_size bytes have been buffered or the handler finishes
+type compressWriter struct {
+	http.ResponseWriter
+	compressor *compressor
+	encoding   string
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	enc         encoder
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	// 1xx informational responses may precede the final header
+	if code >= 100 && code < 200 {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.wroteHeader {
+		return
+	}
+	cw.status = code
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	if !cw.compressor.compressible(code, h) {
+		cw.decide(false)
+		return
+	}
+	// The representation now depends on the request's Accept-Encoding,
+	// whether or not this particular response ends up compressed
+	h.Add("Vary", "Accept-Encoding")
+	if cw.encoding == "" {
+		cw.decide(false)
+		return
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		cw.decide(err == nil && n >= int64(cw.compressor.config.MinSize))
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.compressor.config.MinSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide sends the held-back header and any buffered body, switching to
+// the encoder when compress is true
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	if compress {
+		h := cw.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		// Ranges of the encoded body can't be served
+		h.Del("Accept-Ranges")
+		// The encoded bytes differ from the backend's, so a strong
+		// validator no longer holds
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.enc = cw.compressor.pools[cw.encoding].Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	if cw.enc != nil {
+		_, err := cw.enc.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// ReadFrom passes a body that isn't compressed to the underlying writer's
+// ReadFrom
+func (cw *compressWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided && cw.enc == nil {
+		return io.Copy(cw.ResponseWriter, src)
+	}
+	return io.Copy(writerOnly{cw}, src)
+}
+
+// Flush commits to compressing, since a streamed body's final size is unknown
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		_ = cw.decide(true)
+	}
+	if cw.enc != nil {
+		_ = cw.enc.Flush()
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// close finishes the response once the handler has returned
+func (cw *compressWriter) close() {
+	if cw.wroteHeader && !cw.decided {
+		_ = cw.decide(false)
+	}
+	if cw.enc != nil {
+		_ = cw.enc.Close()
+		cw.enc.Reset(io.Discard)
+		cw.compressor.pools[cw.encoding].Put(cw.enc)
+		cw.enc = nil
+	}
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// connTracker counts the client connections open across the proxy's
+// listeners and caps them when a limit is configured. A listener at the cap
+// stops accepting, so further connections wait in the kernel's backlog
+// instead of costing the proxy memory.
+type connTracker struct {
+	slots chan struct{} // nil when unlimited
+	open  int64
+	peak  int64
+}
+
+func newConnTracker(limit int) *connTracker {
+	ct := &connTracker{}
+	if limit > 0 {
+		ct.slots = make(chan struct{}, limit)
+	}
+	return ct
+}
+
+// wrap returns ln with its connections counted and capped
+func (ct *connTracker) wrap(ln net.Listener) net.Listener {
+	return &limitedListener{Listener: ln, tracker: ct, done: make(chan struct{})}
+}
+
+// counts returns the open connections and the most open at once
+func (ct *connTracker) counts() (open, peak int64) {
+	return atomic.LoadInt64(&ct.open), atomic.LoadInt64(&ct.peak)
+}
+
+func (ct *connTracker) opened() {
+	open := atomic.AddInt64(&ct.open, 1)
+	for {
+		peak := atomic.LoadInt64(&ct.peak)
+		if open <= peak || atomic.CompareAndSwapInt64(&ct.peak, peak, open) {
+			return
+		}
+	}
+}
+
+func (ct *connTracker) closed() {
+	atomic.AddInt64(&ct.open, -1)
+	if ct.slots != nil {
+		<-ct.slots
+	}
+}
+
+type limitedListener struct {
+	net.Listener
+	tracker   *connTracker
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept waits for a free slot before accepting the next connection
+func (l *limitedListener) Accept() (net.Conn, error) {
+	if l.tracker.slots != nil {
+		select {
+		case l.tracker.slots <- struct{}{}:
+		case <-l.done:
+			return nil, net.ErrClosed
+		}
+	}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		if l.tracker.slots != nil {
+			<-l.tracker.slots
+		}
+		return nil, err
+	}
+	l.tracker.opened()
+	return &limitedConn{Conn: conn, tracker: l.tracker}, nil
+}
+
+func (l *limitedListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// limitedConn frees its slot when closed, however often Close is called
+type limitedConn struct {
+	net.Conn
+	tracker   *connTracker
+	closeOnce sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(c.tracker.closed)
+	return err
+}
+
+func (c *limitedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
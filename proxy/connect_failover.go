@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// connectFailover sends requests whose backend couldn't be connected to on
+// to another backend of the pool, whatever their method
+type connectFailover struct {
+	maxAttempts int
+}
+
+// newConnectFailover returns nil when connect failover is disabled
+func newConnectFailover(cfg config.ConnectFailoverConfig) *connectFailover {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &connectFailover{maxAttempts: cfg.MaxAttempts}
+}
+
+// attempts returns the number of backends a request may be sent to
+func (f *connectFailover) attempts() int {
+	if f == nil {
+		return 1
+	}
+	return f.maxAttempts
+}
+
+// isConnectError reports whether err means the request never reached the
+// backend: its address didn't resolve or the connection was not accepted
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// unreadBody lets a request body be sent to another backend as long as
+// none of it was read. The transport closes the body of a failed request,
+// so closing is left to the server.
+type unreadBody struct {
+	io.ReadCloser
+	read bool
+}
+
+func (b *unreadBody) Read(p []byte) (int, error) {
+	b.read = true
+	return b.ReadCloser.Read(p)
+}
+
+func (b *unreadBody) Close() error {
+	return nil
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// connectionHeaders tells backends about the client's connection through
+// the configured request headers, replacing any the client sent
+type connectionHeaders struct {
+	config config.ConnectionHeadersConfig
+	names  []string
+}
+
+// newConnectionHeaders returns nil when no header is configured
+func newConnectionHeaders(cfg config.ConnectionHeadersConfig) *connectionHeaders {
+	names := cfg.Names()
+	if len(names) == 0 {
+		return nil
+	}
+	return &connectionHeaders{config: cfg, names: names}
+}
+
+func (ch *connectionHeaders) middleware(next http.Handler) http.Handler {
+	if ch == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range ch.names {
+			r.Header.Del(name)
+		}
+		// The port is the connection's, which PROXY protocol makes the
+		// original client's
+		if _, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			ch.set(r, ch.config.ClientPort, port)
+		}
+		if cs := r.TLS; cs != nil {
+			ch.set(r, ch.config.TLSVersion, tls.VersionName(cs.Version))
+			ch.set(r, ch.config.TLSCipher, tls.CipherSuiteName(cs.CipherSuite))
+			ch.set(r, ch.config.TLSALPN, cs.NegotiatedProtocol)
+			if len(cs.PeerCertificates) > 0 {
+				sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+				ch.set(r, ch.config.ClientCertFingerprint, hex.EncodeToString(sum[:]))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// set sets the header name, when configured, to a known value
+func (ch *connectionHeaders) set(r *http.Request, name, value string) {
+	if name != "" && value != "" {
+		r.Header.Set(name, value)
+	}
+}
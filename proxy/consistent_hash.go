@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// Consistent Hash Load Balancer with bounded loads: each backend owns
+// several points on a hash ring and a request goes to the owner of the first
+// point after its key's hash. A backend already serving more than
+// load_factor times the average in-flight load is skipped, so hot keys
+// spill over to the next backend on the ring instead of overloading one.
+type ConsistentHashBalancer struct {
+	config   config.ConsistentHashConfig
+	backends []*Backend
+	ring     []ringPoint // sorted by hash
+}
+
+type ringPoint struct {
+	hash    uint64
+	backend *Backend
+}
+
+func NewConsistentHashBalancer(cfg config.ConsistentHashConfig, backends []*Backend) *ConsistentHashBalancer {
+	cb := &ConsistentHashBalancer{
+		config:   cfg,
+		backends: backends,
+	}
+	for _, b := range backends {
+		points := cfg.VirtualNodes * max(b.GetWeight(), 1)
+		for i := 0; i < points; i++ {
+			cb.ring = append(cb.ring, ringPoint{
+				hash:    hashKey(b.URL.String() + "#" + strconv.Itoa(i)),
+				backend: b,
+			})
+		}
+	}
+	sort.Slice(cb.ring, func(i, j int) bool { return cb.ring[i].hash < cb.ring[j].hash })
+	return cb
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	// FNV alone clusters similar keys; finish with a 64-bit mixer
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// requestKey extracts the configured hash key, falling back to the client
+// address when the header or cookie is missing
+func (cb *ConsistentHashBalancer) requestKey(r *http.Request) string {
+	switch cb.config.Key {
+	case "header":
+		if v := r.Header.Get(cb.config.Name); v != "" {
+			return v
+		}
+	case "cookie":
+		if c, err := r.Cookie(cb.config.Name); err == nil && c.Value != "" {
+			return c.Value
+		}
+	default:
+		return r.URL.Path
+	}
+	if addr := clientAddr(r); addr.IsValid() {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
+
+func (cb *ConsistentHashBalancer) NextBackend(r *http.Request) *Backend {
+	if len(cb.ring) == 0 {
+		return nil
+	}
+
+	// Capacity per backend: ceil(load_factor * (in-flight + 1) / available)
+	available, load := 0, 0
+	for _, b := range cb.backends {
+		if b.IsAvailable() {
+			available++
+			load += b.GetConnections()
+		}
+	}
+	if available == 0 {
+		return nil
+	}
+	capacity := int(math.Ceil(cb.config.LoadFactor * float64(load+1) / float64(available)))
+
+	h := hashKey(cb.requestKey(r))
+	start := sort.Search(len(cb.ring), func(i int) bool { return cb.ring[i].hash >= h })
+
+	var fallback *Backend
+	for i := 0; i < len(cb.ring); i++ {
+		b := cb.ring[(start+i)%len(cb.ring)].backend
+		if !b.IsAvailable() {
+			continue
+		}
+		if b.GetConnections() < capacity {
+			return b
+		}
+		if fallback == nil {
+			fallback = b
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+const (
+	// consulRetryInterval is how long to wait after a failed query
+	consulRetryInterval = 5 * time.Second
+
+	// consulRequestSlack covers the jitter Consul adds to the wait time of
+	// a blocking query
+	consulRequestSlack = 30 * time.Second
+)
+
+// consulClient reads the healthy instances of services from the Consul
+// health API
+type consulClient struct {
+	config config.ConsulConfig
+	client *http.Client
+}
+
+func newConsulClient(cfg config.ConsulConfig) *consulClient {
+	return &consulClient{config: cfg, client: &http.Client{}}
+}
+
+// consulInstance is one healthy instance of a service
+type consulInstance struct {
+	address string
+	port    int
+	weight  int
+}
+
+// healthyInstances returns the instances of service whose checks all pass.
+// With a non-zero index the query blocks until the service changes after
+// that index or the wait time passes. The returned index is passed to the
+// next query.
+func (c *consulClient) healthyInstances(ctx context.Context, service, tag string, index uint64) ([]consulInstance, uint64, error) {
+	query := url.Values{"passing": {"1"}}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	if c.config.Datacenter != "" {
+		query.Set("dc", c.config.Datacenter)
+	}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(c.config.WaitTime.Seconds())))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.WaitTime+consulRequestSlack)
+	defer cancel()
+	endpoint := singleJoiningSlash(c.config.Address, "/v1/health/service/"+url.PathEscape(service)) + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.config.Token != "" {
+		req.Header.Set("X-Consul-Token", c.config.Token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul returned %s", resp.Status)
+	}
+
+	var entries []struct {
+		Node struct {
+			Address string
+		}
+		Service struct {
+			Address string
+			Port    int
+			Weights struct {
+				Passing int
+			}
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("invalid consul response: %w", err)
+	}
+	newIndex, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	instances := make([]consulInstance, 0, len(entries))
+	for _, e := range entries {
+		// Services registered without an address live at the node's address
+		address := e.Service.Address
+		if address == "" {
+			address = e.Node.Address
+		}
+		if address == "" || e.Service.Port == 0 {
+			continue
+		}
+		instances = append(instances, consulInstance{
+			address: address,
+			port:    e.Service.Port,
+			weight:  e.Service.Weights.Passing,
+		})
+	}
+	return instances, newIndex, nil
+}
+
+// queryConsul fetches the healthy instances of the source's service and
+// makes them the source's targets. Services without a healthy instance
+// leave the pool with no members from this source.
+func (d *backendDiscovery) queryConsul(ctx context.Context, dp *discoveredPool, src *resolvedBackend) error {
+	instances, index, err := d.consul.healthyInstances(ctx, src.config.Service, src.config.Tag, src.index)
+	if err != nil {
+		return err
+	}
+	// Consul asks clients to start over when the index goes backwards and
+	// never to block on an index of zero
+	if index < src.index || index == 0 {
+		index = 1
+	}
+	src.index = index
+
+	wanted := make(map[string]config.Backend, len(instances))
+	for _, inst := range instances {
+		port := strconv.Itoa(inst.port)
+		b := src.target(inst.address, port)
+		if inst.weight > 0 {
+			weight := inst.weight
+			b.Weight = &weight
+		}
+		wanted[fmt.Sprintf("%s/%d", net.JoinHostPort(inst.address, port), inst.weight)] = b
+	}
+	d.update(dp, src, wanted)
+	return nil
+}
+
+// watchConsul follows the source's service with blocking queries until
+// discovery stops
+func (d *backendDiscovery) watchConsul(dp *discoveredPool, src *resolvedBackend) {
+	for {
+		err := d.queryConsul(d.ctx, dp, src)
+		if d.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			continue
+		}
+
+		d.rp.logger.Warn("Failed to query consul", "service", src.config.Service, "error", err)
+		timer := time.NewTimer(consulRetryInterval)
+		select {
+		case <-timer.C:
+		case <-d.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
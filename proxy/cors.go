@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// cors answers preflight requests itself and adds CORS headers to every
+// other response, replacing any the backends send
+type cors struct {
+	anyOrigin     bool
+	origins       map[string]bool
+	suffixes      []string // "https://*.example.com" as {"https://", ".example.com"} pairs
+	methods       map[string]bool
+	allowMethods  string
+	headers       map[string]bool // nil allows any requested header
+	allowHeaders  string
+	exposeHeaders string
+	credentials   bool
+	maxAge        string
+}
+
+// newCORS returns nil when CORS handling is disabled
+func newCORS(cfg config.CORSConfig) *cors {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	c := &cors{
+		origins:       make(map[string]bool),
+		methods:       make(map[string]bool),
+		allowMethods:  strings.Join(cfg.AllowMethods, ", "),
+		exposeHeaders: strings.Join(cfg.ExposeHeaders, ", "),
+		credentials:   cfg.AllowCredentials,
+	}
+	for _, origin := range cfg.AllowOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			c.anyOrigin = true
+		case strings.Contains(origin, "://*."):
+			scheme, host, _ := strings.Cut(origin, "*")
+			c.suffixes = append(c.suffixes, scheme, host)
+		default:
+			c.origins[origin] = true
+		}
+	}
+	for _, method := range cfg.AllowMethods {
+		c.methods[method] = true
+	}
+	if len(cfg.AllowHeaders) > 0 {
+		c.headers = make(map[string]bool, len(cfg.AllowHeaders))
+		names := make([]string, len(cfg.AllowHeaders))
+		for i, name := range cfg.AllowHeaders {
+			names[i] = http.CanonicalHeaderKey(name)
+			c.headers[strings.ToLower(name)] = true
+		}
+		c.allowHeaders = strings.Join(names, ", ")
+	}
+	if cfg.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(cfg.MaxAge)
+	}
+	return c
+}
+
+func (c *cors) allowedOrigin(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if c.origins[origin] {
+		return true
+	}
+	for i := 0; i < len(c.suffixes); i += 2 {
+		scheme, suffix := c.suffixes[i], c.suffixes[i+1]
+		if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, suffix) &&
+			len(origin) > len(scheme)+len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedHeaders reports whether every header named in a preflight's
+// Access-Control-Request-Headers is allowed
+func (c *cors) allowedHeaders(requested string) bool {
+	if c.headers == nil {
+		return true
+	}
+	for _, name := range strings.Split(requested, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !c.headers[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// setOrigin adds the headers common to preflight and actual responses
+func (c *cors) setOrigin(h http.Header, origin string) {
+	if c.anyOrigin && !c.credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *cors) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	requested := r.Header.Get("Access-Control-Request-Headers")
+	if !c.allowedOrigin(origin) || !c.methods[r.Header.Get("Access-Control-Request-Method")] || !c.allowedHeaders(requested) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	c.setOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", c.allowMethods)
+	if c.allowHeaders != "" {
+		h.Set("Access-Control-Allow-Headers", c.allowHeaders)
+	} else if requested != "" {
+		h.Set("Access-Control-Allow-Headers", requested)
+	}
+	if c.maxAge != "" {
+		h.Set("Access-Control-Max-Age", c.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *cors) middleware(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+			c.preflight(w, r, origin)
+			return
+		}
+		next.ServeHTTP(&corsWriter{ResponseWriter: w, cors: c, origin: origin}, r)
+	})
+}
+
+// corsWriter replaces the backend's CORS headers just before the response
+// header is written, so proxy-generated errors carry them too
+type corsWriter struct {
+	http.ResponseWriter
+	cors        *cors
+	origin      string
+	wroteHeader bool
+}
+
+func (cw *corsWriter) WriteHeader(code int) {
+	if !cw.wroteHeader && code >= 200 {
+		cw.wroteHeader = true
+		h := cw.Header()
+		for name := range h {
+			if strings.HasPrefix(name, "Access-Control-") {
+				delete(h, name)
+			}
+		}
+		if !cw.cors.anyOrigin || cw.cors.credentials {
+			h.Add("Vary", "Origin")
+		}
+		if cw.origin != "" && cw.cors.allowedOrigin(cw.origin) {
+			cw.cors.setOrigin(h, cw.origin)
+			if cw.cors.exposeHeaders != "" {
+				h.Set("Access-Control-Expose-Headers", cw.cors.exposeHeaders)
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *corsWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *corsWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return io.Copy(cw.ResponseWriter, src)
+}
+
+func (cw *corsWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *corsWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	_ "embed"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+const (
+	// dashboardWindow is how many seconds of request rates and latencies
+	// the dashboard keeps
+	dashboardWindow = 300
+
+	// dashboardErrors is how many of the most recent server errors the
+	// dashboard keeps
+	dashboardErrors = 50
+)
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// dashboardBounds are the upper bounds of the latency buckets, 25% apart
+// from 100µs to a minute; slower requests fall in a final bucket
+var dashboardBounds = func() []time.Duration {
+	var bounds []time.Duration
+	for b := 100 * time.Microsecond; b < time.Minute; b = b * 5 / 4 {
+		bounds = append(bounds, b)
+	}
+	return append(bounds, time.Minute)
+}()
+
+// dashboard records recent request rates, latencies and server errors for
+// the live status page on the admin API
+type dashboard struct {
+	mu      sync.Mutex
+	seconds [dashboardWindow]dashboardSecond // indexed by Unix time modulo the window
+	errors  []dashboardError                 // oldest first
+}
+
+// dashboardSecond counts the requests completed in one second
+type dashboardSecond struct {
+	unix     int64
+	requests int
+	errors   int
+	max      time.Duration
+	buckets  []uint32 // by dashboardBounds, the last for slower requests
+}
+
+type dashboardError struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Host       string    `json:"host"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMs float64   `json:"duration_ms"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+// dashboardPoint summarizes one second; latencies are in milliseconds and
+// estimated to within a bucket
+type dashboardPoint struct {
+	Time     int64   `json:"time"`
+	Requests int     `json:"requests"`
+	Errors   int     `json:"errors"`
+	P50      float64 `json:"p50_ms"`
+	P95      float64 `json:"p95_ms"`
+	P99      float64 `json:"p99_ms"`
+	Max      float64 `json:"max_ms"`
+}
+
+type dashboardView struct {
+	Status       Status           `json:"status"`
+	Series       []dashboardPoint `json:"series"` // oldest first, one per second
+	RecentErrors []dashboardError `json:"recent_errors"`
+}
+
+// newDashboard returns nil unless the admin API serves the dashboard
+func newDashboard(cfg config.AdminConfig) *dashboard {
+	if !cfg.Enabled || !cfg.Dashboard {
+		return nil
+	}
+	return &dashboard{}
+}
+
+func (d *dashboard) middleware(next http.Handler) http.Handler {
+	if d == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := newResponseWriter(w)
+		next.ServeHTTP(rw, r)
+		d.observe(r, rw.status, start)
+	})
+}
+
+// observe records a request that started at start and was answered with
+// status
+func (d *dashboard) observe(r *http.Request, status int, start time.Time) {
+	now := time.Now()
+	duration := now.Sub(start)
+	i := len(dashboardBounds)
+	for j, bound := range dashboardBounds {
+		if duration <= bound {
+			i = j
+			break
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := &d.seconds[now.Unix()%dashboardWindow]
+	if s.unix != now.Unix() {
+		buckets := s.buckets
+		if buckets == nil {
+			buckets = make([]uint32, len(dashboardBounds)+1)
+		}
+		clear(buckets)
+		*s = dashboardSecond{unix: now.Unix(), buckets: buckets}
+	}
+	s.requests++
+	s.buckets[i]++
+	s.max = max(s.max, duration)
+	if status < 500 {
+		return
+	}
+
+	s.errors++
+	if len(d.errors) == dashboardErrors {
+		d.errors = append(d.errors[:0], d.errors[1:]...)
+	}
+	d.errors = append(d.errors, dashboardError{
+		Time:       now.UTC(),
+		Method:     r.Method,
+		Host:       r.Host,
+		Path:       r.URL.Path,
+		Status:     status,
+		DurationMs: milliseconds(duration),
+		RequestID:  requestID(r),
+	})
+}
+
+// series returns one point for each of the last n seconds, oldest first
+func (d *dashboard) series(n int) []dashboardPoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now().Unix()
+	points := make([]dashboardPoint, 0, n)
+	for t := now - int64(n) + 1; t <= now; t++ {
+		p := dashboardPoint{Time: t}
+		if s := &d.seconds[t%dashboardWindow]; s.unix == t {
+			p.Requests, p.Errors = s.requests, s.errors
+			p.P50, p.P95, p.P99 = s.percentile(50), s.percentile(95), s.percentile(99)
+			p.Max = milliseconds(s.max)
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// percentile estimates the p-th percentile latency in milliseconds as the
+// upper bound of its bucket, or the maximum when that is lower
+func (s *dashboardSecond) percentile(p int) float64 {
+	rank := uint32((s.requests*p + 99) / 100)
+	var cumulative uint32
+	for i, n := range s.buckets {
+		cumulative += n
+		if cumulative >= rank && i < len(dashboardBounds) {
+			return milliseconds(min(dashboardBounds[i], s.max))
+		}
+	}
+	return milliseconds(s.max)
+}
+
+func (d *dashboard) recentErrors() []dashboardError {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	errors := make([]dashboardError, len(d.errors))
+	copy(errors, d.errors)
+	return errors
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// dashboardHandler serves the dashboard page on GET. The page holds no
+// data, so it is served without the admin token; its script asks for the
+// token and sends it to dashboardDataHandler.
+func (rp *ReverseProxy) dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Write(dashboardPage)
+}
+
+// dashboardDataHandler serves the backends' status, the per-second series
+// and the recent server errors on GET. The seconds parameter limits the
+// series, by default to the whole window.
+func (rp *ReverseProxy) dashboardDataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	seconds := dashboardWindow
+	if v := r.URL.Query().Get("seconds"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > dashboardWindow {
+			writeJSONError(w, http.StatusBadRequest, "seconds must be between 1 and "+strconv.Itoa(dashboardWindow))
+			return
+		}
+		seconds = n
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, dashboardView{
+		Status:       rp.status(),
+		Series:       rp.dashboard.series(seconds),
+		RecentErrors: rp.dashboard.recentErrors(),
+	})
+}
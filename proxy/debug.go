@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// registerDebug serves the pprof profiles and runtime statistics on the
+// admin API, for profiling the proxy in production
+func (a *adminServer) registerDebug() {
+	a.handle("/debug/pprof/", pprof.Index)
+	a.handle("/debug/pprof/cmdline", pprof.Cmdline)
+	a.handle("/debug/pprof/profile", pprof.Profile)
+	a.handle("/debug/pprof/symbol", pprof.Symbol)
+	a.handle("/debug/pprof/trace", pprof.Trace)
+	a.handle("/debug/runtime", runtimeHandler)
+}
+
+// RuntimeStatus summarizes the Go runtime's scheduler, heap and garbage
+// collector
+type RuntimeStatus struct {
+	Goroutines   int        `json:"goroutines"`
+	GOMAXPROCS   int        `json:"gomaxprocs"`
+	HeapAlloc    uint64     `json:"heap_alloc_bytes"`
+	HeapSys      uint64     `json:"heap_sys_bytes"`
+	HeapObjects  uint64     `json:"heap_objects"`
+	TotalAlloc   uint64     `json:"total_alloc_bytes"`
+	NumGC        int64      `json:"num_gc"`
+	LastGC       *time.Time `json:"last_gc,omitempty"`
+	PauseTotal   string     `json:"pause_total"`
+	RecentPauses []string   `json:"recent_pauses"` // most recent first
+	NextGC       uint64     `json:"next_gc_bytes"`
+}
+
+func runtimeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+
+	status := RuntimeStatus{
+		Goroutines:   runtime.NumGoroutine(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		HeapAlloc:    mem.HeapAlloc,
+		HeapSys:      mem.HeapSys,
+		HeapObjects:  mem.HeapObjects,
+		TotalAlloc:   mem.TotalAlloc,
+		NumGC:        gc.NumGC,
+		PauseTotal:   gc.PauseTotal.String(),
+		RecentPauses: make([]string, 0, 10),
+		NextGC:       mem.NextGC,
+	}
+	if gc.NumGC > 0 {
+		status.LastGC = &gc.LastGC
+	}
+	for i := 0; i < len(gc.Pause) && i < 10; i++ {
+		status.RecentPauses = append(status.RecentPauses, gc.Pause[i].String())
+	}
+	writeJSON(w, http.StatusOK, status)
+}
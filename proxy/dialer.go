@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// defaultFallbackDelay matches the net package's Happy Eyeballs delay
+const defaultFallbackDelay = 300 * time.Millisecond
+
+type lookupFunc func(ctx context.Context, host string) ([]net.IP, error)
+
+func systemLookup(ctx context.Context, host string) ([]net.IP, error) {
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+type srvLookupFunc func(ctx context.Context, name string) ([]*net.SRV, error)
+
+func systemLookupSRV(ctx context.Context, name string) ([]*net.SRV, error) {
+	_, addrs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	return addrs, err
+}
+
+// addrDialer resolves backend hostnames itself and dials the resulting
+// addresses with the backend's address family preferences, racing the
+// preferred family against the other one Happy Eyeballs style (RFC 8305)
+type addrDialer struct {
+	dial          dialFunc
+	lookup        lookupFunc
+	family        string
+	prefer        string
+	fallbackDelay time.Duration
+}
+
+func newAddrDialer(dial dialFunc, lookup lookupFunc, cfg *config.DialConfig) *addrDialer {
+	d := &addrDialer{
+		dial:          dial,
+		lookup:        lookup,
+		fallbackDelay: defaultFallbackDelay,
+	}
+	if cfg != nil {
+		d.family = cfg.AddressFamily
+		d.prefer = cfg.Prefer
+		if cfg.FallbackDelay != 0 {
+			d.fallbackDelay = cfg.FallbackDelay
+		}
+	}
+	return d
+}
+
+// DialContext resolves addr and connects to the first address that answers
+func (d *addrDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ips, err = d.lookup(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+		}
+	}
+
+	primaries, fallbacks := d.partition(ips)
+	if len(primaries) == 0 {
+		return nil, fmt.Errorf("no %s addresses for %s", d.family, host)
+	}
+
+	toAddrs := func(ips []net.IP) []string {
+		addrs := make([]string, len(ips))
+		for i, ip := range ips {
+			addrs[i] = net.JoinHostPort(ip.String(), port)
+		}
+		return addrs
+	}
+
+	return d.dialParallel(ctx, network, toAddrs(primaries), toAddrs(fallbacks))
+}
+
+// partition filters ips by address family and splits them into the
+// preferred family and the fallback family
+func (d *addrDialer) partition(ips []net.IP) (primaries, fallbacks []net.IP) {
+	isV4 := func(ip net.IP) bool { return ip.To4() != nil }
+
+	var filtered []net.IP
+	for _, ip := range ips {
+		switch {
+		case d.family == "ipv4" && !isV4(ip), d.family == "ipv6" && isV4(ip):
+			continue
+		}
+		filtered = append(filtered, ip)
+	}
+	if len(filtered) == 0 {
+		return nil, nil
+	}
+
+	// Without an explicit preference the family of the first answer wins
+	preferV4 := isV4(filtered[0])
+	switch d.prefer {
+	case "ipv4":
+		preferV4 = true
+	case "ipv6":
+		preferV4 = false
+	}
+
+	for _, ip := range filtered {
+		if isV4(ip) == preferV4 {
+			primaries = append(primaries, ip)
+		} else {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	if len(primaries) == 0 {
+		return fallbacks, nil
+	}
+	return primaries, fallbacks
+}
+
+// dialSerial tries each address in turn
+func (d *addrDialer) dialSerial(ctx context.Context, network string, addrs []string) (net.Conn, error) {
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := d.dial(ctx, network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no addresses to dial")
+	}
+	return nil, lastErr
+}
+
+// dialParallel races the primary addresses against the fallbacks, which
+// start after the fallback delay or as soon as every primary has failed
+func (d *addrDialer) dialParallel(ctx context.Context, network string, primaries, fallbacks []string) (net.Conn, error) {
+	if len(fallbacks) == 0 || d.fallbackDelay < 0 {
+		return d.dialSerial(ctx, network, append(primaries, fallbacks...))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	start := func(addrs []string) {
+		go func() {
+			conn, err := d.dialSerial(ctx, network, addrs)
+			results <- result{conn, err}
+		}()
+	}
+
+	start(primaries)
+	pending := 1
+	fallbackStarted := false
+	timer := time.NewTimer(d.fallbackDelay)
+	defer timer.Stop()
+
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				start(fallbacks)
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				// Close the connection of a racer that succeeds late
+				go func(n int) {
+					for i := 0; i < n; i++ {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				start(fallbacks)
+				continue
+			}
+			if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
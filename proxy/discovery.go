@@ -0,0 +1,431 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// initialDiscoveryTimeout bounds the lookups made before the proxy serves
+const initialDiscoveryTimeout = 10 * time.Second
+
+// backendFactory creates and registers a backend from its configuration
+type backendFactory func(config.Backend, *transportBuilder) (*Backend, error)
+
+// backendDiscovery expands backends whose members are discovered at
+// runtime. A backend with resolve set becomes one backend per address its
+// hostname resolves to and a backend with srv set one backend per SRV
+// target; both are looked up again periodically so pools follow
+// round-robin DNS and changing addresses. A backend with discovery consul
+// becomes one backend per healthy instance of its Consul service, watched
+// with blocking queries so registrations take effect right away, and one
+// with discovery kubernetes one backend per ready endpoint of its Service.
+type backendDiscovery struct {
+	rp         *ReverseProxy
+	transports *transportBuilder
+	lookup     lookupFunc
+	lookupSRV  srvLookupFunc
+	interval   time.Duration
+	consul     *consulClient
+	kube       *kubeClient // nil unless a backend uses discovery kubernetes
+
+	mu    sync.Mutex
+	pools []*discoveredPool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// discoveredPool is a pool with at least one discovered backend
+type discoveredPool struct {
+	pool       *backendPool
+	static     []*Backend
+	sources    []*resolvedBackend
+	newBackend backendFactory
+	mu         sync.Mutex // serializes member updates from different sources
+}
+
+// resolvedBackend is one discovered backend configuration and the backends
+// created for the targets it currently resolves to
+type resolvedBackend struct {
+	config  config.Backend
+	url     *url.URL
+	targets map[string]*Backend // keyed by IP address or discovered target
+	index   uint64              // Consul index of the last answer
+	file    string              // upstreams file the targets are read from
+}
+
+// newBackendDiscovery returns nil when no backend is discovered at runtime
+func newBackendDiscovery(rp *ReverseProxy, transports *transportBuilder) (*backendDiscovery, error) {
+	discovered := discoveredBackends(rp.config)
+	if len(discovered) == 0 && !watchesFiles(rp.config) {
+		return nil, nil
+	}
+
+	var kube *kubeClient
+	for _, b := range discovered {
+		if b.Discovery == config.DiscoveryKubernetes {
+			var err error
+			if kube, err = newKubeClient(rp.config.Kubernetes); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	lookup, lookupSRV := lookupFunc(systemLookup), srvLookupFunc(systemLookupSRV)
+	if transports.resolver != nil {
+		lookup, lookupSRV = transports.resolver.LookupIP, transports.resolver.LookupSRV
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &backendDiscovery{
+		rp:         rp,
+		transports: transports,
+		lookup:     lookup,
+		lookupSRV:  lookupSRV,
+		interval:   rp.config.DNS.RefreshInterval,
+		consul:     newConsulClient(rp.config.Consul),
+		kube:       kube,
+		ctx:        ctx,
+		cancel:     cancel,
+	}, nil
+}
+
+// watchesFiles reports whether any pool of cfg has an upstreams file
+func watchesFiles(cfg *config.Config) bool {
+	for _, p := range cfg.Pools {
+		if p.UpstreamsFile != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// discoveredBackends returns the backends of cfg that are discovered at
+// runtime
+func discoveredBackends(cfg *config.Config) []config.Backend {
+	groups := [][]config.Backend{cfg.Backends}
+	for _, p := range cfg.Pools {
+		groups = append(groups, p.Backends)
+	}
+	for _, vh := range cfg.VHosts {
+		groups = append(groups, vh.Backends)
+	}
+	for _, sc := range cfg.Streams {
+		groups = append(groups, sc.Backends)
+		for _, route := range sc.SNIRoutes {
+			groups = append(groups, route.Backends)
+		}
+	}
+	var discovered []config.Backend
+	for _, backends := range groups {
+		for _, b := range backends {
+			if b.Discovered() {
+				discovered = append(discovered, b)
+			}
+		}
+	}
+	return discovered
+}
+
+// watch resolves the pool's discovered backends, adds a member for each
+// target and keeps the members current from then on. Other backends stay
+// in the pool unchanged.
+func (d *backendDiscovery) watch(pool *backendPool, cfgs []config.Backend, newBackend backendFactory) {
+	if d == nil {
+		return
+	}
+	dp := &discoveredPool{
+		pool:       pool,
+		static:     pool.backends(),
+		newBackend: newBackend,
+	}
+	for _, b := range cfgs {
+		if !b.Discovered() {
+			continue
+		}
+		u, err := url.Parse(b.URL)
+		if err != nil {
+			continue
+		}
+		dp.sources = append(dp.sources, &resolvedBackend{
+			config:  b,
+			url:     u,
+			targets: make(map[string]*Backend),
+		})
+	}
+	if len(dp.sources) == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	d.pools = append(d.pools, dp)
+	d.mu.Unlock()
+
+	// Resolve once before serving so the pool starts with its members
+	ctx, cancel := context.WithTimeout(d.ctx, initialDiscoveryTimeout)
+	defer cancel()
+	d.refresh(ctx, dp)
+	for _, src := range dp.sources {
+		switch src.config.Discovery {
+		case config.DiscoveryConsul:
+			if err := d.queryConsul(ctx, dp, src); err != nil {
+				d.rp.logger.Warn("Failed to query consul", "service", src.config.Service, "error", err)
+			}
+		case config.DiscoveryKubernetes:
+			if _, _, err := d.listKubernetes(ctx, dp, src); err != nil {
+				d.rp.logger.Warn("Failed to list kubernetes endpoints", "service", src.config.Service, "error", err)
+			}
+		}
+	}
+}
+
+func (d *backendDiscovery) Start() {
+	d.mu.Lock()
+	for _, dp := range d.pools {
+		for _, src := range dp.sources {
+			switch src.config.Discovery {
+			case config.DiscoveryConsul:
+				go d.watchConsul(dp, src)
+			case config.DiscoveryKubernetes:
+				go d.watchKubernetes(dp, src)
+			}
+		}
+	}
+	d.mu.Unlock()
+	d.watchFiles()
+
+	if d.interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(d.interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.refreshAll()
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (d *backendDiscovery) Stop() {
+	d.cancel()
+}
+
+func (d *backendDiscovery) refreshAll() {
+	d.mu.Lock()
+	pools := append([]*discoveredPool(nil), d.pools...)
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(d.ctx, d.interval)
+	defer cancel()
+	for _, dp := range pools {
+		d.refresh(ctx, dp)
+	}
+}
+
+// refresh looks up the DNS names of the pool. A failed lookup keeps the
+// previous targets so a DNS outage doesn't empty the pool.
+func (d *backendDiscovery) refresh(ctx context.Context, dp *discoveredPool) {
+	dp.mu.Lock()
+	sources := append([]*resolvedBackend(nil), dp.sources...)
+	dp.mu.Unlock()
+	for _, src := range sources {
+		if !src.config.Resolve && src.config.SRV == "" {
+			continue
+		}
+		name := src.name()
+		wanted, err := d.resolve(ctx, src)
+		if err != nil {
+			d.rp.logger.Warn("Failed to resolve backend", "name", name, "error", err)
+			continue
+		}
+		if len(wanted) == 0 {
+			d.rp.logger.Warn("Backend name has no usable targets", "name", name)
+			continue
+		}
+		d.update(dp, src, wanted)
+	}
+}
+
+// update replaces the targets of src with wanted, creating backends for new
+// targets and retiring those that are gone
+func (d *backendDiscovery) update(dp *discoveredPool, src *resolvedBackend, wanted map[string]config.Backend) {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+
+	name := src.name()
+	var added, removed []*Backend
+	targets := make(map[string]*Backend, len(wanted))
+	for key, cfg := range wanted {
+		if b, ok := src.targets[key]; ok {
+			targets[key] = b
+			continue
+		}
+		b, err := dp.newBackend(cfg, d.transports)
+		if err != nil {
+			d.rp.logger.Error("Failed to create discovered backend", "name", name, "backend", cfg.URL, "error", err)
+			continue
+		}
+		d.rp.logger.Info("Backend discovered", "name", name, "backend", b.URL.String())
+		targets[key] = b
+		added = append(added, b)
+	}
+	for key, b := range src.targets {
+		if _, ok := targets[key]; !ok {
+			d.rp.logger.Info("Discovered backend removed", "name", name, "backend", b.URL.String())
+			removed = append(removed, b)
+		}
+	}
+	src.targets = targets
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	// Backends joining a pool that already serves warm up first
+	if len(dp.pool.backends()) > 0 {
+		for _, b := range added {
+			b.warmUp()
+		}
+	}
+	dp.pool.setBackends(dp.members())
+	if d.rp.healthCheck != nil {
+		for _, b := range added {
+			d.rp.healthCheck.add(b)
+		}
+	}
+	for _, b := range removed {
+		d.rp.removeBackend(b)
+	}
+}
+
+// members returns the static backends followed by the discovered ones in
+// a stable order, so balancers see the same order across refreshes
+func (dp *discoveredPool) members() []*Backend {
+	members := append([]*Backend(nil), dp.static...)
+	for _, src := range dp.sources {
+		keys := make([]string, 0, len(src.targets))
+		for key := range src.targets {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			members = append(members, src.targets[key])
+		}
+	}
+	return members
+}
+
+// name is the DNS name, service or file the backend is discovered through
+func (src *resolvedBackend) name() string {
+	switch {
+	case src.file != "":
+		return src.file
+	case src.config.Discovery != "":
+		return src.config.Service
+	case src.config.SRV != "":
+		return src.config.SRV
+	}
+	return src.url.Hostname()
+}
+
+// resolve returns the configuration of each backend the source currently
+// resolves to, keyed by target
+func (d *backendDiscovery) resolve(ctx context.Context, src *resolvedBackend) (map[string]config.Backend, error) {
+	wanted := make(map[string]config.Backend)
+	if src.config.SRV != "" {
+		addrs, err := d.lookupSRV(ctx, src.config.SRV)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			host := strings.TrimSuffix(addr.Target, ".")
+			if host == "" {
+				continue
+			}
+			// Priority and weight are part of the key so a changed record
+			// replaces the backend rather than mutating it under the balancer
+			key := fmt.Sprintf("%s:%d/%d/%d", host, addr.Port, addr.Priority, addr.Weight)
+			b := src.target(host, strconv.Itoa(int(addr.Port)))
+			b.Priority = int(addr.Priority)
+			if addr.Weight > 0 {
+				// Weight 0 in a record is the lowest share, not a drain
+				weight := int(addr.Weight)
+				b.Weight = &weight
+			}
+			wanted[key] = b
+		}
+		return wanted, nil
+	}
+
+	ips, err := d.lookup(ctx, src.url.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	port := src.url.Port()
+	if port == "" {
+		port = "80"
+		if src.url.Scheme == "https" {
+			port = "443"
+		}
+	}
+	for _, ip := range filterFamily(ips, src.config.Dial) {
+		wanted[ip.String()] = src.target(ip.String(), port)
+	}
+	return wanted, nil
+}
+
+// target is the configuration of the backend for one discovered host and
+// port. TLS backends keep verifying and sending SNI for the hostname of
+// the configured URL.
+func (src *resolvedBackend) target(host, port string) config.Backend {
+	b := src.config
+	b.Resolve = false
+	b.SRV = ""
+	b.Discovery, b.Service, b.Tag, b.Namespace, b.Port = "", "", "", "", ""
+
+	u := *src.url
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+	u.Host = net.JoinHostPort(host, port)
+	b.URL = u.String()
+
+	if u.Scheme == "https" && src.url.Hostname() != "" {
+		var tlsConfig config.BackendTLSConfig
+		if b.TLS != nil {
+			tlsConfig = *b.TLS
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = src.url.Hostname()
+		}
+		b.TLS = &tlsConfig
+	}
+	return b
+}
+
+// filterFamily drops addresses outside the backend's address family
+func filterFamily(ips []net.IP, dial *config.DialConfig) []net.IP {
+	if dial == nil || dial.AddressFamily == "" || dial.AddressFamily == "any" {
+		return ips
+	}
+	var filtered []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (dial.AddressFamily == "ipv4") {
+			filtered = append(filtered, ip)
+		}
+	}
+	return filtered
+}
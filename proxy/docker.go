@@ -0,0 +1,391 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// dockerRetryInterval is how long to wait before reconnecting to the daemon
+const dockerRetryInterval = 5 * time.Second
+
+// dockerEvents are the container events after which the containers are
+// listed again
+var dockerEvents = map[string]bool{
+	"start":   true,
+	"stop":    true,
+	"die":     true,
+	"kill":    true,
+	"pause":   true,
+	"unpause": true,
+	"destroy": true,
+}
+
+// dockerProvider routes requests to running containers by their labels. Each
+// host named by a container's host label gets a pool of the containers
+// naming it, kept in step with the daemon's container events.
+type dockerProvider struct {
+	config     config.DockerConfig
+	client     *http.Client
+	base       string
+	rp         *ReverseProxy
+	transports *transportBuilder
+
+	hosts atomic.Pointer[hostTable[*router]]
+
+	mu    sync.Mutex // serializes syncs
+	pools map[string]*dockerHost
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// dockerHost is the pool of one host and the backend of each container
+type dockerHost struct {
+	router  *router
+	pool    *backendPool
+	targets map[string]*Backend // keyed by container ID
+}
+
+// dockerContainer is the part of a container listing the provider reads
+type dockerContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Status string            `json:"Status"`
+	Labels map[string]string `json:"Labels"`
+	Ports  []struct {
+		PrivatePort int    `json:"PrivatePort"`
+		Type        string `json:"Type"`
+	} `json:"Ports"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// newDockerProvider returns nil when Docker discovery is disabled. The
+// containers running at startup are registered before the proxy serves.
+func newDockerProvider(cfg config.DockerConfig, rp *ReverseProxy, transports *transportBuilder) *dockerProvider {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	u, _ := url.Parse(cfg.Endpoint)
+	transport := &http.Transport{}
+	base := "http://" + u.Host
+	if u.Scheme == "unix" {
+		// The host part of requests is ignored when dialling the socket
+		socket := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		base = "http://docker"
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dp := &dockerProvider{
+		config:     cfg,
+		client:     &http.Client{Transport: transport},
+		base:       base,
+		rp:         rp,
+		transports: transports,
+		pools:      make(map[string]*dockerHost),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	dp.hosts.Store(newHostTable[*router]())
+
+	syncCtx, syncCancel := context.WithTimeout(ctx, initialDiscoveryTimeout)
+	defer syncCancel()
+	if err := dp.sync(syncCtx); err != nil {
+		dp.rp.logger.Warn("Failed to list docker containers", "error", err)
+	}
+	return dp
+}
+
+// route returns the router of the containers serving host, or nil
+func (dp *dockerProvider) route(host string) *router {
+	if dp == nil {
+		return nil
+	}
+	rt, _ := dp.hosts.Load().lookup(host)
+	return rt
+}
+
+func (dp *dockerProvider) Start() {
+	go func() {
+		for {
+			err := dp.watch()
+			if dp.ctx.Err() != nil {
+				return
+			}
+			dp.rp.logger.Warn("Lost docker event stream", "error", err)
+
+			timer := time.NewTimer(dockerRetryInterval)
+			select {
+			case <-timer.C:
+			case <-dp.ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (dp *dockerProvider) Stop() {
+	dp.cancel()
+}
+
+// watch follows the daemon's container events, listing the containers once
+// the stream is open and again after each relevant event
+func (dp *dockerProvider) watch() error {
+	filters, _ := json.Marshal(map[string][]string{"type": {"container"}})
+	resp, err := dp.get(dp.ctx, "/events", url.Values{"filters": {string(filters)}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Containers may have changed while the stream was down
+	if err := dp.sync(dp.ctx); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Action string `json:"Action"`
+		}
+		if err := dec.Decode(&event); err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("docker closed the event stream")
+			}
+			return err
+		}
+		if dockerEvents[event.Action] || strings.HasPrefix(event.Action, "health_status") {
+			if err := dp.sync(dp.ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (dp *dockerProvider) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dp.base+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := dp.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("docker returned %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// sync lists the running containers with a host label and brings the pools
+// of their hosts in line with them
+func (dp *dockerProvider) sync(ctx context.Context) error {
+	hostLabel := dp.config.LabelPrefix + ".host"
+	filters, _ := json.Marshal(map[string][]string{
+		"label":  {hostLabel},
+		"status": {"running"},
+	})
+	resp, err := dp.get(ctx, "/containers/json", url.Values{"filters": {string(filters)}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var containers []dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return fmt.Errorf("invalid container list: %w", err)
+	}
+
+	wanted := make(map[string]map[string]config.Backend)
+	for _, c := range containers {
+		// Containers failing their health check take no traffic
+		if strings.Contains(c.Status, "(unhealthy)") || strings.Contains(c.Status, "(health: starting)") {
+			continue
+		}
+		b, err := dp.backend(c)
+		if err != nil {
+			dp.rp.logger.Warn("Ignoring docker container", "container", c.name(), "error", err)
+			continue
+		}
+		for _, host := range strings.Split(c.Labels[hostLabel], ",") {
+			host = config.NormalizeHost(strings.TrimSpace(host))
+			if host == "" {
+				continue
+			}
+			if wanted[host] == nil {
+				wanted[host] = make(map[string]config.Backend)
+			}
+			wanted[host][c.ID] = b
+		}
+	}
+
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	changed := false
+	for host, targets := range wanted {
+		h, ok := dp.pools[host]
+		if !ok {
+			pool := newPool("docker:"+host, nil, func(backends []*Backend) LoadBalancer {
+				return dp.rp.newPoolBalancer(dp.rp.config.LoadBalancer, backends)
+			})
+			pool.timeouts = dp.rp.timeouts()
+			h = &dockerHost{router: &router{fallback: pool}, pool: pool, targets: make(map[string]*Backend)}
+			dp.pools[host] = h
+			changed = true
+		}
+		dp.update(host, h, targets)
+	}
+	for host, h := range dp.pools {
+		if _, ok := wanted[host]; !ok {
+			dp.update(host, h, nil)
+			delete(dp.pools, host)
+			changed = true
+		}
+	}
+
+	if changed {
+		hosts := newHostTable[*router]()
+		for host, h := range dp.pools {
+			hosts.add(host, h.router)
+		}
+		dp.hosts.Store(hosts)
+	}
+	return nil
+}
+
+// update replaces the containers of a host's pool with wanted
+func (dp *dockerProvider) update(host string, h *dockerHost, wanted map[string]config.Backend) {
+	var added, removed []*Backend
+	targets := make(map[string]*Backend, len(wanted))
+	for id, cfg := range wanted {
+		// A container that moved to another address gets a new backend
+		if b, ok := h.targets[id]; ok && b.URL.String() == cfg.URL {
+			targets[id] = b
+			continue
+		}
+		b, err := dp.rp.newBackend(cfg, dp.transports)
+		if err != nil {
+			dp.rp.logger.Error("Failed to create docker backend", "host", host, "backend", cfg.URL, "error", err)
+			continue
+		}
+		dp.rp.logger.Info("Docker container registered", "host", host, "backend", b.URL.String())
+		targets[id] = b
+		added = append(added, b)
+	}
+	for id, b := range h.targets {
+		if targets[id] != b {
+			dp.rp.logger.Info("Docker container removed", "host", host, "backend", b.URL.String())
+			removed = append(removed, b)
+		}
+	}
+	h.targets = targets
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(targets))
+	for id := range targets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	members := make([]*Backend, 0, len(ids))
+	for _, id := range ids {
+		members = append(members, targets[id])
+	}
+	// Containers joining a host that already serves warm up first
+	if len(h.pool.backends()) > 0 {
+		for _, b := range added {
+			b.warmUp()
+		}
+	}
+	h.pool.setBackends(members)
+
+	if dp.rp.healthCheck != nil {
+		for _, b := range added {
+			dp.rp.healthCheck.add(b)
+		}
+	}
+	for _, b := range removed {
+		dp.rp.removeBackend(b)
+	}
+}
+
+// backend is the backend configuration of a container, from its address on
+// the configured network and the port and scheme labels
+func (dp *dockerProvider) backend(c dockerContainer) (config.Backend, error) {
+	var address string
+	if dp.config.Network != "" {
+		address = c.NetworkSettings.Networks[dp.config.Network].IPAddress
+	} else {
+		names := make([]string, 0, len(c.NetworkSettings.Networks))
+		for name := range c.NetworkSettings.Networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if address = c.NetworkSettings.Networks[name].IPAddress; address != "" {
+				break
+			}
+		}
+	}
+	if address == "" {
+		return config.Backend{}, fmt.Errorf("no address on a docker network")
+	}
+
+	// Without a port label the container's only exposed TCP port is used
+	port := c.Labels[dp.config.LabelPrefix+".port"]
+	if port == "" {
+		var exposed []int
+		for _, p := range c.Ports {
+			if p.Type == "tcp" && (len(exposed) == 0 || exposed[0] != p.PrivatePort) {
+				exposed = append(exposed, p.PrivatePort)
+			}
+		}
+		if len(exposed) != 1 {
+			return config.Backend{}, fmt.Errorf("%s.port label is required unless exactly one port is exposed", dp.config.LabelPrefix)
+		}
+		port = strconv.Itoa(exposed[0])
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return config.Backend{}, fmt.Errorf("invalid port %q", port)
+	}
+
+	scheme := c.Labels[dp.config.LabelPrefix+".scheme"]
+	if scheme == "" {
+		scheme = "http"
+	}
+	if scheme != "http" && scheme != "https" {
+		return config.Backend{}, fmt.Errorf("invalid scheme %q", scheme)
+	}
+
+	return config.Backend{URL: scheme + "://" + net.JoinHostPort(address, port)}, nil
+}
+
+func (c *dockerContainer) name() string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	return c.ID
+}
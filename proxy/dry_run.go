@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+)
+
+// DryRunResult is how the proxy would handle a request, as found by DryRun
+type DryRunResult struct {
+	Forwarded bool   // the request passed every check and would be sent on
+	Status    int    // the proxy's own answer when it isn't forwarded
+	Location  string // the target of a redirect
+	Route     string // the matched route's pattern; empty for the default backends
+	Pool      string // empty for the top-level backends
+	Static    string // the directory of a static route, which has no pool
+	Backend   string // the load balancer's pick
+	Host      string // the Host header sent to the backend
+	URI       string // the path and query sent to the backend
+
+	// Header is the request's headers after header rules, before the
+	// transport adds its own
+	Header http.Header
+}
+
+type dryRunKey struct{}
+
+// DryRun passes r through the proxy's handlers as if it were served, with
+// access lists, authentication, redirects and rewrites applied, but stops
+// before anything reaches a backend
+func (rp *ReverseProxy) DryRun(r *http.Request) DryRunResult {
+	res := &DryRunResult{}
+	r = r.WithContext(context.WithValue(r.Context(), dryRunKey{}, res))
+	w := &dryRunWriter{header: make(http.Header), status: http.StatusOK}
+	rp.handler.ServeHTTP(w, r)
+	if !res.Forwarded {
+		res.Status = w.status
+		res.Location = w.header.Get("Location")
+	}
+	return *res
+}
+
+// dryRunFromContext returns the result a dry run collects, or nil
+func dryRunFromContext(ctx context.Context) *DryRunResult {
+	res, _ := ctx.Value(dryRunKey{}).(*DryRunResult)
+	return res
+}
+
+// record fills in how the route would forward r
+func (res *DryRunResult) record(r *http.Request, route route) {
+	res.Forwarded = true
+	res.Route = route.pattern()
+	res.URI = r.URL.RequestURI()
+	res.Header = r.Header.Clone()
+	if route.static != nil {
+		res.Static = string(route.static.root)
+		return
+	}
+	res.Pool = route.pool.name
+	res.Host = r.Host
+	if backend := route.pool.NextBackend(r); backend != nil {
+		res.Backend = backend.URL.String()
+		res.Host = upstreamHost(r, route, backend)
+	}
+}
+
+// dryRunWriter keeps the status and headers of the proxy's own answer
+type dryRunWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+}
+
+func (w *dryRunWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *dryRunWriter) WriteHeader(code int) {
+	if code >= 200 && !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+}
+
+func (w *dryRunWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return len(b), nil
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+// earlyHints are the Link header values a route sends in a 103 Early Hints
+// response, so clients can fetch assets while the backend renders the page
+type earlyHints []string
+
+// send writes the hints ahead of a GET request's response
+func (eh earlyHints) send(w http.ResponseWriter, r *http.Request) {
+	if len(eh) == 0 || r.Method != http.MethodGet {
+		return
+	}
+	writeInformational(w, r, http.StatusEarlyHints, http.Header{"Link": eh})
+}
+
+// writeInformational sends an informational response with only header,
+// leaving w's header map, which holds the final response's headers so far,
+// as it was. HTTP/1.0 clients don't expect informational responses.
+func writeInformational(w http.ResponseWriter, r *http.Request, code int, header http.Header) {
+	if !r.ProtoAtLeast(1, 1) {
+		return
+	}
+	h := w.Header()
+	saved := h.Clone()
+	clear(h)
+	for k, v := range header {
+		h[k] = v
+	}
+	w.WriteHeader(code)
+	clear(h)
+	for k, v := range saved {
+		h[k] = v
+	}
+}
+
+// informationalWriter passes a backend's informational responses, such as
+// 103 Early Hints, to the client with only their own headers. The reverse
+// proxy copies them into the header map it writes to and clears it after,
+// which would otherwise send the headers set so far along with them and
+// then lose them.
+type informationalWriter struct {
+	http.ResponseWriter
+	request     *http.Request
+	header      http.Header // the reverse proxy's view until the final header
+	sent        bool        // an informational response was passed on
+	wroteHeader bool
+}
+
+func newInformationalWriter(w http.ResponseWriter, r *http.Request) *informationalWriter {
+	return &informationalWriter{ResponseWriter: w, request: r, header: w.Header().Clone()}
+}
+
+func (iw *informationalWriter) Header() http.Header {
+	if iw.wroteHeader {
+		return iw.ResponseWriter.Header()
+	}
+	return iw.header
+}
+
+func (iw *informationalWriter) WriteHeader(code int) {
+	if iw.wroteHeader {
+		iw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		header := iw.header
+		if !iw.sent {
+			header = addedHeaders(iw.header, iw.ResponseWriter.Header())
+		}
+		writeInformational(iw.ResponseWriter, iw.request, code, header)
+		iw.sent = true
+		clear(iw.header)
+		return
+	}
+	iw.wroteHeader = true
+	h := iw.ResponseWriter.Header()
+	if !iw.sent {
+		// The view started as a copy, so it has every header or removed it
+		clear(h)
+	}
+	for k, v := range iw.header {
+		h[k] = v
+	}
+	iw.ResponseWriter.WriteHeader(code)
+}
+
+func (iw *informationalWriter) Write(b []byte) (int, error) {
+	if !iw.wroteHeader {
+		iw.WriteHeader(http.StatusOK)
+	}
+	return iw.ResponseWriter.Write(b)
+}
+
+func (iw *informationalWriter) Flush() {
+	if !iw.wroteHeader {
+		iw.WriteHeader(http.StatusOK)
+	}
+	_ = http.NewResponseController(iw.ResponseWriter).Flush()
+}
+
+func (iw *informationalWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
+
+// addedHeaders returns the values of h appended to those of base
+func addedHeaders(h, base http.Header) http.Header {
+	added := make(http.Header)
+	for k, v := range h {
+		if n := len(base[k]); len(v) > n {
+			added[k] = v[n:]
+		}
+	}
+	return added
+}
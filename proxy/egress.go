@@ -0,0 +1,294 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// dialFunc matches the signature of net.Dialer.DialContext
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// egressDialer tunnels backend connections through a SOCKS5 or HTTP CONNECT proxy
+type egressDialer struct {
+	proxyURL *url.URL
+	username string
+	password string
+	dial     dialFunc
+	policy   *egressPolicy
+	resolver *upstreamResolver
+}
+
+func newEgressDialer(cfg *config.EgressProxyConfig, dial dialFunc) (*egressDialer, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid egress proxy URL %s: %w", cfg.URL, err)
+	}
+
+	ed := &egressDialer{
+		proxyURL: u,
+		username: cfg.Username,
+		password: cfg.Password,
+		dial:     dial,
+	}
+
+	// Credentials embedded in the URL are used unless set explicitly
+	if ed.username == "" && u.User != nil {
+		ed.username = u.User.Username()
+		ed.password, _ = u.User.Password()
+	}
+
+	return ed, nil
+}
+
+func (ed *egressDialer) proxyAddr() string {
+	if ed.proxyURL.Port() != "" {
+		return ed.proxyURL.Host
+	}
+	switch ed.proxyURL.Scheme {
+	case "http":
+		return net.JoinHostPort(ed.proxyURL.Hostname(), "80")
+	case "https":
+		return net.JoinHostPort(ed.proxyURL.Hostname(), "443")
+	default:
+		return net.JoinHostPort(ed.proxyURL.Hostname(), "1080")
+	}
+}
+
+// DialContext connects to addr through the egress proxy
+func (ed *egressDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := ed.dial(ctx, "tcp", ed.proxyAddr())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to egress proxy %s: %w", ed.proxyURL.Host, err)
+	}
+
+	// Abort the handshake if the context is cancelled while it is in progress
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	switch ed.proxyURL.Scheme {
+	case "https":
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: ed.proxyURL.Hostname()})
+		if err = tlsConn.HandshakeContext(ctx); err == nil {
+			conn = tlsConn
+			err = ed.connect(conn, addr)
+		}
+	case "http":
+		err = ed.connect(conn, addr)
+	default:
+		err = ed.socks5(ctx, conn, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("egress proxy %s: %w", ed.proxyURL.Host, err)
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connect establishes an HTTP CONNECT tunnel to addr
+func (ed *egressDialer) connect(conn net.Conn, addr string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if ed.username != "" {
+		creds := base64.StdEncoding.EncodeToString([]byte(ed.username + ":" + ed.password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("failed to send CONNECT request: %w", err)
+	}
+
+	// The response is read byte-by-byte so no tunnelled data is buffered away
+	resp, err := http.ReadResponse(bufio.NewReader(byteReader{conn}), req)
+	if err != nil {
+		return fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT to %s failed: %s", addr, resp.Status)
+	}
+	return nil
+}
+
+// byteReader limits each Read to a single byte
+type byteReader struct {
+	r io.Reader
+}
+
+func (br byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return br.r.Read(p)
+}
+
+const (
+	socks5Version         = 0x05
+	socks5AuthNone        = 0x00
+	socks5AuthPassword    = 0x02
+	socks5AuthUnavailable = 0xff
+	socks5CmdConnect      = 0x01
+	socks5AddrIPv4        = 0x01
+	socks5AddrDomain      = 0x03
+	socks5AddrIPv6        = 0x04
+)
+
+// socks5 performs the RFC 1928 handshake (with RFC 1929 authentication) for addr
+func (ed *egressDialer) socks5(ctx context.Context, conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return fmt.Errorf("invalid port in address %s", addr)
+	}
+
+	// Method negotiation
+	methods := []byte{socks5AuthNone}
+	if ed.username != "" {
+		methods = []byte{socks5AuthNone, socks5AuthPassword}
+	}
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return fmt.Errorf("failed to send SOCKS5 greeting: %w", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("failed to read SOCKS5 greeting: %w", err)
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if err := ed.socks5Auth(conn); err != nil {
+			return err
+		}
+	case socks5AuthUnavailable:
+		return errors.New("SOCKS5 proxy rejected all authentication methods")
+	default:
+		return fmt.Errorf("unsupported SOCKS5 authentication method %d", reply[1])
+	}
+
+	// Connect request; with socks5h the proxy resolves hostnames itself
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	ip := net.ParseIP(host)
+	if ip == nil && ed.proxyURL.Scheme == "socks5" {
+		ips, err := ed.lookupIP(ctx, host)
+		if err != nil || len(ips) == 0 {
+			return fmt.Errorf("failed to resolve %s: %w", host, err)
+		}
+		ip = ips[0]
+		if ed.policy != nil {
+			hostAllowed, _ := ctx.Value(hostAllowedKey{}).(bool)
+			if err := ed.policy.checkIP(ip, hostAllowed); err != nil {
+				return err
+			}
+		}
+	}
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return fmt.Errorf("hostname too long: %s", host)
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	case ip.To4() != nil:
+		req = append(req, socks5AddrIPv4)
+		req = append(req, ip.To4()...)
+	default:
+		req = append(req, socks5AddrIPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("failed to send SOCKS5 connect request: %w", err)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("failed to read SOCKS5 connect reply: %w", err)
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("SOCKS5 connect to %s failed with code %d", addr, header[1])
+	}
+
+	// Discard the bound address
+	var skip int
+	switch header[3] {
+	case socks5AddrIPv4:
+		skip = net.IPv4len + 2
+	case socks5AddrIPv6:
+		skip = net.IPv6len + 2
+	case socks5AddrDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return fmt.Errorf("failed to read SOCKS5 connect reply: %w", err)
+		}
+		skip = int(l[0]) + 2
+	default:
+		return fmt.Errorf("unknown SOCKS5 address type %d", header[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip)); err != nil {
+		return fmt.Errorf("failed to read SOCKS5 connect reply: %w", err)
+	}
+
+	return nil
+}
+
+func (ed *egressDialer) lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ed.resolver != nil {
+		return ed.resolver.LookupIP(ctx, host)
+	}
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+func (ed *egressDialer) socks5Auth(conn net.Conn) error {
+	if ed.username == "" {
+		return errors.New("SOCKS5 proxy requires authentication but no credentials are configured")
+	}
+	if len(ed.username) > 255 || len(ed.password) > 255 {
+		return errors.New("SOCKS5 username and password must be at most 255 bytes")
+	}
+
+	msg := []byte{0x01, byte(len(ed.username))}
+	msg = append(msg, ed.username...)
+	msg = append(msg, byte(len(ed.password)))
+	msg = append(msg, ed.password...)
+	if _, err := conn.Write(msg); err != nil {
+		return fmt.Errorf("failed to send SOCKS5 credentials: %w", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("failed to read SOCKS5 authentication reply: %w", err)
+	}
+	if reply[1] != 0x00 {
+		return errors.New("SOCKS5 authentication failed")
+	}
+	return nil
+}
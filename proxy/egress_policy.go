@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// Ranges that are never dialled unless explicitly allowed: link-local
+// addresses and the well-known cloud instance metadata endpoints
+var defaultDeniedCIDRs = []string{
+	"169.254.0.0/16",     // IPv4 link-local, includes 169.254.169.254
+	"fe80::/10",          // IPv6 link-local
+	"fd00:ec2::254/128",  // AWS IMDS over IPv6
+	"100.100.100.200/32", // Alibaba Cloud metadata
+}
+
+type hostAllowedKey struct{}
+
+// egressPolicy decides whether the proxy may connect to a destination.
+// Hostnames are checked before resolution and the resolved IP is checked
+// again when the socket connects, so DNS rebinding can't bypass the policy.
+type egressPolicy struct {
+	allowedNets  []*net.IPNet
+	allowedHosts []string
+	deniedNets   []*net.IPNet
+}
+
+func newEgressPolicy(cfg config.EgressConfig) (*egressPolicy, error) {
+	p := &egressPolicy{}
+
+	for _, cidr := range cfg.AllowedCIDRs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid egress CIDR %s: %w", cidr, err)
+		}
+		p.allowedNets = append(p.allowedNets, n)
+	}
+
+	denied := cfg.DeniedCIDRs
+	if !cfg.AllowLinkLocal {
+		denied = append(append([]string{}, defaultDeniedCIDRs...), denied...)
+	}
+	for _, cidr := range denied {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid egress CIDR %s: %w", cidr, err)
+		}
+		p.deniedNets = append(p.deniedNets, n)
+	}
+
+	for _, host := range cfg.AllowedHosts {
+		p.allowedHosts = append(p.allowedHosts, strings.ToLower(host))
+	}
+
+	return p, nil
+}
+
+// restricted reports whether an allowlist is configured at all
+func (p *egressPolicy) restricted() bool {
+	return len(p.allowedNets) > 0 || len(p.allowedHosts) > 0
+}
+
+func (p *egressPolicy) hostAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.allowedHosts {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIP validates a resolved destination address. hostAllowed is true when
+// the destination was reached via a name on the host allowlist.
+func (p *egressPolicy) checkIP(ip net.IP, hostAllowed bool) error {
+	for _, n := range p.deniedNets {
+		if n.Contains(ip) {
+			return fmt.Errorf("egress to %s is denied by policy", ip)
+		}
+	}
+
+	if !p.restricted() || hostAllowed {
+		return nil
+	}
+	for _, n := range p.allowedNets {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("egress to %s is not in the allowlist", ip)
+}
+
+// checkAddr validates a host:port destination before it is dialled or tunnelled.
+// Hostnames are only checked here; their addresses are checked on connect.
+func (p *egressPolicy) checkAddr(addr string) (hostAllowed bool, err error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false, err
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return false, p.checkIP(ip, false)
+	}
+
+	hostAllowed = p.hostAllowed(host)
+	if len(p.allowedNets) == 0 && len(p.allowedHosts) > 0 && !hostAllowed {
+		return false, fmt.Errorf("egress to %s is not in the allowlist", host)
+	}
+	return hostAllowed, nil
+}
+
+// wrap guards a dial function with the policy
+func (p *egressPolicy) wrap(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		hostAllowed, err := p.checkAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		return dial(context.WithValue(ctx, hostAllowedKey{}, hostAllowed), network, addr)
+	}
+}
+
+// control is installed as net.Dialer.ControlContext to check the address
+// actually being connected to
+func (p *egressPolicy) control(ctx context.Context, network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("egress to %s: unresolved address", address)
+	}
+	hostAllowed, _ := ctx.Value(hostAllowedKey{}).(bool)
+	return p.checkIP(ip, hostAllowed)
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// errorPages renders the bodies of errors generated by the proxy
+type errorPages struct {
+	pages map[int]*errorPage
+}
+
+type errorPage struct {
+	contentType string
+	tmpl        interface {
+		Execute(io.Writer, any) error
+	}
+}
+
+// errorPageData is available to error page templates
+type errorPageData struct {
+	Status     int
+	StatusText string
+	Message    string
+	RequestID  string
+	Timestamp  string
+	Method     string
+	Host       string
+	Path       string
+}
+
+// newErrorPages loads the configured templates, returning nil when there
+// are none
+func newErrorPages(cfg config.ErrorPagesConfig) (*errorPages, error) {
+	ep := &errorPages{pages: make(map[int]*errorPage)}
+	loaded := make(map[string]*errorPage)
+	for _, status := range config.ErrorPageStatuses {
+		file := cfg.Pages[status]
+		if file == "" {
+			file = cfg.Default
+		}
+		if file == "" {
+			continue
+		}
+		page, ok := loaded[file]
+		if !ok {
+			var err error
+			if page, err = loadErrorPage(file); err != nil {
+				return nil, err
+			}
+			loaded[file] = page
+		}
+		ep.pages[status] = page
+	}
+	if len(ep.pages) == 0 {
+		return nil, nil
+	}
+	return ep, nil
+}
+
+// loadErrorPage parses file, escaping values for HTML when the page is HTML
+func loadErrorPage(file string) (*errorPage, error) {
+	page := &errorPage{contentType: mime.TypeByExtension(filepath.Ext(file))}
+	if page.contentType == "" {
+		page.contentType = "text/html; charset=utf-8"
+	}
+
+	var err error
+	if strings.HasPrefix(page.contentType, "text/html") {
+		page.tmpl, err = htmltemplate.ParseFiles(file)
+	} else {
+		page.tmpl, err = template.ParseFiles(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load error page: %w", err)
+	}
+	return page, nil
+}
+
+// serve answers r with status, using the page for status if one is
+// configured and a plain-text message otherwise
+func (ep *errorPages) serve(w http.ResponseWriter, r *http.Request, status int, message string) {
+	ep.page(status).serve(w, r, status, message)
+}
+
+// page returns the page for status, or nil if there is none
+func (ep *errorPages) page(status int) *errorPage {
+	if ep == nil {
+		return nil
+	}
+	return ep.pages[status]
+}
+
+// serve renders the page for r, falling back to a plain-text message when
+// p is nil or fails to render
+func (p *errorPage) serve(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if p == nil {
+		http.Error(w, message, status)
+		return
+	}
+
+	var buf bytes.Buffer
+	err := p.tmpl.Execute(&buf, errorPageData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Message:    message,
+		RequestID:  requestID(r),
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		Method:     r.Method,
+		Host:       r.Host,
+		Path:       r.URL.Path,
+	})
+	if err != nil {
+		requestLogger(r).Error("Failed to render error page", "status", status, "error", err)
+		http.Error(w, message, status)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", p.contentType)
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(buf.Bytes())
+	}
+}
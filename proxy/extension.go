@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Middleware wraps the handler of the remaining pipeline, for programs that
+// embed the proxy and register their own request processing with Use
+type Middleware func(http.Handler) http.Handler
+
+// Hooks are callbacks into the handling of a request, for extensions that
+// only need to look at or change it rather than wrap the pipeline. Nil
+// hooks are skipped.
+type Hooks struct {
+	// OnRequest runs after the built-in middleware, before the request is
+	// routed, and may change it. Returning an error ends the request: a
+	// *StatusError answers it with its status and message, any other error
+	// with 500.
+	OnRequest func(r *http.Request) error
+
+	// OnResponse runs on a backend's response before it is sent to the
+	// client and may change it. Returning an error discards the response,
+	// which is then handled like a backend failure.
+	OnResponse func(resp *http.Response) error
+
+	// OnError runs when the proxy answers a request with an error of its
+	// own, such as 502 when the backend failed or 503 when none is
+	// available. It returns true if it wrote the response itself; the
+	// error page is sent otherwise.
+	OnError func(w http.ResponseWriter, r *http.Request, status int, err error) bool
+}
+
+// StatusError is an error an OnRequest hook returns to answer the request
+// with a particular status
+type StatusError struct {
+	Status  int
+	Message string // the status text when empty
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.Status)
+	}
+	return e.Message
+}
+
+// extensions holds the middleware and hooks registered by an embedding
+// program. The pipeline composes them on its first request, after which
+// they can't change.
+type extensions struct {
+	mu          sync.Mutex
+	middlewares []Middleware
+	hooks       []Hooks
+	sealed      atomic.Bool
+}
+
+// Use registers middleware that runs on every request after the built-in
+// middleware, in the order given, before the request is routed. It must be
+// called before Start and panics once the proxy has served a request.
+func (rp *ReverseProxy) Use(mws ...Middleware) {
+	rp.extensions.mu.Lock()
+	defer rp.extensions.mu.Unlock()
+	if rp.extensions.sealed.Load() {
+		panic("proxy: Use called after the proxy started serving requests")
+	}
+	rp.extensions.middlewares = append(rp.extensions.middlewares, mws...)
+}
+
+// AddHooks registers callbacks into the handling of every request. Hooks
+// of the same kind run in the order they were added, OnRequest hooks after
+// all middleware registered with Use. It must be called before Start and
+// panics once the proxy has served a request.
+func (rp *ReverseProxy) AddHooks(h Hooks) {
+	rp.extensions.mu.Lock()
+	defer rp.extensions.mu.Unlock()
+	if rp.extensions.sealed.Load() {
+		panic("proxy: AddHooks called after the proxy started serving requests")
+	}
+	rp.extensions.hooks = append(rp.extensions.hooks, h)
+}
+
+// seal stops registrations and returns the hooks
+func (e *extensions) seal() []Hooks {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sealed.Store(true)
+	return e.hooks
+}
+
+// extensionsMiddleware runs the registered middleware and OnRequest hooks.
+// They are registered after the pipeline is built, so they are composed on
+// its first request.
+func (rp *ReverseProxy) extensionsMiddleware(next http.Handler) http.Handler {
+	var (
+		once    sync.Once
+		handler http.Handler
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			hooks := rp.extensions.seal()
+			handler = next
+			if len(hooks) > 0 {
+				handler = rp.requestHooks(hooks, next)
+			}
+			for i := len(rp.extensions.middlewares) - 1; i >= 0; i-- {
+				handler = rp.extensions.middlewares[i](handler)
+			}
+		})
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// requestHooks runs the OnRequest hooks before next
+func (rp *ReverseProxy) requestHooks(hooks []Hooks, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range hooks {
+			if h.OnRequest == nil {
+				continue
+			}
+			if err := h.OnRequest(r); err != nil {
+				status := http.StatusInternalServerError
+				var se *StatusError
+				if errors.As(err, &se) {
+					status = se.Status
+				}
+				rp.serveError(w, r, status, err.Error(), err)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// onResponse runs the OnResponse hooks on a backend's response
+func (e *extensions) onResponse(resp *http.Response) error {
+	for _, h := range e.registered() {
+		if h.OnResponse == nil {
+			continue
+		}
+		if err := h.OnResponse(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registered returns the hooks once registration has ended; responses and
+// errors can only come about after the first request sealed them
+func (e *extensions) registered() []Hooks {
+	if !e.sealed.Load() {
+		return nil
+	}
+	return e.hooks
+}
+
+// serveError answers r with an error of the proxy's own, giving the OnError
+// hooks the first chance to write it. cause is the underlying error, if any.
+func (rp *ReverseProxy) serveError(w http.ResponseWriter, r *http.Request, status int, message string, cause error) {
+	if hooks := rp.extensions.registered(); len(hooks) > 0 {
+		if cause == nil {
+			cause = errors.New(message)
+		}
+		for _, h := range hooks {
+			if h.OnError != nil && h.OnError(w, r, status, cause) {
+				return
+			}
+		}
+	}
+	rp.errorPages.serve(w, r, status, message)
+}
+
+// serveErrorFunc is the signature of serveError, for route checks that
+// answer requests they refuse
+type serveErrorFunc func(w http.ResponseWriter, r *http.Request, status int, message string, cause error)
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// priorityBalancer sends requests to the group of backends with the lowest
+// priority that has a member in service. Backup groups only receive
+// traffic while every backend of the groups before them is down; a group
+// whose members are merely at their max_connections keeps its requests,
+// which then wait in the backend queue.
+type priorityBalancer struct {
+	groups  []LoadBalancer // ordered by priority
+	members [][]*Backend   // of each group
+}
+
+// newPoolBalancer creates the load balancer of a set of backends, failing
+// over between priority groups when the backends have more than one
+func (rp *ReverseProxy) newPoolBalancer(cfg config.LoadBalancerConfig, backends []*Backend) LoadBalancer {
+	newBalancer := rp.balancer
+	if newBalancer == nil {
+		newBalancer = func(backends []*Backend) LoadBalancer {
+			return newLoadBalancer(cfg, backends)
+		}
+	}
+
+	byPriority := make(map[int][]*Backend)
+	for _, b := range backends {
+		byPriority[b.Priority] = append(byPriority[b.Priority], b)
+	}
+	if len(byPriority) < 2 {
+		return newBalancer(backends)
+	}
+
+	priorities := make([]int, 0, len(byPriority))
+	for p := range byPriority {
+		priorities = append(priorities, p)
+	}
+	sort.Ints(priorities)
+
+	pb := &priorityBalancer{
+		groups:  make([]LoadBalancer, 0, len(priorities)),
+		members: make([][]*Backend, 0, len(priorities)),
+	}
+	for _, p := range priorities {
+		pb.groups = append(pb.groups, newBalancer(byPriority[p]))
+		pb.members = append(pb.members, byPriority[p])
+	}
+	return pb
+}
+
+func (pb *priorityBalancer) NextBackend(r *http.Request) *Backend {
+	for i, group := range pb.groups {
+		if slices.ContainsFunc(pb.members[i], (*Backend).inService) {
+			return group.NextBackend(r)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+func TestPriorityBalancerFailover(t *testing.T) {
+	primary := newTestBackend(t, "http://primary:8080", 1)
+	primary.maxConns = 1
+	backup := newTestBackend(t, "http://backup:8080", 1)
+	backup.Priority = 1
+	lb := (&ReverseProxy{}).newPoolBalancer(config.LoadBalancerConfig{}, []*Backend{primary, backup})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if got := lb.NextBackend(r); got != primary {
+		t.Fatalf("got %v, want the primary", got)
+	}
+
+	// A saturated primary keeps its requests, which queue for a slot
+	primary.acquire()
+	if got := lb.NextBackend(r); got != nil {
+		t.Fatalf("saturated primary: got %v, want none", got.URL)
+	}
+	primary.connections.Add(-1)
+
+	for _, tc := range []struct {
+		name    string
+		remove  func()
+		restore func()
+	}{
+		{"down", func() { primary.SetAlive(false) }, func() { primary.SetAlive(true) }},
+		{"draining", func() { primary.SetDraining(true) }, func() { primary.SetDraining(false) }},
+		{"weight 0", func() { primary.SetWeight(0) }, func() { primary.SetWeight(1) }},
+	} {
+		tc.remove()
+		if got := lb.NextBackend(r); got != backup {
+			t.Errorf("primary %s: got %v, want the backup", tc.name, got)
+		}
+		tc.restore()
+		if got := lb.NextBackend(r); got != primary {
+			t.Errorf("primary back from %s: got %v, want the primary", tc.name, got)
+		}
+	}
+}
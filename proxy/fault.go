@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// maxAdminBodySize bounds request bodies accepted by admin endpoints
+const maxAdminBodySize = 1 << 20
+
+// faultInjector delays, fails or drops a share of requests according to
+// rules that can be replaced at runtime through the admin API
+type faultInjector struct {
+	mu      sync.RWMutex
+	enabled bool
+	rules   []config.FaultRule
+}
+
+func newFaultInjector(cfg config.FaultConfig) *faultInjector {
+	return &faultInjector{
+		enabled: cfg.Enabled,
+		rules:   cfg.Rules,
+	}
+}
+
+// match returns the first rule whose prefix matches path
+func (fi *faultInjector) match(path string) (config.FaultRule, bool) {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+
+	if !fi.enabled {
+		return config.FaultRule{}, false
+	}
+	for _, rule := range fi.rules {
+		if strings.HasPrefix(path, rule.PathPrefix) {
+			return rule, true
+		}
+	}
+	return config.FaultRule{}, false
+}
+
+func chance(percent float64) bool {
+	return percent > 0 && rand.Float64()*100 < percent
+}
+
+func (fi *faultInjector) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := fi.match(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if chance(rule.DelayPercent) {
+			timer := time.NewTimer(rule.Delay)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		if chance(rule.ResetPercent) {
+			// The server closes the connection without writing a response
+			panic(http.ErrAbortHandler)
+		}
+
+		if chance(rule.AbortPercent) {
+			w.Header().Set("X-Fault-Injected", "abort")
+			http.Error(w, http.StatusText(rule.AbortStatus), rule.AbortStatus)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type faultRuleView struct {
+	Name         string  `json:"name,omitempty"`
+	PathPrefix   string  `json:"path_prefix"`
+	DelayPercent float64 `json:"delay_percent"`
+	Delay        string  `json:"delay"`
+	AbortPercent float64 `json:"abort_percent"`
+	AbortStatus  int     `json:"abort_status"`
+	ResetPercent float64 `json:"reset_percent"`
+}
+
+type faultConfigView struct {
+	Enabled bool            `json:"enabled"`
+	Rules   []faultRuleView `json:"rules"`
+}
+
+func (fi *faultInjector) view() faultConfigView {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+
+	v := faultConfigView{Enabled: fi.enabled, Rules: make([]faultRuleView, 0, len(fi.rules))}
+	for _, r := range fi.rules {
+		v.Rules = append(v.Rules, faultRuleView{
+			Name:         r.Name,
+			PathPrefix:   r.PathPrefix,
+			DelayPercent: r.DelayPercent,
+			Delay:        r.Delay.String(),
+			AbortPercent: r.AbortPercent,
+			AbortStatus:  r.AbortStatus,
+			ResetPercent: r.ResetPercent,
+		})
+	}
+	return v
+}
+
+// adminHandler serves GET /faults to inspect and PUT /faults to replace the
+// fault configuration. PUT bodies use the same fields as the config file,
+// as JSON or YAML.
+func (fi *faultInjector) adminHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, fi.view())
+
+	case http.MethodPut:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxAdminBodySize))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var cfg config.FaultConfig
+		if err := yaml.Unmarshal(body, &cfg); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid fault configuration: "+err.Error())
+			return
+		}
+		for i := range cfg.Rules {
+			cfg.Rules[i].SetDefaults()
+			if err := cfg.Rules[i].Validate(); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		fi.mu.Lock()
+		fi.enabled = cfg.Enabled
+		fi.rules = cfg.Rules
+		fi.mu.Unlock()
+
+		requestLogger(r).Info("Fault injection updated via admin API", "enabled", cfg.Enabled, "rules", len(cfg.Rules))
+		writeJSON(w, http.StatusOK, fi.view())
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// maxForwardAuthBodySize bounds the denial body relayed from the auth service
+const maxForwardAuthBodySize = 1 << 20
+
+// hopHeaders apply to a single connection and are not copied between the
+// client, the auth service and the proxied request
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length",
+}
+
+// forwardAuth asks an external service whether each request may proceed.
+// A 2xx answer lets the request through, carrying the configured response
+// headers; any other answer is relayed to the client as is, so the service
+// can redirect to a login page.
+type forwardAuth struct {
+	config config.ForwardAuthConfig
+	client *http.Client
+}
+
+// newForwardAuth returns nil when forward authentication is disabled
+func newForwardAuth(cfg config.ForwardAuthConfig) *forwardAuth {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &forwardAuth{
+		config: cfg,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			// Redirects are the service's answer to the client, not to us
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (fa *forwardAuth) middleware(next http.Handler) http.Handler {
+	if fa == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if excludedPath(r, fa.config.ExcludePaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Identity headers only ever come from the auth service
+		for _, h := range fa.config.ResponseHeaders {
+			r.Header.Del(h)
+		}
+
+		resp, err := fa.check(r.Context(), r)
+		if err != nil {
+			logRequest(r, slog.LevelError, "Forward auth request failed", "error", err)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			copyHeaders(w.Header(), resp.Header)
+			w.WriteHeader(resp.StatusCode)
+			_, _ = io.Copy(w, io.LimitReader(resp.Body, maxForwardAuthBodySize))
+			return
+		}
+
+		for _, h := range fa.config.ResponseHeaders {
+			if values := resp.Header.Values(h); len(values) > 0 {
+				r.Header[http.CanonicalHeaderKey(h)] = values
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// check sends the request's metadata, without its body, to the auth service
+func (fa *forwardAuth) check(ctx context.Context, r *http.Request) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fa.config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fa.config.RequestHeaders) == 0 {
+		copyHeaders(req.Header, r.Header)
+	} else {
+		for _, h := range fa.config.RequestHeaders {
+			if values := r.Header.Values(h); len(values) > 0 {
+				req.Header[http.CanonicalHeaderKey(h)] = values
+			}
+		}
+	}
+
+	// X-Forwarded-Proto and X-Forwarded-Host were settled by the forwarded
+	// headers middleware; describe the rest of the original request
+	req.Header.Set("X-Forwarded-Proto", r.Header.Get("X-Forwarded-Proto"))
+	req.Header.Set("X-Forwarded-Host", r.Header.Get("X-Forwarded-Host"))
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	if addr := clientAddr(r); addr.IsValid() {
+		req.Header.Set("X-Forwarded-For", addr.String())
+	}
+
+	return fa.client.Do(req)
+}
+
+// copyHeaders copies end-to-end headers from src to dst
+func copyHeaders(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = append([]string(nil), v...)
+	}
+	for _, h := range hopHeaders {
+		dst.Del(h)
+	}
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+type clientAddrKey struct{}
+
+// forwardedHeaders resolves the real client address and maintains the
+// X-Forwarded-* and X-Real-IP headers sent to backends. Forwarded headers
+// are only kept when the peer is a trusted proxy; the X-Forwarded-For chain
+// itself is extended with the peer address by the reverse proxy.
+type forwardedHeaders struct {
+	trusted *ipSet
+}
+
+func newForwardedHeaders(cfg config.ForwardedConfig) (*forwardedHeaders, error) {
+	trusted, err := newIPSet(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &forwardedHeaders{trusted: trusted}, nil
+}
+
+func (fh *forwardedHeaders) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := peerAddr(r)
+		client := peer
+
+		if peer.IsValid() && fh.trusted.Contains(peer) {
+			client = fh.resolveClient(r.Header.Values("X-Forwarded-For"), peer)
+		} else {
+			for _, h := range []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Real-IP", "Forwarded"} {
+				r.Header.Del(h)
+			}
+		}
+
+		if r.Header.Get("X-Forwarded-Proto") == "" {
+			proto := "http"
+			if r.TLS != nil {
+				proto = "https"
+			}
+			r.Header.Set("X-Forwarded-Proto", proto)
+		}
+		if r.Header.Get("X-Forwarded-Host") == "" {
+			r.Header.Set("X-Forwarded-Host", r.Host)
+		}
+		if client.IsValid() {
+			r.Header.Set("X-Real-IP", client.String())
+			r = r.WithContext(context.WithValue(r.Context(), clientAddrKey{}, client))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resolveClient walks the X-Forwarded-For chain from the right, skipping
+// trusted proxies, and returns the first address that isn't one
+func (fh *forwardedHeaders) resolveClient(values []string, peer netip.Addr) netip.Addr {
+	var hops []string
+	for _, v := range values {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			if ap, err := netip.ParseAddrPort(strings.TrimSpace(hops[i])); err == nil {
+				addr = ap.Addr()
+			} else {
+				break
+			}
+		}
+		client = addr.Unmap()
+		if !fh.trusted.Contains(client) {
+			break
+		}
+	}
+	return client
+}
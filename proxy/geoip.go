@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoIP resolves client countries from a MaxMind database, admitting or
+// refusing clients by country and telling backends where clients are
+type geoIP struct {
+	config config.GeoIPConfig
+	allow  map[string]bool
+	deny   map[string]bool
+	logger *slog.Logger
+
+	mu      sync.RWMutex
+	reader  *maxminddb.Reader
+	modTime time.Time
+
+	stop chan struct{}
+}
+
+// countryRecord is the part of a Country or City record the proxy needs
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// newGeoIP returns nil when GeoIP is disabled. The database must be
+// readable at startup.
+func newGeoIP(cfg config.GeoIPConfig, logger *slog.Logger) (*geoIP, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	g := &geoIP{
+		config: cfg,
+		allow:  make(map[string]bool, len(cfg.AllowCountries)),
+		deny:   make(map[string]bool, len(cfg.DenyCountries)),
+		logger: logger,
+		stop:   make(chan struct{}),
+	}
+	for _, c := range cfg.AllowCountries {
+		g.allow[c] = true
+	}
+	for _, c := range cfg.DenyCountries {
+		g.deny[c] = true
+	}
+	if _, err := g.reload(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *geoIP) Start() {
+	go func() {
+		ticker := time.NewTicker(g.config.ReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if reloaded, err := g.reload(); err != nil {
+					g.logger.Error("Failed to reload GeoIP database", "path", g.config.Database, "error", err)
+				} else if reloaded {
+					g.logger.Info("Reloaded GeoIP database", "path", g.config.Database)
+				}
+			case <-g.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (g *geoIP) Stop() {
+	close(g.stop)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.reader != nil {
+		g.reader.Close()
+		g.reader = nil
+	}
+}
+
+// reload opens the database if the file changed since it was last loaded,
+// keeping the current one on failure
+func (g *geoIP) reload() (bool, error) {
+	info, err := os.Stat(g.config.Database)
+	if err != nil {
+		return false, err
+	}
+	g.mu.RLock()
+	unchanged := g.reader != nil && info.ModTime().Equal(g.modTime)
+	g.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	reader, err := maxminddb.Open(g.config.Database)
+	if err != nil {
+		return false, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+
+	// Lookups hold the read lock, so the old reader is idle once this
+	// lock is acquired and can be closed safely
+	g.mu.Lock()
+	old := g.reader
+	g.reader = reader
+	g.modTime = info.ModTime()
+	g.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return true, nil
+}
+
+// country returns the ISO code of the client's country, or "" if unknown
+func (g *geoIP) country(r *http.Request) string {
+	addr := clientAddr(r)
+	if !addr.IsValid() {
+		return ""
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.reader == nil {
+		return ""
+	}
+	var record countryRecord
+	if err := g.reader.Lookup(addr.AsSlice(), &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+func (g *geoIP) admitted(country string) bool {
+	if g.deny[country] {
+		return false
+	}
+	if len(g.allow) == 0 || g.allow[country] {
+		return true
+	}
+	return country == "" && g.config.AllowUnknown
+}
+
+func (g *geoIP) middleware(next http.Handler) http.Handler {
+	if g == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		country := g.country(r)
+		if !g.admitted(country) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if g.config.Header != "-" {
+			r.Header.Del(g.config.Header)
+			if country != "" {
+				r.Header.Set(g.config.Header, country)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
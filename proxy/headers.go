@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// headerOps is a compiled list of header operations
+type headerOps struct {
+	remove []string
+	set    []headerValue
+	add    []headerValue
+}
+
+type headerValue struct {
+	name  string
+	value string // may contain $variables
+}
+
+// headerRules rewrites the request sent upstream and the response sent downstream
+type headerRules struct {
+	request  headerOps
+	response headerOps
+}
+
+// newHeaderRules returns nil when cfg contains no operations
+func newHeaderRules(cfg *config.HeaderRulesConfig) *headerRules {
+	if cfg == nil {
+		return nil
+	}
+	hr := &headerRules{
+		request:  newHeaderOps(cfg.Request),
+		response: newHeaderOps(cfg.Response),
+	}
+	if hr.request.empty() && hr.response.empty() {
+		return nil
+	}
+	return hr
+}
+
+func newHeaderOps(cfg config.HeaderOpsConfig) headerOps {
+	ops := headerOps{
+		set: sortedHeaderValues(cfg.Set),
+		add: sortedHeaderValues(cfg.Add),
+	}
+	for _, name := range cfg.Remove {
+		ops.remove = append(ops.remove, http.CanonicalHeaderKey(name))
+	}
+	return ops
+}
+
+func sortedHeaderValues(m map[string]string) []headerValue {
+	values := make([]headerValue, 0, len(m))
+	for name, value := range m {
+		values = append(values, headerValue{name: http.CanonicalHeaderKey(name), value: value})
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].name < values[j].name })
+	return values
+}
+
+func (ops headerOps) empty() bool {
+	return len(ops.remove) == 0 && len(ops.set) == 0 && len(ops.add) == 0
+}
+
+// apply rewrites h, expanding variables from r
+func (ops headerOps) apply(h http.Header, r *http.Request) {
+	for _, name := range ops.remove {
+		h.Del(name)
+	}
+	for _, hv := range ops.set {
+		h.Set(hv.name, expandHeaderValue(hv.value, r))
+	}
+	for _, hv := range ops.add {
+		h.Add(hv.name, expandHeaderValue(hv.value, r))
+	}
+}
+
+// applyRequest rewrites the request headers sent to the backend. A Host
+// header rule changes the request's Host.
+func (hr *headerRules) applyRequest(r *http.Request) {
+	if hr == nil || hr.request.empty() {
+		return
+	}
+	hr.request.apply(r.Header, r)
+	if host := r.Header.Get("Host"); host != "" {
+		r.Host = host
+		r.Header.Del("Host")
+	}
+}
+
+func expandHeaderValue(value string, r *http.Request) string {
+	return os.Expand(value, func(name string) string {
+		switch name {
+		case "remote_addr":
+			if addr := clientAddr(r); addr.IsValid() {
+				return addr.String()
+			}
+			return ""
+		case "host":
+			return r.Host
+		case "scheme":
+			if r.TLS != nil {
+				return "https"
+			}
+			return "http"
+		case "method":
+			return r.Method
+		case "uri":
+			return r.URL.Path
+		case "request_uri":
+			return r.URL.RequestURI()
+		case "query_string":
+			return r.URL.RawQuery
+		}
+		return ""
+	})
+}
+
+// headerRewriter applies response header rules just before the response
+// header is written, so they also cover errors generated by the proxy
+type headerRewriter struct {
+	http.ResponseWriter
+	request     *http.Request
+	rules       []*headerRules
+	wroteHeader bool
+}
+
+func (hw *headerRewriter) WriteHeader(code int) {
+	if !hw.wroteHeader && code >= 200 {
+		hw.wroteHeader = true
+		for _, rules := range hw.rules {
+			rules.response.apply(hw.Header(), hw.request)
+		}
+	}
+	hw.ResponseWriter.WriteHeader(code)
+}
+
+func (hw *headerRewriter) Write(b []byte) (int, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	return hw.ResponseWriter.Write(b)
+}
+
+func (hw *headerRewriter) ReadFrom(src io.Reader) (int64, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	return io.Copy(hw.ResponseWriter, src)
+}
+
+func (hw *headerRewriter) Flush() {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (hw *headerRewriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// rewriteHeaders applies the request rules to r and returns a writer that
+// applies the response rules; rules that are nil are skipped
+func rewriteHeaders(w http.ResponseWriter, r *http.Request, rules ...*headerRules) http.ResponseWriter {
+	var response []*headerRules
+	for _, hr := range rules {
+		if hr == nil {
+			continue
+		}
+		hr.applyRequest(r)
+		if !hr.response.empty() {
+			response = append(response, hr)
+		}
+	}
+	if len(response) == 0 {
+		return w
+	}
+	return &headerRewriter{ResponseWriter: w, request: r, rules: response}
+}
+
+// upstreamHost returns the Host header sent to backend, following the
+// route's host_header or else the backend's
+func upstreamHost(r *http.Request, route route, backend *Backend) string {
+	mode := route.host
+	if mode == "" {
+		mode = backend.hostHeader
+	}
+	switch mode {
+	case "", config.HostHeaderPreserve:
+		return r.Host
+	case config.HostHeaderBackend:
+		return backend.URL.Host
+	}
+	return mode
+}
@@ -2,8 +2,15 @@ package proxy
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bunnydevv/reverse-proxy/config"
@@ -13,75 +20,367 @@ type HealthChecker struct {
 	config   *config.Config
 	backends []*Backend
 	client   *http.Client
-	stop     chan struct{}
+	onChange func(backend *Backend, alive bool, reason string)
+	ejected  func(backend *Backend) bool // passive ejections that probes must not override
+	logger   *slog.Logger
+
+	ctx    context.Context // cancelled by Stop, ending every probe
+	cancel context.CancelFunc
+	slots  chan struct{}  // bounds the probes in flight; nil for no limit
+	loops  sync.WaitGroup // the backends' probe loops
+
+	mu      sync.Mutex
+	streaks map[*Backend]*probeStreak
+	probes  map[*Backend]context.CancelFunc // stops the probes of one backend
+	started bool
+}
+
+// probeStreak counts consecutive probe results of one backend
+type probeStreak struct {
+	successes int
+	failures  int
+	untested  bool // started unhealthy and no probe has succeeded yet
 }
 
 func NewHealthChecker(cfg *config.Config, backends []*Backend) *HealthChecker {
-	return &HealthChecker{
+	ctx, cancel := context.WithCancel(context.Background())
+	hc := &HealthChecker{
 		config:   cfg,
 		backends: backends,
 		client: &http.Client{
 			Timeout: cfg.HealthCheck.Timeout,
 		},
-		stop: make(chan struct{}),
+		logger:  slog.Default(),
+		ctx:     ctx,
+		cancel:  cancel,
+		streaks: make(map[*Backend]*probeStreak),
+		probes:  make(map[*Backend]context.CancelFunc),
+	}
+	if cfg.HealthCheck.MaxConcurrent > 0 {
+		hc.slots = make(chan struct{}, cfg.HealthCheck.MaxConcurrent)
 	}
+	return hc
 }
 
 func (hc *HealthChecker) Start() {
-	ticker := time.NewTicker(hc.config.HealthCheck.Interval)
-	go func() {
-		// Do initial health check
-		hc.checkAll()
-
-		for {
-			select {
-			case <-ticker.C:
-				hc.checkAll()
-			case <-hc.stop:
-					ticker.Stop()
-					return
-			}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.started = true
+	for _, backend := range hc.backends {
+		hc.launch(backend)
+	}
+}
+
+// launch starts probing a backend; hc.mu must be held
+func (hc *HealthChecker) launch(backend *Backend) {
+	if hc.probe(backend).startUnhealthy {
+		backend.SetAlive(false)
+		hc.streaks[backend] = &probeStreak{untested: true}
+	}
+	ctx, cancel := context.WithCancel(hc.ctx)
+	hc.probes[backend] = cancel
+	hc.loops.Add(1)
+	go hc.run(ctx, backend)
+}
+
+// add starts checking a backend that joined a pool after startup
+func (hc *HealthChecker) add(backend *Backend) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.backends = append(hc.backends, backend)
+	if hc.started {
+		hc.launch(backend)
+	}
+}
+
+// remove stops checking a backend that left its pool
+func (hc *HealthChecker) remove(backend *Backend) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	for i, b := range hc.backends {
+		if b == backend {
+			hc.backends = append(hc.backends[:i:i], hc.backends[i+1:]...)
+			break
 		}
-	}()
+	}
+	if cancel, ok := hc.probes[backend]; ok {
+		cancel()
+		delete(hc.probes, backend)
+	}
+	delete(hc.streaks, backend)
 }
 
+// Stop cancels the probes in flight and waits for every backend's probe
+// loop to end
 func (hc *HealthChecker) Stop() {
-	close(hc.stop)
+	hc.mu.Lock()
+	hc.started = false
+	hc.cancel()
+	hc.mu.Unlock()
+	hc.loops.Wait()
 }
 
-func (hc *HealthChecker) checkAll() {
-	for _, backend := range hc.backends {
-		go hc.check(backend)
+// run probes one backend on its own jittered schedule so probes against
+// different backends don't all fire at the same moment, until ctx is
+// cancelled. Each probe finishes before the next is scheduled, so slow
+// backends can't make probes pile up.
+func (hc *HealthChecker) run(ctx context.Context, backend *Backend) {
+	defer hc.loops.Done()
+	probe := hc.probe(backend)
+	jitter := time.Duration(hc.config.HealthCheck.Jitter * float64(probe.interval))
+	delay := probe.initialDelay
+	if jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(jitter)))
+	}
+
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+			hc.check(ctx, backend)
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		delay = probe.interval
+		if jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(2*jitter))) - jitter
+		}
 	}
 }
 
-func (hc *HealthChecker) check(backend *Backend) {
-	url := backend.URL.String() + hc.config.HealthCheck.Path
-	ctx, cancel := context.WithTimeout(context.Background(), hc.config.HealthCheck.Timeout)
-	defer cancel()
+// maxProbeBody is how much of a probe response is searched for the
+// expected body
+const maxProbeBody = 64 << 10
+
+// healthProbe is the effective health check request for one backend
+type healthProbe struct {
+	typ      string
+	path     string
+	method   string
+	header   http.Header
+	host     string
+	expected map[int]bool // empty means any 2xx
+	body     string       // text the response must contain, if set
+	timeout  time.Duration
+
+	interval       time.Duration
+	initialDelay   time.Duration
+	startUnhealthy bool
+}
+
+// probe merges the backend's overrides over the global health check settings
+func (hc *HealthChecker) probe(backend *Backend) healthProbe {
+	p := healthProbe{
+		typ:     hc.config.HealthCheck.Type,
+		path:    hc.config.HealthCheck.Path,
+		method:  hc.config.HealthCheck.Method,
+		header:  make(http.Header),
+		body:    hc.config.HealthCheck.ExpectedBody,
+		timeout: hc.config.HealthCheck.Timeout,
+
+		interval:       hc.config.HealthCheck.Interval,
+		initialDelay:   hc.config.HealthCheck.InitialDelay,
+		startUnhealthy: hc.config.HealthCheck.StartUnhealthy,
+	}
+	p.setHeaders(hc.config.HealthCheck.Headers)
+
+	o := backend.healthCheck
+	if o == nil {
+		return p
+	}
+	if o.Type != "" {
+		p.typ = o.Type
+	}
+	if o.Path != "" {
+		p.path = o.Path
+	}
+	if o.Method != "" {
+		p.method = o.Method
+	}
+	if o.Timeout > 0 {
+		p.timeout = o.Timeout
+	}
+	if o.Interval > 0 {
+		p.interval = o.Interval
+	}
+	if o.InitialDelay > 0 {
+		p.initialDelay = o.InitialDelay
+	}
+	if o.StartUnhealthy != nil {
+		p.startUnhealthy = *o.StartUnhealthy
+	}
+	if o.ExpectedBody != "" {
+		p.body = o.ExpectedBody
+	}
+	p.setHeaders(o.Headers)
+	if len(o.ExpectedStatus) > 0 {
+		p.expected = make(map[int]bool, len(o.ExpectedStatus))
+		for _, code := range o.ExpectedStatus {
+			p.expected[code] = true
+		}
+	}
+	return p
+}
+
+// setHeaders adds request headers to the probe, a Host entry setting its Host
+func (p *healthProbe) setHeaders(headers map[string]string) {
+	for k, v := range headers {
+		if http.CanonicalHeaderKey(k) == "Host" {
+			p.host = v
+			continue
+		}
+		p.header.Set(k, v)
+	}
+}
+
+func (p healthProbe) healthy(status int) bool {
+	if len(p.expected) > 0 {
+		return p.expected[status]
+	}
+	return status >= 200 && status < 300
+}
+
+// check probes a backend once, once a slot is free, and records the
+// result unless ctx was cancelled meanwhile
+func (hc *HealthChecker) check(ctx context.Context, backend *Backend) {
+	if hc.slots != nil {
+		select {
+		case hc.slots <- struct{}{}:
+			defer func() { <-hc.slots }()
+		case <-ctx.Done():
+			return
+		}
+	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	reason, err := hc.probeOnce(ctx, backend)
+	// A probe cut short by Stop or the backend's removal says nothing
+	// about the backend
+	if ctx.Err() != nil {
+		return
+	}
 	if err != nil {
-		log.Printf("Health check failed for %s: %v", backend.URL.String(), err)
-		backend.SetAlive(false)
+		hc.logger.Warn("Health check failed", "backend", backend.URL.String(), "error", err)
+		hc.setAlive(backend, false, err.Error())
+		return
+	}
+	if hc.ejected != nil && hc.ejected(backend) {
 		return
 	}
+	hc.setAlive(backend, true, reason)
+}
+
+// probeOnce sends one probe to a backend and returns why it is healthy, or
+// the error that makes it unhealthy
+func (hc *HealthChecker) probeOnce(ctx context.Context, backend *Backend) (string, error) {
+	probe := hc.probe(backend)
+	target := *backend.URL
+	if probe.typ == config.HealthCheckHTTPS {
+		target.Scheme = "https"
+	}
+	url := target.String() + probe.path
+	ctx, cancel := context.WithTimeout(ctx, probe.timeout)
+	defer cancel()
+
+	// Stream backends don't speak HTTP; accepting a connection is healthy
+	if backend.Proxy == nil || probe.typ == config.HealthCheckTCP {
+		conn, err := dialProbe(ctx, backend)
+		if err != nil {
+			return "", err
+		}
+		conn.Close()
+		return "connection accepted", nil
+	}
 
-	resp, err := hc.client.Do(req)
+	req, err := http.NewRequestWithContext(ctx, probe.method, url, nil)
 	if err != nil {
-		log.Printf("Health check failed for %s: %v", backend.URL.String(), err)
-		backend.SetAlive(false)
-		return
+		return "", err
+	}
+	req.Header = probe.header
+	if probe.host != "" {
+		req.Host = probe.host
+	}
+
+	// Probe through the backend's own transport so DNS and egress settings apply
+	client := hc.client
+	if backend.Proxy != nil && backend.Proxy.Transport != nil {
+		client = &http.Client{Transport: backend.Proxy.Transport, Timeout: probe.timeout}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		if !backend.IsAlive() {
-			log.Printf("Backend %s is now healthy", backend.URL.String())
+	if !probe.healthy(resp.StatusCode) {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if probe.body != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+		if err != nil {
+			return "", err
+		}
+		if !strings.Contains(string(body), probe.body) {
+			return "", fmt.Errorf("response body lacks %q", probe.body)
 		}
-		backend.SetAlive(true)
+	}
+	return "status " + resp.Status, nil
+}
+
+// dialProbe connects to a backend for a tcp probe through the backend's
+// dialer, so its egress policy, egress proxy and resolver apply. Without a
+// port, the URL's scheme decides it.
+func dialProbe(ctx context.Context, backend *Backend) (net.Conn, error) {
+	return backend.dial(ctx, "tcp", probeAddress(backend.URL))
+}
+
+// probeAddress is the host and port of u, with the scheme's default port
+// when u has none
+func probeAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// setAlive records a probe result and changes the backend's state once the
+// healthy or unhealthy threshold of consecutive results is reached,
+// reporting transitions to onChange with the reason of the last result
+func (hc *HealthChecker) setAlive(backend *Backend, alive bool, reason string) {
+	hc.mu.Lock()
+	streak, ok := hc.streaks[backend]
+	if !ok {
+		streak = &probeStreak{}
+		hc.streaks[backend] = streak
+	}
+	var reached bool
+	if alive {
+		streak.successes++
+		streak.failures = 0
+		reached = streak.successes >= hc.config.HealthCheck.HealthyThreshold || streak.untested
+		streak.untested = false
 	} else {
-		log.Printf("Health check failed for %s: status code %d", backend.URL.String(), resp.StatusCode)
-		backend.SetAlive(false)
+		streak.failures++
+		streak.successes = 0
+		reached = streak.failures >= hc.config.HealthCheck.UnhealthyThreshold
 	}
-}
\ No newline at end of file
+	hc.mu.Unlock()
+
+	if !reached || backend.IsAlive() == alive {
+		return
+	}
+	if alive {
+		hc.logger.Info("Backend is now healthy", "backend", backend.URL.String())
+	} else {
+		hc.logger.Warn("Backend is now unhealthy", "backend", backend.URL.String())
+	}
+	backend.SetAlive(alive)
+	if hc.onChange != nil {
+		hc.onChange(backend, alive, reason)
+	}
+}
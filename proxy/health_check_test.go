@@ -0,0 +1,24 @@
+package proxy
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTCPProbeFollowsEgressPolicy(t *testing.T) {
+	rp := newTestProxy(t, `server:
+  address: ":0"
+health_check:
+  enabled: true
+backends:
+  - url: "http://169.254.169.254"
+    health_check:
+      type: tcp
+`)
+	backend := rp.backendList()[0]
+	_, err := rp.healthCheck.probeOnce(context.Background(), backend)
+	if err == nil || !strings.Contains(err.Error(), "egress") {
+		t.Fatalf("probe of a denied address: %v, want an egress policy error", err)
+	}
+}
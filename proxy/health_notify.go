@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// maxQueuedHealthEvents bounds the notifications waiting to be sent, so a
+// slow webhook can't hold up health checks
+const maxQueuedHealthEvents = 100
+
+// healthNotifier posts backend health transitions to a webhook, one at a
+// time and in order, from its own goroutine
+type healthNotifier struct {
+	config   config.HealthNotifyConfig
+	client   *http.Client
+	instance string
+	events   chan healthEvent
+	stop     chan struct{}
+	done     chan struct{}
+	logger   *slog.Logger
+}
+
+// healthEvent is the JSON payload of a notification
+type healthEvent struct {
+	Event     string    `json:"event"` // backend_healthy or backend_unhealthy
+	Backend   string    `json:"backend"`
+	Healthy   bool      `json:"healthy"`
+	Reason    string    `json:"reason"`
+	Check     string    `json:"check"`    // active or passive
+	Instance  string    `json:"instance"` // the proxy's host name
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Sources of health transitions
+const (
+	healthCheckActive  = "active"
+	healthCheckPassive = "passive"
+)
+
+// newHealthNotifier returns nil when no webhook is configured
+func newHealthNotifier(cfg config.HealthNotifyConfig, logger *slog.Logger) *healthNotifier {
+	if cfg.WebhookURL == "" {
+		return nil
+	}
+	instance, _ := os.Hostname()
+	return &healthNotifier{
+		config:   cfg,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		instance: instance,
+		events:   make(chan healthEvent, maxQueuedHealthEvents),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		logger:   logger,
+	}
+}
+
+// notify queues a notification that the backend became healthy or not,
+// dropping it when the webhook has fallen too far behind
+func (hn *healthNotifier) notify(backend *Backend, alive bool, check, reason string) {
+	if hn == nil {
+		return
+	}
+	event := healthEvent{
+		Event:     "backend_unhealthy",
+		Backend:   backend.URL.String(),
+		Healthy:   alive,
+		Reason:    reason,
+		Check:     check,
+		Instance:  hn.instance,
+		Timestamp: time.Now().UTC(),
+	}
+	if alive {
+		event.Event = "backend_healthy"
+	}
+	select {
+	case hn.events <- event:
+	default:
+		hn.logger.Warn("Dropped health notification while the webhook was behind", "backend", event.Backend, "healthy", alive)
+	}
+}
+
+func (hn *healthNotifier) Start() {
+	go func() {
+		defer close(hn.done)
+		for {
+			select {
+			case event := <-hn.events:
+				hn.send(event)
+			case <-hn.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop sends the notifications still queued
+func (hn *healthNotifier) Stop() {
+	close(hn.stop)
+	<-hn.done
+	for {
+		select {
+		case event := <-hn.events:
+			hn.send(event)
+		default:
+			return
+		}
+	}
+}
+
+func (hn *healthNotifier) send(event healthEvent) {
+	if err := hn.post(event); err != nil {
+		hn.logger.Warn("Failed to send health notification", "backend", event.Backend, "healthy", event.Healthy, "error", err)
+	}
+}
+
+func (hn *healthNotifier) post(event healthEvent) error {
+	var payload any = event
+	if hn.config.Format == config.HealthNotifySlack {
+		payload = map[string]string{"text": event.text()}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, hn.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range hn.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := hn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// text describes the event as a chat message
+func (e healthEvent) text() string {
+	state := ":red_circle: Backend %s is unhealthy"
+	if e.Healthy {
+		state = ":large_green_circle: Backend %s is healthy again"
+	}
+	msg := fmt.Sprintf(state, e.Backend)
+	if e.Instance != "" {
+		msg += " on " + e.Instance
+	}
+	return msg + fmt.Sprintf(" (%s check: %s) at %s", e.Check, e.Reason, e.Timestamp.Format(time.RFC3339))
+}
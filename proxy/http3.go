@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3Listener serves the proxy over QUIC and advertises itself to
+// clients connected over TCP with Alt-Svc
+type http3Listener struct {
+	server *http3.Server
+	altSvc string
+	conn   net.PacketConn
+	logger *slog.Logger
+}
+
+// newHTTP3Listener returns nil when HTTP/3 is disabled
+func newHTTP3Listener(cfg config.HTTP3Config, certificates *certificateStore, handler http.Handler, logger *slog.Logger) (*http3Listener, error) {
+	if !cfg.Enabled || certificates == nil {
+		return nil, nil
+	}
+
+	_, port, err := net.SplitHostPort(cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http3 address %q: %w", cfg.Address, err)
+	}
+	return &http3Listener{
+		server: &http3.Server{
+			Addr:      cfg.Address,
+			Handler:   handler,
+			TLSConfig: certificates.tlsConfig(),
+		},
+		altSvc: fmt.Sprintf(`h3=":%s"; ma=%d`, port, int(cfg.AltSvcMaxAge.Seconds())),
+		logger: logger,
+	}, nil
+}
+
+// middleware advertises HTTP/3 on responses to TLS requests made over TCP
+func (h *http3Listener) middleware(next http.Handler) http.Handler {
+	if h == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && r.ProtoMajor < 3 {
+			w.Header().Set("Alt-Svc", h.altSvc)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Start binds the UDP socket and serves HTTP/3 in the background
+func (h *http3Listener) Start(sockets *socketRegistry) error {
+	conn, err := sockets.listenPacket(h.server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for HTTP/3: %w", err)
+	}
+	h.conn = conn
+
+	go func() {
+		h.logger.Info("Serving HTTP/3", "address", conn.LocalAddr().String())
+		if err := h.server.Serve(conn); err != nil && err != http.ErrServerClosed {
+			h.logger.Error("HTTP/3 listener failed", "error", err)
+		}
+	}()
+	return nil
+}
+
+func (h *http3Listener) Stop() error {
+	err := h.server.Close()
+	if h.conn != nil {
+		h.conn.Close()
+	}
+	return err
+}
@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// idempotencyCache remembers the first response for each idempotency key
+// so retried requests are answered without reaching the backend again
+type idempotencyCache struct {
+	config  config.IdempotencyConfig
+	methods map[string]bool
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently stored at the front
+}
+
+type idempotentResponse struct {
+	key       string
+	completed bool
+	status    int
+	header    http.Header
+	body      []byte
+	expires   time.Time
+}
+
+// newIdempotencyCache returns nil when de-duplication is disabled
+func newIdempotencyCache(cfg config.IdempotencyConfig) *idempotencyCache {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	ic := &idempotencyCache{
+		config:  cfg,
+		methods: make(map[string]bool, len(cfg.Methods)),
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+	for _, m := range cfg.Methods {
+		ic.methods[m] = true
+	}
+	return ic
+}
+
+func (ic *idempotencyCache) middleware(next http.Handler) http.Handler {
+	if ic == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(ic.config.Header)
+		if key == "" || !ic.methods[r.Method] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cacheKey := ic.cacheKey(r, key)
+		entry, created := ic.begin(cacheKey)
+		if !created {
+			if !entry.completed {
+				http.Error(w, "A request with this idempotency key is already in progress", http.StatusConflict)
+				return
+			}
+			replayResponse(w, entry)
+			return
+		}
+
+		cw := &captureWriter{responseWriter: newResponseWriter(w), limit: ic.config.MaxResponseSize}
+		finished := false
+		defer func() {
+			// Release the key if the handler panicked so retries can proceed
+			if !finished {
+				ic.release(cacheKey)
+			}
+		}()
+
+		next.ServeHTTP(cw, r)
+		finished = true
+
+		// Server errors and oversized responses are not remembered so the
+		// client's retry gets another chance at the backend
+		if cw.overflow || cw.status >= http.StatusInternalServerError {
+			ic.release(cacheKey)
+			return
+		}
+		ic.complete(cacheKey, cw)
+	})
+}
+
+// cacheKey scopes the client's key to the method, path, credentials and the
+// identity they were authenticated as, so different clients can't replay
+// each other's responses
+func (ic *idempotencyCache) cacheKey(r *http.Request, key string) string {
+	keyID, _ := apiKeyID(r)
+	subject, _ := jwtSubject(r)
+	var cert string
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		cert = string(r.TLS.PeerCertificates[0].Raw)
+	}
+
+	h := sha256.New()
+	for _, part := range []string{r.Method, r.Host, r.URL.Path, r.Header.Get("Authorization"), keyID, subject, cert, key} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// begin returns the existing entry for key, or registers a new in-flight one
+func (ic *idempotencyCache) begin(key string) (*idempotentResponse, bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	if el, ok := ic.entries[key]; ok {
+		entry := el.Value.(*idempotentResponse)
+		if !entry.completed || time.Now().Before(entry.expires) {
+			return entry, false
+		}
+		ic.order.Remove(el)
+		delete(ic.entries, key)
+	}
+
+	entry := &idempotentResponse{key: key}
+	ic.entries[key] = ic.order.PushFront(entry)
+	ic.evict()
+	return entry, true
+}
+
+func (ic *idempotencyCache) complete(key string, cw *captureWriter) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	el, ok := ic.entries[key]
+	if !ok {
+		return
+	}
+	entry := el.Value.(*idempotentResponse)
+	entry.completed = true
+	entry.status = cw.status
+	entry.header = cw.header
+	entry.body = cw.body
+	entry.expires = time.Now().Add(ic.config.Window)
+	ic.order.MoveToFront(el)
+}
+
+func (ic *idempotencyCache) release(key string) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	if el, ok := ic.entries[key]; ok {
+		ic.order.Remove(el)
+		delete(ic.entries, key)
+	}
+}
+
+// evict drops the oldest entries beyond the configured capacity; callers hold mu
+func (ic *idempotencyCache) evict() {
+	for ic.order.Len() > ic.config.MaxEntries {
+		el := ic.order.Back()
+		ic.order.Remove(el)
+		delete(ic.entries, el.Value.(*idempotentResponse).key)
+	}
+}
+
+func replayResponse(w http.ResponseWriter, entry *idempotentResponse) {
+	for k, v := range entry.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(entry.status)
+	_, _ = w.Write(entry.body)
+}
+
+// captureWriter passes a response through while keeping a copy of it,
+// up to limit bytes of body
+type captureWriter struct {
+	*responseWriter
+	limit    int64
+	header   http.Header
+	body     []byte
+	overflow bool
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if !cw.wroteHeader && code >= 200 {
+		cw.header = cw.Header().Clone()
+		// A body announced as too large isn't collected at all
+		if n, err := strconv.ParseInt(cw.header.Get("Content-Length"), 10, 64); err == nil && n > cw.limit {
+			cw.overflow = true
+		}
+	}
+	cw.responseWriter.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.overflow {
+		if int64(len(cw.body)+len(b)) > cw.limit {
+			cw.overflow = true
+			cw.body = nil
+		} else {
+			cw.body = append(cw.body, b...)
+		}
+	}
+	return cw.responseWriter.Write(b)
+}
+
+// ReadFrom copies through Write, which keeps the copy
+func (cw *captureWriter) ReadFrom(src io.Reader) (int64, error) {
+	return io.Copy(writerOnly{cw}, src)
+}
+
+func (cw *captureWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	cw.responseWriter.Flush()
+}
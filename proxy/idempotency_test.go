@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+func TestIdempotencyKeyIsScopedToTheClient(t *testing.T) {
+	ic := newIdempotencyCache(config.IdempotencyConfig{Enabled: true})
+	request := func(set func(r *http.Request) *http.Request) string {
+		r := httptest.NewRequest(http.MethodPost, "/orders", nil)
+		if set != nil {
+			r = set(r)
+		}
+		return ic.cacheKey(r, "k1")
+	}
+	withValue := func(key, value any) func(r *http.Request) *http.Request {
+		return func(r *http.Request) *http.Request {
+			return r.WithContext(context.WithValue(r.Context(), key, value))
+		}
+	}
+	withCert := func(raw string) func(r *http.Request) *http.Request {
+		return func(r *http.Request) *http.Request {
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: []byte(raw)}}}
+			return r
+		}
+	}
+
+	seen := map[string]string{request(nil): "anonymous"}
+	for name, set := range map[string]func(r *http.Request) *http.Request{
+		"api key a":     withValue(apiKeyIDKey{}, "a"),
+		"api key b":     withValue(apiKeyIDKey{}, "b"),
+		"jwt subject a": withValue(jwtSubjectKey{}, "a"),
+		"jwt subject b": withValue(jwtSubjectKey{}, "b"),
+		"certificate a": withCert("a"),
+		"certificate b": withCert("b"),
+	} {
+		key := request(set)
+		if other, ok := seen[key]; ok {
+			t.Errorf("%s shares its cache key with %s", name, other)
+		}
+		seen[key] = name
+	}
+
+	if request(withValue(apiKeyIDKey{}, "a")) != request(withValue(apiKeyIDKey{}, "a")) {
+		t.Error("the same client got different cache keys")
+	}
+}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// ipRange is an inclusive range of addresses of a single family
+type ipRange struct {
+	start, end netip.Addr
+}
+
+// ipSet answers membership queries for a set of CIDR prefixes using
+// sorted, merged ranges and binary search
+type ipSet struct {
+	ranges []ipRange
+}
+
+// parsePrefix accepts a CIDR prefix or a bare address
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// newIPSet builds a set from CIDR prefixes or bare addresses
+func newIPSet(entries []string) (*ipSet, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, e := range entries {
+		p, err := parsePrefix(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or CIDR %q: %w", e, err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return newIPSetFromPrefixes(prefixes), nil
+}
+
+func newIPSetFromPrefixes(prefixes []netip.Prefix) *ipSet {
+	ranges := make([]ipRange, 0, len(prefixes))
+	for _, p := range prefixes {
+		addr := p.Addr().Unmap()
+		bits := p.Bits()
+		if p.Addr().Is4In6() {
+			bits -= 96
+		}
+		p = netip.PrefixFrom(addr, bits).Masked()
+		ranges = append(ranges, ipRange{start: p.Addr(), end: lastAddr(p)})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start.Less(ranges[j].start)
+	})
+
+	// Merge overlapping and adjacent ranges of the same family
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			sameFamily := last.end.BitLen() == r.start.BitLen()
+			if sameFamily && (!last.end.Less(r.start) || last.end.Next() == r.start) {
+				if last.end.Less(r.end) {
+					last.end = r.end
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+
+	return &ipSet{ranges: merged}
+}
+
+// lastAddr returns the highest address in p
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - uint(i%8))
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// Contains reports whether addr is in the set
+func (s *ipSet) Contains(addr netip.Addr) bool {
+	if s == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+
+	// Find the last range starting at or before addr
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return addr.Less(s.ranges[i].start)
+	}) - 1
+	if i < 0 {
+		return false
+	}
+	r := s.ranges[i]
+	return r.start.BitLen() == addr.BitLen() && !r.end.Less(addr)
+}
+
+// Len returns the number of merged ranges
+func (s *ipSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.ranges)
+}
+
+// clientAddr returns the address of the client that sent r, as resolved
+// from forwarded headers when the request came through a trusted proxy
+func clientAddr(r *http.Request) netip.Addr {
+	if addr, ok := r.Context().Value(clientAddrKey{}).(netip.Addr); ok {
+		return addr
+	}
+	return peerAddr(r)
+}
+
+// peerAddr returns the address of the connection's remote end
+func peerAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
@@ -0,0 +1,496 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // hash implementations for signature verification
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+const (
+	// maxJWKSSize bounds the size of a downloaded key set
+	maxJWKSSize = 1 << 20
+	// minJWKSRefresh rate-limits refreshes triggered by unknown key IDs
+	minJWKSRefresh = 30 * time.Second
+)
+
+// jwtAlgorithm describes how one JWS "alg" value is verified
+type jwtAlgorithm struct {
+	hash   crypto.Hash
+	verify func(key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) bool
+}
+
+// jwtAlgorithms lists the accepted asymmetric algorithms; "none" and the
+// HMAC family are never accepted
+var jwtAlgorithms = map[string]jwtAlgorithm{
+	"RS256": {crypto.SHA256, verifyPKCS1}, "RS384": {crypto.SHA384, verifyPKCS1}, "RS512": {crypto.SHA512, verifyPKCS1},
+	"PS256": {crypto.SHA256, verifyPSS}, "PS384": {crypto.SHA384, verifyPSS}, "PS512": {crypto.SHA512, verifyPSS},
+	"ES256": {crypto.SHA256, verifyECDSA}, "ES384": {crypto.SHA384, verifyECDSA}, "ES512": {crypto.SHA512, verifyECDSA},
+	"EdDSA": {0, verifyEd25519},
+}
+
+// jwtAuthenticator rejects requests without a valid bearer token and passes
+// selected claims of valid ones to backends as headers
+type jwtAuthenticator struct {
+	config config.JWTConfig
+	client *http.Client
+	logger *slog.Logger
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	lastRefresh time.Time
+	refreshMu   sync.Mutex // serializes downloads
+
+	stop chan struct{}
+}
+
+// newJWTAuthenticator returns nil when JWT authentication is disabled
+func newJWTAuthenticator(cfg config.JWTConfig, logger *slog.Logger) *jwtAuthenticator {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &jwtAuthenticator{
+		config: cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		logger: logger,
+		stop:   make(chan struct{}),
+	}
+}
+
+func (ja *jwtAuthenticator) Start() {
+	go func() {
+		refresh := func() {
+			if err := ja.refresh(); err != nil {
+				ja.logger.Error("Failed to refresh JWKS", "url", ja.config.JWKSURL, "error", err)
+			}
+		}
+		refresh()
+
+		ticker := time.NewTicker(ja.config.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				refresh()
+			case <-ja.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (ja *jwtAuthenticator) Stop() {
+	close(ja.stop)
+}
+
+func (ja *jwtAuthenticator) middleware(next http.Handler) http.Handler {
+	if ja == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if excludedPath(r, ja.config.ExcludePaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Claim headers only ever come from a verified token
+		for _, header := range ja.config.ClaimsToHeaders {
+			r.Header.Del(header)
+		}
+
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := ja.verify(strings.TrimSpace(token))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description=`+strconv.Quote(err.Error()))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		for claim, header := range ja.config.ClaimsToHeaders {
+			if v, ok := claimString(claims[claim]); ok {
+				r.Header.Set(header, v)
+			}
+		}
+		if sub, ok := claimString(claims["sub"]); ok {
+			r = r.WithContext(context.WithValue(r.Context(), jwtSubjectKey{}, sub))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type jwtSubjectKey struct{}
+
+// jwtSubject returns the subject of the token r was authenticated with, if
+// any
+func jwtSubject(r *http.Request) (string, bool) {
+	sub, ok := r.Context().Value(jwtSubjectKey{}).(string)
+	return sub, ok
+}
+
+// verify checks a compact JWS token's signature and registered claims and
+// returns its claims
+func (ja *jwtAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	alg, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	key := ja.key(header.Kid)
+	if key == nil {
+		return nil, errors.New("unknown signing key")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	digest := signed
+	if alg.hash != 0 {
+		h := alg.hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+	if !alg.verify(key, alg.hash, digest, sig) {
+		return nil, errors.New("invalid signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed claims")
+	}
+	if err := ja.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (ja *jwtAuthenticator) checkClaims(claims map[string]interface{}) error {
+	now := time.Now()
+
+	exp, ok := claims["exp"].(json.Number)
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if t, err := numericDate(exp); err != nil || !now.Before(t.Add(ja.config.Leeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(json.Number); ok {
+		if t, err := numericDate(nbf); err != nil || now.Add(ja.config.Leeway).Before(t) {
+			return errors.New("token not yet valid")
+		}
+	}
+
+	if ja.config.Issuer != "" && claims["iss"] != ja.config.Issuer {
+		return errors.New("unexpected issuer")
+	}
+	if ja.config.Audience != "" && !audienceContains(claims["aud"], ja.config.Audience) {
+		return errors.New("unexpected audience")
+	}
+	return nil
+}
+
+// key returns the public key for kid, refreshing the key set once if the
+// ID is unknown so rotated keys are picked up before the next scheduled
+// refresh. Tokens without a kid are accepted when the set holds one key.
+func (ja *jwtAuthenticator) key(kid string) crypto.PublicKey {
+	lookup := func() (crypto.PublicKey, time.Time) {
+		ja.mu.RLock()
+		defer ja.mu.RUnlock()
+		if kid == "" && len(ja.keys) == 1 {
+			for _, k := range ja.keys {
+				return k, ja.lastRefresh
+			}
+		}
+		return ja.keys[kid], ja.lastRefresh
+	}
+
+	key, last := lookup()
+	if key != nil || time.Since(last) < minJWKSRefresh {
+		return key
+	}
+	if err := ja.refresh(); err != nil {
+		ja.logger.Error("Failed to refresh JWKS", "url", ja.config.JWKSURL, "error", err)
+	}
+	key, _ = lookup()
+	return key
+}
+
+// refresh downloads the key set, keeping the previous keys on failure
+func (ja *jwtAuthenticator) refresh() error {
+	ja.refreshMu.Lock()
+	defer ja.refreshMu.Unlock()
+
+	// Another request may have refreshed while this one waited
+	ja.mu.RLock()
+	recent := time.Since(ja.lastRefresh) < time.Second
+	ja.mu.RUnlock()
+	if recent {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ja.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ja.config.JWKSURL, nil)
+	if err != nil {
+		return err
+	}
+
+	// Record the attempt so failing downloads are not retried per request
+	ja.mu.Lock()
+	ja.lastRefresh = time.Now()
+	ja.mu.Unlock()
+
+	resp, err := ja.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	keys, skipped, err := parseJWKS(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.New("no usable signing keys")
+	}
+
+	ja.mu.Lock()
+	ja.keys = keys
+	ja.mu.Unlock()
+
+	ja.logger.Info("Loaded JWKS", "url", ja.config.JWKSURL, "keys", len(keys), "skipped", skipped)
+	return nil
+}
+
+// parseJWKS reads the RSA, EC and Ed25519 signing keys of a JSON Web Key Set
+func parseJWKS(r io.Reader) (map[string]crypto.PublicKey, int, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(r).Decode(&set); err != nil {
+		return nil, 0, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	skipped := 0
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			skipped++
+			continue
+		}
+
+		var key crypto.PublicKey
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = rsaKey(k.N, k.E)
+		case "EC":
+			key, err = ecKey(k.Crv, k.X, k.Y)
+		case "OKP":
+			key, err = ed25519Key(k.Crv, k.X)
+		default:
+			err = fmt.Errorf("unsupported key type %q", k.Kty)
+		}
+		if err != nil {
+			skipped++
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, skipped, nil
+}
+
+func rsaKey(n, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil || len(eb) == 0 || len(eb) > 4 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+	exponent := 0
+	for _, b := range eb {
+		exponent = exponent<<8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: exponent}, nil
+}
+
+func ecKey(crv, x, y string) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	var check ecdh.Curve
+	switch crv {
+	case "P-256":
+		curve, check = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, check = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, check = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	xb, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil || len(xb) != size {
+		return nil, errors.New("invalid EC x coordinate")
+	}
+	yb, err := base64.RawURLEncoding.DecodeString(y)
+	if err != nil || len(yb) != size {
+		return nil, errors.New("invalid EC y coordinate")
+	}
+	// Reject points that are not on the curve
+	point := append(append([]byte{4}, xb...), yb...)
+	if _, err := check.NewPublicKey(point); err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}, nil
+}
+
+func ed25519Key(crv, x string) (ed25519.PublicKey, error) {
+	if crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+	xb, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil || len(xb) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid Ed25519 key")
+	}
+	return ed25519.PublicKey(xb), nil
+}
+
+func verifyPKCS1(key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) bool {
+	k, ok := key.(*rsa.PublicKey)
+	return ok && rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil
+}
+
+func verifyPSS(key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) bool {
+	k, ok := key.(*rsa.PublicKey)
+	return ok && rsa.VerifyPSS(k, hash, digest, sig, nil) == nil
+}
+
+// verifyECDSA checks a JWS ECDSA signature, which is r and s concatenated
+func verifyECDSA(key crypto.PublicKey, _ crypto.Hash, digest, sig []byte) bool {
+	k, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return false
+	}
+	size := (k.Curve.Params().BitSize + 7) / 8
+	if len(sig) != 2*size {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:size])
+	s := new(big.Int).SetBytes(sig[size:])
+	return ecdsa.Verify(k, digest, r, s)
+}
+
+func verifyEd25519(key crypto.PublicKey, _ crypto.Hash, message, sig []byte) bool {
+	k, ok := key.(ed25519.PublicKey)
+	return ok && ed25519.Verify(k, message, sig)
+}
+
+// decodeSegment decodes a base64url JSON token segment, keeping numbers exact
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func numericDate(n json.Number) (time.Time, error) {
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(f), 0), nil
+}
+
+// audienceContains reports whether an aud claim, a string or an array of
+// strings, includes audience
+func audienceContains(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimString renders a claim as a header value; arrays are comma-joined
+func claimString(v interface{}) (string, bool) {
+	switch c := v.(type) {
+	case string:
+		return c, true
+	case json.Number:
+		return c.String(), true
+	case bool:
+		return strconv.FormatBool(c), true
+	case []interface{}:
+		parts := make([]string, 0, len(c))
+		for _, item := range c {
+			if s, ok := claimString(item); ok {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, ","), true
+	}
+	return "", false
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+func TestJWTExcludePaths(t *testing.T) {
+	ja := newJWTAuthenticator(config.JWTConfig{
+		Enabled:      true,
+		JWKSURL:      "http://127.0.0.1:1/jwks.json",
+		ExcludePaths: []string{"/health", "/public/"},
+	}, slog.Default())
+	handler := ja.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for path, want := range map[string]int{
+		"/health":            http.StatusOK,
+		"/health/live":       http.StatusOK,
+		"/public/":           http.StatusOK,
+		"/public/logo.png":   http.StatusOK,
+		"/healthz":           http.StatusUnauthorized,
+		"/public":            http.StatusUnauthorized,
+		"/publicity":         http.StatusUnauthorized,
+		"/public/../admin":   http.StatusUnauthorized,
+		"/public/%2e%2e/api": http.StatusUnauthorized,
+		"/admin":             http.StatusUnauthorized,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("%s: status %d, want %d", path, w.Code, want)
+		}
+	}
+}
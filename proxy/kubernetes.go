@@ -0,0 +1,431 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+const (
+	// inClusterDir holds the credentials of the pod's service account
+	inClusterDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	// kubeRetryInterval is how long to wait after a failed list or watch
+	kubeRetryInterval = 5 * time.Second
+
+	// kubeWatchTimeout makes the API server end watches periodically, after
+	// which the endpoints are listed afresh
+	kubeWatchTimeout = 5 * time.Minute
+)
+
+// kubeClient reads EndpointSlices from the Kubernetes API
+type kubeClient struct {
+	server    string
+	client    *http.Client
+	token     string
+	tokenFile string // read per request because service account tokens rotate
+	namespace string // used for backends that don't name one
+}
+
+// newKubeClient connects with the kubeconfig when one is configured and
+// with the pod's service account otherwise
+func newKubeClient(cfg config.KubernetesConfig) (*kubeClient, error) {
+	if cfg.Kubeconfig == "" {
+		return newInClusterClient()
+	}
+	return newKubeconfigClient(cfg)
+}
+
+func newInClusterClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("kubernetes: not running in a cluster; set kubernetes.kubeconfig")
+	}
+	ca, err := os.ReadFile(filepath.Join(inClusterDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("kubernetes: no certificates in %s", filepath.Join(inClusterDir, "ca.crt"))
+	}
+	namespace, _ := os.ReadFile(filepath.Join(inClusterDir, "namespace"))
+
+	return &kubeClient{
+		server:    "https://" + net.JoinHostPort(host, port),
+		client:    newKubeHTTPClient(&tls.Config{RootCAs: roots}),
+		tokenFile: filepath.Join(inClusterDir, "token"),
+		namespace: strings.TrimSpace(string(namespace)),
+	}, nil
+}
+
+// kubeconfig is the subset of the kubeconfig format needed to reach the
+// API server with a token or a client certificate
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+func newKubeconfigClient(cfg config.KubernetesConfig) (*kubeClient, error) {
+	data, err := os.ReadFile(cfg.Kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: %w", err)
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("kubernetes: invalid kubeconfig: %w", err)
+	}
+
+	// Files named in a kubeconfig are relative to the kubeconfig itself
+	dir := filepath.Dir(cfg.Kubeconfig)
+	load := func(inline, file string) ([]byte, error) {
+		if inline != "" {
+			return base64.StdEncoding.DecodeString(inline)
+		}
+		if file == "" {
+			return nil, nil
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		return os.ReadFile(file)
+	}
+
+	name := cfg.Context
+	if name == "" {
+		name = kc.CurrentContext
+	}
+	c := &kubeClient{}
+	var clusterName, userName string
+	found := false
+	for _, ctx := range kc.Contexts {
+		if ctx.Name == name {
+			clusterName, userName, c.namespace = ctx.Context.Cluster, ctx.Context.User, ctx.Context.Namespace
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("kubernetes: context %q not found in kubeconfig", name)
+	}
+
+	tlsConfig := &tls.Config{}
+	found = false
+	for _, cl := range kc.Clusters {
+		if cl.Name != clusterName {
+			continue
+		}
+		found = true
+		c.server = strings.TrimSuffix(cl.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = cl.Cluster.InsecureSkipTLSVerify
+		ca, err := load(cl.Cluster.CertificateAuthorityData, cl.Cluster.CertificateAuthority)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: certificate authority: %w", err)
+		}
+		if ca != nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("kubernetes: no certificates in the certificate authority of cluster %q", clusterName)
+			}
+		}
+	}
+	if !found || c.server == "" {
+		return nil, fmt.Errorf("kubernetes: cluster %q not found in kubeconfig", clusterName)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		c.token = u.User.Token
+		c.tokenFile = u.User.TokenFile
+		if c.tokenFile != "" && !filepath.IsAbs(c.tokenFile) {
+			c.tokenFile = filepath.Join(dir, c.tokenFile)
+		}
+		certPEM, err := load(u.User.ClientCertificateData, u.User.ClientCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: client certificate: %w", err)
+		}
+		keyPEM, err := load(u.User.ClientKeyData, u.User.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: client key: %w", err)
+		}
+		if certPEM != nil || keyPEM != nil {
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return nil, fmt.Errorf("kubernetes: client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	c.client = newKubeHTTPClient(tlsConfig)
+	return c, nil
+}
+
+// newKubeHTTPClient has no overall timeout because watches stay open; a
+// server that doesn't answer at all still fails the request
+func newKubeHTTPClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		TLSClientConfig:       tlsConfig,
+		ResponseHeaderTimeout: 30 * time.Second,
+	}}
+}
+
+// get requests path from the API server and fails on any status but 200
+func (c *kubeClient) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	token := c.token
+	if c.tokenFile != "" {
+		data, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("kubernetes API returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// endpointSlice is the part of a discovery.k8s.io/v1 EndpointSlice that
+// decides which addresses receive traffic
+type endpointSlice struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"` // unknown readiness counts as ready
+		} `json:"conditions"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name string `json:"name"`
+		Port int    `json:"port"`
+	} `json:"ports"`
+}
+
+// port returns the number of the port with the given name, or of the first
+// port when name is empty
+func (s *endpointSlice) port(name string) int {
+	for _, p := range s.Ports {
+		if name == "" || p.Name == name {
+			return p.Port
+		}
+	}
+	return 0
+}
+
+func endpointSlicesPath(namespace string) string {
+	return "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(namespace) + "/endpointslices"
+}
+
+// listEndpointSlices returns the EndpointSlices of a Service by name and
+// the resource version to watch from
+func (c *kubeClient) listEndpointSlices(ctx context.Context, namespace, service string) (map[string]endpointSlice, string, error) {
+	query := url.Values{"labelSelector": {"kubernetes.io/service-name=" + service}}
+	resp, err := c.get(ctx, endpointSlicesPath(namespace), query)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []endpointSlice `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, "", fmt.Errorf("invalid EndpointSlice list: %w", err)
+	}
+	slices := make(map[string]endpointSlice, len(list.Items))
+	for _, s := range list.Items {
+		slices[s.Metadata.Name] = s
+	}
+	return slices, list.Metadata.ResourceVersion, nil
+}
+
+// watchEndpointSlices streams changes to the EndpointSlices of a Service
+// after version to apply until the server ends the watch
+func (c *kubeClient) watchEndpointSlices(ctx context.Context, namespace, service, version string, apply func(event string, s endpointSlice)) error {
+	query := url.Values{
+		"labelSelector":       {"kubernetes.io/service-name=" + service},
+		"watch":               {"1"},
+		"resourceVersion":     {version},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {strconv.Itoa(int(kubeWatchTimeout.Seconds()))},
+	}
+	resp, err := c.get(ctx, endpointSlicesPath(namespace), query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&event); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			var s endpointSlice
+			if err := json.Unmarshal(event.Object, &s); err != nil {
+				return fmt.Errorf("invalid EndpointSlice: %w", err)
+			}
+			apply(event.Type, s)
+		case "ERROR":
+			// Usually an expired resource version; listing again recovers
+			return fmt.Errorf("watch failed: %s", event.Object)
+		}
+	}
+}
+
+// namespace is the namespace of the source's Service
+func (d *backendDiscovery) namespace(src *resolvedBackend) string {
+	if src.config.Namespace != "" {
+		return src.config.Namespace
+	}
+	if d.kube.namespace != "" {
+		return d.kube.namespace
+	}
+	return "default"
+}
+
+// endpointTargets returns a backend for every ready address of slices
+func endpointTargets(src *resolvedBackend, slices map[string]endpointSlice) map[string]config.Backend {
+	wanted := make(map[string]config.Backend)
+	for _, s := range slices {
+		port := s.port(src.config.Port)
+		if port == 0 {
+			continue
+		}
+		for _, ep := range s.Endpoints {
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			for _, addr := range ep.Addresses {
+				hostPort := net.JoinHostPort(addr, strconv.Itoa(port))
+				wanted[hostPort] = src.target(addr, strconv.Itoa(port))
+			}
+		}
+	}
+	return wanted
+}
+
+// listKubernetes makes the ready endpoints of the source's Service its
+// targets and returns the slices with the version to watch from
+func (d *backendDiscovery) listKubernetes(ctx context.Context, dp *discoveredPool, src *resolvedBackend) (map[string]endpointSlice, string, error) {
+	slices, version, err := d.kube.listEndpointSlices(ctx, d.namespace(src), src.config.Service)
+	if err != nil {
+		return nil, "", err
+	}
+	d.update(dp, src, endpointTargets(src, slices))
+	return slices, version, nil
+}
+
+// watchKubernetes keeps the source's targets in step with the Service's
+// endpoints until discovery stops, listing them afresh whenever a watch
+// ends
+func (d *backendDiscovery) watchKubernetes(dp *discoveredPool, src *resolvedBackend) {
+	for {
+		err := d.syncKubernetes(dp, src)
+		if d.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			continue
+		}
+
+		d.rp.logger.Warn("Failed to watch kubernetes endpoints", "service", src.config.Service, "error", err)
+		timer := time.NewTimer(kubeRetryInterval)
+		select {
+		case <-timer.C:
+		case <-d.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (d *backendDiscovery) syncKubernetes(dp *discoveredPool, src *resolvedBackend) error {
+	slices, version, err := d.listKubernetes(d.ctx, dp, src)
+	if err != nil {
+		return err
+	}
+	return d.kube.watchEndpointSlices(d.ctx, d.namespace(src), src.config.Service, version, func(event string, s endpointSlice) {
+		if event == "DELETED" {
+			delete(slices, s.Metadata.Name)
+		} else {
+			slices[s.Metadata.Name] = s
+		}
+		d.update(dp, src, endpointTargets(src, slices))
+	})
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// latencyEWMA is a backend's exponentially weighted moving average response
+// time. Older samples lose influence with time rather than with the number
+// of samples, and a slower sample than the average replaces it outright so
+// a backend that slows down is avoided right away.
+type latencyEWMA struct {
+	mu    sync.Mutex
+	value float64 // nanoseconds
+	stamp time.Time
+}
+
+// observe records a response time
+func (e *latencyEWMA) observe(rtt time.Duration, decay time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	sample := float64(rtt)
+	switch {
+	case e.stamp.IsZero(), sample > e.value:
+		e.value = sample
+	default:
+		w := math.Exp(-float64(now.Sub(e.stamp)) / float64(decay))
+		e.value = e.value*w + sample*(1-w)
+	}
+	e.stamp = now
+}
+
+// get returns the average, decayed towards zero for the time since the last
+// sample so that a backend avoided after a slow period is retried
+// eventually. It is zero until the first sample.
+func (e *latencyEWMA) get(decay time.Duration) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stamp.IsZero() {
+		return 0
+	}
+	return e.value * math.Exp(-float64(time.Since(e.stamp))/float64(decay))
+}
+
+// Least Response Time Load Balancer: picks the backend with the lowest
+// moving average response time multiplied by its outstanding requests plus
+// one, so fast backends get more traffic until their queue makes up for
+// their speed
+type LeastResponseTimeBalancer struct {
+	backends []*Backend
+	decay    time.Duration
+	next     uint32 // rotates the starting point so ties are spread
+}
+
+func NewLeastResponseTimeBalancer(cfg config.LeastResponseTimeConfig, backends []*Backend) *LeastResponseTimeBalancer {
+	return &LeastResponseTimeBalancer{
+		backends: backends,
+		decay:    cfg.DecayTime,
+	}
+}
+
+func (lb *LeastResponseTimeBalancer) NextBackend(r *http.Request) *Backend {
+	n := len(lb.backends)
+	if n == 0 {
+		return nil
+	}
+
+	// Backends without a measurement yet are scored as fast as the fastest
+	// measured one, so they are tried soon without receiving a burst
+	latencies := make([]float64, n)
+	fastest := 0.0
+	for i, b := range lb.backends {
+		latencies[i] = b.latency.get(lb.decay)
+		if latencies[i] > 0 && (fastest == 0 || latencies[i] < fastest) {
+			fastest = latencies[i]
+		}
+	}
+	if fastest == 0 {
+		fastest = 1
+	}
+
+	var selected *Backend
+	minCost := 0.0
+	start := int(atomic.AddUint32(&lb.next, 1) % uint32(n))
+	for i := 0; i < n; i++ {
+		idx := (start + i) % n
+		backend := lb.backends[idx]
+		if !backend.IsAvailable() {
+			continue
+		}
+		latency := latencies[idx]
+		if latency == 0 {
+			latency = fastest
+		}
+		cost := latency * float64(backend.GetConnections()+1)
+		if selected == nil || cost < minCost {
+			selected, minCost = backend, cost
+		}
+	}
+	return selected
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// concurrencyLimiter caps the number of requests in flight. Requests beyond
+// the cap wait in a bounded queue for a free slot; when the queue is full or
+// the wait times out they are shed with 503 instead of piling up.
+type concurrencyLimiter struct {
+	slots        chan struct{}
+	queued       int64
+	maxQueue     int64
+	queueTimeout time.Duration
+	shed         uint64
+}
+
+// newConcurrencyLimiter returns nil when no limit is configured
+func newConcurrencyLimiter(cfg config.LimitsConfig) *concurrencyLimiter {
+	if cfg.MaxConnections <= 0 {
+		return nil
+	}
+	return &concurrencyLimiter{
+		slots:        make(chan struct{}, cfg.MaxConnections),
+		maxQueue:     int64(cfg.MaxQueue),
+		queueTimeout: cfg.QueueTimeout,
+	}
+}
+
+// acquire takes a slot, waiting in the queue if there is room; it reports
+// whether the request may proceed
+func (cl *concurrencyLimiter) acquire(r *http.Request) bool {
+	select {
+	case cl.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if atomic.AddInt64(&cl.queued, 1) > cl.maxQueue {
+		atomic.AddInt64(&cl.queued, -1)
+		return false
+	}
+	defer atomic.AddInt64(&cl.queued, -1)
+
+	timer := time.NewTimer(cl.queueTimeout)
+	defer timer.Stop()
+	select {
+	case cl.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (cl *concurrencyLimiter) release() {
+	<-cl.slots
+}
+
+func (cl *concurrencyLimiter) middleware(next http.Handler) http.Handler {
+	if cl == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cl.acquire(r) {
+			// Log the first shed request and then every thousandth
+			if n := atomic.AddUint64(&cl.shed, 1); n%1000 == 1 {
+				requestLogger(r).Warn("Shedding load", "in_flight", len(cl.slots),
+					"queued", atomic.LoadInt64(&cl.queued), "rejected", n)
+			}
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Service overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		defer cl.release()
+
+		next.ServeHTTP(w, r)
+	})
+}
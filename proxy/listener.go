@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// extraListener serves one of server.listeners next to the main server
+type extraListener struct {
+	config config.ListenerConfig
+	server *http.Server
+}
+
+func newExtraListener(cfg config.ListenerConfig, handler http.Handler, server config.ServerConfig) *extraListener {
+	return &extraListener{
+		config: cfg,
+		server: &http.Server{
+			Addr:         cfg.Address,
+			Handler:      handler,
+			ReadTimeout:  server.ReadTimeout,
+			WriteTimeout: server.WriteTimeout,
+			IdleTimeout:  server.IdleTimeout,
+		},
+	}
+}
+
+func (l *extraListener) start(rp *ReverseProxy) error {
+	ln, err := rp.listen(l.config.Address, l.config.ProxyProtocol)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", l.config.Address, err)
+	}
+
+	serve := l.server.Serve
+	if l.config.TLS {
+		l.server.TLSConfig = rp.certificates.tlsConfig()
+		serve = func(ln net.Listener) error { return l.server.ServeTLS(ln, "", "") }
+	}
+	go func() {
+		rp.logger.Info("Starting listener", "address", l.config.Address, "tls", l.config.TLS)
+		if err := serve(ln); err != nil && err != http.ErrServerClosed {
+			rp.logger.Error("Listener failed", "address", l.config.Address, "error", err)
+		}
+	}()
+	return nil
+}
+
+// httpsRedirect sends clients to the same URL over HTTPS on the port of
+// httpsAddr
+func httpsRedirect(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "Bad Request: missing Host header", http.StatusBadRequest)
+			return
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -1,12 +1,35 @@
 package proxy
 
 import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
 	"sync"
 	"sync/atomic"
+
+	"github.com/bunnydevv/reverse-proxy/config"
 )
 
 type LoadBalancer interface {
-	NextBackend() *Backend
+	NextBackend(r *http.Request) *Backend
+}
+
+// newLoadBalancer creates the balancer implementing the configured algorithm over backends
+func newLoadBalancer(cfg config.LoadBalancerConfig, backends []*Backend) LoadBalancer {
+	switch cfg.Algorithm {
+	case "least-connections":
+		return NewLeastConnectionsBalancer(backends)
+	case "weighted":
+		return NewWeightedBalancer(backends)
+	case "ip-hash":
+		return NewIPHashBalancer(backends)
+	case "consistent-hash":
+		return NewConsistentHashBalancer(cfg.ConsistentHash, backends)
+	case "least-response-time":
+		return NewLeastResponseTimeBalancer(cfg.LeastResponseTime, backends)
+	default:
+		return NewRoundRobinBalancer(backends)
+	}
 }
 
 // Round Robin Load Balancer
@@ -22,7 +45,7 @@ func NewRoundRobinBalancer(backends []*Backend) *RoundRobinBalancer {
 	}
 }
 
-func (rb *RoundRobinBalancer) NextBackend() *Backend {
+func (rb *RoundRobinBalancer) NextBackend(r *http.Request) *Backend {
 	n := len(rb.backends)
 	if n == 0 {
 		return nil
@@ -31,7 +54,7 @@ func (rb *RoundRobinBalancer) NextBackend() *Backend {
 	for i := 0; i < n; i++ {
 		idx := atomic.AddUint32(&rb.current, 1) % uint32(n)
 		backend := rb.backends[idx]
-		if backend.IsAlive() {
+		if backend.IsAvailable() {
 			return backend
 		}
 	}
@@ -39,10 +62,12 @@ func (rb *RoundRobinBalancer) NextBackend() *Backend {
 	return nil
 }
 
-// Least Connections Load Balancer
+// Least Connections Load Balancer. Selection only reads the backends'
+// atomic counters, so concurrent requests never wait on each other. The scan
+// starts at a random backend so that ties, such as every backend being idle,
+// are spread across the pool instead of all going to the first.
 type LeastConnectionsBalancer struct {
 	backends []*Backend
-	mu       sync.RWMutex
 }
 
 func NewLeastConnectionsBalancer(backends []*Backend) *LeastConnectionsBalancer {
@@ -51,15 +76,19 @@ func NewLeastConnectionsBalancer(backends []*Backend) *LeastConnectionsBalancer
 	}
 }
 
-func (lb *LeastConnectionsBalancer) NextBackend() *Backend {
-	lb.mu.RLock()
-	defer lb.mu.RUnlock()
+func (lb *LeastConnectionsBalancer) NextBackend(r *http.Request) *Backend {
+	n := len(lb.backends)
+	if n == 0 {
+		return nil
+	}
 
 	var selected *Backend
 	minConnections := -1
 
-	for _, backend := range lb.backends {
-		if !backend.IsAlive() {
+	start := rand.Intn(n)
+	for i := 0; i < n; i++ {
+		backend := lb.backends[(start+i)%n]
+		if !backend.IsAvailable() {
 			continue
 		}
 
@@ -73,39 +102,81 @@ func (lb *LeastConnectionsBalancer) NextBackend() *Backend {
 	return selected
 }
 
-// Weighted Load Balancer
+// Weighted Load Balancer using nginx's smooth weighted round-robin: every
+// pick raises each available backend's current weight by its weight, selects
+// the highest and lowers it by the total. Heavier backends are chosen more
+// often without being chosen in bursts.
 type WeightedBalancer struct {
 	backends []*Backend
-	current  uint32
+	current  []int // current weight of each backend, guarded by mu
+	mu       sync.Mutex
 }
 
 func NewWeightedBalancer(backends []*Backend) *WeightedBalancer {
 	return &WeightedBalancer{
 		backends: backends,
-		current:  0,
+		current:  make([]int, len(backends)),
 	}
 }
 
-func (wb *WeightedBalancer) NextBackend() *Backend {
-	var expandedBackends []*Backend
-	for _, backend := range wb.backends {
-		for i := 0; i < backend.Weight; i++ {
-			expandedBackends = append(expandedBackends, backend)
+func (wb *WeightedBalancer) NextBackend(r *http.Request) *Backend {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	selected, total := -1, 0
+	for i, backend := range wb.backends {
+		if !backend.IsAvailable() {
+			continue
+		}
+		weight := backend.GetWeight()
+		wb.current[i] += weight
+		total += weight
+		if selected == -1 || wb.current[i] > wb.current[selected] {
+			selected = i
 		}
 	}
 
-	n := len(expandedBackends)
+	if selected == -1 {
+		return nil
+	}
+	wb.current[selected] -= total
+	return wb.backends[selected]
+}
+
+// IP Hash Load Balancer
+type IPHashBalancer struct {
+	backends []*Backend
+}
+
+func NewIPHashBalancer(backends []*Backend) *IPHashBalancer {
+	return &IPHashBalancer{
+		backends: backends,
+	}
+}
+
+// NextBackend hashes the client address so a client keeps landing on the
+// same backend. If that backend is unavailable the next available one in
+// order is used, leaving the other clients' assignments unchanged.
+func (ib *IPHashBalancer) NextBackend(r *http.Request) *Backend {
+	n := len(ib.backends)
 	if n == 0 {
 		return nil
 	}
 
-	for i := 0; i < n; i++ {
-		idx := atomic.AddUint32(&wb.current, 1) % uint32(n)
-		backend := expandedBackends[idx]
-		if backend.IsAlive() {
+	h := fnv.New32a()
+	if addr := clientAddr(r); addr.IsValid() {
+		h.Write(addr.AsSlice())
+	} else {
+		h.Write([]byte(r.RemoteAddr))
+	}
+	start := h.Sum32() % uint32(n)
+
+	for i := uint32(0); i < uint32(n); i++ {
+		backend := ib.backends[(start+i)%uint32(n)]
+		if backend.IsAvailable() {
 			return backend
 		}
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLeastConnectionsBalancer(t *testing.T) {
+	a := newTestBackend(t, "http://a:8080", 1)
+	b := newTestBackend(t, "http://b:8080", 1)
+	c := newTestBackend(t, "http://c:8080", 1)
+	lb := NewLeastConnectionsBalancer([]*Backend{a, b, c})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	a.connections.Store(3)
+	b.connections.Store(1)
+	c.connections.Store(2)
+	if got := lb.NextBackend(r); got != b {
+		t.Fatalf("got %s, want the backend with the fewest connections", got.URL)
+	}
+
+	b.SetAlive(false)
+	if got := lb.NextBackend(r); got != c {
+		t.Errorf("got %s, want the least loaded available backend", got.URL)
+	}
+
+	a.SetAlive(false)
+	c.SetAlive(false)
+	if got := lb.NextBackend(r); got != nil {
+		t.Errorf("got %s with every backend down, want none", got.URL)
+	}
+}
+
+func TestLeastConnectionsBalancerSpreadsTies(t *testing.T) {
+	backends := []*Backend{
+		newTestBackend(t, "http://a:8080", 1),
+		newTestBackend(t, "http://b:8080", 1),
+		newTestBackend(t, "http://c:8080", 1),
+	}
+	lb := NewLeastConnectionsBalancer(backends)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	picked := make(map[*Backend]int)
+	for i := 0; i < 300; i++ {
+		picked[lb.NextBackend(r)]++
+	}
+	for _, b := range backends {
+		if picked[b] == 0 {
+			t.Errorf("idle backend %s never picked", b.URL)
+		}
+	}
+}
+
+func TestLeastConnectionsBalancerUnderConcurrency(t *testing.T) {
+	backends := make([]*Backend, 4)
+	for i := range backends {
+		backends[i] = newTestBackend(t, fmt.Sprintf("http://b%d:8080", i), 1)
+		backends[i].maxConns = 8
+	}
+	lb := NewLeastConnectionsBalancer(backends)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for j := 0; j < 1000; j++ {
+				if b := lb.NextBackend(r); b != nil && b.acquire() {
+					b.connections.Add(-1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, b := range backends {
+		if n := b.GetConnections(); n != 0 {
+			t.Errorf("backend %s has %d connections after every request finished", b.URL, n)
+		}
+	}
+}
+
+func TestWeightedBalancerIsSmooth(t *testing.T) {
+	a := newTestBackend(t, "http://a:8080", 5)
+	b := newTestBackend(t, "http://b:8080", 1)
+	c := newTestBackend(t, "http://c:8080", 1)
+	lb := NewWeightedBalancer([]*Backend{a, b, c})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	name := map[*Backend]string{a: "a", b: "b", c: "c"}
+	var picks []string
+	for i := 0; i < 14; i++ {
+		picks = append(picks, name[lb.NextBackend(r)])
+	}
+	// The sequence nginx documents for weights 5, 1 and 1, twice
+	if got, want := strings.Join(picks, ""), "aabacaaaabacaa"; got != want {
+		t.Errorf("picks %s, want %s", got, want)
+	}
+}
+
+func TestWeightedBalancerSkipsUnavailable(t *testing.T) {
+	a := newTestBackend(t, "http://a:8080", 3)
+	b := newTestBackend(t, "http://b:8080", 1)
+	lb := NewWeightedBalancer([]*Backend{a, b})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	a.SetWeight(0)
+	for i := 0; i < 4; i++ {
+		if got := lb.NextBackend(r); got != b {
+			t.Fatalf("drained backend picked: %s", got.URL)
+		}
+	}
+	b.SetAlive(false)
+	if got := lb.NextBackend(r); got != nil {
+		t.Errorf("got %s with no backend available, want none", got.URL)
+	}
+}
+
+func TestWeightedBalancerDoesNotAllocate(t *testing.T) {
+	lb := NewWeightedBalancer([]*Backend{
+		newTestBackend(t, "http://a:8080", 5),
+		newTestBackend(t, "http://b:8080", 2),
+		newTestBackend(t, "http://c:8080", 1),
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if allocs := testing.AllocsPerRun(1000, func() { lb.NextBackend(r) }); allocs != 0 {
+		t.Errorf("%v allocations per pick, want 0", allocs)
+	}
+}
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// rotationTimeFormat stamps rotated log files; it sorts chronologically
+const rotationTimeFormat = "20060102T150405.000"
+
+// NewLogOutput opens the configured log destination. Closing it is a no-op
+// for the standard streams.
+func NewLogOutput(cfg config.LoggingConfig) (io.WriteCloser, error) {
+	switch cfg.Output {
+	case config.LogOutputStdout:
+		return nopWriteCloser{os.Stdout}, nil
+	case config.LogOutputStderr:
+		return nopWriteCloser{os.Stderr}, nil
+	case config.LogOutputSyslog:
+		s, err := newSyslogOutput(cfg.Syslog)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		rf, err := openRotatingFile(cfg.Output, cfg.Rotation)
+		if err != nil {
+			return nil, err
+		}
+		return rf, nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// rotatingFile appends to a log file, moving it aside once it grows past
+// the maximum size or age. Rotated files are optionally compressed in the
+// background and pruned to the configured number of backups.
+type rotatingFile struct {
+	path   string
+	config config.LogRotationConfig
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	compressing sync.WaitGroup
+}
+
+func openRotatingFile(path string, cfg config.LogRotationConfig) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, config: cfg}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	rf.file = f
+	rf.size = info.Size()
+	rf.opened = time.Now()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.due(int64(len(p))) {
+		if err := rf.rotate(); err != nil {
+			// Keep logging to the current file rather than losing lines
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file %s: %v\n", rf.path, err)
+		}
+	}
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// due reports whether the file must be rotated before writing n bytes
+func (rf *rotatingFile) due(n int64) bool {
+	if rf.size == 0 {
+		return false
+	}
+	if rf.config.MaxSize > 0 && rf.size+n > rf.config.MaxSize {
+		return true
+	}
+	return rf.config.MaxAge > 0 && time.Since(rf.opened) >= rf.config.MaxAge
+}
+
+// rotate renames the current file and starts a new one
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+
+	ext := filepath.Ext(rf.path)
+	rotated := strings.TrimSuffix(rf.path, ext) + "-" + time.Now().Format(rotationTimeFormat) + ext
+	if err := os.Rename(rf.path, rotated); err != nil {
+		return err
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+
+	rf.compressing.Add(1)
+	go func() {
+		defer rf.compressing.Done()
+		if rf.config.Compress {
+			if err := compressFile(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to compress rotated log file %s: %v\n", rotated, err)
+			}
+		}
+		rf.prune()
+	}()
+	return nil
+}
+
+// prune removes the oldest rotated files beyond the number of backups kept
+func (rf *rotatingFile) prune() {
+	if rf.config.MaxBackups == 0 {
+		return
+	}
+	ext := filepath.Ext(rf.path)
+	matches, err := filepath.Glob(strings.TrimSuffix(rf.path, ext) + "-*" + ext + "*")
+	if err != nil {
+		return
+	}
+	// A file whose compression is still running has both forms; count it once
+	seen := make(map[string]bool)
+	var backups []string
+	for _, m := range matches {
+		name := strings.TrimSuffix(m, ".gz")
+		if !seen[name] {
+			seen[name] = true
+			backups = append(backups, name)
+		}
+	}
+	sort.Strings(backups)
+	for _, b := range backups[:max(len(backups)-rf.config.MaxBackups, 0)] {
+		os.Remove(b)
+		os.Remove(b + ".gz")
+	}
+}
+
+// compressFile replaces path with a gzipped copy
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Close closes the file once rotated files have been compressed
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.compressing.Wait()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
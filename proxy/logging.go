@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// NewLogger creates the logger for the configured level and format. Set as
+// the default logger, it also receives output of the standard log package.
+func NewLogger(cfg config.LoggingConfig, w io.Writer) *slog.Logger {
+	var level slog.Level
+	switch strings.ToLower(cfg.Level) {
+	case "debug":
+		level = slog.LevelDebug
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		level = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	if strings.ToLower(cfg.Format) == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	if s, ok := w.(*syslogOutput); ok {
+		h = &syslogHandler{Handler: h, output: s}
+	}
+	return slog.New(h)
+}
+
+// syslogHandler passes the level of each record to the syslog output, so
+// lines are sent with the matching severity
+type syslogHandler struct {
+	slog.Handler
+	output *syslogOutput
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.output.handle(func() error { return h.Handler.Handle(ctx, r) }, r.Level)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{Handler: h.Handler.WithAttrs(attrs), output: h.output}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{Handler: h.Handler.WithGroup(name), output: h.output}
+}
+
+type loggerKey struct{}
+
+// withLogger has requests log to logger rather than the default logger
+func withLogger(logger *slog.Logger) middleware {
+	if logger == slog.Default() {
+		return nil
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), loggerKey{}, logger)))
+		})
+	}
+}
+
+// requestLogger returns the logger of the proxy serving r
+func requestLogger(r *http.Request) *slog.Logger {
+	if logger, ok := r.Context().Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+type routeKey struct{}
+
+// withRoute records the pattern of the route serving r for its log lines
+func withRoute(r *http.Request, pattern string) *http.Request {
+	if pattern == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, pattern))
+}
+
+// logRequest logs a message about r with the request's method, path, route
+// and ID, followed by args
+func logRequest(r *http.Request, level slog.Level, msg string, args ...any) {
+	logger := requestLogger(r)
+	if !logger.Enabled(r.Context(), level) {
+		return
+	}
+
+	attrs := make([]any, 0, 8+len(args))
+	attrs = append(attrs, "method", r.Method, "path", r.URL.Path)
+	if route, ok := r.Context().Value(routeKey{}).(string); ok {
+		attrs = append(attrs, "route", route)
+	}
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		attrs = append(attrs, "request_id", id)
+	}
+	if id, ok := apiKeyID(r); ok {
+		attrs = append(attrs, "api_key", id)
+	}
+	if name, ok := tenantName(r); ok {
+		attrs = append(attrs, "tenant", name)
+	}
+	logger.Log(r.Context(), level, msg, append(attrs, args...)...)
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+	"github.com/bunnydevv/reverse-proxy/schedule"
+)
+
+// maintenanceCheckInterval is how often maintenance windows are evaluated
+const maintenanceCheckInterval = 5 * time.Second
+
+type maintenanceWindow struct {
+	spec        string
+	schedule    *schedule.Schedule
+	duration    time.Duration
+	drainBefore time.Duration
+	location    *time.Location
+}
+
+func newMaintenanceWindows(cfgs []config.MaintenanceWindow) ([]maintenanceWindow, error) {
+	windows := make([]maintenanceWindow, 0, len(cfgs))
+	for _, c := range cfgs {
+		sched, err := schedule.Parse(c.Schedule)
+		if err != nil {
+			return nil, err
+		}
+
+		loc := time.Local
+		if c.Timezone != "" {
+			if loc, err = time.LoadLocation(c.Timezone); err != nil {
+				return nil, fmt.Errorf("invalid maintenance timezone %s: %w", c.Timezone, err)
+			}
+		}
+
+		windows = append(windows, maintenanceWindow{
+			spec:        c.Schedule,
+			schedule:    sched,
+			duration:    c.Duration,
+			drainBefore: c.DrainBefore,
+			location:    loc,
+		})
+	}
+	return windows, nil
+}
+
+// next returns the start of the earliest window that has not yet ended at now
+func (w maintenanceWindow) next(now time.Time) time.Time {
+	return w.schedule.Next(now.In(w.location).Add(-w.duration))
+}
+
+// active reports whether now falls inside the window or its drain period
+func (w maintenanceWindow) active(now time.Time) bool {
+	start := w.next(now)
+	return !start.IsZero() && !now.Before(start.Add(-w.drainBefore))
+}
+
+// MaintenanceWindow describes an upcoming or ongoing maintenance window
+type MaintenanceWindow struct {
+	Schedule string    `json:"schedule"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	DrainAt  time.Time `json:"drain_at"`
+}
+
+// MaintenanceWindows returns the next occurrence of each of the backend's
+// maintenance schedules
+func (b *Backend) MaintenanceWindows(now time.Time) []MaintenanceWindow {
+	out := make([]MaintenanceWindow, 0, len(b.maintenance))
+	for _, w := range b.maintenance {
+		start := w.next(now)
+		if start.IsZero() {
+			continue
+		}
+		out = append(out, MaintenanceWindow{
+			Schedule: w.spec,
+			Start:    start,
+			End:      start.Add(w.duration),
+			DrainAt:  start.Add(-w.drainBefore),
+		})
+	}
+	return out
+}
+
+// maintenanceScheduler drains backends ahead of their maintenance windows
+// and restores them once the windows end
+type maintenanceScheduler struct {
+	backends func() []*Backend // backends can be discovered at runtime
+	logger   *slog.Logger
+	stop     chan struct{}
+}
+
+// newMaintenanceScheduler returns nil when no backend has a maintenance window
+func newMaintenanceScheduler(backends func() []*Backend, logger *slog.Logger) *maintenanceScheduler {
+	scheduled := false
+	for _, b := range backends() {
+		if len(b.maintenance) > 0 {
+			scheduled = true
+			break
+		}
+	}
+	if !scheduled {
+		return nil
+	}
+
+	return &maintenanceScheduler{
+		backends: backends,
+		logger:   logger,
+		stop:     make(chan struct{}),
+	}
+}
+
+func (ms *maintenanceScheduler) Start() {
+	ticker := time.NewTicker(maintenanceCheckInterval)
+	go func() {
+		ms.evaluate(time.Now())
+
+		for {
+			select {
+			case now := <-ticker.C:
+				ms.evaluate(now)
+			case <-ms.stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (ms *maintenanceScheduler) Stop() {
+	close(ms.stop)
+}
+
+func (ms *maintenanceScheduler) evaluate(now time.Time) {
+	for _, b := range ms.backends() {
+		if len(b.maintenance) == 0 {
+			continue
+		}
+		inWindow := false
+		for _, w := range b.maintenance {
+			if w.active(now) {
+				inWindow = true
+				break
+			}
+		}
+
+		if inWindow == b.IsDraining() {
+			continue
+		}
+		if inWindow {
+			ms.logger.Info("Backend entering scheduled maintenance, draining", "backend", b.URL.String())
+		} else {
+			ms.logger.Info("Backend maintenance window ended, restoring", "backend", b.URL.String())
+		}
+		b.SetDraining(inWindow)
+	}
+}
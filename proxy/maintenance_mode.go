@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// maintenanceMode answers requests with a 503 maintenance page while the
+// proxy or individual routes are in maintenance. Routes are identified by
+// their path_prefix or path_regex. Both can be toggled through the admin
+// API.
+type maintenanceMode struct {
+	retryAfter string
+	page       *errorPage
+	allow      *ipSet
+	known      map[string]bool // patterns of configured routes
+
+	mu      sync.RWMutex
+	enabled bool
+	routes  map[string]bool
+}
+
+// newMaintenanceMode prepares maintenance mode for routes, falling back to
+// the given 503 page when no maintenance page is configured
+func newMaintenanceMode(cfg config.MaintenanceModeConfig, routes []config.RouteConfig, fallback *errorPage) (*maintenanceMode, error) {
+	allow, err := newIPSet(cfg.Allow)
+	if err != nil {
+		return nil, err
+	}
+	mm := &maintenanceMode{
+		retryAfter: strconv.Itoa(int(cfg.RetryAfter / time.Second)),
+		page:       fallback,
+		allow:      allow,
+		known:      make(map[string]bool, len(routes)),
+		enabled:    cfg.Enabled,
+		routes:     make(map[string]bool),
+	}
+	if cfg.Page != "" {
+		if mm.page, err = loadErrorPage(cfg.Page); err != nil {
+			return nil, err
+		}
+	}
+	for _, rc := range routes {
+		pattern := routePattern(rc)
+		mm.known[pattern] = true
+		if rc.Maintenance {
+			mm.routes[pattern] = true
+		}
+	}
+	return mm, nil
+}
+
+// routePattern identifies a route by its path_regex or path_prefix
+func routePattern(rc config.RouteConfig) string {
+	if rc.PathRegex != "" {
+		return rc.PathRegex
+	}
+	return rc.PathPrefix
+}
+
+// admitted reports whether r may pass, writing the maintenance page if not
+func (mm *maintenanceMode) admitted(w http.ResponseWriter, r *http.Request, global bool, pattern string) bool {
+	mm.mu.RLock()
+	active := (global && mm.enabled) || (pattern != "" && mm.routes[pattern])
+	mm.mu.RUnlock()
+	if !active || mm.allow.Contains(clientAddr(r)) {
+		return true
+	}
+
+	w.Header().Set("Retry-After", mm.retryAfter)
+	mm.page.serve(w, r, http.StatusServiceUnavailable, "Service Unavailable: down for maintenance")
+	return false
+}
+
+// middleware applies proxy-wide maintenance ahead of authentication and
+// other request processing
+func (mm *maintenanceMode) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mm.admitted(w, r, true, "") {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// authorize applies maintenance of the matched route
+func (mm *maintenanceMode) authorize(w http.ResponseWriter, r *http.Request, rt route) bool {
+	return mm.admitted(w, r, false, rt.pattern())
+}
+
+type maintenanceView struct {
+	Enabled bool     `json:"enabled" yaml:"enabled"`
+	Routes  []string `json:"routes" yaml:"routes"`
+}
+
+func (mm *maintenanceMode) view() maintenanceView {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	v := maintenanceView{Enabled: mm.enabled, Routes: make([]string, 0, len(mm.routes))}
+	for pattern := range mm.routes {
+		v.Routes = append(v.Routes, pattern)
+	}
+	sort.Strings(v.Routes)
+	return v
+}
+
+// adminHandler serves GET /maintenance to inspect and PUT /maintenance to
+// replace the maintenance state, e.g. {"enabled": false, "routes": ["/api/"]}
+func (mm *maintenanceMode) adminHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, mm.view())
+
+	case http.MethodPut:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxAdminBodySize))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var v maintenanceView
+		if err := yaml.Unmarshal(body, &v); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid maintenance state: "+err.Error())
+			return
+		}
+		routes := make(map[string]bool, len(v.Routes))
+		for _, pattern := range v.Routes {
+			if !mm.known[pattern] {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("no route with path_prefix or path_regex %q", pattern))
+				return
+			}
+			routes[pattern] = true
+		}
+
+		mm.mu.Lock()
+		mm.enabled = v.Enabled
+		mm.routes = routes
+		mm.mu.Unlock()
+
+		requestLogger(r).Info("Maintenance mode updated via admin API", "enabled", v.Enabled, "routes", len(routes))
+		writeJSON(w, http.StatusOK, mm.view())
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// metrics collects the proxy's measurements and serves them on the admin
+// API in the Prometheus text format, pushing them to StatsD as well when
+// configured
+type metrics struct {
+	buckets []float64 // seconds
+
+	conns   *connTracker
+	tenants *tenants
+	statsd  *statsdSink
+	flush   time.Duration // how often gauges and buffered lines are pushed
+
+	mu       sync.Mutex
+	upstream map[string]*upstreamMetrics // by backend URL
+	slo      map[string]*sloMetrics      // by objective name
+}
+
+// upstreamMetrics are the latency histograms of one backend
+type upstreamMetrics struct {
+	connect  *histogram
+	ttfb     *histogram
+	duration *histogram
+}
+
+func newMetrics(cfg config.MetricsConfig) *metrics {
+	m := &metrics{upstream: make(map[string]*upstreamMetrics), slo: make(map[string]*sloMetrics), flush: cfg.StatsD.FlushInterval}
+	for _, b := range cfg.LatencyBuckets {
+		m.buckets = append(m.buckets, b.Seconds())
+	}
+	return m
+}
+
+// observeUpstream records the timing of one request to backend
+func (m *metrics) observeUpstream(backend *Backend, t *upstreamTiming, duration time.Duration) {
+	connect, ttfb := t.durations()
+
+	if m.statsd != nil {
+		b := backend.URL.String()
+		m.statsd.count("upstream.requests", 1, b)
+		if connect > 0 {
+			m.statsd.timing("upstream.connect", connect, b)
+		}
+		if ttfb > 0 {
+			m.statsd.timing("upstream.ttfb", ttfb, b)
+		}
+		m.statsd.timing("upstream.duration", duration, b)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	um, ok := m.upstream[backend.URL.String()]
+	if !ok {
+		um = &upstreamMetrics{
+			connect:  newHistogram(m.buckets),
+			ttfb:     newHistogram(m.buckets),
+			duration: newHistogram(m.buckets),
+		}
+		m.upstream[backend.URL.String()] = um
+	}
+	if connect > 0 {
+		um.connect.observe(connect.Seconds())
+	}
+	if ttfb > 0 {
+		um.ttfb.observe(ttfb.Seconds())
+	}
+	um.duration.observe(duration.Seconds())
+}
+
+// Start pushes gauges and buffered metrics to StatsD on every flush
+// interval until Stop; it is a no-op without StatsD
+func (m *metrics) Start() {
+	if m.statsd == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(m.flush)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if m.conns != nil {
+					open, peak := m.conns.counts()
+					m.statsd.gauge("client_connections", int64(open))
+					m.statsd.gauge("client_connections_peak", int64(peak))
+				}
+				m.statsd.flush()
+			case <-m.statsd.stop:
+				m.statsd.flush()
+				return
+			}
+		}
+	}()
+}
+
+func (m *metrics) Stop() {
+	if m.statsd != nil {
+		close(m.statsd.stop)
+	}
+}
+
+// adminHandler serves all metrics on GET
+func (m *metrics) adminHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	m.write(bw)
+	bw.Flush()
+}
+
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	backends := make([]string, 0, len(m.upstream))
+	for b := range m.upstream {
+		backends = append(backends, b)
+	}
+	sort.Strings(backends)
+
+	families := []struct {
+		name, help string
+		histogram  func(*upstreamMetrics) *histogram
+	}{
+		{"reverse_proxy_upstream_connect_seconds", "Time to open a new connection to a backend, including the TLS handshake.",
+			func(um *upstreamMetrics) *histogram { return um.connect }},
+		{"reverse_proxy_upstream_ttfb_seconds", "Time from sending a request to a backend until the first byte of its response.",
+			func(um *upstreamMetrics) *histogram { return um.ttfb }},
+		{"reverse_proxy_upstream_duration_seconds", "Total time spent proxying a request to a backend.",
+			func(um *upstreamMetrics) *histogram { return um.duration }},
+	}
+	for _, f := range families {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", f.name, f.help, f.name)
+		for _, b := range backends {
+			f.histogram(m.upstream[b]).write(w, f.name, `backend="`+escapeLabel(b)+`"`)
+		}
+	}
+	m.writeSLO(w)
+	m.tenants.write(w)
+
+	if m.conns != nil {
+		open, peak := m.conns.counts()
+		fmt.Fprintf(w, "# HELP reverse_proxy_client_connections Client connections currently open.\n# TYPE reverse_proxy_client_connections gauge\nreverse_proxy_client_connections %d\n", open)
+		fmt.Fprintf(w, "# HELP reverse_proxy_client_connections_peak Most client connections open at once since startup.\n# TYPE reverse_proxy_client_connections_peak gauge\nreverse_proxy_client_connections_peak %d\n", peak)
+	}
+}
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, the last one for +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, labels string) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
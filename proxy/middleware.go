@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+// middleware wraps a handler with additional request processing
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h so that the first middleware listed sees the request first
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i] != nil {
+			h = mws[i](h)
+		}
+	}
+	return h
+}
+
+// buildHandler assembles the request pipeline in front of the proxying
+// handler, which routes requests through vhosts
+func (rp *ReverseProxy) buildHandler(vhosts *vhostRouter) http.Handler {
+	proxy := func(w http.ResponseWriter, r *http.Request) {
+		rp.proxyRequest(w, r, vhosts)
+	}
+	return chain(http.HandlerFunc(proxy),
+		withLogger(rp.logger),
+		rp.requestIDs.middleware,
+		rp.dashboard.middleware,
+		rp.http3.middleware,
+		rp.forwarded.middleware,
+		rp.connHeaders.middleware,
+		rp.redirects.middleware,
+		rp.maintMode.middleware,
+		rp.apiKeys.middleware,
+		rp.tenants.middleware,
+		rp.clientLimit.middleware,
+		rp.limiter.middleware,
+		rp.access.middleware,
+		rp.geoIP.middleware,
+		rp.blocklists.middleware,
+		rp.userAgents.middleware,
+		rp.cors.middleware,
+		rp.jwt.middleware,
+		rp.forwardAuth.middleware,
+		rp.plugins.middleware,
+		rp.compression.middleware,
+		rp.idempotency.middleware,
+		rp.faults.middleware,
+		rp.extensionsMiddleware,
+	)
+}
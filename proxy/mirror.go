@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// maxMirrorsInFlight bounds the shadow requests of one route that may be
+// outstanding; further requests are not mirrored until some complete
+const maxMirrorsInFlight = 256
+
+// mirror copies requests to a shadow backend in the background and discards
+// its responses
+type mirror struct {
+	config    config.MirrorConfig
+	target    *url.URL
+	transport http.RoundTripper
+	inFlight  chan struct{}
+}
+
+// newMirror returns nil when the route isn't mirrored
+func newMirror(cfg *config.MirrorConfig, transports *transportBuilder) (*mirror, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	target, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := transports.build(config.Backend{URL: cfg.URL, TLS: cfg.TLS})
+	if err != nil {
+		return nil, err
+	}
+	return &mirror{
+		config:    *cfg,
+		target:    target,
+		transport: transport,
+		inFlight:  make(chan struct{}, maxMirrorsInFlight),
+	}, nil
+}
+
+// send mirrors r when it is sampled. The request body is buffered so that
+// it can be read by both backends; requests whose body is too large, and
+// protocol upgrades, are not mirrored.
+func (m *mirror) send(r *http.Request) {
+	if m == nil || rand.Float64()*100 >= m.config.Percent || r.Header.Get("Upgrade") != "" {
+		return
+	}
+
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength > m.config.MaxBodySize {
+			return
+		}
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Body, m.config.MaxBodySize+1))
+		rest := r.Body
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), rest), rest}
+		if err != nil || int64(len(body)) > m.config.MaxBodySize {
+			return
+		}
+	}
+
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		logRequest(r, slog.LevelWarn, "Not mirroring request: too many mirrored requests in flight")
+		return
+	}
+
+	// The shadow request outlives the client's
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), m.config.Timeout)
+	req := r.Clone(ctx)
+	req.RequestURI = ""
+	req.URL.Scheme = m.target.Scheme
+	req.URL.Host = m.target.Host
+	req.URL.Path = singleJoiningSlash(m.target.Path, r.URL.Path)
+	req.URL.RawPath = ""
+	req.Body = http.NoBody
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	for _, h := range hopHeaders {
+		req.Header.Del(h)
+	}
+
+	go func() {
+		defer func() { <-m.inFlight }()
+		defer cancel()
+
+		resp, err := m.transport.RoundTrip(req)
+		if err != nil {
+			logRequest(req, slog.LevelWarn, "Mirrored request failed", "mirror", m.target.Host, "error", err)
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+}
+
+// singleJoiningSlash joins a target path and a request path the way
+// httputil.NewSingleHostReverseProxy does
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,825 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+	"gopkg.in/yaml.v3"
+)
+
+// maxOpenAPIRefDepth bounds chains of $ref pointing at further $refs
+const maxOpenAPIRefDepth = 32
+
+// openAPIValidator checks requests against the operations of an OpenAPI 3
+// document: path, query, header and cookie parameters, the content type and
+// JSON bodies. It covers the commonly used subset of JSON Schema; unknown
+// keywords are ignored, so documents using them validate less strictly.
+type openAPIValidator struct {
+	doc      *openAPIDocument
+	basePath string
+	paths    []openAPIPath // literal paths before templated ones
+}
+
+// openAPIPath is a path template of the document compiled to a regexp
+type openAPIPath struct {
+	re     *regexp.Regexp
+	names  []string // of the template parameters, in order
+	item   *openAPIPathItem
+	params int
+}
+
+type openAPIDocument struct {
+	Paths      map[string]*openAPIPathItem `yaml:"paths"`
+	Components struct {
+		Schemas       map[string]*openAPISchema      `yaml:"schemas"`
+		Parameters    map[string]*openAPIParameter   `yaml:"parameters"`
+		RequestBodies map[string]*openAPIRequestBody `yaml:"requestBodies"`
+	} `yaml:"components"`
+}
+
+type openAPIPathItem struct {
+	Parameters []*openAPIParameter `yaml:"parameters"`
+	Get        *openAPIOperation   `yaml:"get"`
+	Put        *openAPIOperation   `yaml:"put"`
+	Post       *openAPIOperation   `yaml:"post"`
+	Delete     *openAPIOperation   `yaml:"delete"`
+	Options    *openAPIOperation   `yaml:"options"`
+	Head       *openAPIOperation   `yaml:"head"`
+	Patch      *openAPIOperation   `yaml:"patch"`
+	Trace      *openAPIOperation   `yaml:"trace"`
+}
+
+type openAPIOperation struct {
+	Parameters  []*openAPIParameter `yaml:"parameters"`
+	RequestBody *openAPIRequestBody `yaml:"requestBody"`
+}
+
+type openAPIParameter struct {
+	Ref      string         `yaml:"$ref"`
+	Name     string         `yaml:"name"`
+	In       string         `yaml:"in"`
+	Required bool           `yaml:"required"`
+	Schema   *openAPISchema `yaml:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Ref      string                       `yaml:"$ref"`
+	Required bool                         `yaml:"required"`
+	Content  map[string]*openAPIMediaType `yaml:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `yaml:"schema"`
+}
+
+type openAPISchema struct {
+	Ref        string                    `yaml:"$ref"`
+	Type       interface{}               `yaml:"type"` // a name, or a list of names in OpenAPI 3.1
+	Nullable   bool                      `yaml:"nullable"`
+	Enum       []interface{}             `yaml:"enum"`
+	Properties map[string]*openAPISchema `yaml:"properties"`
+	Required   []string                  `yaml:"required"`
+	Additional *openAPIAdditional        `yaml:"additionalProperties"`
+	Items      *openAPISchema            `yaml:"items"`
+	AllOf      []*openAPISchema          `yaml:"allOf"`
+	AnyOf      []*openAPISchema          `yaml:"anyOf"`
+	OneOf      []*openAPISchema          `yaml:"oneOf"`
+	Minimum    *float64                  `yaml:"minimum"`
+	Maximum    *float64                  `yaml:"maximum"`
+	ExclMin    interface{}               `yaml:"exclusiveMinimum"` // a flag in 3.0, a bound in 3.1
+	ExclMax    interface{}               `yaml:"exclusiveMaximum"`
+	MinLength  *int                      `yaml:"minLength"`
+	MaxLength  *int                      `yaml:"maxLength"`
+	Pattern    string                    `yaml:"pattern"`
+	MinItems   *int                      `yaml:"minItems"`
+	MaxItems   *int                      `yaml:"maxItems"`
+
+	pattern *regexp.Regexp
+}
+
+// openAPIAdditional is additionalProperties: false, true or a schema
+type openAPIAdditional struct {
+	denied bool
+	schema *openAPISchema
+}
+
+func (a *openAPIAdditional) UnmarshalYAML(node *yaml.Node) error {
+	var allowed bool
+	if node.Decode(&allowed) == nil {
+		a.denied = !allowed
+		return nil
+	}
+	return node.Decode(&a.schema)
+}
+
+// newOpenAPIValidator returns nil when the route has no OpenAPI document
+func newOpenAPIValidator(cfg *config.OpenAPIConfig) (*openAPIValidator, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(cfg.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read openapi spec: %w", err)
+	}
+	// YAML is a superset of JSON, so both forms parse
+	var doc openAPIDocument
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse openapi spec %s: %w", cfg.Spec, err)
+	}
+
+	v := &openAPIValidator{doc: &doc, basePath: strings.TrimSuffix(cfg.BasePath, "/")}
+	if err := v.compile(); err != nil {
+		return nil, fmt.Errorf("openapi spec %s: %w", cfg.Spec, err)
+	}
+	for template, item := range doc.Paths {
+		if item == nil {
+			continue
+		}
+		p, err := compileOpenAPIPath(template)
+		if err != nil {
+			return nil, fmt.Errorf("openapi spec %s: %w", cfg.Spec, err)
+		}
+		p.item = item
+		v.paths = append(v.paths, p)
+	}
+	sort.Slice(v.paths, func(i, j int) bool {
+		if v.paths[i].params != v.paths[j].params {
+			return v.paths[i].params < v.paths[j].params
+		}
+		return v.paths[i].re.String() > v.paths[j].re.String()
+	})
+	return v, nil
+}
+
+// compileOpenAPIPath turns a template such as /users/{id} into a regexp
+// capturing each parameter from one path segment
+func compileOpenAPIPath(template string) (openAPIPath, error) {
+	var p openAPIPath
+	var expr strings.Builder
+	expr.WriteString("^")
+	rest := template
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			expr.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return p, fmt.Errorf("invalid path template %q", template)
+		}
+		expr.WriteString(regexp.QuoteMeta(rest[:open]))
+		expr.WriteString("([^/]+)")
+		p.names = append(p.names, rest[open+1:open+end])
+		rest = rest[open+end+1:]
+	}
+	expr.WriteString("$")
+	p.re = regexp.MustCompile(expr.String())
+	p.params = len(p.names)
+	return p, nil
+}
+
+// compile checks that every $ref of the document resolves and compiles the
+// schema patterns
+func (v *openAPIValidator) compile() error {
+	doc := v.doc
+	for _, s := range doc.Components.Schemas {
+		if err := v.compileSchema(s); err != nil {
+			return err
+		}
+	}
+	for _, p := range doc.Components.Parameters {
+		if err := v.compileParameter(p); err != nil {
+			return err
+		}
+	}
+	for _, b := range doc.Components.RequestBodies {
+		if err := v.compileBody(b); err != nil {
+			return err
+		}
+	}
+	for _, item := range doc.Paths {
+		if item == nil {
+			continue
+		}
+		for _, p := range item.Parameters {
+			if err := v.compileParameter(p); err != nil {
+				return err
+			}
+		}
+		for _, op := range item.operations() {
+			for _, p := range op.Parameters {
+				if err := v.compileParameter(p); err != nil {
+					return err
+				}
+			}
+			if err := v.compileBody(op.RequestBody); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *openAPIValidator) compileParameter(p *openAPIParameter) error {
+	if p == nil {
+		return nil
+	}
+	if p.Ref != "" {
+		_, err := v.parameter(p)
+		return err
+	}
+	return v.compileSchema(p.Schema)
+}
+
+func (v *openAPIValidator) compileBody(b *openAPIRequestBody) error {
+	if b == nil {
+		return nil
+	}
+	if b.Ref != "" {
+		_, err := v.requestBody(b)
+		return err
+	}
+	for _, mt := range b.Content {
+		if mt != nil {
+			if err := v.compileSchema(mt.Schema); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *openAPIValidator) compileSchema(s *openAPISchema) error {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		_, err := v.schema(s)
+		return err
+	}
+	if s.Pattern != "" && s.pattern == nil {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", s.Pattern, err)
+		}
+		s.pattern = re
+	}
+	children := append(append(append([]*openAPISchema{s.Items}, s.AllOf...), s.AnyOf...), s.OneOf...)
+	for _, p := range s.Properties {
+		children = append(children, p)
+	}
+	if s.Additional != nil {
+		children = append(children, s.Additional.schema)
+	}
+	for _, c := range children {
+		if err := v.compileSchema(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (item *openAPIPathItem) operations() []*openAPIOperation {
+	var ops []*openAPIOperation
+	for _, op := range []*openAPIOperation{item.Get, item.Put, item.Post, item.Delete, item.Options, item.Head, item.Patch, item.Trace} {
+		if op != nil {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+func (item *openAPIPathItem) operation(method string) *openAPIOperation {
+	switch method {
+	case http.MethodGet:
+		return item.Get
+	case http.MethodPut:
+		return item.Put
+	case http.MethodPost:
+		return item.Post
+	case http.MethodDelete:
+		return item.Delete
+	case http.MethodOptions:
+		return item.Options
+	case http.MethodHead:
+		if item.Head == nil {
+			return item.Get
+		}
+		return item.Head
+	case http.MethodPatch:
+		return item.Patch
+	case http.MethodTrace:
+		return item.Trace
+	}
+	return nil
+}
+
+// componentName returns the name a local reference such as
+// #/components/schemas/User points at within kind
+func componentName(ref, kind string) (string, error) {
+	name, ok := strings.CutPrefix(ref, "#/components/"+kind+"/")
+	if !ok {
+		return "", fmt.Errorf("unsupported $ref %q: only #/components/%s/ references are resolved", ref, kind)
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~1", "/"), "~0", "~"), nil
+}
+
+func (v *openAPIValidator) schema(s *openAPISchema) (*openAPISchema, error) {
+	for depth := 0; s != nil && s.Ref != ""; depth++ {
+		name, err := componentName(s.Ref, "schemas")
+		if err != nil {
+			return nil, err
+		}
+		next, ok := v.doc.Components.Schemas[name]
+		if !ok || depth == maxOpenAPIRefDepth {
+			return nil, fmt.Errorf("unresolved $ref %q", s.Ref)
+		}
+		s = next
+	}
+	return s, nil
+}
+
+func (v *openAPIValidator) parameter(p *openAPIParameter) (*openAPIParameter, error) {
+	for depth := 0; p != nil && p.Ref != ""; depth++ {
+		name, err := componentName(p.Ref, "parameters")
+		if err != nil {
+			return nil, err
+		}
+		next, ok := v.doc.Components.Parameters[name]
+		if !ok || depth == maxOpenAPIRefDepth {
+			return nil, fmt.Errorf("unresolved $ref %q", p.Ref)
+		}
+		p = next
+	}
+	return p, nil
+}
+
+func (v *openAPIValidator) requestBody(b *openAPIRequestBody) (*openAPIRequestBody, error) {
+	for depth := 0; b != nil && b.Ref != ""; depth++ {
+		name, err := componentName(b.Ref, "requestBodies")
+		if err != nil {
+			return nil, err
+		}
+		next, ok := v.doc.Components.RequestBodies[name]
+		if !ok || depth == maxOpenAPIRefDepth {
+			return nil, fmt.Errorf("unresolved $ref %q", b.Ref)
+		}
+		b = next
+	}
+	return b, nil
+}
+
+// authorize reports whether r conforms to the document, and otherwise
+// answers with 400, or 413 when the body is too large to check. Clients
+// aren't told which part of the request is wrong; the log is.
+func (v *openAPIValidator) authorize(w http.ResponseWriter, r *http.Request, serveError serveErrorFunc) bool {
+	if v == nil {
+		return true
+	}
+
+	err := v.validate(r)
+	if err == nil {
+		return true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		logRequest(r, slog.LevelWarn, "Request body too large", "limit", tooLarge.Limit)
+		serveError(w, r, http.StatusRequestEntityTooLarge, bodyTooLargeMessage(tooLarge.Limit), err)
+		return false
+	}
+	logRequest(r, slog.LevelInfo, "Request does not match the OpenAPI spec", "error", err)
+	serveError(w, r, http.StatusBadRequest, "Bad Request", err)
+	return false
+}
+
+// validate checks r against the operation its method and path select
+func (v *openAPIValidator) validate(r *http.Request) error {
+	path := r.URL.Path
+	if v.basePath != "" {
+		if !hasPathPrefix(path, v.basePath) {
+			return fmt.Errorf("path %s is outside of the API", path)
+		}
+		path = strings.TrimPrefix(path, v.basePath)
+		if path == "" {
+			path = "/"
+		}
+	}
+
+	var (
+		found  *openAPIPath
+		values []string
+	)
+	for i := range v.paths {
+		if m := v.paths[i].re.FindStringSubmatch(path); m != nil {
+			found, values = &v.paths[i], m[1:]
+			break
+		}
+	}
+	if found == nil {
+		return fmt.Errorf("path %s is not part of the API", path)
+	}
+	op := found.item.operation(r.Method)
+	if op == nil {
+		return fmt.Errorf("method %s is not allowed for %s", r.Method, path)
+	}
+
+	// Operation parameters override path item ones of the same name and location
+	params := make(map[string]*openAPIParameter)
+	for _, list := range [][]*openAPIParameter{found.item.Parameters, op.Parameters} {
+		for _, p := range list {
+			p, err := v.parameter(p)
+			if err != nil {
+				return err
+			}
+			if p == nil {
+				continue
+			}
+			params[p.In+"\x00"+p.Name] = p
+		}
+	}
+	pathValues := make(map[string]string, len(found.names))
+	for i, name := range found.names {
+		pathValues[name], _ = url.PathUnescape(values[i])
+	}
+	query := r.URL.Query()
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := v.validateParameter(params[k], r, pathValues, query); err != nil {
+			return err
+		}
+	}
+
+	return v.validateBody(op.RequestBody, r)
+}
+
+func (v *openAPIValidator) validateParameter(p *openAPIParameter, r *http.Request, pathValues map[string]string, query url.Values) error {
+	var raw []string
+	switch p.In {
+	case "path":
+		if value, ok := pathValues[p.Name]; ok {
+			raw = []string{value}
+		}
+	case "query":
+		raw = query[p.Name]
+	case "header":
+		raw = r.Header.Values(p.Name)
+	case "cookie":
+		if c, err := r.Cookie(p.Name); err == nil {
+			raw = []string{c.Value}
+		}
+	}
+	if len(raw) == 0 {
+		if p.Required || p.In == "path" {
+			return fmt.Errorf("%s parameter %q is required", p.In, p.Name)
+		}
+		return nil
+	}
+
+	schema, err := v.schema(p.Schema)
+	if err != nil || schema == nil {
+		return err
+	}
+	var value interface{}
+	if schema.hasType("array") {
+		// Arrays come as repeated parameters or comma separated values
+		if len(raw) == 1 && (p.In != "query" || strings.Contains(raw[0], ",")) {
+			raw = strings.Split(raw[0], ",")
+		}
+		items, err := v.schema(schema.Items)
+		if err != nil {
+			return err
+		}
+		list := make([]interface{}, len(raw))
+		for i, s := range raw {
+			list[i] = parseParameterValue(s, items)
+		}
+		value = list
+	} else {
+		value = parseParameterValue(raw[0], schema)
+	}
+	return v.validateValue(schema, value, fmt.Sprintf("%s parameter %q", p.In, p.Name))
+}
+
+// parseParameterValue converts a parameter to the JSON value its schema
+// expects; values that don't convert stay strings and fail validation
+func parseParameterValue(s string, schema *openAPISchema) interface{} {
+	if schema == nil {
+		return s
+	}
+	switch {
+	case schema.hasType("integer"), schema.hasType("number"):
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case schema.hasType("boolean"):
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	}
+	return s
+}
+
+func (v *openAPIValidator) validateBody(body *openAPIRequestBody, r *http.Request) error {
+	body, err := v.requestBody(body)
+	if err != nil {
+		return err
+	}
+	empty := r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0
+	if body == nil {
+		return nil
+	}
+	if empty {
+		if body.Required {
+			return fmt.Errorf("request body is required")
+		}
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("invalid or missing Content-Type")
+	}
+	mt, ok := body.Content[mediaType]
+	if !ok {
+		major, _, _ := strings.Cut(mediaType, "/")
+		if mt, ok = body.Content[major+"/*"]; !ok {
+			mt, ok = body.Content["*/*"]
+		}
+	}
+	if !ok {
+		return fmt.Errorf("content type %s is not accepted", mediaType)
+	}
+	if mt == nil || mt.Schema == nil || !isJSONMediaType(mediaType) {
+		return nil
+	}
+
+	data, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	if len(bytes.TrimSpace(data)) == 0 {
+		if body.Required {
+			return fmt.Errorf("request body is required")
+		}
+		return nil
+	}
+
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&value); err != nil {
+		return fmt.Errorf("invalid JSON body: %v", err)
+	}
+	if dec.More() {
+		return fmt.Errorf("invalid JSON body: unexpected data after the value")
+	}
+	return v.validateValue(mt.Schema, value, "body")
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// hasType reports whether the schema declares the JSON type name
+func (s *openAPISchema) hasType(name string) bool {
+	switch t := s.Type.(type) {
+	case string:
+		return t == name
+	case []interface{}:
+		for _, n := range t {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *openAPISchema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		names := make([]string, 0, len(t))
+		for _, n := range t {
+			if name, ok := n.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// jsonType names the JSON type of a decoded value as schemas do
+func jsonType(value interface{}) string {
+	switch x := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if x == math.Trunc(x) && !math.IsInf(x, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// validateValue checks a decoded JSON value against schema; at describes
+// the value's location for error messages
+func (v *openAPIValidator) validateValue(schema *openAPISchema, value interface{}, at string) error {
+	schema, err := v.schema(schema)
+	if err != nil || schema == nil {
+		return err
+	}
+
+	for _, sub := range schema.AllOf {
+		if err := v.validateValue(sub, value, at); err != nil {
+			return err
+		}
+	}
+	if len(schema.AnyOf) > 0 {
+		var first error
+		for _, sub := range schema.AnyOf {
+			if first = v.validateValue(sub, value, at); first == nil {
+				break
+			}
+		}
+		if first != nil {
+			return fmt.Errorf("%s matches none of the allowed schemas", at)
+		}
+	}
+	if len(schema.OneOf) > 0 {
+		matched := 0
+		for _, sub := range schema.OneOf {
+			if v.validateValue(sub, value, at) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s must match exactly one of the allowed schemas", at)
+		}
+	}
+
+	kind := jsonType(value)
+	if kind == "null" && schema.Nullable {
+		return nil
+	}
+	if types := schema.types(); len(types) > 0 {
+		ok := false
+		for _, t := range types {
+			if t == kind || t == "number" && kind == "integer" {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%s must be of type %s", at, strings.Join(types, " or "))
+		}
+	}
+	if len(schema.Enum) > 0 && !enumContains(schema.Enum, value) {
+		return fmt.Errorf("%s is not one of the allowed values", at)
+	}
+
+	switch x := value.(type) {
+	case string:
+		n := utf8.RuneCountInString(x)
+		if schema.MinLength != nil && n < *schema.MinLength {
+			return fmt.Errorf("%s must be at least %d characters long", at, *schema.MinLength)
+		}
+		if schema.MaxLength != nil && n > *schema.MaxLength {
+			return fmt.Errorf("%s must be at most %d characters long", at, *schema.MaxLength)
+		}
+		if schema.pattern != nil && !schema.pattern.MatchString(x) {
+			return fmt.Errorf("%s must match %s", at, schema.Pattern)
+		}
+	case float64:
+		return schema.checkRange(x, at)
+	case []interface{}:
+		if schema.MinItems != nil && len(x) < *schema.MinItems {
+			return fmt.Errorf("%s must have at least %d items", at, *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(x) > *schema.MaxItems {
+			return fmt.Errorf("%s must have at most %d items", at, *schema.MaxItems)
+		}
+		if schema.Items != nil {
+			for i, item := range x {
+				if err := v.validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range schema.Required {
+			if _, ok := x[name]; !ok {
+				return fmt.Errorf("%s.%s is required", at, name)
+			}
+		}
+		names := make([]string, 0, len(x))
+		for name := range x {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := schema.Properties[name]; ok {
+				if err := v.validateValue(prop, x[name], at+"."+name); err != nil {
+					return err
+				}
+				continue
+			}
+			if a := schema.Additional; a != nil {
+				if a.denied {
+					return fmt.Errorf("%s.%s is not allowed", at, name)
+				}
+				if err := v.validateValue(a.schema, x[name], at+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *openAPISchema) checkRange(x float64, at string) error {
+	if s.Minimum != nil {
+		if excl, _ := s.ExclMin.(bool); excl && x <= *s.Minimum {
+			return fmt.Errorf("%s must be greater than %v", at, *s.Minimum)
+		}
+		if x < *s.Minimum {
+			return fmt.Errorf("%s must be at least %v", at, *s.Minimum)
+		}
+	}
+	if s.Maximum != nil {
+		if excl, _ := s.ExclMax.(bool); excl && x >= *s.Maximum {
+			return fmt.Errorf("%s must be less than %v", at, *s.Maximum)
+		}
+		if x > *s.Maximum {
+			return fmt.Errorf("%s must be at most %v", at, *s.Maximum)
+		}
+	}
+	if bound, ok := yamlNumber(s.ExclMin); ok && x <= bound {
+		return fmt.Errorf("%s must be greater than %v", at, bound)
+	}
+	if bound, ok := yamlNumber(s.ExclMax); ok && x >= bound {
+		return fmt.Errorf("%s must be less than %v", at, bound)
+	}
+	return nil
+}
+
+// yamlNumber converts a number decoded by YAML into a float64
+func yamlNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// enumContains compares a JSON value against enum entries decoded by YAML
+func enumContains(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if n, ok := yamlNumber(e); ok {
+			if f, ok := value.(float64); ok && f == n {
+				return true
+			}
+			continue
+		}
+		if reflect.DeepEqual(e, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+const testOpenAPISpec = `openapi: 3.0.3
+paths:
+  /users:
+    get:
+      parameters:
+        - name: limit
+          in: query
+          schema: {type: integer, minimum: 1, maximum: 100}
+        - name: tags
+          in: query
+          schema: {type: array, items: {type: string}, maxItems: 2}
+        - $ref: "#/components/parameters/Tenant"
+    post:
+      requestBody:
+        $ref: "#/components/requestBodies/User"
+  /users/{id}:
+    parameters:
+      - name: id
+        in: path
+        required: true
+        schema: {type: integer}
+    get:
+      parameters:
+        - name: session
+          in: cookie
+          schema: {type: string, pattern: "^[a-f0-9]+$"}
+    delete: {}
+  /users/me:
+    get: {}
+components:
+  parameters:
+    Tenant:
+      name: X-Tenant
+      in: header
+      required: true
+      schema: {type: string, enum: [acme, globex]}
+  requestBodies:
+    User:
+      required: true
+      content:
+        application/json:
+          schema:
+            $ref: "#/components/schemas/User"
+  schemas:
+    User:
+      type: object
+      required: [name]
+      additionalProperties: false
+      properties:
+        name: {type: string, minLength: 1, maxLength: 20}
+        age: {type: integer, minimum: 0}
+        email: {type: string, nullable: true}
+        role:
+          $ref: "#/components/schemas/Role"
+    Role:
+      oneOf:
+        - {type: string, enum: [admin, user]}
+        - {type: integer}
+`
+
+func newTestOpenAPIValidator(t *testing.T, spec, basePath string) *openAPIValidator {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "openapi.yaml")
+	if err := os.WriteFile(path, []byte(spec), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := newOpenAPIValidator(&config.OpenAPIConfig{Spec: path, BasePath: basePath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestOpenAPIValidate(t *testing.T) {
+	v := newTestOpenAPIValidator(t, testOpenAPISpec, "/api")
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		header  map[string]string
+		body    string
+		wantErr string // substring of the error, "" when the request conforms
+	}{
+		// Operations
+		{name: "unknown path", method: "GET", target: "/api/orders", wantErr: "not part of the API"},
+		{name: "outside the base path", method: "GET", target: "/users", wantErr: "outside of the API"},
+		{name: "base path on a segment boundary", method: "GET", target: "/apiusers", wantErr: "outside of the API"},
+		{name: "undeclared method", method: "PUT", target: "/api/users/1", wantErr: "method PUT is not allowed"},
+		{name: "literal path before template", method: "GET", target: "/api/users/me"},
+
+		// Parameters
+		{name: "query and header", method: "GET", target: "/api/users?limit=10&tags=a,b", header: map[string]string{"X-Tenant": "acme"}},
+		{name: "repeated array query", method: "GET", target: "/api/users?tags=a&tags=b", header: map[string]string{"X-Tenant": "acme"}},
+		{name: "query not an integer", method: "GET", target: "/api/users?limit=ten", header: map[string]string{"X-Tenant": "acme"}, wantErr: `query parameter "limit" must be of type integer`},
+		{name: "query above maximum", method: "GET", target: "/api/users?limit=101", header: map[string]string{"X-Tenant": "acme"}, wantErr: "at most 100"},
+		{name: "too many array items", method: "GET", target: "/api/users?tags=a,b,c", header: map[string]string{"X-Tenant": "acme"}, wantErr: "at most 2 items"},
+		{name: "missing required header", method: "GET", target: "/api/users", wantErr: `header parameter "X-Tenant" is required`},
+		{name: "header not in enum", method: "GET", target: "/api/users", header: map[string]string{"X-Tenant": "initech"}, wantErr: "not one of the allowed values"},
+		{name: "path parameter of the path item", method: "GET", target: "/api/users/abc", wantErr: `path parameter "id" must be of type integer`},
+		{name: "cookie pattern", method: "GET", target: "/api/users/1", header: map[string]string{"Cookie": "session=xyz"}, wantErr: "must match"},
+		{name: "valid cookie", method: "GET", target: "/api/users/1", header: map[string]string{"Cookie": "session=abc123"}},
+
+		// Bodies and $ref
+		{name: "valid body", method: "POST", target: "/api/users", body: `{"name":"ada","age":36,"email":null,"role":"admin"}`},
+		{name: "body required", method: "POST", target: "/api/users", wantErr: "request body is required"},
+		{name: "content type not accepted", method: "POST", target: "/api/users", header: map[string]string{"Content-Type": "text/plain"}, body: "ada", wantErr: "content type text/plain is not accepted"},
+		{name: "invalid JSON", method: "POST", target: "/api/users", body: `{"name":`, wantErr: "invalid JSON body"},
+		{name: "trailing data", method: "POST", target: "/api/users", body: `{"name":"ada"} {}`, wantErr: "unexpected data"},
+		{name: "missing required property", method: "POST", target: "/api/users", body: `{"age":1}`, wantErr: "body.name is required"},
+		{name: "additional property denied", method: "POST", target: "/api/users", body: `{"name":"ada","admin":true}`, wantErr: "body.admin is not allowed"},
+		{name: "property type", method: "POST", target: "/api/users", body: `{"name":"ada","age":1.5}`, wantErr: "body.age must be of type integer"},
+		{name: "property minimum", method: "POST", target: "/api/users", body: `{"name":"ada","age":-1}`, wantErr: "body.age must be at least 0"},
+		{name: "string too long", method: "POST", target: "/api/users", body: `{"name":"` + strings.Repeat("a", 21) + `"}`, wantErr: "at most 20 characters"},
+		{name: "oneOf through $ref", method: "POST", target: "/api/users", body: `{"name":"ada","role":"root"}`, wantErr: "body.role must match exactly one"},
+		{name: "oneOf second branch", method: "POST", target: "/api/users", body: `{"name":"ada","role":3}`},
+		{name: "null without nullable", method: "POST", target: "/api/users", body: `{"name":null}`, wantErr: "body.name must be of type string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.body != "" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+			for k, val := range tt.header {
+				r.Header.Set(k, val)
+			}
+			err := v.validate(r)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("validate: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("validate = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOpenAPIRefErrors(t *testing.T) {
+	tests := []struct {
+		name, spec string
+	}{
+		{"unknown schema", `paths:
+  /a:
+    post:
+      requestBody:
+        content:
+          application/json:
+            schema: {$ref: "#/components/schemas/Missing"}
+`},
+		{"external reference", `paths:
+  /a:
+    get:
+      parameters:
+        - $ref: "other.yaml#/components/parameters/P"
+`},
+		{"reference cycle", `paths:
+  /a:
+    post:
+      requestBody: {$ref: "#/components/requestBodies/A"}
+components:
+  requestBodies:
+    A: {$ref: "#/components/requestBodies/B"}
+    B: {$ref: "#/components/requestBodies/A"}
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "openapi.yaml")
+			if err := os.WriteFile(path, []byte(tt.spec), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := newOpenAPIValidator(&config.OpenAPIConfig{Spec: path}); err == nil {
+				t.Error("spec with a broken $ref was accepted")
+			}
+		})
+	}
+}
+
+func TestOpenAPIRejectionHidesDetail(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	spec := filepath.Join(t.TempDir(), "openapi.yaml")
+	if err := os.WriteFile(spec, []byte(testOpenAPISpec), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rp := newTestProxy(t, fmt.Sprintf(`server:
+  address: ":0"
+routes:
+  - path_prefix: "/api"
+    pool: api
+    openapi:
+      spec: %q
+      base_path: /api
+pools:
+  api:
+    backends:
+      - url: %q
+`, spec, backend.URL))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{"name":"ada","secret_field":1}`))
+	r.Header.Set("Content-Type", "application/json")
+	rp.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "secret_field") {
+		t.Errorf("response %q reveals the validation error", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/api/users/me", nil)
+	rp.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("conforming request: %d %q", w.Code, w.Body.String())
+	}
+}
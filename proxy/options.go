@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+)
+
+// Option customizes a ReverseProxy for programs that embed it, beyond what
+// the configuration can express
+type Option func(*options)
+
+type options struct {
+	logger    *slog.Logger
+	transport http.RoundTripper
+	balancer  func(backends []*Backend) LoadBalancer
+	tlsConfig *tls.Config
+}
+
+// WithLogger sends the proxy's logs to logger instead of the default logger
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithTransport sends requests to every backend through transport instead
+// of the transports built from each backend's configuration, whose TLS,
+// protocol, egress and connection settings then don't apply
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *options) { o.transport = transport }
+}
+
+// WithBalancer picks backends with the balancers newBalancer creates instead
+// of the configured algorithms. It is called with the members of each pool
+// and failover group whenever they change.
+func WithBalancer(newBalancer func(backends []*Backend) LoadBalancer) Option {
+	return func(o *options) { o.balancer = newBalancer }
+}
+
+// WithTLSConfig has Start serve TLS with config instead of the certificates
+// of the tls section
+func WithTLSConfig(config *tls.Config) Option {
+	return func(o *options) { o.tlsConfig = config }
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// passiveHealthMonitor watches the outcome of proxied requests and ejects a
+// backend whose failure rate over a window crosses the threshold. Ejected
+// backends are reinstated once their ejection time has passed.
+type passiveHealthMonitor struct {
+	config config.PassiveHealthCheckConfig
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	stats map[*Backend]*passiveStats
+
+	// onChange is told of ejections and reinstatements, with the reason
+	onChange func(backend *Backend, alive bool, reason string)
+
+	stop chan struct{}
+}
+
+type passiveStats struct {
+	windowStart  time.Time
+	requests     int
+	failures     int
+	ejectedUntil time.Time // zero while the backend is not ejected
+}
+
+// newPassiveHealthMonitor returns nil when passive checks are disabled
+func newPassiveHealthMonitor(cfg config.PassiveHealthCheckConfig, logger *slog.Logger) *passiveHealthMonitor {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &passiveHealthMonitor{
+		config: cfg,
+		logger: logger,
+		stats:  make(map[*Backend]*passiveStats),
+		stop:   make(chan struct{}),
+	}
+}
+
+// record accounts a finished request; connection errors surface as 502
+func (pm *passiveHealthMonitor) record(backend *Backend, status int) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	now := time.Now()
+	s, ok := pm.stats[backend]
+	if !ok {
+		s = &passiveStats{windowStart: now}
+		pm.stats[backend] = s
+	}
+	if !s.ejectedUntil.IsZero() {
+		return
+	}
+	if now.Sub(s.windowStart) >= pm.config.Window {
+		s.windowStart, s.requests, s.failures = now, 0, 0
+	}
+
+	s.requests++
+	if status >= http.StatusInternalServerError {
+		s.failures++
+	}
+
+	if s.requests < pm.config.MinRequests {
+		return
+	}
+	rate := float64(s.failures) / float64(s.requests)
+	if rate < pm.config.FailureRate {
+		return
+	}
+
+	s.ejectedUntil = now.Add(pm.config.EjectionTime)
+	pm.logger.Warn("Backend ejected", "backend", backend.URL.String(), "duration", pm.config.EjectionTime, "failures", s.failures, "requests", s.requests)
+	backend.SetAlive(false)
+	if pm.onChange != nil {
+		pm.onChange(backend, false, fmt.Sprintf("%d of %d requests failed within %s", s.failures, s.requests, pm.config.Window))
+	}
+}
+
+// ejected reports whether the backend is currently ejected
+func (pm *passiveHealthMonitor) ejected(backend *Backend) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	s, ok := pm.stats[backend]
+	return ok && !s.ejectedUntil.IsZero()
+}
+
+// forget drops the statistics of a backend that left its pool
+func (pm *passiveHealthMonitor) forget(backend *Backend) {
+	if pm == nil {
+		return
+	}
+	pm.mu.Lock()
+	delete(pm.stats, backend)
+	pm.mu.Unlock()
+}
+
+func (pm *passiveHealthMonitor) Start() {
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				pm.reinstate(now)
+			case <-pm.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (pm *passiveHealthMonitor) Stop() {
+	close(pm.stop)
+}
+
+// reinstate returns backends whose ejection has expired to rotation
+func (pm *passiveHealthMonitor) reinstate(now time.Time) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for backend, s := range pm.stats {
+		if s.ejectedUntil.IsZero() || now.Before(s.ejectedUntil) {
+			continue
+		}
+		s.ejectedUntil = time.Time{}
+		s.windowStart, s.requests, s.failures = now, 0, 0
+		pm.logger.Info("Backend reinstated after passive ejection", "backend", backend.URL.String())
+		backend.SetAlive(true)
+		if pm.onChange != nil {
+			pm.onChange(backend, true, "ejection time elapsed")
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// Header kinds in the plugin ABI
+const (
+	pluginRequestHeaders  = 0
+	pluginResponseHeaders = 1
+)
+
+// plugins runs WebAssembly modules at two hook points: on_request, before
+// the request is routed, and on_response, before the response header is
+// sent. Modules call back into the proxy through the functions of the
+// "proxy" host module to read and change the exchange. An instance serves
+// one call at a time and the two hooks of a request may run on different
+// instances.
+type plugins struct {
+	runtime wazero.Runtime
+	list    []*plugin
+	logger  *slog.Logger
+}
+
+type plugin struct {
+	name     string
+	config   string
+	timeout  time.Duration
+	failOpen bool
+
+	runtime    wazero.Runtime
+	module     wazero.CompiledModule
+	onRequest  bool // the module exports on_request
+	onResponse bool // the module exports on_response
+	idle       chan api.Module
+	slots      chan struct{} // one per instance, up to max_instances
+}
+
+// pluginCall is the exchange a hook call works on
+type pluginCall struct {
+	plugin *plugin
+	r      *http.Request
+	header http.Header // the response's
+	status int         // the response's, in on_response
+	body   []byte      // of the response on_request answers with
+}
+
+type pluginCallKey struct{}
+
+// newPlugins compiles the configured modules and starts one instance of
+// each, so that broken modules are reported at startup. It returns nil
+// when no plugins are configured.
+func newPlugins(cfgs []config.PluginConfig, logger *slog.Logger) (*plugins, error) {
+	if len(cfgs) == 0 {
+		return nil, nil
+	}
+
+	ctx := context.Background()
+	// A call past its timeout is stopped by closing the instance
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	ps := &plugins{runtime: rt, logger: logger}
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		ps.close()
+		return nil, fmt.Errorf("failed to start WASI: %w", err)
+	}
+	if _, err := pluginHostModule(rt).Instantiate(ctx); err != nil {
+		ps.close()
+		return nil, fmt.Errorf("failed to start plugin host module: %w", err)
+	}
+
+	for _, c := range cfgs {
+		p, err := newPlugin(ctx, rt, c)
+		if err != nil {
+			ps.close()
+			return nil, fmt.Errorf("plugin %s: %w", c.Name, err)
+		}
+		ps.list = append(ps.list, p)
+	}
+	return ps, nil
+}
+
+func newPlugin(ctx context.Context, rt wazero.Runtime, c config.PluginConfig) (*plugin, error) {
+	code, err := os.ReadFile(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := rt.CompileModule(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile %s: %w", c.Path, err)
+	}
+
+	p := &plugin{
+		name:     c.Name,
+		config:   c.Config,
+		timeout:  c.Timeout,
+		failOpen: c.FailOpen,
+		runtime:  rt,
+		module:   compiled,
+	}
+	n := c.MaxInstances
+	if n == 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	p.idle = make(chan api.Module, n)
+	p.slots = make(chan struct{}, n)
+	exports := compiled.ExportedFunctions()
+	_, p.onRequest = exports["on_request"]
+	_, p.onResponse = exports["on_response"]
+	if !p.onRequest && !p.onResponse {
+		return nil, fmt.Errorf("%s exports neither on_request nor on_response", c.Path)
+	}
+
+	m, err := p.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.put(m)
+	return p, nil
+}
+
+// instantiate starts a new instance of the module. Modules are libraries
+// ("reactors" in WASI terms): _initialize runs, _start doesn't.
+func (p *plugin) instantiate(ctx context.Context) (api.Module, error) {
+	m, err := p.runtime.InstantiateModule(ctx, p.module, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStderr(os.Stderr))
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate module: %w", err)
+	}
+	return m, nil
+}
+
+// get returns an idle instance, or a new one while there are fewer than
+// max_instances, waiting for one to become idle otherwise
+func (p *plugin) get(ctx context.Context) (api.Module, error) {
+	select {
+	case m := <-p.idle:
+		return m, nil
+	default:
+	}
+	select {
+	case m := <-p.idle:
+		return m, nil
+	case p.slots <- struct{}{}:
+		m, err := p.instantiate(context.Background())
+		if err != nil {
+			<-p.slots
+			return nil, err
+		}
+		return m, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// put returns an instance for reuse. Failed calls close their instance,
+// since its state can't be trusted, which frees its slot instead.
+func (p *plugin) put(m api.Module) {
+	if m.IsClosed() {
+		<-p.slots
+		return
+	}
+	p.idle <- m
+}
+
+// run calls a hook on c in an instance of the module, waiting for one
+// while the request lasts. The call itself has the plugin's timeout.
+func (p *plugin) run(ctx context.Context, hook string, c *pluginCall, args ...uint64) (uint64, error) {
+	m, err := p.get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer p.put(m)
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), pluginCallKey{}, c), p.timeout)
+	defer cancel()
+	res, err := m.ExportedFunction(hook).Call(ctx, args...)
+	if err != nil {
+		m.Close(context.Background())
+		return 0, err
+	}
+	if len(res) == 0 {
+		return 0, nil
+	}
+	return res[0], nil
+}
+
+// close frees the modules and their instances
+func (ps *plugins) close() {
+	if ps == nil {
+		return
+	}
+	if err := ps.runtime.Close(context.Background()); err != nil {
+		ps.logger.Error("Failed to close plugins", "error", err)
+	}
+}
+
+func (ps *plugins) middleware(next http.Handler) http.Handler {
+	if ps == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pw := &pluginWriter{ResponseWriter: w, request: r}
+
+		for _, p := range ps.list {
+			if p.onRequest {
+				c := &pluginCall{plugin: p, r: r, header: w.Header()}
+				code, err := p.run(r.Context(), "on_request", c)
+				if err == nil && code != 0 && (code < 200 || code > 599) {
+					err = fmt.Errorf("on_request returned invalid status %d", code)
+				}
+				if err != nil {
+					if !pw.failed(p, err) {
+						continue
+					}
+					http.Error(pw, "Plugin failed", http.StatusInternalServerError)
+					return
+				}
+				if code != 0 {
+					// The plugin answered the request itself
+					pw.WriteHeader(int(code))
+					pw.Write(c.body)
+					return
+				}
+			}
+			if p.onResponse {
+				pw.pending = append(pw.pending, p)
+			}
+		}
+
+		if len(pw.pending) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(pw, r)
+	})
+}
+
+// pluginWriter runs the on_response hooks of the plugins the request went
+// through, last plugin first, before the response header is sent
+type pluginWriter struct {
+	http.ResponseWriter
+	request     *http.Request
+	pending     []*plugin
+	wroteHeader bool
+	discard     bool // a hook failed and the body is replaced
+}
+
+// failed logs a plugin's failure and reports whether the request fails with
+// it rather than going on without the plugin
+func (pw *pluginWriter) failed(p *plugin, err error) bool {
+	if p.failOpen {
+		logRequest(pw.request, slog.LevelWarn, "Plugin failed, continuing without it", "plugin", p.name, "error", err)
+		return false
+	}
+	logRequest(pw.request, slog.LevelError, "Plugin failed", "plugin", p.name, "error", err)
+	return true
+}
+
+func (pw *pluginWriter) WriteHeader(code int) {
+	// 1xx informational responses may precede the final header
+	if code >= 100 && code < 200 {
+		pw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if pw.wroteHeader {
+		return
+	}
+	pw.wroteHeader = true
+
+	for i := len(pw.pending) - 1; i >= 0; i-- {
+		p := pw.pending[i]
+		c := &pluginCall{plugin: p, r: pw.request, header: pw.Header(), status: code}
+		if _, err := p.run(pw.request.Context(), "on_response", c, uint64(code)); err != nil && pw.failed(p, err) {
+			pw.discard = true
+			clear(pw.Header())
+			pw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			pw.Header().Set("X-Content-Type-Options", "nosniff")
+			pw.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(pw.ResponseWriter, "Plugin failed\n")
+			return
+		}
+	}
+	pw.ResponseWriter.WriteHeader(code)
+}
+
+func (pw *pluginWriter) Write(b []byte) (int, error) {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	if pw.discard {
+		return len(b), nil
+	}
+	return pw.ResponseWriter.Write(b)
+}
+
+func (pw *pluginWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	if pw.discard {
+		return io.Copy(io.Discard, src)
+	}
+	return io.Copy(pw.ResponseWriter, src)
+}
+
+func (pw *pluginWriter) Flush() {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	_ = http.NewResponseController(pw.ResponseWriter).Flush()
+}
+
+func (pw *pluginWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
+
+// pluginHostModule builds the "proxy" module that plugins import. Values
+// are passed as a pointer and length into the plugin's memory. Functions
+// returning a value write it to the buffer given when it fits and return
+// its length either way, so a plugin can retry with a larger buffer;
+// get_header returns -1 for a missing header.
+func pluginHostModule(rt wazero.Runtime) wazero.HostModuleBuilder {
+	b := rt.NewHostModuleBuilder("proxy")
+	export := func(name string, fn any) {
+		b.NewFunctionBuilder().WithFunc(fn).Export(name)
+	}
+
+	export("get_config", func(ctx context.Context, m api.Module, buf, size uint32) uint32 {
+		return writeGuest(m, buf, size, currentPluginCall(ctx).plugin.config)
+	})
+	export("get_method", func(ctx context.Context, m api.Module, buf, size uint32) uint32 {
+		return writeGuest(m, buf, size, currentPluginCall(ctx).r.Method)
+	})
+	export("get_uri", func(ctx context.Context, m api.Module, buf, size uint32) uint32 {
+		return writeGuest(m, buf, size, currentPluginCall(ctx).r.URL.RequestURI())
+	})
+	export("set_uri", func(ctx context.Context, m api.Module, ptr, size uint32) {
+		c := currentPluginCall(ctx)
+		uri := readGuest(m, ptr, size)
+		u, err := url.ParseRequestURI(uri)
+		if err != nil || u.Host != "" {
+			panic(fmt.Errorf("set_uri: invalid URI %q", uri))
+		}
+		c.r.URL.Path, c.r.URL.RawPath, c.r.URL.RawQuery = u.Path, u.RawPath, u.RawQuery
+	})
+	export("get_client_ip", func(ctx context.Context, m api.Module, buf, size uint32) uint32 {
+		var ip string
+		if addr := clientAddr(currentPluginCall(ctx).r); addr.IsValid() {
+			ip = addr.String()
+		}
+		return writeGuest(m, buf, size, ip)
+	})
+	export("get_header", func(ctx context.Context, m api.Module, kind, name, nameSize, buf, size uint32) int32 {
+		values := currentPluginCall(ctx).headers(kind).Values(readGuest(m, name, nameSize))
+		if len(values) == 0 {
+			return -1
+		}
+		return int32(writeGuest(m, buf, size, strings.Join(values, ", ")))
+	})
+	export("set_header", func(ctx context.Context, m api.Module, kind, name, nameSize, value, valueSize uint32) {
+		k, v := readHeader(m, name, nameSize, value, valueSize)
+		currentPluginCall(ctx).headers(kind).Set(k, v)
+	})
+	export("add_header", func(ctx context.Context, m api.Module, kind, name, nameSize, value, valueSize uint32) {
+		k, v := readHeader(m, name, nameSize, value, valueSize)
+		currentPluginCall(ctx).headers(kind).Add(k, v)
+	})
+	export("remove_header", func(ctx context.Context, m api.Module, kind, name, nameSize uint32) {
+		currentPluginCall(ctx).headers(kind).Del(readGuest(m, name, nameSize))
+	})
+	export("get_status", func(ctx context.Context) uint32 {
+		return uint32(currentPluginCall(ctx).status)
+	})
+	export("set_body", func(ctx context.Context, m api.Module, ptr, size uint32) {
+		currentPluginCall(ctx).body = []byte(readGuest(m, ptr, size))
+	})
+	export("log", func(ctx context.Context, m api.Module, level, ptr, size uint32) {
+		c := currentPluginCall(ctx)
+		logRequest(c.r, pluginLogLevel(level), readGuest(m, ptr, size), "plugin", c.plugin.name)
+	})
+	return b
+}
+
+func currentPluginCall(ctx context.Context) *pluginCall {
+	return ctx.Value(pluginCallKey{}).(*pluginCall)
+}
+
+// headers returns the request or response headers
+func (c *pluginCall) headers(kind uint32) http.Header {
+	switch kind {
+	case pluginRequestHeaders:
+		return c.r.Header
+	case pluginResponseHeaders:
+		return c.header
+	}
+	panic(fmt.Errorf("invalid header kind %d", kind))
+}
+
+// pluginLogLevel maps the log levels of the ABI, 0 (debug) to 3 (error)
+func pluginLogLevel(level uint32) slog.Level {
+	switch level {
+	case 0:
+		return slog.LevelDebug
+	case 1:
+		return slog.LevelInfo
+	case 2:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+// readGuest reads a string from the plugin's memory. Panics in host
+// functions fail the plugin's call.
+func readGuest(m api.Module, ptr, size uint32) string {
+	b, ok := m.Memory().Read(ptr, size)
+	if !ok {
+		panic(fmt.Errorf("out of bounds memory access at %d+%d", ptr, size))
+	}
+	return string(b)
+}
+
+// writeGuest writes v to the plugin's buffer if it fits and returns its
+// length
+func writeGuest(m api.Module, buf, size uint32, v string) uint32 {
+	if len(v) <= int(size) && !m.Memory().WriteString(buf, v) {
+		panic(fmt.Errorf("out of bounds memory access at %d+%d", buf, size))
+	}
+	return uint32(len(v))
+}
+
+// readHeader reads a header field the plugin sets, which must be valid so
+// it can't break the requests and responses it is sent in
+func readHeader(m api.Module, name, nameSize, value, valueSize uint32) (string, string) {
+	k, v := readGuest(m, name, nameSize), readGuest(m, value, valueSize)
+	if !httpguts.ValidHeaderFieldName(k) || !httpguts.ValidHeaderFieldValue(v) {
+		panic(fmt.Errorf("invalid header %q: %q", k, v))
+	}
+	return k, v
+}
@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// WebAssembly opcodes and types the test module uses
+const (
+	wasmI32         = 0x7f
+	wasmUnreachable = 0x00
+	wasmIf          = 0x04
+	wasmEnd         = 0x0b
+	wasmReturn      = 0x0f
+	wasmCall        = 0x10
+	wasmLocalTee    = 0x22
+	wasmLocalGet    = 0x20
+	wasmI32Const    = 0x41
+	wasmI32Ne       = 0x47
+	wasmEmptyBlock  = 0x40
+)
+
+// wasmLEB encodes v as a signed LEB128; for non-negative values that is
+// also a valid unsigned encoding
+func wasmLEB(v int64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func wasmVec(items ...[]byte) []byte {
+	b := wasmLEB(int64(len(items)))
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}
+
+func wasmName(s string) []byte {
+	return append(wasmLEB(int64(len(s))), s...)
+}
+
+func wasmSection(id byte, content []byte) []byte {
+	return append(append([]byte{id}, wasmLEB(int64(len(content)))...), content...)
+}
+
+func wasmConst(v int64) []byte {
+	return append([]byte{wasmI32Const}, wasmLEB(v)...)
+}
+
+func wasmCode(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// Strings in the test module's memory, and the buffer header values are
+// read into
+var pluginTestData = []struct {
+	offset int64
+	value  string
+}{
+	{0, "X-In"}, {16, "X-Out"}, {32, "blocked"}, {64, "X-Block"}, {80, "X-Crash"},
+	{96, "X-Bad"}, {112, "bad name"}, {128, "X-Plugin"}, {144, "seen"},
+}
+
+const pluginTestBuffer = 256
+
+// pluginTestModule assembles a plugin that exercises the host ABI:
+//
+//   - on_request copies the request header X-In to X-Out, answers 403 with
+//     the body "blocked" when X-Block is present, traps when X-Crash is, and
+//     sets an invalid header name when X-Bad is
+//   - on_response sets the response header X-Plugin: seen
+func pluginTestModule() []byte {
+	const (
+		getHeader = iota // imported functions
+		setHeader
+		setBody
+	)
+	// hasHeader leaves whether the request header at name is present
+	hasHeader := func(name, size int64) []byte {
+		return wasmCode(wasmConst(pluginRequestHeaders), wasmConst(name), wasmConst(size),
+			wasmConst(pluginTestBuffer), wasmConst(0), []byte{wasmCall, getHeader},
+			wasmConst(-1), []byte{wasmI32Ne})
+	}
+	onRequest := wasmCode(
+		[]byte{1, 1, wasmI32}, // one i32 local: the length of X-In
+		wasmConst(pluginRequestHeaders), wasmConst(0), wasmConst(4), wasmConst(pluginTestBuffer), wasmConst(64),
+		[]byte{wasmCall, getHeader, wasmLocalTee, 0},
+		wasmConst(-1), []byte{wasmI32Ne, wasmIf, wasmEmptyBlock},
+		wasmConst(pluginRequestHeaders), wasmConst(16), wasmConst(5), wasmConst(pluginTestBuffer), []byte{wasmLocalGet, 0},
+		[]byte{wasmCall, setHeader, wasmEnd},
+
+		hasHeader(64, 7), []byte{wasmIf, wasmEmptyBlock},
+		wasmConst(32), wasmConst(7), []byte{wasmCall, setBody},
+		wasmConst(http.StatusForbidden), []byte{wasmReturn, wasmEnd},
+
+		hasHeader(80, 7), []byte{wasmIf, wasmEmptyBlock, wasmUnreachable, wasmEnd},
+
+		hasHeader(96, 5), []byte{wasmIf, wasmEmptyBlock},
+		wasmConst(pluginRequestHeaders), wasmConst(112), wasmConst(8), wasmConst(32), wasmConst(7),
+		[]byte{wasmCall, setHeader, wasmEnd},
+
+		wasmConst(0), []byte{wasmEnd},
+	)
+	onResponse := wasmCode(
+		[]byte{0}, // no locals
+		wasmConst(pluginResponseHeaders), wasmConst(128), wasmConst(8), wasmConst(144), wasmConst(4),
+		[]byte{wasmCall, setHeader, wasmEnd},
+	)
+
+	i32s := func(n int) []byte {
+		b := wasmLEB(int64(n))
+		for i := 0; i < n; i++ {
+			b = append(b, wasmI32)
+		}
+		return b
+	}
+	funcType := func(params int, results int) []byte {
+		return wasmCode([]byte{0x60}, i32s(params), i32s(results))
+	}
+	importFunc := func(name string, typ byte) []byte {
+		return wasmCode(wasmName("proxy"), wasmName(name), []byte{0x00, typ})
+	}
+	export := func(name string, kind, index byte) []byte {
+		return wasmCode(wasmName(name), []byte{kind, index})
+	}
+	var data [][]byte
+	for _, d := range pluginTestData {
+		data = append(data, wasmCode([]byte{0}, wasmConst(d.offset), []byte{wasmEnd}, wasmName(d.value)))
+	}
+
+	return wasmCode(
+		[]byte("\x00asm\x01\x00\x00\x00"),
+		wasmSection(1, wasmVec(
+			funcType(5, 1), // get_header
+			funcType(5, 0), // set_header
+			funcType(2, 0), // set_body
+			funcType(0, 1), // on_request
+			funcType(1, 0), // on_response
+		)),
+		wasmSection(2, wasmVec(
+			importFunc("get_header", 0),
+			importFunc("set_header", 1),
+			importFunc("set_body", 2),
+		)),
+		wasmSection(3, wasmVec([]byte{3}, []byte{4})),
+		wasmSection(5, wasmVec([]byte{0x00, 1})), // one page
+		wasmSection(7, wasmVec(
+			export("memory", 0x02, 0),
+			export("on_request", 0x00, 3),
+			export("on_response", 0x00, 4),
+		)),
+		wasmSection(10, wasmVec(
+			append(wasmLEB(int64(len(onRequest))), onRequest...),
+			append(wasmLEB(int64(len(onResponse))), onResponse...),
+		)),
+		wasmSection(11, wasmVec(data...)),
+	)
+}
+
+func newTestPlugins(t *testing.T, failOpen bool) *plugins {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.wasm")
+	if err := os.WriteFile(path, pluginTestModule(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ps, err := newPlugins([]config.PluginConfig{{
+		Name:         "test",
+		Path:         path,
+		Timeout:      time.Second,
+		MaxInstances: 1,
+		FailOpen:     failOpen,
+	}}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ps.close)
+	return ps
+}
+
+func TestPluginHostABI(t *testing.T) {
+	ps := newTestPlugins(t, false)
+	var seen http.Header
+	handler := ps.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		io.WriteString(w, "backend")
+	}))
+
+	tests := []struct {
+		name       string
+		header     map[string]string
+		status     int
+		body       string
+		wantOut    string // X-Out the backend received
+		wantPlugin string // X-Plugin of the response
+	}{
+		{name: "header copied", header: map[string]string{"X-In": "hello"}, status: http.StatusOK, body: "backend", wantOut: "hello", wantPlugin: "seen"},
+		{name: "header missing", status: http.StatusOK, body: "backend", wantPlugin: "seen"},
+		{name: "answered by the plugin", header: map[string]string{"X-Block": "1"}, status: http.StatusForbidden, body: "blocked"},
+		{name: "trap", header: map[string]string{"X-Crash": "1"}, status: http.StatusInternalServerError, body: "Plugin failed\n"},
+		{name: "invalid header from the plugin", header: map[string]string{"X-Bad": "1"}, status: http.StatusInternalServerError, body: "Plugin failed\n"},
+		// The failed calls closed their instance; a new one takes over
+		{name: "after failures", header: map[string]string{"X-In": "again"}, status: http.StatusOK, body: "backend", wantOut: "again", wantPlugin: "seen"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status || w.Body.String() != tt.body {
+				t.Errorf("response %d %q, want %d %q", w.Code, w.Body.String(), tt.status, tt.body)
+			}
+			if tt.status == http.StatusOK && seen == nil {
+				t.Fatal("request didn't reach the backend")
+			}
+			if tt.status != http.StatusOK && seen != nil {
+				t.Error("refused request reached the backend")
+			}
+			if got := seen.Get("X-Out"); got != tt.wantOut {
+				t.Errorf("backend received X-Out %q, want %q", got, tt.wantOut)
+			}
+			if got := w.Header().Get("X-Plugin"); got != tt.wantPlugin {
+				t.Errorf("X-Plugin %q, want %q", got, tt.wantPlugin)
+			}
+		})
+	}
+}
+
+func TestPluginFailOpen(t *testing.T) {
+	ps := newTestPlugins(t, true)
+	reached := false
+	handler := ps.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Crash", "1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if !reached || w.Code != http.StatusOK {
+		t.Errorf("fail_open plugin trapped: status %d, reached backend %v", w.Code, reached)
+	}
+}
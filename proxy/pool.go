@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// backendPool is a set of backends that share a load balancer. Requests are
+// routed to a pool first and the pool's balancer then picks a member. The
+// members can change at runtime when backends are discovered through DNS.
+type backendPool struct {
+	name     string
+	balancer func([]*Backend) LoadBalancer
+	members  atomic.Pointer[poolMembers]
+	timeouts upstreamTimeouts // for routes without their own
+	queue    backendQueue     // requests waiting while all members are at their cap
+}
+
+// poolMembers is one generation of a pool's backends with their balancer
+type poolMembers struct {
+	backends     []*Backend
+	loadBalancer LoadBalancer
+}
+
+func newPool(name string, backends []*Backend, balancer func([]*Backend) LoadBalancer) *backendPool {
+	pool := &backendPool{name: name, balancer: balancer}
+	pool.setBackends(backends)
+	return pool
+}
+
+// newBackendPool creates the pool's backends and a load balancer configured
+// by lbConfig
+func (rp *ReverseProxy) newBackendPool(name string, cfgs []config.Backend, lbConfig config.LoadBalancerConfig, transports *transportBuilder) (*backendPool, error) {
+	backends := make([]*Backend, 0, len(cfgs))
+	for _, b := range cfgs {
+		if b.Discovered() {
+			// Expanded into members by the DNS discovery
+			continue
+		}
+		backend, err := rp.newBackend(b, transports)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, backend)
+	}
+	pool := newPool(name, backends, func(backends []*Backend) LoadBalancer {
+		return rp.newPoolBalancer(lbConfig, backends)
+	})
+	pool.timeouts = rp.timeouts()
+	rp.discovery.watch(pool, cfgs, rp.newBackend)
+	return pool, nil
+}
+
+// backends returns the current members of the pool
+func (p *backendPool) backends() []*Backend {
+	return p.members.Load().backends
+}
+
+// NextBackend picks a member with the pool's load balancer. A backend in
+// its slow start window turns away part of its picks to the balancer's next
+// choice.
+func (p *backendPool) NextBackend(r *http.Request) *Backend {
+	lb := p.members.Load().loadBalancer
+	backend := lb.NextBackend(r)
+	for i := 0; i < slowStartAttempts && backend != nil && !backend.admit(); i++ {
+		backend = lb.NextBackend(r)
+	}
+	return backend
+}
+
+// setBackends replaces the members of the pool
+func (p *backendPool) setBackends(backends []*Backend) {
+	p.members.Store(&poolMembers{backends: backends, loadBalancer: p.balancer(backends)})
+}
+
+// backend returns the member with the given URL, or nil
+func (p *backendPool) backend(rawURL string) *Backend {
+	for _, b := range p.backends() {
+		if b.URL.String() == rawURL {
+			return b
+		}
+	}
+	return nil
+}
+
+// preferred reports whether b belongs to the highest priority group that
+// has a backend in service, so that requests pinned to a backup return to
+// the primaries once they recover
+func (p *backendPool) preferred(b *Backend) bool {
+	for _, other := range p.backends() {
+		if other.Priority < b.Priority && other.inService() {
+			return false
+		}
+	}
+	return true
+}
@@ -1,15 +1,23 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/bunnydevv/reverse-proxy/cluster"
 	"github.com/bunnydevv/reverse-proxy/config"
 )
 
@@ -17,70 +25,365 @@ type ReverseProxy struct {
 	config       *config.Config
 	server       *http.Server
 	backends     []*Backend
-	loadBalancer LoadBalancer
+	vhosts       *vhostRouter
+	certificates *certificateStore
+	http3        *http3Listener
+	listeners    []*extraListener
+	streams      []*streamProxy
+	canary       *canaryController
+	discovery    *backendDiscovery
+	containers   *dockerProvider
 	healthCheck  *HealthChecker
+	passive      *passiveHealthMonitor
+	notifier     *healthNotifier // nil without a health webhook
+	maintenance  *maintenanceScheduler
+	cluster      *cluster.Node
+	sessions     SessionStore
+	sticky       *stickySessions
+	retry        *retryPolicy
+	failover     *connectFailover
+	headers      *headerRules
+	forwarded    *forwardedHeaders
+	connHeaders  *connectionHeaders
+	redirects    *redirects
+	requestIDs   *requestIDs
+	limiter      *concurrencyLimiter
+	clientLimit  *clientLimiter
+	tenants      *tenants
+	idempotency  *idempotencyCache
+	access       *accessList
+	geoIP        *geoIP
+	blocklists   *blocklistManager
+	userAgents   *userAgentFilter
+	jwt          *jwtAuthenticator
+	forwardAuth  *forwardAuth
+	plugins      *plugins
+	extensions   extensions // registered by programs embedding the proxy
+	apiKeys      *apiKeyAuth
+	cors         *cors
+	security     *securityHeaders
+	tracer       *tracer
+	errorPages   *errorPages
+	maintMode    *maintenanceMode
+	compression  *compressor
+	cache        *responseCache
+	faults       *faultInjector
+	captures     *bodyCapture
+	dashboard    *dashboard // nil unless the admin API serves it
+	admin        *adminServer
+	metrics      *metrics
+	conns        *connTracker
+	started      time.Time
+	configHash   string
+	handler      http.Handler
+	sockets      *socketRegistry
+	buffers      *bufferPool // copy buffers of routes that stream
 	mu           sync.RWMutex
+
+	// Set by options
+	logger    *slog.Logger
+	balancer  func([]*Backend) LoadBalancer // nil uses the configured algorithms
+	tlsConfig *tls.Config                   // nil uses the tls section
 }
 
 type Backend struct {
-	URL          *url.URL
-	Proxy        *httputil.ReverseProxy
-	Alive        bool
-	Weight       int
-	Connections  int
-	mu           sync.RWMutex
+	URL         *url.URL
+	Proxy       *httputil.ReverseProxy
+	Canary      bool
+	Priority    int
+	maintenance []maintenanceWindow
+	healthCheck *config.BackendHealthCheckConfig
+	dial        dialFunc // opens connections subject to the egress settings
+	latency     latencyEWMA
+	slowStart   config.SlowStartConfig
+	maxConns    int    // 0 is unlimited
+	hostHeader  string // see config.Backend.HostHeader
+	mu          sync.RWMutex
+
+	// Read on every request by the balancers, so kept outside mu
+	alive       atomic.Bool
+	draining    atomic.Bool
+	connections atomic.Int64
+	weight      atomic.Int64
+
+	warmingSince time.Time // start of the slow start window; zero once warm
 }
 
-func New(cfg *config.Config) (*ReverseProxy, error) {
-	if len(cfg.Backends) == 0 {
+// New creates a proxy for cfg. Programs embedding the proxy can customize
+// it with opts, and serve it as an http.Handler after StartBackground
+// instead of calling Start.
+func New(cfg *config.Config, opts ...Option) (*ReverseProxy, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.logger == nil {
+		o.logger = slog.Default()
+	}
+
+	if len(cfg.Backends) == 0 && len(cfg.Pools) == 0 && len(cfg.VHosts) == 0 && len(cfg.Streams) == 0 && !cfg.Docker.Enabled {
 		return nil, fmt.Errorf("no backends configured")
 	}
 
 	rp := &ReverseProxy{
-		config:   cfg,
-		backends: make([]*Backend, 0, len(cfg.Backends)),
+		config:     cfg,
+		retry:      newRetryPolicy(cfg.Retry),
+		failover:   newConnectFailover(cfg.ConnectFailover),
+		headers:    newHeaderRules(&cfg.Headers),
+		metrics:    newMetrics(cfg.Metrics),
+		conns:      newConnTracker(cfg.Limits.MaxClientConnections),
+		started:    time.Now(),
+		configHash: configHash(cfg),
+		sockets:    newSocketRegistry(),
+		buffers:    newBufferPool(streamBufferSize),
+		logger:     o.logger,
+		balancer:   o.balancer,
+		tlsConfig:  o.tlsConfig,
+	}
+	rp.metrics.conns = rp.conns
+
+	transports, err := newTransportBuilder(cfg, o.transport, rp.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	rp.metrics.statsd, err = newStatsDSink(cfg.Metrics.StatsD)
+	if err != nil {
+		return nil, err
+	}
+
+	// Backends with resolve, srv or discovery set are expanded at runtime
+	rp.discovery, err = newBackendDiscovery(rp, transports)
+	if err != nil {
+		return nil, err
 	}
 
 	// Initialize backends
-	for _, b := range cfg.Backends {
-		backendURL, err := url.Parse(b.URL)
+	defaultPool, err := rp.newBackendPool("", cfg.Backends, cfg.LoadBalancer, transports)
+	if err != nil {
+		return nil, err
+	}
+
+	// A canary split takes over backend selection while it is configured
+	rp.canary = newCanaryController(cfg.Canary, rp.logger)
+	if rp.canary != nil {
+		defaultPool.balancer = func(backends []*Backend) LoadBalancer {
+			return rp.canary.split(backends, func(backends []*Backend) LoadBalancer {
+				return rp.newPoolBalancer(cfg.LoadBalancer, backends)
+			})
+		}
+		defaultPool.setBackends(defaultPool.backends())
+	}
+
+	// Initialize backend pools and the routes that select them
+	pools := make(map[string]*backendPool, len(cfg.Pools))
+	for name, p := range cfg.Pools {
+		pool, err := rp.newBackendPool(name, p.Backends, p.LoadBalancer(cfg.LoadBalancer), transports)
 		if err != nil {
-			return nil, fmt.Errorf("invalid backend URL %s: %w", b.URL, err)
+			return nil, fmt.Errorf("pool %s: %w", name, err)
+		}
+		pool.timeouts = pool.timeouts.override(p.Timeouts)
+		if p.UpstreamsFile != "" {
+			if err := rp.discovery.watchFile(pool, p, rp.newBackend); err != nil {
+				return nil, fmt.Errorf("pool %s: %w", name, err)
+			}
 		}
+		pools[name] = pool
+	}
 
-		weight := b.Weight
-		if weight == 0 {
-			weight = 1
+	// Initialize virtual hosts, each with its own backends and routes
+	rp.vhosts = &vhostRouter{
+		hosts:   newHostTable[*router](),
+		unknown: cfg.UnknownHost,
+	}
+	for _, vh := range cfg.VHosts {
+		fallback := pools[vh.DefaultPool]
+		if len(vh.Backends) > 0 {
+			lbConfig := cfg.LoadBalancer
+			lbConfig.Algorithm = vh.Algorithm
+			fallback, err = rp.newBackendPool("vhost:"+vh.Hosts[0], vh.Backends, lbConfig, transports)
+			if err != nil {
+				return nil, fmt.Errorf("vhost %v: %w", vh.Hosts, err)
+			}
 		}
+		rt, err := newRouter(vh.Routes, pools, fallback, transports)
+		if err != nil {
+			return nil, fmt.Errorf("vhost %v: %w", vh.Hosts, err)
+		}
+		for _, host := range vh.Hosts {
+			rp.vhosts.hosts.add(host, rt)
+		}
+	}
+
+	// Hosts named by container labels come after the configured ones
+	rp.containers = newDockerProvider(cfg.Docker, rp, transports)
+	rp.vhosts.containers = rp.containers
 
-		backend := &Backend{
-			URL:    backendURL,
-			Proxy:  httputil.NewSingleHostReverseProxy(backendURL),
-			Alive:  true,
-			Weight: weight,
+	// Requests for other hosts use the top-level routes and backends
+	if !cfg.UnknownHost.Enabled() {
+		fallback := pools[cfg.DefaultPool]
+		if len(cfg.Backends) > 0 {
+			fallback = defaultPool
 		}
+		rp.vhosts.fallback, err = newRouter(cfg.Routes, pools, fallback, transports)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Initialize TCP streams
+	for _, sc := range cfg.Streams {
+		stream, err := rp.newStreamProxy(sc, transports)
+		if err != nil {
+			return nil, fmt.Errorf("stream %s: %w", sc.Name, err)
+		}
+		rp.streams = append(rp.streams, stream)
+	}
+
+	rp.certificates, err = newCertificateStore(cfg, rp.logger)
+	if err != nil {
+		return nil, err
+	}
 
-		// Customize error handler
-		backend.Proxy.ErrorHandler = rp.errorHandler
+	// Initialize maintenance scheduling
+	rp.maintenance = newMaintenanceScheduler(rp.backendList, rp.logger)
 
-		rp.backends = append(rp.backends, backend)
+	// Initialize cluster membership
+	if cfg.Cluster.Enabled {
+		rp.cluster = cluster.New(cfg.Cluster, rp.logger)
+		rp.cluster.Subscribe(healthKeyPrefix, rp.applyPeerHealth)
 	}
 
-	// Initialize load balancer
-	switch cfg.LoadBalancer.Algorithm {
-	case "round-robin":
-		rp.loadBalancer = NewRoundRobinBalancer(rp.backends)
-	case "least-connections":
-		rp.loadBalancer = NewLeastConnectionsBalancer(rp.backends)
-	case "weighted":
-		rp.loadBalancer = NewWeightedBalancer(rp.backends)
-	default:
-		rp.loadBalancer = NewRoundRobinBalancer(rp.backends)
+	// Initialize session affinity store
+	rp.sessions, err = newSessionStore(cfg.LoadBalancer.SessionStore, rp.cluster, rp.logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize session store: %w", err)
 	}
+	rp.sticky = newStickySessions(cfg.LoadBalancer.Sticky, rp.sessions, cfg.LoadBalancer.SessionStore.TTL)
 
 	// Initialize health checker
+	rp.notifier = newHealthNotifier(cfg.HealthCheck.Notify, rp.logger)
+	rp.passive = newPassiveHealthMonitor(cfg.HealthCheck.Passive, rp.logger)
+	if rp.passive != nil && rp.notifier != nil {
+		rp.passive.onChange = func(backend *Backend, alive bool, reason string) {
+			rp.notifier.notify(backend, alive, healthCheckPassive, reason)
+		}
+	}
 	if cfg.HealthCheck.Enabled {
-		rp.healthCheck = NewHealthChecker(cfg, rp.backends)
+		rp.healthCheck = NewHealthChecker(cfg, rp.backendList())
+		rp.healthCheck.logger = rp.logger
+		if rp.cluster != nil || rp.notifier != nil {
+			rp.healthCheck.onChange = rp.activeHealthChanged
+		}
+		if rp.passive != nil {
+			rp.healthCheck.ejected = rp.passive.ejected
+		}
+	}
+
+	// Assemble the request pipeline
+	rp.forwarded, err = newForwardedHeaders(cfg.Forwarded)
+	if err != nil {
+		return nil, err
+	}
+	rp.connHeaders = newConnectionHeaders(cfg.ConnectionHeaders)
+	rp.requestIDs = newRequestIDs(cfg.RequestID)
+	rp.limiter = newConcurrencyLimiter(cfg.Limits)
+	rp.clientLimit = newClientLimiter(cfg.Limits.PerClient)
+	rp.tenants = newTenants(cfg.Tenants, rp.cluster)
+	rp.metrics.tenants = rp.tenants
+	rp.idempotency = newIdempotencyCache(cfg.Idempotency)
+	rp.access, err = newAccessList(&cfg.Access)
+	if err != nil {
+		return nil, err
+	}
+	rp.geoIP, err = newGeoIP(cfg.GeoIP, rp.logger)
+	if err != nil {
+		return nil, err
+	}
+	rp.blocklists = newBlocklistManager(cfg.Blocklists, rp.logger)
+	rp.userAgents = newUserAgentFilter(cfg.UserAgents, rp.cluster)
+	rp.jwt = newJWTAuthenticator(cfg.JWT, rp.logger)
+	rp.forwardAuth = newForwardAuth(cfg.ForwardAuth)
+	rp.plugins, err = newPlugins(cfg.Plugins, rp.logger)
+	if err != nil {
+		return nil, err
+	}
+	rp.apiKeys, err = newAPIKeyAuth(cfg.APIKeys)
+	if err != nil {
+		return nil, err
+	}
+	rp.redirects = newRedirects(cfg.Redirects)
+	rp.cors = newCORS(cfg.CORS)
+	rp.security = newSecurityHeaders(cfg.Security)
+	rp.tracer = newTracer(cfg.Tracing, rp.logger)
+	rp.errorPages, err = newErrorPages(cfg.ErrorPages)
+	if err != nil {
+		return nil, err
+	}
+	routes := cfg.Routes
+	for _, vh := range cfg.VHosts {
+		routes = append(routes[:len(routes):len(routes)], vh.Routes...)
+	}
+	for _, lc := range cfg.Server.Listeners {
+		routes = append(routes[:len(routes):len(routes)], lc.Routes...)
+	}
+	rp.maintMode, err = newMaintenanceMode(cfg.Maintenance, routes, rp.errorPages.page(http.StatusServiceUnavailable))
+	if err != nil {
+		return nil, err
+	}
+	rp.compression = newCompressor(cfg.Compression)
+	rp.cache = newResponseCache(cfg.Cache, cfg.APIKeys)
+	rp.faults = newFaultInjector(cfg.Faults)
+	rp.captures = newBodyCapture(cfg.BodyCapture)
+	rp.dashboard = newDashboard(cfg.Admin)
+	rp.http3, err = newHTTP3Listener(cfg.Server.HTTP3, rp.certificates, rp, rp.logger)
+	if err != nil {
+		return nil, err
+	}
+	rp.handler = rp.buildHandler(rp.vhosts)
+
+	// Serve additional listeners with the same pipeline, unless they
+	// redirect to HTTPS or route requests differently
+	for _, lc := range cfg.Server.Listeners {
+		handler := rp.handler
+		switch {
+		case lc.RedirectHTTPS:
+			handler = httpsRedirect(cfg.Server.Address)
+		case len(lc.Routes) > 0:
+			vhosts := *rp.vhosts
+			vhosts.fallback, err = newRouter(lc.Routes, pools, rp.vhosts.fallback.fallback, transports)
+			if err != nil {
+				return nil, fmt.Errorf("listener %s: %w", lc.Address, err)
+			}
+			handler = rp.buildHandler(&vhosts)
+		}
+		if rp.certificates != nil {
+			handler = rp.certificates.challengeHandler(lc.Address, handler)
+		}
+		rp.listeners = append(rp.listeners, newExtraListener(lc, handler, cfg.Server))
+	}
+
+	// Register admin endpoints
+	rp.admin, err = newAdminServer(cfg.Admin, rp.logger)
+	if err != nil {
+		return nil, err
+	}
+	rp.admin.handle("/status", rp.statusHandler)
+	rp.admin.handle("/config", rp.configHandler)
+	rp.admin.handle("/metrics", rp.metrics.adminHandler)
+	rp.admin.handle("/faults", rp.faults.adminHandler)
+	rp.admin.handle("/captures", rp.captures.adminHandler)
+	rp.admin.handle("/weights", rp.weightsHandler)
+	rp.admin.handle("/maintenance", rp.maintMode.adminHandler)
+	if rp.cache != nil {
+		rp.admin.handle("/cache", rp.cache.adminHandler)
+	}
+	if rp.dashboard != nil {
+		rp.admin.handle("/dashboard", rp.dashboardHandler)
+		rp.admin.handle("/dashboard/data", rp.dashboardDataHandler)
+	}
+	if cfg.Admin.Debug {
+		rp.admin.registerDebug()
 	}
 
 	// Create HTTP server
@@ -95,45 +398,524 @@ func New(cfg *config.Config) (*ReverseProxy, error) {
 	return rp, nil
 }
 
+// newBackend creates a backend from its configuration and registers it with
+// the proxy so health checks and maintenance cover it
+func (rp *ReverseProxy) newBackend(b config.Backend, transports *transportBuilder) (*Backend, error) {
+	backendURL, err := url.Parse(b.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend URL %s: %w", b.URL, err)
+	}
+
+	weight := 1
+	if b.Weight != nil {
+		weight = *b.Weight
+	}
+
+	// Health probes dial the same way as the transport
+	dial, err := transports.dialer(b)
+	if err != nil {
+		return nil, fmt.Errorf("backend %s: %w", b.URL, err)
+	}
+	transport, err := transports.transport(b, dial)
+	if err != nil {
+		return nil, fmt.Errorf("backend %s: %w", b.URL, err)
+	}
+
+	windows, err := newMaintenanceWindows(b.Maintenance)
+	if err != nil {
+		return nil, fmt.Errorf("backend %s: %w", b.URL, err)
+	}
+
+	backend := &Backend{
+		URL:         backendURL,
+		Proxy:       httputil.NewSingleHostReverseProxy(backendURL),
+		Canary:      b.Canary,
+		Priority:    b.Priority,
+		maintenance: windows,
+		healthCheck: b.HealthCheck,
+		dial:        dial,
+		slowStart:   rp.config.LoadBalancer.SlowStart,
+		maxConns:    b.MaxConnections,
+		hostHeader:  b.HostHeader,
+	}
+	backend.alive.Store(true)
+	backend.weight.Store(int64(weight))
+
+	// Customize transport and error handler
+	backend.Proxy.Transport = transport
+	backend.Proxy.ErrorHandler = rp.errorHandler
+	backend.Proxy.ModifyResponse = rp.modifyResponse
+
+	rp.addBackend(backend)
+	return backend, nil
+}
+
+// addBackend registers a backend with the proxy
+func (rp *ReverseProxy) addBackend(backend *Backend) {
+	rp.mu.Lock()
+	rp.backends = append(rp.backends, backend)
+	rp.mu.Unlock()
+}
+
+// removeBackend unregisters a backend that left its pool and stops checking
+// its health
+func (rp *ReverseProxy) removeBackend(backend *Backend) {
+	rp.mu.Lock()
+	for i, b := range rp.backends {
+		if b == backend {
+			rp.backends = append(rp.backends[:i:i], rp.backends[i+1:]...)
+			break
+		}
+	}
+	rp.mu.Unlock()
+
+	if rp.healthCheck != nil {
+		rp.healthCheck.remove(backend)
+	}
+	rp.passive.forget(backend)
+}
+
+// backendList returns a snapshot of all registered backends
+func (rp *ReverseProxy) backendList() []*Backend {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+	return append([]*Backend(nil), rp.backends...)
+}
+
 func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rp.handler.ServeHTTP(w, r)
+}
+
+// proxyRequest forwards a request to the next backend chosen by the load
+// balancer of the pool its virtual host and route select
+func (rp *ReverseProxy) proxyRequest(w http.ResponseWriter, r *http.Request, vhosts *vhostRouter) {
+	rt := vhosts.route(r.Host)
+	if rt == nil {
+		vhosts.serveUnknownHost(w, r)
+		return
+	}
+
+	route := rt.match(r)
+	if route.pool == nil && route.static == nil {
+		http.NotFound(w, r)
+		return
+	}
+	r = withRoute(r, route.pattern())
+
+	// SLOs cover everything the proxy does for the request
+	w, endSLO := route.slo.track(w, r, rp.metrics)
+	defer endSLO()
+
+	// Requests are traced from the moment their route is known
+	w, r, endSpan := rp.tracer.start(w, r, route.tracing)
+	defer endSpan()
+
+	// Captured exchanges show the response as it leaves the proxy
+	w, endCapture := rp.captures.start(w, r, route.pattern())
+	defer endCapture()
+
+	// Header rules apply to everything sent from here on, including errors.
+	// Security headers are added first so that header rules can override
+	// or remove them.
+	w = rewriteHeaders(w, r, rp.headers, route.headers)
+	w = rp.security.wrap(w, r, route.securityHeaders)
+
+	if !rp.maintMode.authorize(w, r, route) || !route.access.authorize(w, r) || !route.auth.authorize(w, r) {
+		return
+	}
+
+	// Oversized bodies are refused before any of them reaches a backend
+	if !rp.limitBody(w, r, route.maxBody) {
+		return
+	}
+
+	// Signatures and schemas cover the body, so they are checked once it
+	// is bounded
+	if !route.signed.authorize(w, r, rp.serveError) || !route.openAPI.authorize(w, r, rp.serveError) {
+		return
+	}
+
+	// Backends and mirrors see the rewritten URL
+	route.rewrite.apply(r)
+
+	// A dry run ends once the request is ready to be sent on
+	if res := dryRunFromContext(r.Context()); res != nil {
+		res.record(r, route)
+		return
+	}
+
+	if route.static != nil {
+		route.static.serve(w, r)
+		return
+	}
+
+	// Shadow traffic is sent regardless of how the request is answered
+	route.mirror.send(r)
+
+	// Backends are offered only the encodings the route allows
+	r = withUpstreamEncoding(r, route.encoding)
+
+	fetch := func(w http.ResponseWriter, r *http.Request) {
+		route.hints.send(w, r)
+		rp.forward(w, r, route)
+	}
+	if route.stream {
+		fetch(w, r)
+		return
+	}
+
+	// Fresh cached responses are served without reaching a backend
+	rp.cache.serve(w, r, route.cache, fetch)
+}
+
+// forward sends a request to a backend of the route's pool, retrying on
+// another backend when the policy allows and failing over to another one
+// when connecting fails
+func (rp *ReverseProxy) forward(w http.ResponseWriter, r *http.Request, route route) {
+	pool := route.pool
+
 	// Get next backend
-	backend := rp.loadBalancer.NextBackend()
+	backend := rp.pick(w, r, pool)
+	if backend == nil && pool.saturated() {
+		w.Header().Set("Retry-After", "1")
+		rp.serveError(w, r, http.StatusServiceUnavailable, "All backends are at capacity", nil)
+		logRequest(r, slog.LevelWarn, "All backends are at capacity", "pool", pool.name)
+		return
+	}
 	if backend == nil {
-		http.Error(w, "No healthy backends available", http.StatusServiceUnavailable)
-		log.Printf("No healthy backends available for request: %s %s", r.Method, r.URL.Path)
+		rp.serveError(w, r, http.StatusServiceUnavailable, "No healthy backends available", nil)
+		logRequest(r, slog.LevelError, "No healthy backends available", "pool", pool.name)
 		return
 	}
 
-	// Track connection
-	backend.mu.Lock()
-	backend.Connections++
-	backend.mu.Unlock()
+	attempts, body := rp.retry.prepare(r)
+	connects := rp.failover.attempts()
+	var unread *unreadBody
+	if connects > 1 && body == nil && r.Body != nil && r.Body != http.NoBody {
+		unread = &unreadBody{ReadCloser: r.Body}
+		r.Body = unread
+	}
+	var aw *attemptWriter
+	if attempts > 1 || connects > 1 {
+		aw = &attemptWriter{ResponseWriter: w}
+		w = aw
+	}
+	var tried []*Backend
+	for attempt := 1; ; attempt++ {
+		req := r
+		var state *retryAttempt
+		if attempt < attempts || attempt < connects {
+			state = &retryAttempt{connectOnly: attempt >= attempts}
+			req = r.WithContext(context.WithValue(r.Context(), retryAttemptKey{}, state))
+		}
+		if body != nil {
+			req.Body = io.NopCloser(bytes.NewReader(body))
+		}
 
-	defer func() {
-		backend.mu.Lock()
-		backend.Connections--
-		backend.mu.Unlock()
-	}()
+		rp.serveBackend(w, req, route, backend)
+		if state == nil || state.err == nil {
+			return
+		}
+		if aw.wrote {
+			// Part of a response already reached the client
+			logRequest(r, slog.LevelWarn, "Not retrying partly answered request", "backend", backend.URL.String(), "error", state.err)
+			if !aw.wroteHeader {
+				rp.serveError(w, r, http.StatusBadGateway, "Bad Gateway", state.err)
+			}
+			return
+		}
+
+		// The attempt failed without answering the client; try another backend
+		tried = append(tried, backend)
+		if state.connectOnly {
+			// A failed connect needs no backoff, but the body must be intact
+			if unread != nil && unread.read {
+				logRequest(r, slog.LevelError, "Proxy error", "backend", backend.URL.String(), "error", state.err)
+				rp.serveError(w, r, http.StatusBadGateway, "Bad Gateway", state.err)
+				return
+			}
+			logRequest(r, slog.LevelWarn, "Failing over to another backend", "backend", backend.URL.String(),
+				"attempt", attempt, "error", state.err)
+		} else {
+			delay := rp.retry.backoff(attempt)
+			logRequest(r, slog.LevelWarn, "Retrying request", "backend", backend.URL.String(),
+				"attempt", attempt, "delay", delay, "error", state.err)
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		backend = rp.retry.nextBackend(r, pool, tried)
+		// Failing over to a backend that already refused the connection is futile
+		if backend != nil && state.connectOnly && slices.Contains(tried, backend) {
+			backend = nil
+		}
+		if backend == nil || !backend.acquire() {
+			rp.serveError(w, r, http.StatusBadGateway, "Bad Gateway", state.err)
+			return
+		}
+	}
+}
+
+// serveBackend proxies one attempt of a request to a backend of the route's
+// pool, releasing the backend's connection slot
+func (rp *ReverseProxy) serveBackend(w http.ResponseWriter, r *http.Request, route route, backend *Backend) {
+	defer rp.release(route.pool, backend)
 
 	// Log request
-	log.Printf("Proxying request: %s %s -> %s", r.Method, r.URL.Path, backend.URL.String())
+	logRequest(r, slog.LevelDebug, "Proxying request", "backend", backend.URL.String())
 
-	// Proxy the request
-	backend.Proxy.ServeHTTP(w, r)
+	// Proxy the request, timing the phases of the backend's response
+	start := time.Now()
+	r, timing := traceUpstream(r, start)
+	spanFromContext(r.Context()).setBackend(backend)
+	r.Host = upstreamHost(r, route, backend)
+	r, release := route.timeouts.apply(r)
+	rw := newResponseWriter(w)
+	proxy := backend.Proxy
+	if route.flush != 0 || route.stream {
+		// The backend's proxy is shared by routes, so flush or stream
+		// through a copy
+		p := *backend.Proxy
+		if route.flush != 0 {
+			p.FlushInterval = route.flush
+		}
+		if route.stream {
+			p.BufferPool = rp.buffers
+		}
+		proxy = &p
+	}
+	proxy.ServeHTTP(newInformationalWriter(rw, r), r)
+	release()
+	rp.metrics.observeUpstream(backend, timing, time.Since(start))
+
+	// Only answered requests count towards the response time, so failing
+	// fast doesn't make a backend look attractive
+	if _, ttfb := timing.durations(); ttfb > 0 {
+		backend.latency.observe(ttfb, rp.config.LoadBalancer.LeastResponseTime.DecayTime)
+	}
+
+	status := rw.status
+	if a := attemptFromContext(r.Context()); a != nil && a.err != nil {
+		status = http.StatusBadGateway
+	}
+	if rp.canary != nil {
+		rp.canary.record(backend, status, time.Since(start))
+	}
+	if rp.passive != nil {
+		rp.passive.record(backend, status)
+	}
 }
 
 func (rp *ReverseProxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
-	log.Printf("Proxy error: %v", err)
-	http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	// A body that outgrew its limit while streaming is not worth retrying
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		logRequest(r, slog.LevelWarn, "Request body too large", "limit", tooLarge.Limit)
+		rp.serveError(w, r, http.StatusRequestEntityTooLarge, bodyTooLargeMessage(tooLarge.Limit), err)
+		return
+	}
+
+	// Leave retryable failures to proxyRequest unless the client has gone
+	// away. Attempts only covered by connect failover leave failed connects.
+	if a := attemptFromContext(r.Context()); a != nil && r.Context().Err() == nil && (!a.connectOnly || isConnectError(err)) {
+		a.err = err
+		return
+	}
+
+	// A timeout of the attempt surfaces as a canceled request
+	if cause := context.Cause(r.Context()); cause != nil && r.Context().Err() != nil {
+		err = cause
+	}
+	logRequest(r, slog.LevelError, "Proxy error", "backend", r.URL.Host, "error", err)
+	if isTimeout(err) {
+		rp.serveError(w, r, http.StatusGatewayTimeout, "Gateway Timeout", err)
+		return
+	}
+	rp.serveError(w, r, http.StatusBadGateway, "Bad Gateway", err)
 }
 
+// isTimeout reports whether a backend failed to answer in time
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// Start starts the proxy's background work and serves the configured
+// listeners, blocking until the main server stops
 func (rp *ReverseProxy) Start() error {
+	if err := rp.StartBackground(); err != nil {
+		return err
+	}
+
+	ln, err := rp.listen(rp.server.Addr, rp.config.Server.ProxyProtocol)
+	if err != nil {
+		return err
+	}
+
+	// Serve HTTP/3 next to the TCP listener
+	if rp.http3 != nil {
+		if err := rp.http3.Start(rp.sockets); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	// Serve the additional listeners
+	for _, l := range rp.listeners {
+		if err := l.start(rp); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	// Accept TCP streams
+	for _, stream := range rp.streams {
+		if err := stream.Start(); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	// Every socket is open, so a previous process handing them over can
+	// start draining
+	rp.sockets.markReady(rp.logger)
+
+	switch {
+	case rp.tlsConfig != nil:
+		rp.server.TLSConfig = rp.tlsConfig.Clone()
+		return rp.server.ServeTLS(ln, "", "")
+	case rp.certificates != nil:
+		rp.server.TLSConfig = rp.certificates.tlsConfig()
+		return rp.server.ServeTLS(ln, "", "")
+	}
+	return rp.server.Serve(ln)
+}
+
+// StartBackground starts what the proxy runs besides serving requests:
+// health checks, discovery, the admin API and the like. Start calls it;
+// programs serving the proxy from their own server call it instead, and
+// Shutdown to stop it.
+func (rp *ReverseProxy) StartBackground() error {
+	// Join the cluster
+	if rp.cluster != nil {
+		ln, err := rp.sockets.listen(rp.config.Cluster.BindAddress)
+		if err != nil {
+			return fmt.Errorf("failed to listen for cluster peers: %w", err)
+		}
+		rp.cluster.Start(ln)
+	}
+
+	// Start expiring session affinity mappings, which only sticky sessions
+	// make
+	if store, ok := rp.sessions.(interface{ start() }); ok && rp.sticky != nil {
+		store.start()
+	}
+
+	// Start sending health notifications
+	if rp.notifier != nil {
+		rp.notifier.Start()
+	}
+
 	// Start health checker
 	if rp.healthCheck != nil {
 		rp.healthCheck.Start()
 	}
 
-	return rp.server.ListenAndServe()
+	// Start backend discovery
+	if rp.discovery != nil {
+		rp.discovery.Start()
+	}
+
+	// Start following docker containers
+	if rp.containers != nil {
+		rp.containers.Start()
+	}
+
+	// Start passive health monitoring
+	if rp.passive != nil {
+		rp.passive.Start()
+	}
+
+	// Start maintenance scheduler
+	if rp.maintenance != nil {
+		rp.maintenance.Start()
+	}
+
+	// Start pushing metrics
+	rp.metrics.Start()
+
+	// Start reporting spans
+	rp.tracer.Start()
+
+	// Start admin API
+	if rp.admin != nil {
+		if err := rp.admin.Start(rp.sockets); err != nil {
+			return err
+		}
+	}
+
+	// Start blocklist refreshes
+	if rp.blocklists != nil {
+		rp.blocklists.Start()
+	}
+
+	// Start GeoIP database reloads
+	if rp.geoIP != nil {
+		rp.geoIP.Start()
+	}
+
+	// Start JWKS refreshes
+	if rp.jwt != nil {
+		rp.jwt.Start()
+	}
+
+	// Start canary rollout
+	if rp.canary != nil {
+		rp.canary.Start()
+	}
+
+	// Answer ACME challenges
+	if rp.certificates != nil {
+		if err := rp.certificates.Start(rp.sockets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listen opens a listener on addr, accepting PROXY protocol headers when
+// enabled. Its connections count towards limits.max_client_connections.
+func (rp *ReverseProxy) listen(addr string, pp config.ProxyProtocolConfig) (net.Listener, error) {
+	ln, err := rp.sockets.listen(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if pp.Enabled {
+		pl, err := newProxyProtocolListener(ln, pp, rp.logger)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		return rp.conns.wrap(pl), nil
+	}
+	return rp.conns.wrap(ln), nil
+}
+
+// Upgrade starts a new process of the proxy binary with the same arguments
+// and hands it the listening sockets. It returns once the new process is
+// serving, after which this process should shut down to drain.
+func (rp *ReverseProxy) Upgrade() error {
+	return rp.sockets.upgrade(rp.logger)
 }
 
 func (rp *ReverseProxy) Shutdown() error {
@@ -142,26 +924,170 @@ func (rp *ReverseProxy) Shutdown() error {
 		rp.healthCheck.Stop()
 	}
 
+	// Stop backend discovery
+	if rp.discovery != nil {
+		rp.discovery.Stop()
+	}
+
+	// Stop following docker containers
+	if rp.containers != nil {
+		rp.containers.Stop()
+	}
+
+	// Stop passive health monitoring
+	if rp.passive != nil {
+		rp.passive.Stop()
+	}
+
+	// Send the health notifications still queued
+	if rp.notifier != nil {
+		rp.notifier.Stop()
+	}
+
+	// Stop maintenance scheduler
+	if rp.maintenance != nil {
+		rp.maintenance.Stop()
+	}
+
+	// Stop blocklist refreshes
+	if rp.blocklists != nil {
+		rp.blocklists.Stop()
+	}
+
+	// Stop GeoIP database reloads
+	if rp.geoIP != nil {
+		rp.geoIP.Stop()
+	}
+
+	// Stop JWKS refreshes
+	if rp.jwt != nil {
+		rp.jwt.Stop()
+	}
+
+	// Stop canary rollout
+	if rp.canary != nil {
+		rp.canary.Stop()
+	}
+
+	// Push the last metrics
+	rp.metrics.Stop()
+
+	// Report the last spans
+	rp.tracer.Stop()
+
+	// Persist session affinity mappings
+	if err := rp.sessions.Close(); err != nil {
+		rp.logger.Error("Failed to close session store", "error", err)
+	}
+
+	// Leave the cluster
+	if rp.cluster != nil {
+		rp.cluster.Stop()
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	// Unload plugins once the servers have finished their requests
+	defer rp.plugins.close()
+
+	// Stop admin API
+	if rp.admin != nil {
+		if err := rp.admin.Shutdown(ctx); err != nil {
+			rp.logger.Error("Failed to shut down admin API", "error", err)
+		}
+	}
+
+	// Stop the HTTP/3 listener
+	if rp.http3 != nil {
+		if err := rp.http3.Stop(); err != nil {
+			rp.logger.Error("Failed to shut down HTTP/3 listener", "error", err)
+		}
+	}
+
+	// Stop the ACME challenge listener
+	if rp.certificates != nil {
+		if err := rp.certificates.Shutdown(ctx); err != nil {
+			rp.logger.Error("Failed to shut down ACME challenge listener", "error", err)
+		}
+	}
+
+	// Stop the additional listeners
+	for _, l := range rp.listeners {
+		if err := l.server.Shutdown(ctx); err != nil {
+			rp.logger.Error("Failed to shut down listener", "address", l.server.Addr, "error", err)
+		}
+	}
+
+	// Drain TCP streams alongside the HTTP servers
+	var streams sync.WaitGroup
+	for _, stream := range rp.streams {
+		streams.Add(1)
+		go func(stream *streamProxy) {
+			defer streams.Done()
+			if err := stream.Shutdown(ctx); err != nil {
+				rp.logger.Error("Failed to drain stream", "stream", stream.config.Name, "error", err)
+			}
+		}(stream)
+	}
+	defer streams.Wait()
+
 	return rp.server.Shutdown(ctx)
 }
 
 func (b *Backend) IsAlive() bool {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-	return b.Alive
+	return b.alive.Load()
 }
 
 func (b *Backend) SetAlive(alive bool) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.Alive = alive
+	if alive && !b.alive.Load() && b.slowStart.Window > 0 {
+		// A recovered backend warms up before taking a full share
+		b.warmingSince = time.Now()
+	}
+	b.alive.Store(alive)
+}
+
+// IsDraining reports whether the backend is excluded from new traffic
+func (b *Backend) IsDraining() bool {
+	return b.draining.Load()
+}
+
+func (b *Backend) SetDraining(draining bool) {
+	b.draining.Store(draining)
+}
+
+// IsAvailable reports whether the backend may receive new requests: it is
+// alive, not draining, its weight is above 0 and it is below its
+// max_connections
+func (b *Backend) IsAvailable() bool {
+	return b.weight.Load() > 0 && b.availablePinned()
+}
+
+// inService reports whether the backend takes new requests, whether or not
+// it is at its max_connections
+func (b *Backend) inService() bool {
+	return b.alive.Load() && !b.draining.Load() && b.weight.Load() > 0
+}
+
+// availablePinned reports whether the backend may receive the requests of
+// sticky sessions pinned to it. A weight of 0 drains a backend of new
+// sessions only, so they can end on their own.
+func (b *Backend) availablePinned() bool {
+	return b.alive.Load() && !b.draining.Load() && (b.maxConns == 0 || b.connections.Load() < int64(b.maxConns))
+}
+
+// GetWeight returns the backend's current weight, which the admin API can
+// change at runtime
+func (b *Backend) GetWeight() int {
+	return int(b.weight.Load())
+}
+
+func (b *Backend) SetWeight(weight int) {
+	b.weight.Store(int64(weight))
 }
 
 func (b *Backend) GetConnections() int {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-	return b.Connections
-}
\ No newline at end of file
+	return int(b.connections.Load())
+}
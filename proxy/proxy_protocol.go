@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocolV1MaxLength is the longest valid v1 header, including CRLF
+const proxyProtocolV1MaxLength = 107
+
+// proxyProtocolListener wraps accepted connections so that their PROXY
+// protocol header is consumed and RemoteAddr reports the original client
+type proxyProtocolListener struct {
+	net.Listener
+	allowed *ipSet // nil means every peer must send a header
+	timeout time.Duration
+	logger  *slog.Logger
+}
+
+func newProxyProtocolListener(ln net.Listener, cfg config.ProxyProtocolConfig, logger *slog.Logger) (*proxyProtocolListener, error) {
+	pl := &proxyProtocolListener{Listener: ln, timeout: cfg.HeaderTimeout, logger: logger}
+	if len(cfg.AllowedSources) > 0 {
+		allowed, err := newIPSet(cfg.AllowedSources)
+		if err != nil {
+			return nil, err
+		}
+		pl.allowed = allowed
+	}
+	return pl, nil
+}
+
+func (pl *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := pl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if pl.allowed != nil {
+		peer, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+		if err != nil || !pl.allowed.Contains(peer.Addr().Unmap()) {
+			return conn, nil
+		}
+	}
+	return &proxyProtocolConn{Conn: conn, timeout: pl.timeout, logger: pl.logger}, nil
+}
+
+// proxyProtocolConn reads the header lazily on first use so a slow peer
+// doesn't block the accept loop
+type proxyProtocolConn struct {
+	net.Conn
+	timeout time.Duration
+	logger  *slog.Logger
+
+	once   sync.Once
+	reader *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyProtocolConn) init() {
+	c.once.Do(func() {
+		c.reader = bufio.NewReader(c.Conn)
+		if c.timeout > 0 {
+			_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+
+		c.remote, c.err = readProxyHeader(c.reader)
+		if c.err != nil {
+			c.logger.Warn("PROXY protocol error", "peer", c.Conn.RemoteAddr().String(), "error", c.err)
+		}
+		if c.remote == nil {
+			c.remote = c.Conn.RemoteAddr()
+		}
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+// readProxyHeader consumes a v1 or v2 header. It returns a nil address for
+// headers that carry no client address (LOCAL or UNKNOWN).
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	if sig, err := r.Peek(len(proxyProtocolV2Signature)); err == nil && bytes.Equal(sig, proxyProtocolV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	if prefix, err := r.Peek(6); err == nil && string(prefix) == "PROXY " {
+		return readProxyHeaderV1(r)
+	}
+	return nil, errors.New("missing PROXY protocol header")
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyProtocolV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("malformed v1 header")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed v1 header %q", line)
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil || ip.Is4() != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("invalid v1 source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid v1 source port %q", fields[4])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 version %d", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	// LOCAL connections (e.g. balancer health checks) carry no client
+	if header[12]&0x0f == 0 {
+		return nil, nil
+	}
+
+	switch header[13] >> 4 {
+	case 1: // IPv4
+		if len(payload) < 12 {
+			return nil, errors.New("short v2 IPv4 address block")
+		}
+		ip := netip.AddrFrom4([4]byte(payload[0:4]))
+		port := binary.BigEndian.Uint16(payload[8:10])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+	case 2: // IPv6
+		if len(payload) < 36 {
+			return nil, errors.New("short v2 IPv6 address block")
+		}
+		ip := netip.AddrFrom16([16]byte(payload[0:16]))
+		port := binary.BigEndian.Uint16(payload[32:34])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+	default:
+		// Unix sockets and unspecified families keep the peer address
+		return nil, nil
+	}
+}
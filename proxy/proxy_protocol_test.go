@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// proxyHeaderV2 builds a v2 header with the given command and address
+// family byte around payload
+func proxyHeaderV2(command, family byte, payload []byte) string {
+	header := append([]byte{}, proxyProtocolV2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return string(append(header, payload...))
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4 := []byte{192, 0, 2, 1, 198, 51, 100, 7, 0x30, 0x39, 0x01, 0xbb}
+	v6 := make([]byte, 36)
+	copy(v6, []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1})
+	binary.BigEndian.PutUint16(v6[32:], 12345)
+
+	tests := []struct {
+		name    string
+		header  string
+		want    string // client address, "" for none
+		wantErr string
+	}{
+		{name: "v1 TCP4", header: "PROXY TCP4 192.0.2.1 198.51.100.7 12345 443\r\n", want: "192.0.2.1:12345"},
+		{name: "v1 TCP6", header: "PROXY TCP6 2001:db8::1 2001:db8::2 12345 443\r\n", want: "[2001:db8::1]:12345"},
+		{name: "v1 UNKNOWN", header: "PROXY UNKNOWN\r\n"},
+		{name: "v1 UNKNOWN with addresses", header: "PROXY UNKNOWN 192.0.2.1 198.51.100.7 12345 443\r\n"},
+		{name: "v1 family mismatch", header: "PROXY TCP4 2001:db8::1 2001:db8::2 12345 443\r\n", wantErr: "invalid v1 source address"},
+		{name: "v1 bad port", header: "PROXY TCP4 192.0.2.1 198.51.100.7 123456 443\r\n", wantErr: "invalid v1 source port"},
+		{name: "v1 missing fields", header: "PROXY TCP4 192.0.2.1 198.51.100.7 12345\r\n", wantErr: "malformed v1 header"},
+		{name: "v1 without CR", header: "PROXY TCP4 192.0.2.1 198.51.100.7 12345 443\n", wantErr: "malformed v1 header"},
+		{name: "v1 oversized", header: "PROXY TCP4 " + strings.Repeat("1", proxyProtocolV1MaxLength) + "\r\n", wantErr: "malformed v1 header"},
+		{name: "v1 truncated", header: "PROXY TCP4 192.0.2.1", wantErr: "EOF"},
+		{name: "v2 PROXY IPv4", header: proxyHeaderV2(1, 0x11, v4), want: "192.0.2.1:12345"},
+		{name: "v2 PROXY IPv6", header: proxyHeaderV2(1, 0x21, v6), want: "[2001:db8::1]:12345"},
+		{name: "v2 PROXY with TLVs", header: proxyHeaderV2(1, 0x11, append(append([]byte{}, v4...), 0x04, 0, 1, 'x')), want: "192.0.2.1:12345"},
+		{name: "v2 LOCAL", header: proxyHeaderV2(0, 0x11, v4)},
+		{name: "v2 unix socket", header: proxyHeaderV2(1, 0x31, make([]byte, 216))},
+		{name: "v2 short IPv4 block", header: proxyHeaderV2(1, 0x11, v4[:8]), wantErr: "short v2 IPv4"},
+		{name: "v2 short IPv6 block", header: proxyHeaderV2(1, 0x21, v6[:20]), wantErr: "short v2 IPv6"},
+		{name: "v2 truncated payload", header: proxyHeaderV2(1, 0x11, v4)[:20], wantErr: "EOF"},
+		{name: "v2 truncated header", header: proxyHeaderV2(1, 0x11, v4)[:14], wantErr: "EOF"},
+		{name: "v2 wrong version", header: strings.Replace(proxyHeaderV2(1, 0x11, v4), "\x21\x11", "\x31\x11", 1), wantErr: "unsupported v2 version"},
+		{name: "no header", header: "GET / HTTP/1.1\r\n", wantErr: "missing PROXY protocol header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Invalid headers are all the peer sends, so truncated ones end
+			// the stream
+			data := tt.header
+			if tt.wantErr == "" {
+				data += "GET / HTTP/1.1\r\n"
+			}
+			r := bufio.NewReader(strings.NewReader(data))
+			addr, err := readProxyHeader(r)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.want {
+				t.Errorf("address %q, want %q", got, tt.want)
+			}
+			// Exactly the header is consumed
+			if rest, _ := io.ReadAll(r); string(rest) != "GET / HTTP/1.1\r\n" {
+				t.Errorf("left %q after the header", rest)
+			}
+		})
+	}
+}
+
+// acceptProxied sends data to a proxyProtocolListener allowing sources and
+// returns the accepted connection's remote address and what it reads
+func acceptProxied(t *testing.T, sources []string, data string) (string, string, error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	pl, err := newProxyProtocolListener(ln, config.ProxyProtocolConfig{AllowedSources: sources, HeaderTimeout: time.Second}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := io.WriteString(client, data); err != nil {
+		t.Fatal(err)
+	}
+	client.(*net.TCPConn).CloseWrite()
+
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	read, err := io.ReadAll(conn)
+	return conn.RemoteAddr().String(), string(read), err
+}
+
+func TestProxyProtocolListenerTrustsOnlyAllowedSources(t *testing.T) {
+	const header = "PROXY TCP4 192.0.2.1 198.51.100.7 12345 443\r\n"
+
+	remote, read, err := acceptProxied(t, []string{"127.0.0.1/32"}, header+"hello")
+	if err != nil || remote != "192.0.2.1:12345" || read != "hello" {
+		t.Errorf("allowed source: remote %q, read %q, err %v", remote, read, err)
+	}
+
+	// An untrusted peer can't claim another address: its header is left in
+	// the stream, where the HTTP server rejects it
+	remote, read, err = acceptProxied(t, []string{"10.0.0.0/8"}, header+"hello")
+	if err != nil || !strings.HasPrefix(remote, "127.0.0.1:") || read != header+"hello" {
+		t.Errorf("untrusted source: remote %q, read %q, err %v", remote, read, err)
+	}
+
+	// Without allowed sources every peer must send a header
+	if _, _, err := acceptProxied(t, nil, "hello"); err == nil || !strings.Contains(err.Error(), "missing PROXY protocol header") {
+		t.Errorf("peer without a header: %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// newTestProxy creates a proxy from a YAML configuration
+func newTestProxy(t *testing.T, yml string) *ReverseProxy {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rp.Shutdown() })
+	return rp
+}
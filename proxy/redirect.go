@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// redirects answers requests matching a redirect rule before they are
+// proxied. The first rule that applies wins.
+type redirects struct {
+	rules []redirectRule
+}
+
+type redirectRule struct {
+	host     string         // normalized; empty matches any host
+	path     *regexp.Regexp // nil matches any path
+	https    bool
+	stripWWW bool
+	to       string
+	status   int
+}
+
+// newRedirects returns nil when no redirect rules are configured
+func newRedirects(cfg []config.RedirectRule) *redirects {
+	if len(cfg) == 0 {
+		return nil
+	}
+
+	rd := &redirects{rules: make([]redirectRule, len(cfg))}
+	for i, c := range cfg {
+		rule := redirectRule{
+			host:     config.NormalizeHost(c.Host),
+			https:    c.HTTPS,
+			stripWWW: c.StripWWW,
+			to:       c.To,
+			status:   c.Status,
+		}
+		if c.Path != "" {
+			rule.path = regexp.MustCompile(c.Path)
+		}
+		rd.rules[i] = rule
+	}
+	return rd
+}
+
+func (rd *redirects) middleware(next http.Handler) http.Handler {
+	if rd == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rule := range rd.rules {
+			if target, ok := rule.target(r); ok {
+				http.Redirect(w, r, target, rule.status)
+				logRequest(r, slog.LevelDebug, "Redirected request", "location", target, "status", rule.status)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// target returns where the rule sends r, or false when it doesn't apply.
+// The scheme is the one the client used, as reported by X-Forwarded-Proto.
+func (rule redirectRule) target(r *http.Request) (string, bool) {
+	if rule.host != "" && config.NormalizeHost(r.Host) != rule.host {
+		return "", false
+	}
+	var match []int
+	if rule.path != nil {
+		if match = rule.path.FindStringSubmatchIndex(r.URL.Path); match == nil {
+			return "", false
+		}
+	}
+
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme != "https" {
+		scheme = "http"
+	}
+	switch {
+	case rule.https:
+		if scheme == "https" {
+			return "", false
+		}
+		// Clients are sent to the default HTTPS port
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return "https://" + host + r.URL.RequestURI(), true
+
+	case rule.stripWWW:
+		host, ok := strings.CutPrefix(strings.ToLower(r.Host), "www.")
+		if !ok {
+			return "", false
+		}
+		return scheme + "://" + host + r.URL.RequestURI(), true
+	}
+
+	target := rule.to
+	if match != nil {
+		target = string(rule.path.ExpandString(nil, rule.to, r.URL.Path, match))
+	}
+	if r.URL.RawQuery != "" && !strings.Contains(target, "?") {
+		target += "?" + r.URL.RawQuery
+	}
+	return target, true
+}
@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// errRedisNil is returned when a key does not exist
+var errRedisNil = errors.New("redis: nil")
+
+const redisMaxIdle = 4
+
+// redisClient is a minimal RESP client supporting the handful of commands
+// the proxy needs, with a small pool of idle connections
+type redisClient struct {
+	config config.RedisConfig
+	idle   chan *redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func newRedisClient(cfg config.RedisConfig) *redisClient {
+	return &redisClient{
+		config: cfg,
+		idle:   make(chan *redisConn, redisMaxIdle),
+	}
+}
+
+// Do sends a command and returns its reply: a string, int64, nil or []interface{}
+func (c *redisClient) Do(args ...string) (interface{}, error) {
+	rc, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := rc.do(c.config.Timeout, args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection state is unknown after an I/O error
+		rc.conn.Close()
+		return nil, err
+	}
+	c.put(rc)
+	return reply, err
+}
+
+// Close closes all idle connections
+func (c *redisClient) Close() error {
+	for {
+		select {
+		case rc := <-c.idle:
+			rc.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *redisClient) get() (*redisConn, error) {
+	select {
+	case rc := <-c.idle:
+		return rc, nil
+	default:
+	}
+
+	conn, err := net.DialTimeout("tcp", c.config.Address, c.config.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("redis: failed to connect to %s: %w", c.config.Address, err)
+	}
+	rc := &redisConn{conn: conn, rd: bufio.NewReader(conn)}
+
+	if c.config.Password != "" {
+		if _, err := rc.do(c.config.Timeout, "AUTH", c.config.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.config.DB != 0 {
+		if _, err := rc.do(c.config.Timeout, "SELECT", strconv.Itoa(c.config.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+func (c *redisClient) put(rc *redisConn) {
+	select {
+	case c.idle <- rc:
+	default:
+		rc.conn.Close()
+	}
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (rc *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	if timeout > 0 {
+		_ = rc.conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := rc.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return rc.readReply()
+}
+
+func (rc *redisConn) readLine() (string, error) {
+	line, err := rc.rd.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: malformed reply %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func (rc *redisConn) readReply() (interface{}, error) {
+	line, err := rc.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.rd, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = rc.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+	}
+}
+
+// Get returns the string value of key or errRedisNil
+func (c *redisClient) Get(key string) (string, error) {
+	reply, err := c.Do("GET", key)
+	if err != nil {
+		return "", err
+	}
+	if reply == nil {
+		return "", errRedisNil
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("redis: unexpected reply type %T", reply)
+	}
+	return s, nil
+}
+
+// Set stores value under key with an optional expiry
+func (c *redisClient) Set(key, value string, ttl time.Duration) error {
+	args := []string{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := c.Do(args...)
+	return err
+}
+
+// Del removes key
+func (c *redisClient) Del(key string) error {
+	_, err := c.Do("DEL", key)
+	return err
+}
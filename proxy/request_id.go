@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// maxRequestIDLength bounds the length of IDs accepted from clients
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// requestIDs gives every request an ID, keeping a well-formed one sent by
+// the client, and passes it to the backend and back to the client
+type requestIDs struct {
+	header string
+}
+
+// newRequestIDs returns nil when request IDs are disabled
+func newRequestIDs(cfg config.RequestIDConfig) *requestIDs {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &requestIDs{header: http.CanonicalHeaderKey(cfg.Header)}
+}
+
+func (ids *requestIDs) middleware(next http.Handler) http.Handler {
+	if ids == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(ids.header)
+		if !validRequestID(id) {
+			id = newAffinityKey()
+		}
+		r.Header.Set(ids.header, id)
+		w.Header().Set(ids.header, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID accepts IDs of printable ASCII that are safe to log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID of r, or the client's X-Request-ID when request
+// IDs are disabled
+func requestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return r.Header.Get("X-Request-ID")
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// upstreamResolver resolves backend hostnames against explicitly configured
+// DNS servers, independent of the host's resolv.conf
+type upstreamResolver struct {
+	resolver *net.Resolver
+	servers  []string
+	timeout  time.Duration
+	search   []string
+	next     uint32
+}
+
+// newUpstreamResolver returns nil when no custom servers are configured,
+// in which case the system resolver is used
+func newUpstreamResolver(cfg config.DNSConfig) *upstreamResolver {
+	if len(cfg.Servers) == 0 {
+		return nil
+	}
+
+	port := "53"
+	if cfg.TLS {
+		port = "853"
+	}
+
+	r := &upstreamResolver{
+		timeout: cfg.Timeout,
+		search:  cfg.SearchDomains,
+	}
+	for _, server := range cfg.Servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, port)
+		}
+		r.servers = append(r.servers, server)
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	r.resolver = &net.Resolver{
+		PreferGo: true,
+		// The Go resolver calls Dial again when a server fails, so rotating
+		// here also gives failover across servers
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			server := r.servers[atomic.AddUint32(&r.next, 1)%uint32(len(r.servers))]
+			if !cfg.TLS {
+				return dialer.DialContext(ctx, network, server)
+			}
+			// A non-packet conn makes the resolver use TCP framing
+			serverName := cfg.TLSServerName
+			if serverName == "" {
+				serverName, _, _ = net.SplitHostPort(server)
+			}
+			tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: serverName}}
+			return tlsDialer.DialContext(ctx, "tcp", server)
+		},
+	}
+
+	return r
+}
+
+// candidates returns the fully qualified names tried for host, in order.
+// Trailing dots stop the Go resolver from applying the system search list.
+func (r *upstreamResolver) candidates(host string) []string {
+	if strings.HasSuffix(host, ".") {
+		return []string{host}
+	}
+	if strings.Contains(host, ".") || len(r.search) == 0 {
+		return []string{host + "."}
+	}
+
+	names := make([]string, 0, len(r.search)+1)
+	for _, domain := range r.search {
+		names = append(names, host+"."+strings.TrimSuffix(domain, ".")+".")
+	}
+	return append(names, host+".")
+}
+
+// LookupIP resolves host using the configured servers and search domains
+func (r *upstreamResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	var lastErr error
+	for _, name := range r.candidates(host) {
+		ips, err := r.resolver.LookupIP(ctx, "ip", name)
+		if err == nil && len(ips) > 0 {
+			return ips, nil
+		}
+
+		var dnsErr *net.DNSError
+		if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+			return nil, err
+		}
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return nil, lastErr
+}
+
+// LookupSRV resolves the SRV record name using the configured servers
+func (r *upstreamResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	_, addrs, err := r.resolver.LookupSRV(ctx, "", "", name)
+	return addrs, err
+}
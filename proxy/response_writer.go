@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+)
+
+// responseWriter wraps an http.ResponseWriter to observe the status code
+// and number of bytes written. Unwrap lets http.ResponseController reach
+// the underlying writer's Flush and Hijack, and ReadFrom lets files reach
+// the connection's, which sends them with sendfile where it can.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	written     int64
+	wroteHeader bool
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	// 1xx informational responses may precede the final header
+	if code >= 100 && code < 200 {
+		rw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if rw.wroteHeader {
+		return
+	}
+	rw.status = code
+	rw.wroteHeader = true
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.written += int64(n)
+	return n, err
+}
+
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := io.Copy(rw.ResponseWriter, src)
+	rw.written += n
+	return n, err
+}
+
+func (rw *responseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	_ = http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// writerOnly hides the ReadFrom of a writer that must see every write, for
+// io.Copy
+type writerOnly struct {
+	io.Writer
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// retryPolicy decides which failed requests are re-dispatched to another
+// backend and how long to wait in between
+type retryPolicy struct {
+	config   config.RetryConfig
+	methods  map[string]bool
+	statuses map[int]bool
+	key      string // idempotency key header; empty when disabled
+}
+
+// newRetryPolicy returns nil when retries are disabled
+func newRetryPolicy(cfg config.RetryConfig) *retryPolicy {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	p := &retryPolicy{
+		config:   cfg,
+		methods:  make(map[string]bool, len(cfg.Methods)),
+		statuses: make(map[int]bool, len(cfg.StatusCodes)),
+	}
+	for _, m := range cfg.Methods {
+		p.methods[m] = true
+	}
+	for _, code := range cfg.StatusCodes {
+		p.statuses[code] = true
+	}
+	if cfg.IdempotencyKey != "-" {
+		p.key = cfg.IdempotencyKey
+	}
+	return p
+}
+
+// retryAttempt travels in the request context of every attempt except the
+// last so the backend's error hooks swallow a retryable failure instead of
+// answering the client. Attempts beyond the retry policy's that connect
+// failover still covers only swallow failed connects.
+type retryAttempt struct {
+	err         error
+	connectOnly bool // only a failed connect may be retried
+}
+
+type retryAttemptKey struct{}
+
+func attemptFromContext(ctx context.Context) *retryAttempt {
+	a, _ := ctx.Value(retryAttemptKey{}).(*retryAttempt)
+	return a
+}
+
+// retryableStatusError reports a backend response whose status is retried
+type retryableStatusError struct {
+	status int
+}
+
+func (e retryableStatusError) Error() string {
+	return fmt.Sprintf("backend responded %d", e.status)
+}
+
+// prepare returns the number of attempts allowed for r and, when the request
+// has a body, a buffered copy of it to replay on each attempt
+func (p *retryPolicy) prepare(r *http.Request) (int, []byte) {
+	if p == nil || !p.retryable(r) || p.config.MaxAttempts < 2 {
+		return 1, nil
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return p.config.MaxAttempts, nil
+	}
+	if r.ContentLength > p.config.MaxBodySize {
+		return 1, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, p.config.MaxBodySize+1))
+	if err != nil || int64(len(body)) > p.config.MaxBodySize {
+		// Too large or unreadable: forward what was read followed by the rest
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return 1, nil
+	}
+	return p.config.MaxAttempts, body
+}
+
+// retryable reports whether r may be sent more than once: its method is
+// idempotent or the client marked it with an idempotency key
+func (p *retryPolicy) retryable(r *http.Request) bool {
+	return p.methods[r.Method] || (p.key != "" && r.Header.Get(p.key) != "")
+}
+
+// backoff returns the delay before retry number n (starting at 1), doubled
+// for each retry up to the maximum and jittered to avoid retry storms
+func (p *retryPolicy) backoff(n int) time.Duration {
+	d := p.config.Backoff
+	for i := 1; i < n && d < p.config.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.config.MaxBackoff {
+		d = p.config.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// nextBackend prefers an available backend of the pool that hasn't been
+// tried yet for this request
+func (p *retryPolicy) nextBackend(r *http.Request, pool *backendPool, tried []*Backend) *Backend {
+	wasTried := func(b *Backend) bool {
+		for _, t := range tried {
+			if t == b {
+				return true
+			}
+		}
+		return false
+	}
+
+	backend := pool.NextBackend(r)
+	if backend == nil || !wasTried(backend) {
+		return backend
+	}
+	var next *Backend
+	for _, b := range pool.backends() {
+		if b.IsAvailable() && !wasTried(b) && (next == nil || b.Priority < next.Priority) {
+			next = b
+		}
+	}
+	if next != nil {
+		return next
+	}
+	return backend
+}
+
+// modifyResponse drops the backend's copy of the request ID header, adds
+// the upstream timing header when enabled, turns a retryable backend status
+// into an error so the response is discarded and the request retried,
+// gunzips responses for clients of decompress routes and runs the
+// OnResponse hooks
+func (rp *ReverseProxy) modifyResponse(resp *http.Response) error {
+	// The client already has the request ID from the proxy
+	if rp.requestIDs != nil {
+		resp.Header.Del(rp.requestIDs.header)
+	}
+	if d := deadlineFromContext(resp.Request.Context()); d != nil {
+		d.responded(resp)
+	}
+	if rp.config.Metrics.UpstreamDurationHeader {
+		if t := timingFromContext(resp.Request.Context()); t != nil {
+			resp.Header.Set("X-Upstream-Duration", t.header())
+		}
+	}
+
+	if rp.retry != nil && rp.retry.statuses[resp.StatusCode] {
+		if a := attemptFromContext(resp.Request.Context()); a != nil && !a.connectOnly {
+			return retryableStatusError{status: resp.StatusCode}
+		}
+	}
+	if err := decompressResponse(resp); err != nil {
+		return err
+	}
+	return rp.extensions.onResponse(resp)
+}
+
+// attemptWriter records whether an attempt wrote anything to the client,
+// including informational responses, after which it must not be retried
+type attemptWriter struct {
+	http.ResponseWriter
+	wrote       bool
+	wroteHeader bool // a final status was written
+}
+
+func (aw *attemptWriter) WriteHeader(code int) {
+	aw.wrote = true
+	if code >= 200 {
+		aw.wroteHeader = true
+	}
+	aw.ResponseWriter.WriteHeader(code)
+}
+
+func (aw *attemptWriter) Write(b []byte) (int, error) {
+	aw.wrote, aw.wroteHeader = true, true
+	return aw.ResponseWriter.Write(b)
+}
+
+func (aw *attemptWriter) Flush() {
+	if f, ok := aw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (aw *attemptWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// countingBackend serves status and counts its requests
+func countingBackend(t *testing.T, status int) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestRetryMovesToAnotherBackend(t *testing.T) {
+	failing, failed := countingBackend(t, http.StatusServiceUnavailable)
+	healthy, served := countingBackend(t, http.StatusOK)
+	rp := newTestProxy(t, fmt.Sprintf(`server:
+  address: ":0"
+retry:
+  enabled: true
+  max_attempts: 2
+  backoff: 1ms
+backends:
+  - url: %q
+  - url: %q
+`, failing.URL, healthy.URL))
+
+	for i := 0; i < 4; i++ {
+		w := httptest.NewRecorder()
+		rp.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want the healthy backend's 200", i+1, w.Code)
+		}
+	}
+	if served.Load() != 4 || failed.Load() == 0 {
+		t.Errorf("healthy backend served %d, failing one %d; want 4 and some", served.Load(), failed.Load())
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	a, aHits := countingBackend(t, http.StatusBadGateway)
+	b, bHits := countingBackend(t, http.StatusBadGateway)
+	rp := newTestProxy(t, fmt.Sprintf(`server:
+  address: ":0"
+retry:
+  enabled: true
+  max_attempts: 3
+  backoff: 1ms
+backends:
+  - url: %q
+  - url: %q
+`, a.URL, b.URL))
+
+	w := httptest.NewRecorder()
+	rp.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status %d, want the last attempt's 502", w.Code)
+	}
+	if got := aHits.Load() + bHits.Load(); got != 3 {
+		t.Errorf("%d attempts, want 3", got)
+	}
+}
+
+func TestRetrySkipsNonIdempotentRequests(t *testing.T) {
+	failing, failed := countingBackend(t, http.StatusServiceUnavailable)
+	rp := newTestProxy(t, fmt.Sprintf(`server:
+  address: ":0"
+retry:
+  enabled: true
+  backoff: 1ms
+backends:
+  - url: %q
+`, failing.URL))
+
+	w := httptest.NewRecorder()
+	rp.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("order")))
+	if w.Code != http.StatusServiceUnavailable || failed.Load() != 1 {
+		t.Errorf("POST: status %d after %d attempts, want 503 after 1", w.Code, failed.Load())
+	}
+}
+
+func TestConnectFailoverSkipsRefusingBackend(t *testing.T) {
+	healthy, served := countingBackend(t, http.StatusOK)
+	rp := newTestProxy(t, fmt.Sprintf(`server:
+  address: ":0"
+connect_failover:
+  enabled: true
+backends:
+  - url: "http://%s"
+  - url: %q
+`, refusedAddr(t), healthy.URL))
+
+	// Any method fails over, since the refusing backend never saw the request
+	for i := 0; i < 4; i++ {
+		w := httptest.NewRecorder()
+		rp.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("order")))
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i+1, w.Code)
+		}
+	}
+	if served.Load() != 4 {
+		t.Errorf("healthy backend served %d requests, want 4", served.Load())
+	}
+}
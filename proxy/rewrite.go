@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// urlRewriter maps request URLs onto the layout a route's backends expect
+type urlRewriter struct {
+	strip string
+	add   string
+	rules []rewriteRule
+}
+
+type rewriteRule struct {
+	match   *regexp.Regexp
+	replace string
+	last    bool
+}
+
+// newURLRewriter returns nil when the route changes neither its prefix nor
+// has rewrite rules
+func newURLRewriter(strip, add string, cfgs []config.RewriteRule) (*urlRewriter, error) {
+	if strip == "" && add == "" && len(cfgs) == 0 {
+		return nil, nil
+	}
+	rw := &urlRewriter{strip: strip, add: strings.TrimSuffix(add, "/")}
+	for _, c := range cfgs {
+		re, err := regexp.Compile(c.Match)
+		if err != nil {
+			return nil, err
+		}
+		rw.rules = append(rw.rules, rewriteRule{match: re, replace: c.Replace, last: c.Last})
+	}
+	return rw, nil
+}
+
+// apply strips and adds the path prefixes of r and then rewrites its path
+// and query. A result that isn't a valid request URI leaves the URL
+// unchanged.
+func (rw *urlRewriter) apply(r *http.Request) {
+	if rw == nil {
+		return
+	}
+
+	path := r.URL.EscapedPath()
+	uri := path
+	if r.URL.RawQuery != "" {
+		uri += "?" + r.URL.RawQuery
+	}
+
+	if rw.strip != "" && hasPathPrefix(path, rw.strip) {
+		path = strings.TrimPrefix(path, rw.strip)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	if rw.add != "" {
+		path = rw.add + path
+	}
+	rewritten := path
+	if r.URL.RawQuery != "" {
+		rewritten += "?" + r.URL.RawQuery
+	}
+
+	for _, rule := range rw.rules {
+		if !rule.match.MatchString(rewritten) {
+			continue
+		}
+		rewritten = rule.match.ReplaceAllString(rewritten, rule.replace)
+		if rule.last {
+			break
+		}
+	}
+	if rewritten == uri {
+		return
+	}
+
+	u, err := url.ParseRequestURI(rewritten)
+	if err != nil {
+		logRequest(r, slog.LevelWarn, "Ignoring invalid rewritten URL", "url", rewritten, "error", err)
+		return
+	}
+	// The URL is shared with the client's request, which logs keep showing
+	target := *r.URL
+	target.Path, target.RawPath, target.RawQuery = u.Path, u.RawPath, u.RawQuery
+	r.URL = &target
+	logRequest(r, slog.LevelDebug, "Rewrote request URL", "from", uri)
+}
+
+// hasPathPrefix reports whether prefix is made of whole segments of path,
+// so that /api strips /api/users but not /apis
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// cleanPath resolves the dot segments and duplicate slashes of a request
+// path, keeping a trailing slash, so that prefixes are matched against the
+// path a backend will serve: /public/../admin is /admin
+func cleanPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// excludedPath reports whether the request's path lies under one of the
+// prefixes that skip authentication
+func excludedPath(r *http.Request, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return false
+	}
+	p := cleanPath(r.URL.Path)
+	for _, prefix := range prefixes {
+		if hasPathPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
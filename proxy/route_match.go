@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// requestMatcher checks the headers and cookies a route requires
+type requestMatcher struct {
+	headers []valueMatcher
+	cookies []valueMatcher
+}
+
+// valueMatcher compares one header or cookie value: exactly, by a regular
+// expression, or only for being present
+type valueMatcher struct {
+	name  string
+	value string
+	re    *regexp.Regexp
+	any   bool
+}
+
+// newRequestMatcher returns nil when the route matches by path alone
+func newRequestMatcher(cfg *config.RouteMatchConfig) *requestMatcher {
+	if cfg == nil {
+		return nil
+	}
+	m := &requestMatcher{}
+	for name, value := range cfg.Headers {
+		m.headers = append(m.headers, newValueMatcher(http.CanonicalHeaderKey(name), value))
+	}
+	for name, value := range cfg.Cookies {
+		m.cookies = append(m.cookies, newValueMatcher(name, value))
+	}
+	return m
+}
+
+func newValueMatcher(name, value string) valueMatcher {
+	vm := valueMatcher{name: name, value: value}
+	if expr, ok := strings.CutPrefix(value, "~"); ok {
+		vm.re = regexp.MustCompile(expr)
+	} else if value == "*" {
+		vm.any = true
+	}
+	return vm
+}
+
+func (vm valueMatcher) matches(value string) bool {
+	switch {
+	case vm.re != nil:
+		return vm.re.MatchString(value)
+	case vm.any:
+		return value != ""
+	}
+	return value == vm.value
+}
+
+// matches reports whether r carries every required header and cookie. A
+// header sent more than once matches when any of its values does.
+func (m *requestMatcher) matches(r *http.Request) bool {
+	if m == nil {
+		return true
+	}
+	for _, h := range m.headers {
+		matched := false
+		for _, value := range r.Header[h.name] {
+			if h.matches(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, c := range m.cookies {
+		cookie, err := r.Cookie(c.name)
+		if err != nil || !c.matches(cookie.Value) {
+			return false
+		}
+	}
+	return true
+}
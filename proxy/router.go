@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// route maps a request path pattern to a backend pool
+type route struct {
+	prefix   string
+	regex    *regexp.Regexp
+	match    *requestMatcher // headers and cookies required besides the path
+	pool     *backendPool
+	static   *staticFiles // serves the route instead of pool when set
+	headers  *headerRules
+	cache    cachePolicy
+	access   *accessList
+	auth     *basicAuth
+	signed   *signatureAuth
+	openAPI  *openAPIValidator
+	mirror   *mirror
+	timeouts upstreamTimeouts
+	maxBody  int64 // 0 uses limits.max_request_body_size
+	flush    time.Duration
+	rewrite  *urlRewriter
+	host     string // host_header; empty defers to the backend
+	encoding string // upstream_encoding
+	hints    earlyHints
+	stream   bool
+	slo      *sloObjective
+
+	securityHeaders *bool // nil follows the global setting
+	tracing         *bool // nil follows the global setting
+}
+
+// matches reports whether the route serves r, whose path cleaned by
+// cleanPath is path. A path_prefix matches whole segments only.
+func (rt route) matches(r *http.Request, path string) bool {
+	if rt.regex != nil {
+		if !rt.regex.MatchString(path) {
+			return false
+		}
+	} else if !hasPathPrefix(path, rt.prefix) {
+		return false
+	}
+	return rt.match.matches(r)
+}
+
+// pattern returns the path_regex or path_prefix the route was configured
+// with, or "" for the fallback
+func (rt route) pattern() string {
+	if rt.regex != nil {
+		return rt.regex.String()
+	}
+	return rt.prefix
+}
+
+// router selects the pool that serves a request. Routes are evaluated in
+// order; requests matching none of them go to the fallback, which is nil
+// when no default backends are configured.
+type router struct {
+	routes   []route
+	fallback *backendPool
+}
+
+func newRouter(cfgs []config.RouteConfig, pools map[string]*backendPool, fallback *backendPool, transports *transportBuilder) (*router, error) {
+	rt := &router{
+		routes:   make([]route, 0, len(cfgs)),
+		fallback: fallback,
+	}
+	for _, c := range cfgs {
+		pool, ok := pools[c.Pool]
+		if !ok && c.Static == nil {
+			return nil, fmt.Errorf("route references unknown pool %q", c.Pool)
+		}
+		static, err := newStaticFiles(c.Static)
+		if err != nil {
+			return nil, err
+		}
+		access, err := newAccessList(c.Access)
+		if err != nil {
+			return nil, err
+		}
+		auth, err := newBasicAuth(c.BasicAuth)
+		if err != nil {
+			return nil, err
+		}
+		signed, err := newSignatureAuth(c.Signature)
+		if err != nil {
+			return nil, err
+		}
+		openAPI, err := newOpenAPIValidator(c.OpenAPI)
+		if err != nil {
+			return nil, err
+		}
+		mirror, err := newMirror(c.Mirror, transports)
+		if err != nil {
+			return nil, err
+		}
+		rewrite, err := newURLRewriter(c.StripPrefix, c.AddPrefix, c.Rewrite)
+		if err != nil {
+			return nil, err
+		}
+		r := route{
+			prefix:   c.PathPrefix,
+			match:    newRequestMatcher(c.Match),
+			pool:     pool,
+			static:   static,
+			headers:  newHeaderRules(c.Headers),
+			cache:    newCachePolicy(c.CacheTTL, c.Cache),
+			access:   access,
+			auth:     auth,
+			signed:   signed,
+			openAPI:  openAPI,
+			mirror:   mirror,
+			maxBody:  c.MaxRequestBodySize,
+			flush:    time.Duration(c.FlushInterval),
+			rewrite:  rewrite,
+			host:     c.HostHeader,
+			encoding: c.UpstreamEncoding,
+			hints:    c.EarlyHints,
+			stream:   c.Stream,
+
+			securityHeaders: c.SecurityHeaders,
+			tracing:         c.Tracing,
+		}
+		if pool != nil {
+			r.timeouts = pool.timeouts.override(c.Timeouts)
+		}
+		if c.PathRegex != "" {
+			re, err := regexp.Compile(c.PathRegex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid route path_regex %q: %w", c.PathRegex, err)
+			}
+			r.regex = re
+		}
+		r.slo = newSLOObjective(c.SLO, r.pattern())
+		rt.routes = append(rt.routes, r)
+	}
+	return rt, nil
+}
+
+// match returns the route serving req. Its pool is nil if no pool serves it.
+func (rt *router) match(req *http.Request) route {
+	path := cleanPath(req.URL.Path)
+	for _, r := range rt.routes {
+		if r.matches(req, path) {
+			return r
+		}
+	}
+	if rt.fallback == nil {
+		return route{}
+	}
+	return route{pool: rt.fallback, timeouts: rt.fallback.timeouts}
+}
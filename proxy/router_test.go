@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRoutePathPrefixMatchesCleanedSegments(t *testing.T) {
+	named := func(name string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	admin, public := named("admin"), named("public")
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp := newTestProxy(t, fmt.Sprintf(`server:
+  address: ":0"
+pools:
+  admin:
+    backends:
+      - url: %q
+routes:
+  - path_prefix: "/admin"
+    pool: admin
+    basic_auth:
+      users:
+        ops: %q
+backends:
+  - url: %q
+`, admin.URL, hash, public.URL))
+
+	for _, tc := range []struct {
+		path   string
+		status int
+		body   string // of an answered request
+	}{
+		{"/admin", http.StatusUnauthorized, ""},
+		{"/admin/users", http.StatusUnauthorized, ""},
+		{"/x/../admin/users", http.StatusUnauthorized, ""},
+		{"/x/%2e%2e/admin/users", http.StatusUnauthorized, ""},
+		{"//admin/users", http.StatusUnauthorized, ""},
+		{"/admin/./users", http.StatusUnauthorized, ""},
+		{"/adminpanel", http.StatusOK, "public"},
+		{"/admin/../public", http.StatusOK, "public"},
+		{"/", http.StatusOK, "public"},
+	} {
+		w := httptest.NewRecorder()
+		// Set as the server parses a request line, which a URL would not
+		// keep for paths such as //admin
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		path, err := url.PathUnescape(tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.URL.Path, r.RequestURI = path, tc.path
+		rp.ServeHTTP(w, r)
+		if w.Code != tc.status || (tc.body != "" && w.Body.String() != tc.body) {
+			t.Errorf("%s: status %d body %q, want %d %q", tc.path, w.Code, w.Body.String(), tc.status, tc.body)
+		}
+	}
+
+	// The right credentials still reach the admin pool
+	r := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+	r.SetBasicAuth("ops", "secret")
+	w := httptest.NewRecorder()
+	rp.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "admin" {
+		t.Errorf("authenticated: status %d body %q, want 200 admin", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// securityHeaders adds security headers to responses that don't set them.
+// Routes can switch it on or off regardless of the global setting.
+type securityHeaders struct {
+	enabled bool
+	hsts    string
+	headers []headerValue
+}
+
+func newSecurityHeaders(cfg config.SecurityHeadersConfig) *securityHeaders {
+	sh := &securityHeaders{enabled: cfg.Enabled}
+	if cfg.HSTSMaxAge > 0 {
+		sh.hsts = "max-age=" + strconv.FormatInt(int64(cfg.HSTSMaxAge/time.Second), 10)
+		if cfg.HSTSIncludeSubdomains {
+			sh.hsts += "; includeSubDomains"
+		}
+		if cfg.HSTSPreload {
+			sh.hsts += "; preload"
+		}
+	}
+	for _, h := range []headerValue{
+		{"X-Content-Type-Options", cfg.ContentTypeOptions},
+		{"X-Frame-Options", cfg.FrameOptions},
+		{"Referrer-Policy", cfg.ReferrerPolicy},
+		{"Content-Security-Policy", cfg.ContentSecurityPolicy},
+	} {
+		if h.value != "" && h.value != "-" {
+			sh.headers = append(sh.headers, h)
+		}
+	}
+	return sh
+}
+
+// wrap returns a writer that adds the headers when they are enabled for
+// the route; override is the route's setting, nil meaning the global one
+func (sh *securityHeaders) wrap(w http.ResponseWriter, r *http.Request, override *bool) http.ResponseWriter {
+	enabled := sh.enabled
+	if override != nil {
+		enabled = *override
+	}
+	if !enabled {
+		return w
+	}
+
+	headers := sh.headers
+	// HSTS is ignored by browsers over plain HTTP, so only send it when the
+	// client connected over HTTPS, possibly through a trusted proxy
+	if sh.hsts != "" && r.Header.Get("X-Forwarded-Proto") == "https" {
+		headers = append(headers[:len(headers):len(headers)], headerValue{"Strict-Transport-Security", sh.hsts})
+	}
+	if len(headers) == 0 {
+		return w
+	}
+	return &securityHeaderWriter{ResponseWriter: w, headers: headers}
+}
+
+type securityHeaderWriter struct {
+	http.ResponseWriter
+	headers     []headerValue
+	wroteHeader bool
+}
+
+func (sw *securityHeaderWriter) WriteHeader(code int) {
+	if !sw.wroteHeader && code >= 200 {
+		sw.wroteHeader = true
+		h := sw.Header()
+		for _, hv := range sw.headers {
+			if h.Get(hv.name) == "" {
+				h.Set(hv.name, hv.value)
+			}
+		}
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *securityHeaderWriter) Write(b []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *securityHeaderWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return io.Copy(sw.ResponseWriter, src)
+}
+
+func (sw *securityHeaderWriter) Flush() {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *securityHeaderWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/cluster"
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// SessionStore maps session-affinity keys (a cookie value or a hashed
+// client key) to the URL of the backend that owns the session
+type SessionStore interface {
+	Get(key string) (backendURL string, ok bool)
+	Set(key, backendURL string)
+	Delete(key string)
+	Close() error
+}
+
+func newSessionStore(cfg config.SessionStoreConfig, node *cluster.Node, logger *slog.Logger) (SessionStore, error) {
+	switch cfg.Type {
+	case "file":
+		return newFileSessionStore(cfg.Path, cfg.TTL, cfg.FlushInterval, logger)
+	case "redis":
+		return &redisSessionStore{
+			client: newRedisClient(cfg.Redis),
+			prefix: cfg.Redis.KeyPrefix,
+			ttl:    cfg.TTL,
+			logger: logger,
+		}, nil
+	case "cluster":
+		if node == nil {
+			return nil, errors.New("cluster session store requires cluster mode")
+		}
+		return &clusterSessionStore{node: node, ttl: cfg.TTL}, nil
+	default:
+		return newMemorySessionStore(cfg.TTL), nil
+	}
+}
+
+type sessionEntry struct {
+	Backend string    `json:"backend"`
+	Expires time.Time `json:"expires"`
+}
+
+// memorySessionStore keeps mappings in process memory; they are lost on restart
+type memorySessionStore struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]sessionEntry
+	dirty   bool
+	stop    chan struct{}
+}
+
+func newMemorySessionStore(ttl time.Duration) *memorySessionStore {
+	return &memorySessionStore{
+		ttl:     ttl,
+		entries: make(map[string]sessionEntry),
+		stop:    make(chan struct{}),
+	}
+}
+
+// start begins forgetting expired mappings
+func (s *memorySessionStore) start() {
+	go s.run(time.Minute, s.sweep)
+}
+
+func (s *memorySessionStore) run(interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fn()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *memorySessionStore) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || time.Now().After(e.Expires) {
+		return "", false
+	}
+	return e.Backend, true
+}
+
+func (s *memorySessionStore) Set(key, backendURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = sessionEntry{Backend: backendURL, Expires: time.Now().Add(s.ttl)}
+	s.dirty = true
+}
+
+func (s *memorySessionStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	s.dirty = true
+}
+
+func (s *memorySessionStore) Close() error {
+	close(s.stop)
+	return nil
+}
+
+func (s *memorySessionStore) sweep() {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, e := range s.entries {
+		if now.After(e.Expires) {
+			delete(s.entries, key)
+			s.dirty = true
+		}
+	}
+}
+
+// fileSessionStore is a memory store that is snapshotted to disk
+// periodically and on shutdown, and reloaded on startup
+type fileSessionStore struct {
+	*memorySessionStore
+	path          string
+	flushInterval time.Duration
+	logger        *slog.Logger
+}
+
+func newFileSessionStore(path string, ttl, flushInterval time.Duration, logger *slog.Logger) (*fileSessionStore, error) {
+	s := &fileSessionStore{
+		memorySessionStore: newMemorySessionStore(ttl),
+		path:               path,
+		flushInterval:      flushInterval,
+		logger:             logger,
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read session store %s: %w", path, err)
+	default:
+		if err := json.Unmarshal(data, &s.entries); err != nil {
+			return nil, fmt.Errorf("failed to parse session store %s: %w", path, err)
+		}
+		s.logger.Info("Loaded session affinity mappings", "count", len(s.entries), "path", path)
+	}
+
+	return s, nil
+}
+
+// start begins forgetting expired mappings and saving the snapshot
+func (s *fileSessionStore) start() {
+	s.memorySessionStore.start()
+	go s.run(s.flushInterval, func() {
+		if err := s.flush(); err != nil {
+			s.logger.Error("Failed to persist session store", "error", err)
+		}
+	})
+}
+
+// flush atomically replaces the snapshot file if anything changed
+func (s *fileSessionStore) flush() error {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(s.entries)
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *fileSessionStore) Close() error {
+	s.memorySessionStore.Close()
+	return s.flush()
+}
+
+// redisSessionStore keeps mappings in Redis so they survive restarts and
+// are shared by every proxy instance using the same server
+type redisSessionStore struct {
+	client *redisClient
+	prefix string
+	ttl    time.Duration
+	logger *slog.Logger
+}
+
+func (s *redisSessionStore) Get(key string) (string, bool) {
+	backendURL, err := s.client.Get(s.prefix + key)
+	if err != nil {
+		if !errors.Is(err, errRedisNil) {
+			s.logger.Error("Session store lookup failed", "error", err)
+		}
+		return "", false
+	}
+	return backendURL, true
+}
+
+func (s *redisSessionStore) Set(key, backendURL string) {
+	if err := s.client.Set(s.prefix+key, backendURL, s.ttl); err != nil {
+		s.logger.Error("Session store update failed", "error", err)
+	}
+}
+
+func (s *redisSessionStore) Delete(key string) {
+	if err := s.client.Del(s.prefix + key); err != nil {
+		s.logger.Error("Session store update failed", "error", err)
+	}
+}
+
+func (s *redisSessionStore) Close() error {
+	return s.client.Close()
+}
+
+// clusterSessionStore replicates mappings to every cluster node
+type clusterSessionStore struct {
+	node *cluster.Node
+	ttl  time.Duration
+}
+
+const sessionKeyPrefix = "session/"
+
+func (s *clusterSessionStore) Get(key string) (string, bool) {
+	return s.node.Get(sessionKeyPrefix + key)
+}
+
+func (s *clusterSessionStore) Set(key, backendURL string) {
+	s.node.Set(sessionKeyPrefix+key, backendURL, s.ttl)
+}
+
+func (s *clusterSessionStore) Delete(key string) {
+	s.node.Delete(sessionKeyPrefix + key)
+}
+
+func (s *clusterSessionStore) Close() error {
+	return nil
+}
@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// signatureScheme names the algorithm in the Authorization header and the
+// first line of the signed string
+const signatureScheme = "HMAC-SHA256"
+
+// signatureAuth verifies HMAC-SHA256 request signatures. A client sends
+//
+//	Authorization: HMAC-SHA256 Credential=<client>, Signature=<hex>
+//
+// where the signature is the HMAC, keyed with the client's secret, of the
+// lines scheme, date header, method, lowercased host, escaped path, raw
+// query and the hex SHA-256 of the body.
+type signatureAuth struct {
+	secrets    map[string][]byte
+	dateHeader string
+	skew       time.Duration
+	identity   string
+}
+
+// newSignatureAuth returns nil when the route doesn't require signatures
+func newSignatureAuth(cfg *config.SignatureConfig) (*signatureAuth, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	sa := &signatureAuth{
+		secrets:    make(map[string][]byte),
+		dateHeader: cfg.DateHeader,
+		skew:       cfg.ClockSkew,
+		identity:   cfg.IdentityHeader,
+	}
+	if cfg.SecretsFile != "" {
+		if err := sa.loadSecrets(cfg.SecretsFile); err != nil {
+			return nil, err
+		}
+	}
+	// Inline secrets take precedence over the file
+	for client, secret := range cfg.Secrets {
+		sa.secrets[client] = []byte(secret)
+	}
+	return sa, nil
+}
+
+// loadSecrets reads "client secret" lines
+func (sa *signatureAuth) loadSecrets(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open signature secrets file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return fmt.Errorf("signature secrets file %s line %d: expected \"client secret\"", path, line)
+		}
+		sa.secrets[fields[0]] = []byte(fields[1])
+	}
+	return scanner.Err()
+}
+
+// authorize reports whether r carries a valid signature, and otherwise
+// answers with 401, or 413 when the body is too large to verify
+func (sa *signatureAuth) authorize(w http.ResponseWriter, r *http.Request, serveError serveErrorFunc) bool {
+	if sa == nil {
+		return true
+	}
+
+	// The identity header only ever comes from a verified signature
+	if sa.identity != "" {
+		r.Header.Del(sa.identity)
+	}
+
+	client, err := sa.verify(r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			logRequest(r, slog.LevelWarn, "Request body too large", "limit", tooLarge.Limit)
+			serveError(w, r, http.StatusRequestEntityTooLarge, bodyTooLargeMessage(tooLarge.Limit), err)
+			return false
+		}
+		logRequest(r, slog.LevelDebug, "Invalid request signature", "client", client, "error", err)
+		w.Header().Set("WWW-Authenticate", signatureScheme)
+		serveError(w, r, http.StatusUnauthorized, "Unauthorized", err)
+		return false
+	}
+
+	if sa.identity != "" {
+		r.Header.Set(sa.identity, client)
+	}
+	return true
+}
+
+// verify checks the signature of r and returns the client that signed it.
+// The body is read to be hashed and replaced with a copy.
+func (sa *signatureAuth) verify(r *http.Request) (string, error) {
+	scheme, params, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if scheme != signatureScheme {
+		return "", fmt.Errorf("missing %s authorization", signatureScheme)
+	}
+	var client, signature string
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "Credential":
+			client = value
+		case "Signature":
+			signature = value
+		}
+	}
+	secret, ok := sa.secrets[client]
+	if !ok {
+		return client, fmt.Errorf("unknown client")
+	}
+	given, err := hex.DecodeString(signature)
+	if err != nil || len(given) != sha256.Size {
+		return client, fmt.Errorf("malformed signature")
+	}
+
+	date := r.Header.Get(sa.dateHeader)
+	signedAt, err := parseSignatureDate(date)
+	if err != nil {
+		return client, err
+	}
+	if d := time.Since(signedAt); d > sa.skew || d < -sa.skew {
+		return client, fmt.Errorf("signing time is outside the allowed clock skew")
+	}
+
+	bodyHash := sha256.New()
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return client, err
+		}
+		bodyHash.Write(body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n%s\n%x", signatureScheme, date, r.Method,
+		strings.ToLower(r.Host), r.URL.EscapedPath(), r.URL.RawQuery, bodyHash.Sum(nil))
+	if !hmac.Equal(mac.Sum(nil), given) {
+		return client, fmt.Errorf("signature mismatch")
+	}
+	return client, nil
+}
+
+// parseSignatureDate accepts RFC 3339 timestamps and HTTP dates
+func parseSignatureDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("missing signing time")
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid signing time %q", value)
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// signRequest signs r the way a client does, over the lines of the
+// canonical string
+func signRequest(r *http.Request, client, secret, date, body string) {
+	bodyHash := sha256.Sum256([]byte(body))
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "HMAC-SHA256\n%s\n%s\n%s\n%s\n%s\n%x", date, r.Method,
+		strings.ToLower(r.Host), r.URL.EscapedPath(), r.URL.RawQuery, bodyHash)
+	r.Header.Set("X-Date", date)
+	r.Header.Set("Authorization", "HMAC-SHA256 Credential="+client+", Signature="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestSignatureVerify(t *testing.T) {
+	sa, err := newSignatureAuth(&config.SignatureConfig{
+		Secrets:    map[string]string{"billing": "s3cret"},
+		DateHeader: "X-Date",
+		ClockSkew:  5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name    string
+		date    time.Time
+		format  string
+		secret  string
+		change  func(r *http.Request) // after signing
+		wantErr string
+	}{
+		{name: "valid", date: now},
+		{name: "http date", date: now, format: http.TimeFormat},
+		{name: "within skew", date: now.Add(-4 * time.Minute)},
+		{name: "too old", date: now.Add(-6 * time.Minute), wantErr: "clock skew"},
+		{name: "too far ahead", date: now.Add(6 * time.Minute), wantErr: "clock skew"},
+		{name: "wrong secret", date: now, secret: "other", wantErr: "signature mismatch"},
+		{name: "body changed", date: now, wantErr: "signature mismatch", change: func(r *http.Request) {
+			r.Body = io.NopCloser(strings.NewReader(`{"amount":1000}`))
+		}},
+		{name: "host changed", date: now, wantErr: "signature mismatch", change: func(r *http.Request) {
+			r.Host = "other.example.com"
+		}},
+		{name: "host case ignored", date: now, change: func(r *http.Request) {
+			r.Host = "API.Example.com"
+		}},
+		{name: "path changed", date: now, wantErr: "signature mismatch", change: func(r *http.Request) {
+			r.URL.Path = "/refunds"
+		}},
+		{name: "query changed", date: now, wantErr: "signature mismatch", change: func(r *http.Request) {
+			r.URL.RawQuery = "dry_run=false"
+		}},
+		{name: "method changed", date: now, wantErr: "signature mismatch", change: func(r *http.Request) {
+			r.Method = http.MethodPut
+		}},
+		{name: "date changed", date: now, wantErr: "signature mismatch", change: func(r *http.Request) {
+			r.Header.Set("X-Date", now.Add(time.Second).Format(time.RFC3339))
+		}},
+		{name: "missing date", date: now, wantErr: "missing signing time", change: func(r *http.Request) {
+			r.Header.Del("X-Date")
+		}},
+		{name: "invalid date", date: now, wantErr: "invalid signing time", change: func(r *http.Request) {
+			r.Header.Set("X-Date", "yesterday")
+		}},
+		{name: "unknown client", date: now, wantErr: "unknown client", change: func(r *http.Request) {
+			r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), "billing", "shipping", 1))
+		}},
+		{name: "malformed signature", date: now, wantErr: "malformed signature", change: func(r *http.Request) {
+			r.Header.Set("Authorization", "HMAC-SHA256 Credential=billing, Signature=xyz")
+		}},
+		{name: "other scheme", date: now, wantErr: "missing HMAC-SHA256", change: func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer token")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"amount":10}`
+			r := httptest.NewRequest(http.MethodPost, "https://api.example.com/payments?dry_run=true", strings.NewReader(body))
+			format, secret := tt.format, tt.secret
+			if format == "" {
+				format = time.RFC3339
+			}
+			if secret == "" {
+				secret = "s3cret"
+			}
+			signRequest(r, "billing", secret, tt.date.Format(format), body)
+			if tt.change != nil {
+				tt.change(r)
+			}
+
+			client, err := sa.verify(r)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verify: %v", err)
+				}
+				if client != "billing" {
+					t.Errorf("client %q, want billing", client)
+				}
+				// The body is still there for the backend
+				if got, _ := io.ReadAll(r.Body); string(got) != body {
+					t.Errorf("body after verify %q", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verify = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignatureAuthorizeServesErrors(t *testing.T) {
+	sa, err := newSignatureAuth(&config.SignatureConfig{
+		Secrets:        map[string]string{"billing": "s3cret"},
+		DateHeader:     "X-Date",
+		ClockSkew:      time.Minute,
+		IdentityHeader: "X-Client",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var served []int
+	serveError := func(w http.ResponseWriter, r *http.Request, status int, message string, cause error) {
+		served = append(served, status)
+		w.WriteHeader(status)
+	}
+	date := time.Now().UTC().Format(time.RFC3339)
+
+	// A forged identity header is dropped even from valid requests
+	r := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader("body"))
+	signRequest(r, "billing", "s3cret", date, "body")
+	r.Header.Set("X-Client", "admin")
+	if !sa.authorize(httptest.NewRecorder(), r, serveError) || r.Header.Get("X-Client") != "billing" {
+		t.Errorf("valid request: identity %q", r.Header.Get("X-Client"))
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader("body"))
+	signRequest(r, "billing", "wrong", date, "body")
+	w := httptest.NewRecorder()
+	if sa.authorize(w, r, serveError) {
+		t.Error("request with a bad signature authorized")
+	}
+	if w.Header().Get("WWW-Authenticate") != "HMAC-SHA256" {
+		t.Errorf("WWW-Authenticate %q", w.Header().Get("WWW-Authenticate"))
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader("too large"))
+	signRequest(r, "billing", "s3cret", date, "too large")
+	r.Body = http.MaxBytesReader(nil, r.Body, 3)
+	if sa.authorize(httptest.NewRecorder(), r, serveError) {
+		t.Error("oversized request authorized")
+	}
+
+	if len(served) != 2 || served[0] != http.StatusUnauthorized || served[1] != http.StatusRequestEntityTooLarge {
+		t.Errorf("served errors %v, want 401 and 413", served)
+	}
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// sloReasons are the ways a request can miss its route's objective, in the
+// order they are reported
+var sloReasons = []string{"error", "latency", "request_size", "response_size"}
+
+// sloObjective is a route's service level objective
+type sloObjective struct {
+	name            string
+	latency         time.Duration // 0 is no latency target
+	maxRequestSize  int64         // 0 is unlimited
+	maxResponseSize int64         // 0 is unlimited
+	budget          float64       // fraction of requests that may miss the objective
+	log             bool
+}
+
+// newSLOObjective returns the objective of the route with the given
+// pattern, or nil if it has none
+func newSLOObjective(cfg *config.SLOConfig, pattern string) *sloObjective {
+	if cfg == nil {
+		return nil
+	}
+	name := cfg.Name
+	if name == "" {
+		name = pattern
+	}
+	return &sloObjective{
+		name:            name,
+		latency:         cfg.Latency,
+		maxRequestSize:  cfg.MaxRequestSize,
+		maxResponseSize: cfg.MaxResponseSize,
+		budget:          cfg.ErrorBudget / 100,
+		log:             cfg.Log,
+	}
+}
+
+// track measures a request to the route from here until the returned
+// function is called, once it has been answered, and records it in m
+func (o *sloObjective) track(w http.ResponseWriter, r *http.Request, m *metrics) (http.ResponseWriter, func()) {
+	if o == nil {
+		return w, func() {}
+	}
+	start := time.Now()
+	rw := newResponseWriter(w)
+	var body *countingBody
+	if r.Body != nil && r.Body != http.NoBody {
+		body = &countingBody{ReadCloser: r.Body}
+		r.Body = body
+	}
+	return rw, func() {
+		duration := time.Since(start)
+		requestSize := r.ContentLength
+		if body != nil && body.n.Load() > requestSize {
+			requestSize = body.n.Load()
+		}
+
+		var missed []string
+		if rw.status >= 500 {
+			missed = append(missed, "error")
+		}
+		if o.latency > 0 && duration > o.latency {
+			missed = append(missed, "latency")
+		}
+		if o.maxRequestSize > 0 && requestSize > o.maxRequestSize {
+			missed = append(missed, "request_size")
+		}
+		if o.maxResponseSize > 0 && rw.written > o.maxResponseSize {
+			missed = append(missed, "response_size")
+		}
+
+		m.observeSLO(o, missed)
+		if o.log && len(missed) > 0 {
+			logRequest(r, slog.LevelWarn, "Request missed its SLO", "slo", o.name, "reasons", strings.Join(missed, ","),
+				"status", rw.status, "duration", duration, "request_size", requestSize, "response_size", rw.written)
+		}
+	}
+}
+
+// countingBody counts the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// sloMetrics are the request counts of one objective
+type sloMetrics struct {
+	budget   float64
+	requests uint64
+	missed   uint64            // requests that missed the objective in any way
+	reasons  map[string]uint64 // by reason; a request can miss in several
+}
+
+// observeSLO records a request tracked by o that missed the objective for
+// the given reasons, if any
+func (m *metrics) observeSLO(o *sloObjective, missed []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sm, ok := m.slo[o.name]
+	if !ok {
+		sm = &sloMetrics{budget: o.budget, reasons: make(map[string]uint64)}
+		m.slo[o.name] = sm
+	}
+	sm.requests++
+	if len(missed) > 0 {
+		sm.missed++
+	}
+	for _, reason := range missed {
+		sm.reasons[reason]++
+	}
+}
+
+// writeSLO writes the objectives' counters; m.mu must be held
+func (m *metrics) writeSLO(w io.Writer) {
+	if len(m.slo) == 0 {
+		return
+	}
+	names := make([]string, 0, len(m.slo))
+	for name := range m.slo {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "# HELP reverse_proxy_slo_requests_total Requests to routes with an SLO.\n# TYPE reverse_proxy_slo_requests_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "reverse_proxy_slo_requests_total{slo=\"%s\"} %d\n", escapeLabel(name), m.slo[name].requests)
+	}
+	fmt.Fprintf(w, "# HELP reverse_proxy_slo_missed_total Requests that missed their route's SLO.\n# TYPE reverse_proxy_slo_missed_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "reverse_proxy_slo_missed_total{slo=\"%s\"} %d\n", escapeLabel(name), m.slo[name].missed)
+	}
+	fmt.Fprintf(w, "# HELP reverse_proxy_slo_violations_total SLO violations by reason; a request can violate an SLO in several ways.\n# TYPE reverse_proxy_slo_violations_total counter\n")
+	for _, name := range names {
+		for _, reason := range sloReasons {
+			fmt.Fprintf(w, "reverse_proxy_slo_violations_total{slo=\"%s\",reason=\"%s\"} %d\n", escapeLabel(name), reason, m.slo[name].reasons[reason])
+		}
+	}
+	fmt.Fprintf(w, "# HELP reverse_proxy_slo_error_budget_remaining Share of the error budget left, negative once it is overspent.\n# TYPE reverse_proxy_slo_error_budget_remaining gauge\n")
+	for _, name := range names {
+		if sm := m.slo[name]; sm.budget > 0 {
+			remaining := 1.0
+			if sm.requests > 0 {
+				remaining = 1 - float64(sm.missed)/(float64(sm.requests)*sm.budget)
+			}
+			fmt.Fprintf(w, "reverse_proxy_slo_error_budget_remaining{slo=\"%s\"} %s\n", escapeLabel(name), strconv.FormatFloat(remaining, 'g', -1, 64))
+		}
+	}
+}
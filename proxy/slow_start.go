@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"math/rand"
+	"time"
+)
+
+// slowStartAttempts is how many times a pool asks its balancer again when a
+// warming backend turns a request away. The last pick is kept regardless so
+// a pool of only warming backends still serves.
+const slowStartAttempts = 3
+
+// warmUp starts the slow start window of a backend that joined a serving pool
+func (b *Backend) warmUp() {
+	if b.slowStart.Window == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.warmingSince = time.Now()
+}
+
+// share is the fraction of a full traffic share the backend takes, growing
+// linearly from the minimum share to 1 over the slow start window
+func (b *Backend) share(now time.Time) float64 {
+	if b.slowStart.Window == 0 {
+		return 1
+	}
+	b.mu.RLock()
+	since := b.warmingSince
+	b.mu.RUnlock()
+	if since.IsZero() {
+		return 1
+	}
+	elapsed := now.Sub(since)
+	if elapsed >= b.slowStart.Window {
+		return 1
+	}
+	min := b.slowStart.MinShare
+	return min + (1-min)*float64(elapsed)/float64(b.slowStart.Window)
+}
+
+// admit reports whether a warming backend takes a request it was picked for
+func (b *Backend) admit() bool {
+	share := b.share(time.Now())
+	return share >= 1 || rand.Float64() < share
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// clientHelloTimeout bounds how long a client may take to send its
+// ClientHello on an SNI-routed stream
+const clientHelloTimeout = 10 * time.Second
+
+var errHelloRead = errors.New("client hello read")
+
+// peekServerName reads the TLS ClientHello from conn and returns the
+// server name it requests, which may be empty, together with a connection
+// that replays the bytes read so far
+func peekServerName(conn net.Conn) (string, net.Conn, error) {
+	var buf bytes.Buffer
+	var serverName string
+	conn.SetReadDeadline(time.Now().Add(clientHelloTimeout))
+	err := tls.Server(readOnlyConn{io.TeeReader(conn, &buf)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	conn.SetReadDeadline(time.Time{})
+	if !errors.Is(err, errHelloRead) {
+		return "", nil, err
+	}
+	return serverName, &replayConn{Conn: conn, reader: io.MultiReader(&buf, conn)}, nil
+}
+
+// readOnlyConn lets crypto/tls parse a ClientHello without answering it
+type readOnlyConn struct {
+	reader io.Reader
+}
+
+func (c readOnlyConn) Read(b []byte) (int, error)         { return c.reader.Read(b) }
+func (c readOnlyConn) Write(b []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// replayConn reads from reader before reading from the connection
+type replayConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *replayConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
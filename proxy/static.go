@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// staticFiles serves a route from a local directory. Directories are served
+// through their index file and never listed, and hidden files, whose names
+// start with a dot, are treated as missing.
+type staticFiles struct {
+	root         http.Dir
+	index        []string
+	fallback     string
+	cacheControl string
+}
+
+// newStaticFiles returns nil when the route is served by a pool
+func newStaticFiles(cfg *config.StaticConfig) (*staticFiles, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	sf := &staticFiles{
+		root:         http.Dir(cfg.Root),
+		index:        cfg.Index,
+		cacheControl: "no-cache",
+	}
+	if cfg.Fallback != "" {
+		sf.fallback = path.Clean("/" + cfg.Fallback)
+	}
+	if cfg.MaxAge > 0 {
+		sf.cacheControl = "public, max-age=" + strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+	return sf, nil
+}
+
+// open returns the file serving name and whether name is a directory,
+// which is served through its index file
+func (sf *staticFiles) open(name string) (http.File, fs.FileInfo, bool, error) {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return nil, nil, false, fs.ErrNotExist
+		}
+	}
+	f, err := sf.root.Open(name)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, false, err
+	}
+	if !info.IsDir() {
+		return f, info, false, nil
+	}
+	f.Close()
+
+	for _, index := range sf.index {
+		f, err := sf.root.Open(path.Join(name, index))
+		if err != nil {
+			continue
+		}
+		if info, err := f.Stat(); err == nil && !info.IsDir() {
+			return f, info, true, nil
+		}
+		f.Close()
+	}
+	return nil, nil, true, fs.ErrNotExist
+}
+
+func (sf *staticFiles) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	f, info, dir, err := sf.open(name)
+	if err == nil && dir && !strings.HasSuffix(r.URL.Path, "/") {
+		// Directories are addressed with a trailing slash so relative links
+		// in their index resolve. The redirect is relative, so it holds
+		// whatever prefix the route strips.
+		f.Close()
+		target := (&url.URL{Path: path.Base(name) + "/"}).String()
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+	if errors.Is(err, fs.ErrNotExist) && sf.fallback != "" {
+		f, info, _, err = sf.open(sf.fallback)
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		logRequest(r, slog.LevelError, "Failed to open static file", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Cache-Control", sf.cacheControl)
+	// The validator is strong so that If-Range can match it
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
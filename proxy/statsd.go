@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// maxStatsDPacket keeps packets below the common path MTU so they are not
+// fragmented
+const maxStatsDPacket = 1432
+
+// statsdSink buffers metric lines and sends them to a StatsD agent in
+// packets, when the buffer fills and on every flush interval
+type statsdSink struct {
+	config config.StatsDConfig
+	conn   net.Conn
+	tags   []string // the configured tags as key:value
+	stop   chan struct{}
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// newStatsDSink returns nil when pushing to StatsD is disabled
+func newStatsDSink(cfg config.StatsDConfig) (*statsdSink, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	conn, err := net.Dial("udp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open statsd connection: %w", err)
+	}
+	s := &statsdSink{
+		config: cfg,
+		conn:   conn,
+		stop:   make(chan struct{}),
+		buf:    make([]byte, 0, maxStatsDPacket),
+	}
+	for k, v := range cfg.Tags {
+		s.tags = append(s.tags, k+":"+v)
+	}
+	sort.Strings(s.tags)
+	return s, nil
+}
+
+// timing records a duration in milliseconds
+func (s *statsdSink) timing(name string, d time.Duration, backend string) {
+	s.emit(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64), "ms", backend)
+}
+
+func (s *statsdSink) count(name string, n int64, backend string) {
+	s.emit(name, strconv.FormatInt(n, 10), "c", backend)
+}
+
+func (s *statsdSink) gauge(name string, v int64) {
+	s.emit(name, strconv.FormatInt(v, 10), "g", "")
+}
+
+// emit adds one metric line to the buffer. DogStatsD gets the backend as a
+// tag, plain StatsD as the last part of the name.
+func (s *statsdSink) emit(name, value, typ, backend string) {
+	if s == nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(s.config.Prefix)
+	b.WriteString(name)
+	if s.config.Format == config.StatsDFormatStatsD {
+		if backend != "" {
+			b.WriteByte('.')
+			b.WriteString(statsdName(backend))
+		}
+		b.WriteString(":" + value + "|" + typ)
+	} else {
+		b.WriteString(":" + value + "|" + typ)
+		tags := s.tags
+		if backend != "" {
+			tags = append(tags[:len(tags):len(tags)], "backend:"+backend)
+		}
+		if len(tags) > 0 {
+			b.WriteString("|#" + strings.Join(tags, ","))
+		}
+	}
+	line := b.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > maxStatsDPacket {
+		s.flushLocked()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+}
+
+func (s *statsdSink) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+}
+
+// flushLocked sends the buffered lines; s.mu must be held. Metrics are
+// best effort, so an agent that is down only loses them.
+func (s *statsdSink) flushLocked() {
+	if len(s.buf) == 0 {
+		return
+	}
+	_, _ = s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+}
+
+// statsdName makes a backend URL usable as part of a metric name
+func statsdName(s string) string {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "http://"), "https://")
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
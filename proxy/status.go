@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the build version reported by the status endpoint. Release
+// builds set it with -ldflags "-X github.com/bunnydevv/reverse-proxy/proxy.Version=v1.2.3";
+// otherwise it is taken from the module or VCS information in the binary.
+var Version string
+
+func buildVersion() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "devel"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+			return "devel-" + s.Value[:12]
+		}
+	}
+	return "devel"
+}
+
+// configHash fingerprints the effective configuration, so instances running
+// the same configuration can be recognized regardless of file formatting
+func configHash(cfg *config.Config) string {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Status describes the running proxy
+type Status struct {
+	Version       string          `json:"version"`
+	StartedAt     time.Time       `json:"started_at"`
+	UptimeSeconds int64           `json:"uptime_seconds"`
+	Algorithm     string          `json:"load_balancer_algorithm"`
+	ConfigHash    string          `json:"config_hash"`
+	Backends      []BackendStatus `json:"backends"`
+}
+
+// BackendStatus describes the health and load of one backend
+type BackendStatus struct {
+	URL         string `json:"url"`
+	Alive       bool   `json:"alive"`
+	Draining    bool   `json:"draining"`
+	Connections int    `json:"connections"`
+	Weight      int    `json:"weight"`
+	Priority    int    `json:"priority"`
+	Canary      bool   `json:"canary"`
+}
+
+func (rp *ReverseProxy) status() Status {
+	s := Status{
+		Version:       buildVersion(),
+		StartedAt:     rp.started.UTC(),
+		UptimeSeconds: int64(time.Since(rp.started).Seconds()),
+		Algorithm:     rp.config.LoadBalancer.Algorithm,
+		ConfigHash:    rp.configHash,
+	}
+	backends := rp.backendList()
+	s.Backends = make([]BackendStatus, 0, len(backends))
+	for _, b := range backends {
+		s.Backends = append(s.Backends, BackendStatus{
+			URL:         b.URL.String(),
+			Alive:       b.IsAlive(),
+			Draining:    b.IsDraining(),
+			Connections: b.GetConnections(),
+			Weight:      b.GetWeight(),
+			Priority:    b.Priority,
+			Canary:      b.Canary,
+		})
+	}
+	return s
+}
+
+// statusHandler reports the proxy's status on GET
+func (rp *ReverseProxy) statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, rp.status())
+}
+
+// configHandler serves the effective configuration as YAML, with secrets
+// redacted
+func (rp *ReverseProxy) configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	out, err := rp.config.Dump()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(out)
+}
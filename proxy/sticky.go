@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// stickySessions keeps a client on the backend that served its first
+// request, identified by an affinity cookie whose key is resolved through
+// the session store
+type stickySessions struct {
+	config config.StickyConfig
+	store  SessionStore
+	ttl    time.Duration // of the store's mappings
+}
+
+// newStickySessions returns nil when sticky sessions are disabled
+func newStickySessions(cfg config.StickyConfig, store SessionStore, ttl time.Duration) *stickySessions {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &stickySessions{config: cfg, store: store, ttl: ttl}
+}
+
+// nextBackend returns the client's pinned backend in pool while it is
+// available, even if drained with a weight of 0, otherwise a backend chosen
+// by the pool's load balancer, and records the choice for the client's
+// following requests
+func (s *stickySessions) nextBackend(w http.ResponseWriter, r *http.Request, pool *backendPool) *Backend {
+	var key string
+	if c, err := r.Cookie(s.config.CookieName); err == nil && c.Value != "" {
+		key = c.Value
+	}
+
+	// Mappings are kept per pool so one cookie can pin a backend in each
+	storeKey := pool.name + "/" + key
+	if key != "" {
+		if value, ok := s.store.Get(storeKey); ok {
+			backendURL, expires := parseStickyMapping(value)
+			if b := pool.backend(backendURL); b != nil && b.availablePinned() && pool.preferred(b) {
+				// Refresh the mapping so active sessions don't expire, but
+				// only once half its TTL has passed: each write costs a
+				// round trip to Redis or a replicated cluster write
+				if time.Until(expires) < s.ttl/2 {
+					s.set(storeKey, backendURL)
+				}
+				return b
+			}
+		}
+	}
+
+	backend := pool.NextBackend(r)
+	if backend == nil {
+		return nil
+	}
+
+	if key == "" {
+		key = newAffinityKey()
+		storeKey = pool.name + "/" + key
+		http.SetCookie(w, &http.Cookie{
+			Name:     s.config.CookieName,
+			Value:    key,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   s.config.Secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	s.set(storeKey, backend.URL.String())
+	return backend
+}
+
+// set maps key to backendURL, storing the mapping's expiry with it as
+// "<unix seconds> <url>"
+func (s *stickySessions) set(key, backendURL string) {
+	expires := time.Now().Add(s.ttl).Unix()
+	s.store.Set(key, strconv.FormatInt(expires, 10)+" "+backendURL)
+}
+
+// parseStickyMapping splits a stored mapping into the backend URL and its
+// expiry. Mappings written without an expiry are due for a refresh.
+func parseStickyMapping(value string) (backendURL string, expires time.Time) {
+	if unix, url, ok := strings.Cut(value, " "); ok {
+		if sec, err := strconv.ParseInt(unix, 10, 64); err == nil {
+			return url, time.Unix(sec, 0)
+		}
+	}
+	return value, time.Time{}
+}
+
+func newAffinityKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
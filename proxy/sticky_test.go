@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// newTestBackend returns an alive backend with the given weight
+func newTestBackend(t *testing.T, rawURL string, weight int) *Backend {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Backend{URL: u}
+	b.SetWeight(weight)
+	b.SetAlive(true)
+	return b
+}
+
+// countingStore is a memory session store that counts its writes
+type countingStore struct {
+	*memorySessionStore
+	sets int
+}
+
+func (s *countingStore) Set(key, backendURL string) {
+	s.sets++
+	s.memorySessionStore.Set(key, backendURL)
+}
+
+func TestStickyRefreshesMappingAfterHalfTheTTL(t *testing.T) {
+	store := &countingStore{memorySessionStore: newMemorySessionStore(time.Hour)}
+	s := newStickySessions(config.StickyConfig{Enabled: true, CookieName: "affinity"}, store, time.Hour)
+	backend := newTestBackend(t, "http://app:8080", 1)
+	pool := newPool("", []*Backend{backend}, func(backends []*Backend) LoadBalancer {
+		return NewRoundRobinBalancer(backends)
+	})
+
+	w := httptest.NewRecorder()
+	if got := s.nextBackend(w, httptest.NewRequest(http.MethodGet, "/", nil), pool); got != backend {
+		t.Fatalf("first request went to %v", got)
+	}
+	cookie := w.Result().Cookies()[0]
+
+	pinned := func() {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(cookie)
+		if got := s.nextBackend(httptest.NewRecorder(), r, pool); got != backend {
+			t.Fatalf("pinned request went to %v", got)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		pinned()
+	}
+	if store.sets != 1 {
+		t.Fatalf("store written %d times for a fresh mapping, want 1", store.sets)
+	}
+
+	// A mapping past half its TTL is renewed by the next request only
+	key := "/" + cookie.Value
+	store.memorySessionStore.Set(key, "0 "+backend.URL.String())
+	pinned()
+	pinned()
+	if store.sets != 2 {
+		t.Errorf("store written %d times, want the stale mapping renewed once", store.sets)
+	}
+}
@@ -0,0 +1,318 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// streamProxy forwards raw TCP connections accepted on one address to a
+// pool of backends, or passes TLS connections through to the pool of the
+// requested server name
+type streamProxy struct {
+	config config.StreamConfig
+	pool   *backendPool             // nil if only SNI routes are configured
+	routes *hostTable[*backendPool] // nil without SNI routes
+	access *accessList
+	rp     *ReverseProxy
+
+	ln    net.Listener
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+func (rp *ReverseProxy) newStreamProxy(cfg config.StreamConfig, transports *transportBuilder) (*streamProxy, error) {
+	access, err := newAccessList(cfg.Access)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &streamProxy{
+		config: cfg,
+		access: access,
+		rp:     rp,
+		conns:  make(map[net.Conn]struct{}),
+	}
+	if len(cfg.Backends) > 0 {
+		sp.pool, err = rp.newStreamPool("stream:"+cfg.Name, cfg.Backends, cfg.Algorithm, transports)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.SNIRoutes) > 0 {
+		sp.routes = newHostTable[*backendPool]()
+		for _, route := range cfg.SNIRoutes {
+			pool, err := rp.newStreamPool("stream:"+cfg.Name+":"+route.Hosts[0], route.Backends, cfg.Algorithm, transports)
+			if err != nil {
+				return nil, err
+			}
+			for _, host := range route.Hosts {
+				sp.routes.add(host, pool)
+			}
+		}
+	}
+	return sp, nil
+}
+
+func (rp *ReverseProxy) newStreamPool(name string, cfgs []config.Backend, algorithm string, transports *transportBuilder) (*backendPool, error) {
+	backends := make([]*Backend, 0, len(cfgs))
+	discovered := make([]config.Backend, 0, len(cfgs))
+	for _, b := range cfgs {
+		if b.Discovered() {
+			// SRV targets of a stream are dialled over plain TCP
+			if b.URL == "" {
+				b.URL = "tcp://"
+			}
+			discovered = append(discovered, b)
+			continue
+		}
+		backend, err := rp.newStreamBackend(b, transports)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, backend)
+	}
+	lbConfig := rp.config.LoadBalancer
+	lbConfig.Algorithm = algorithm
+	pool := newPool(name, backends, func(backends []*Backend) LoadBalancer {
+		return rp.newPoolBalancer(lbConfig, backends)
+	})
+	rp.discovery.watch(pool, discovered, rp.newStreamBackend)
+	return pool, nil
+}
+
+// newStreamBackend creates a backend reached over plain TCP and registers it
+// with the proxy so health checks and maintenance cover it
+func (rp *ReverseProxy) newStreamBackend(b config.Backend, transports *transportBuilder) (*Backend, error) {
+	backendURL, err := url.Parse(b.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend URL %s: %w", b.URL, err)
+	}
+
+	weight := 1
+	if b.Weight != nil {
+		weight = *b.Weight
+	}
+
+	dial, err := transports.dialer(b)
+	if err != nil {
+		return nil, fmt.Errorf("backend %s: %w", b.URL, err)
+	}
+
+	windows, err := newMaintenanceWindows(b.Maintenance)
+	if err != nil {
+		return nil, fmt.Errorf("backend %s: %w", b.URL, err)
+	}
+
+	backend := &Backend{
+		URL:         backendURL,
+		Priority:    b.Priority,
+		maintenance: windows,
+		healthCheck: b.HealthCheck,
+		dial:        dial,
+	}
+	backend.alive.Store(true)
+	backend.weight.Store(int64(weight))
+	rp.addBackend(backend)
+	return backend, nil
+}
+
+func (sp *streamProxy) Start() error {
+	ln, err := sp.rp.listen(sp.config.Address, sp.config.ProxyProtocol)
+	if err != nil {
+		return fmt.Errorf("failed to listen for stream %s: %w", sp.config.Name, err)
+	}
+	sp.ln = ln
+
+	sp.rp.logger.Info("Starting TCP stream", "stream", sp.config.Name, "address", sp.config.Address)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					continue
+				}
+				return
+			}
+			sp.track(conn, true)
+			go sp.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops accepting connections and waits for open ones to finish
+// until ctx is done, then closes them
+func (sp *streamProxy) Shutdown(ctx context.Context) error {
+	if sp.ln == nil {
+		return nil
+	}
+	sp.ln.Close()
+
+	done := make(chan struct{})
+	go func() {
+		sp.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		sp.mu.Lock()
+		for conn := range sp.conns {
+			conn.Close()
+		}
+		sp.mu.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (sp *streamProxy) track(conn net.Conn, add bool) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if add {
+		sp.conns[conn] = struct{}{}
+		sp.wg.Add(1)
+	} else {
+		delete(sp.conns, conn)
+		sp.wg.Done()
+	}
+}
+
+func (sp *streamProxy) serve(client net.Conn) {
+	defer sp.track(client, false)
+	defer client.Close()
+
+	// Load balancers select backends for HTTP requests, so describe the
+	// connection as one
+	r := &http.Request{
+		Method:     http.MethodConnect,
+		URL:        &url.URL{Path: "/"},
+		Header:     make(http.Header),
+		RemoteAddr: client.RemoteAddr().String(),
+	}
+	if !sp.access.admits(peerAddr(r)) {
+		return
+	}
+
+	pool := sp.pool
+	if sp.routes != nil {
+		serverName, conn, err := peekServerName(client)
+		if err != nil {
+			sp.rp.logger.Warn("No TLS ClientHello on stream", "stream", sp.config.Name, "client", r.RemoteAddr, "error", err)
+			return
+		}
+		client = conn
+		if p, ok := sp.routes.lookup(serverName); ok {
+			pool = p
+		}
+		if pool == nil {
+			sp.rp.logger.Warn("No route for server name on stream", "stream", sp.config.Name, "server_name", serverName)
+			return
+		}
+	}
+
+	var tried []*Backend
+	for {
+		backend := sp.rp.retry.nextBackend(r, pool, tried)
+		if backend == nil {
+			sp.rp.logger.Error("No healthy backends available for stream", "stream", sp.config.Name)
+			return
+		}
+		// Once every available backend has refused, the balancer's pick is
+		// one of them again
+		if slices.Contains(tried, backend) {
+			sp.rp.logger.Error("Stream failed to connect to every backend", "stream", sp.config.Name, "attempts", len(tried))
+			return
+		}
+		upstream, err := sp.connect(backend)
+		if err == nil {
+			sp.pipe(client, upstream, backend)
+			return
+		}
+		sp.rp.logger.Warn("Stream failed to connect to backend", "stream", sp.config.Name, "backend", backend.URL.String(), "error", err)
+		tried = append(tried, backend)
+	}
+}
+
+func (sp *streamProxy) connect(backend *Backend) (net.Conn, error) {
+	ctx := context.Background()
+	if sp.config.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sp.config.ConnectTimeout)
+		defer cancel()
+	}
+	conn, err := backend.dial(ctx, "tcp", backend.URL.Host)
+	if sp.rp.passive != nil {
+		status := http.StatusOK
+		if err != nil {
+			status = http.StatusBadGateway
+		}
+		sp.rp.passive.record(backend, status)
+	}
+	return conn, err
+}
+
+// pipe copies data both ways until both sides have finished sending
+func (sp *streamProxy) pipe(client, upstream net.Conn, backend *Backend) {
+	defer upstream.Close()
+
+	backend.connections.Add(1)
+	defer backend.connections.Add(-1)
+
+	if sp.config.IdleTimeout > 0 {
+		client = &idleTimeoutConn{Conn: client, timeout: sp.config.IdleTimeout}
+		upstream = &idleTimeoutConn{Conn: upstream, timeout: sp.config.IdleTimeout}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		closeWrite(dst)
+	}
+	go copyHalf(upstream, client)
+	go copyHalf(client, upstream)
+	wg.Wait()
+}
+
+// closeWrite signals the end of data to the peer of conn, closing it
+// entirely when it can't be half-closed
+func closeWrite(conn net.Conn) {
+	if ic, ok := conn.(*idleTimeoutConn); ok {
+		conn = ic.Conn
+	}
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// idleTimeoutConn fails reads and writes once the connection has been idle
+// for the timeout
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
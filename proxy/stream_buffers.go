@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"sync"
+)
+
+// streamBufferSize is how much of a streamed response is read from the
+// backend and written to the client at a time, eight times the reverse
+// proxy's default, so large bodies take fewer system calls
+const streamBufferSize = 256 * 1024
+
+// bufferPool is an httputil.BufferPool over a sync.Pool
+type bufferPool struct {
+	pool sync.Pool
+}
+
+// newBufferPool returns a pool of buffers of size bytes
+func newBufferPool(size int) *bufferPool {
+	return &bufferPool{pool: sync.Pool{New: func() any {
+		b := make([]byte, size)
+		return &b
+	}}}
+}
+
+func (bp *bufferPool) Get() []byte {
+	return *bp.pool.Get().(*[]byte)
+}
+
+func (bp *bufferPool) Put(b []byte) {
+	bp.pool.Put(&b)
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// refusedAddr returns an address nothing listens on
+func refusedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func newTestStream(t *testing.T, backends ...string) *streamProxy {
+	t.Helper()
+	yml := "server:\n  address: \":0\"\nstreams:\n  - name: test\n    address: \"127.0.0.1:0\"\n    backends:\n"
+	for _, b := range backends {
+		yml += fmt.Sprintf("      - url: \"tcp://%s\"\n", b)
+	}
+	rp := newTestProxy(t, yml)
+	if len(rp.streams) != 1 {
+		t.Fatalf("got %d streams, want 1", len(rp.streams))
+	}
+	return rp.streams[0]
+}
+
+// serveTestConn serves one connection on sp and returns the client's end
+// and a channel closed once serve has returned
+func serveTestConn(sp *streamProxy) (net.Conn, <-chan struct{}) {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	sp.track(server, true)
+	go func() {
+		defer close(done)
+		sp.serve(server)
+	}()
+	return client, done
+}
+
+func TestStreamGivesUpWhenEveryBackendRefuses(t *testing.T) {
+	sp := newTestStream(t, refusedAddr(t), refusedAddr(t))
+
+	client, done := serveTestConn(sp)
+	defer client.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve kept connecting after every backend refused")
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("client read error = %v, want EOF", err)
+	}
+}
+
+func TestStreamFailsOverToNextBackend(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	sp := newTestStream(t, refusedAddr(t), ln.Addr().String())
+
+	// Whichever backend the balancer picks first, the client reaches the
+	// listening one
+	client, done := serveTestConn(sp)
+	defer client.Close()
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = %q, %v; want ping", buf, err)
+	}
+	client.Close()
+	<-done
+}
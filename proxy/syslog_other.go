@@ -0,0 +1,24 @@
+//go:build !unix
+
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// syslogOutput is not available on this platform
+type syslogOutput struct {
+	io.WriteCloser
+}
+
+func newSyslogOutput(cfg config.SyslogConfig) (*syslogOutput, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
+
+func (s *syslogOutput) handle(h func() error, level slog.Level) error {
+	return h()
+}
@@ -0,0 +1,59 @@
+//go:build unix
+
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"sync"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// syslogOutput sends each log line to syslog with the severity of its level
+type syslogOutput struct {
+	w *syslog.Writer
+
+	mu    sync.Mutex
+	level slog.Level
+}
+
+func newSyslogOutput(cfg config.SyslogConfig) (*syslogOutput, error) {
+	w, err := syslog.Dial(cfg.Network, cfg.Address, syslog.Priority(cfg.FacilityCode())|syslog.LOG_INFO, cfg.Tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &syslogOutput{w: w}, nil
+}
+
+// handle has h write one record, sent with the record's severity
+func (s *syslogOutput) handle(h func() error, level slog.Level) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.level = level
+	return h()
+}
+
+func (s *syslogOutput) Write(p []byte) (int, error) {
+	msg := string(p)
+	var err error
+	switch {
+	case s.level >= slog.LevelError:
+		err = s.w.Err(msg)
+	case s.level >= slog.LevelWarn:
+		err = s.w.Warning(msg)
+	case s.level >= slog.LevelInfo:
+		err = s.w.Info(msg)
+	default:
+		err = s.w.Debug(msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *syslogOutput) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/cluster"
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// tenantRejections are the reasons a tenant's request is turned away, in
+// the order they are reported
+var tenantRejections = []string{"rate_limit", "concurrency"}
+
+// tenants assigns requests to the teams sharing the proxy and holds each
+// team to its own limits, so that one can't starve the others
+type tenants struct {
+	list []*tenant // in configured order; the first match wins
+}
+
+type tenant struct {
+	name     string
+	hosts    *hostTable[bool] // nil without hosts
+	prefixes []string
+	apiKeys  map[string]bool
+
+	rate        float64 // tokens per second; 0 is unlimited
+	burst       float64
+	shared      *clusterLimit // replaces the bucket in cluster mode
+	maxRequests int           // 0 is unlimited; per node
+
+	mu        sync.Mutex
+	bucket    tokenBucket
+	inFlight  int
+	rejected  map[string]uint64 // by reason
+	responses [5]uint64         // by status class, 1xx to 5xx
+}
+
+type tenantKey struct{}
+
+// tenantName returns the name of the tenant r belongs to, if any
+func tenantName(r *http.Request) (string, bool) {
+	name, ok := r.Context().Value(tenantKey{}).(string)
+	return name, ok
+}
+
+// newTenants returns nil when no tenants are configured. With a cluster
+// node, rate limits hold for the whole cluster: a tenant may make burst
+// requests in each window of burst / rate seconds.
+func newTenants(cfgs []config.TenantConfig, node *cluster.Node) *tenants {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	ts := &tenants{}
+	now := time.Now()
+	for _, c := range cfgs {
+		t := &tenant{
+			name:        c.Name,
+			prefixes:    c.PathPrefixes,
+			rate:        c.RequestsPerSecond,
+			burst:       float64(c.Burst),
+			maxRequests: c.MaxRequests,
+			bucket:      tokenBucket{tokens: float64(c.Burst), last: now},
+			rejected:    make(map[string]uint64),
+		}
+		if node != nil && c.RequestsPerSecond > 0 {
+			t.shared = &clusterLimit{
+				node:   node,
+				limit:  int64(c.Burst),
+				window: time.Duration(float64(c.Burst) / c.RequestsPerSecond * float64(time.Second)),
+			}
+		}
+		if len(c.Hosts) > 0 {
+			t.hosts = newHostTable[bool]()
+			for _, h := range c.Hosts {
+				t.hosts.add(h, true)
+			}
+		}
+		if len(c.APIKeys) > 0 {
+			t.apiKeys = make(map[string]bool, len(c.APIKeys))
+			for _, id := range c.APIKeys {
+				t.apiKeys[id] = true
+			}
+		}
+		ts.list = append(ts.list, t)
+	}
+	return ts
+}
+
+// match returns the tenant r belongs to, or nil. Path prefixes match whole
+// segments of the cleaned path, like routes.
+func (ts *tenants) match(r *http.Request) *tenant {
+	id, hasKey := apiKeyID(r)
+	path := cleanPath(r.URL.Path)
+	for _, t := range ts.list {
+		if hasKey && t.apiKeys[id] {
+			return t
+		}
+		if t.hosts != nil {
+			if _, ok := t.hosts.lookup(r.Host); ok {
+				return t
+			}
+		}
+		for _, prefix := range t.prefixes {
+			if hasPathPrefix(path, prefix) {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// admit counts a request against the tenant's limits. A request over them
+// is counted as rejected and admit returns the reason, and for the rate
+// limit how long until the next request would be allowed.
+func (t *tenant) admit(now time.Time) (string, time.Duration, uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.maxRequests > 0 && t.inFlight >= t.maxRequests {
+		t.rejected["concurrency"]++
+		return "concurrency", 0, t.rejected["concurrency"]
+	}
+	if t.shared != nil {
+		if ok, wait := t.shared.allow("tenant/"+t.name, now); !ok {
+			t.rejected["rate_limit"]++
+			return "rate_limit", wait, t.rejected["rate_limit"]
+		}
+	} else if t.rate > 0 {
+		b := &t.bucket
+		b.tokens = math.Min(t.burst, b.tokens+now.Sub(b.last).Seconds()*t.rate)
+		b.last = now
+		if b.tokens < 1 {
+			t.rejected["rate_limit"]++
+			return "rate_limit", time.Duration((1 - b.tokens) / t.rate * float64(time.Second)), t.rejected["rate_limit"]
+		}
+		b.tokens--
+	}
+	t.inFlight++
+	return "", 0, 0
+}
+
+// release ends an admitted request that was answered with status
+func (t *tenant) release(status int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight--
+	if class := status/100 - 1; class >= 0 && class < len(t.responses) {
+		t.responses[class]++
+	}
+}
+
+func (ts *tenants) middleware(next http.Handler) http.Handler {
+	if ts == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := ts.match(r)
+		if t == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), tenantKey{}, t.name))
+
+		reason, wait, rejected := t.admit(time.Now())
+		if reason != "" {
+			// Log the first rejected request and then every thousandth
+			if rejected%1000 == 1 {
+				logRequest(r, slog.LevelWarn, "Tenant over its limit", "reason", reason, "rejected", rejected)
+			}
+			retryAfter := int64(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.FormatInt(max(retryAfter, 1), 10))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		rw := newResponseWriter(w)
+		defer func() { t.release(rw.status) }()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// write writes the tenants' metrics in the Prometheus text format
+func (ts *tenants) write(w io.Writer) {
+	if ts == nil {
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP reverse_proxy_tenant_responses_total Responses to each tenant's admitted requests by status class.\n# TYPE reverse_proxy_tenant_responses_total counter\n")
+	for _, t := range ts.list {
+		t.mu.Lock()
+		for i, n := range t.responses {
+			fmt.Fprintf(w, "reverse_proxy_tenant_responses_total{tenant=\"%s\",code=\"%dxx\"} %d\n", escapeLabel(t.name), i+1, n)
+		}
+		t.mu.Unlock()
+	}
+	fmt.Fprintf(w, "# HELP reverse_proxy_tenant_rejected_total Requests refused because their tenant was over a limit.\n# TYPE reverse_proxy_tenant_rejected_total counter\n")
+	for _, t := range ts.list {
+		t.mu.Lock()
+		for _, reason := range tenantRejections {
+			fmt.Fprintf(w, "reverse_proxy_tenant_rejected_total{tenant=\"%s\",reason=\"%s\"} %d\n", escapeLabel(t.name), reason, t.rejected[reason])
+		}
+		t.mu.Unlock()
+	}
+	fmt.Fprintf(w, "# HELP reverse_proxy_tenant_requests_in_flight Requests of each tenant currently being served.\n# TYPE reverse_proxy_tenant_requests_in_flight gauge\n")
+	for _, t := range ts.list {
+		t.mu.Lock()
+		fmt.Fprintf(w, "reverse_proxy_tenant_requests_in_flight{tenant=\"%s\"} %d\n", escapeLabel(t.name), t.inFlight)
+		t.mu.Unlock()
+	}
+}
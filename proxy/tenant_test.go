@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/cluster"
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+func TestTenantRateLimitIsSharedByTheCluster(t *testing.T) {
+	node := cluster.New(config.ClusterConfig{Enabled: true, NodeName: "a"}, slog.Default())
+	cfgs := []config.TenantConfig{{Name: "checkout", RequestsPerSecond: 1, Burst: 2}}
+	ts := newTenants(cfgs, node)
+	tn := ts.list[0]
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if reason, _, _ := tn.admit(now); reason != "" {
+			t.Fatalf("request %d refused: %s", i+1, reason)
+		}
+		tn.release(200)
+	}
+	if reason, _, _ := tn.admit(now); reason != "rate_limit" {
+		t.Fatalf("request over the burst: reason %q, want rate_limit", reason)
+	}
+
+	// The requests were counted where peers see them
+	if got := node.Counter("tenant/checkout", 2*time.Second); got != 2 {
+		t.Errorf("cluster counter = %d, want 2", got)
+	}
+}
+
+func TestTenantConcurrencyCap(t *testing.T) {
+	ts := newTenants([]config.TenantConfig{{Name: "search", MaxRequests: 1}}, nil)
+	tn := ts.list[0]
+
+	now := time.Now()
+	if reason, _, _ := tn.admit(now); reason != "" {
+		t.Fatalf("first request refused: %s", reason)
+	}
+	if reason, _, _ := tn.admit(now); reason != "concurrency" {
+		t.Fatalf("second request: reason %q, want concurrency", reason)
+	}
+	tn.release(200)
+	if reason, _, _ := tn.admit(now); reason != "" {
+		t.Fatalf("request after release refused: %s", reason)
+	}
+}
+
+func TestTenantPathPrefixesMatchCleanedSegments(t *testing.T) {
+	ts := newTenants([]config.TenantConfig{
+		{Name: "t1", PathPrefixes: []string{"/t1"}},
+		{Name: "t2", PathPrefixes: []string{"/t2/"}},
+	}, nil)
+
+	for path, want := range map[string]string{
+		"/t1":             "t1",
+		"/t1/orders":      "t1",
+		"/t1x":            "",
+		"/t2/orders":      "t2",
+		"/t1/../t2/a":     "t2",
+		"/t2/../t1/a":     "t1",
+		"/t2/%2e%2e/t1/a": "t1",
+		"/t2/..":          "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.Path, _ = url.PathUnescape(path)
+		got := ""
+		if tn := ts.match(r); tn != nil {
+			got = tn.name
+		}
+		if got != want {
+			t.Errorf("%s: tenant %q, want %q", path, got, want)
+		}
+	}
+}
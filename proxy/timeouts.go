@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// The causes of an attempt canceled by one of its timeouts. They wrap
+// context.DeadlineExceeded so the client gets 504 Gateway Timeout.
+var (
+	errReadTimeout  = fmt.Errorf("upstream read timeout: %w", context.DeadlineExceeded)
+	errWriteTimeout = fmt.Errorf("upstream write timeout: %w", context.DeadlineExceeded)
+	errTotalTimeout = fmt.Errorf("upstream total timeout: %w", context.DeadlineExceeded)
+)
+
+// upstreamTimeouts bound each attempt to reach a backend; zero disables one
+type upstreamTimeouts struct {
+	read      time.Duration
+	write     time.Duration
+	total     time.Duration
+	streaming bool
+}
+
+// override returns t with the values set in cfg taking precedence
+func (t upstreamTimeouts) override(cfg *config.TimeoutsConfig) upstreamTimeouts {
+	if cfg == nil {
+		return t
+	}
+	if cfg.Read > 0 {
+		t.read = cfg.Read
+	}
+	if cfg.Write > 0 {
+		t.write = cfg.Write
+	}
+	if cfg.Total > 0 {
+		t.total = cfg.Total
+	}
+	if cfg.Streaming != nil {
+		t.streaming = *cfg.Streaming
+	}
+	return t
+}
+
+// upstreamDeadline enforces the timeouts of one attempt by canceling its
+// context with the cause of the timeout that fired
+type upstreamDeadline struct {
+	timeouts  upstreamTimeouts
+	streaming bool
+	cancel    context.CancelCauseFunc
+
+	mu      sync.Mutex
+	total   *time.Timer // nil while the timeout doesn't run
+	write   *time.Timer
+	read    *time.Timer
+	headers bool // the response headers started arriving
+}
+
+type upstreamDeadlineKey struct{}
+
+// apply returns r with a context canceled when a timeout expires and a
+// function releasing it once the attempt is over. The write timeout runs
+// until the request is sent, the read timeout from then until the response
+// headers arrive, and the total timeout across both and the response body.
+// Upgraded connections are treated as streaming.
+func (t upstreamTimeouts) apply(r *http.Request) (*http.Request, func()) {
+	if t.read == 0 && t.write == 0 && t.total == 0 {
+		return r, func() {}
+	}
+
+	d := &upstreamDeadline{
+		timeouts:  t,
+		streaming: t.streaming || r.Header.Get("Upgrade") != "",
+	}
+	ctx, cancel := context.WithCancelCause(r.Context())
+	d.cancel = cancel
+
+	d.mu.Lock()
+	d.total = d.start(t.total, errTotalTimeout)
+	d.write = d.start(t.write, errWriteTimeout)
+	d.mu.Unlock()
+
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			d.stop(&d.write)
+			// A backend may answer before it has read the whole request
+			if !d.headers {
+				d.read = d.start(t.read, errReadTimeout)
+			}
+		},
+		GotFirstResponseByte: func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			d.headers = true
+			d.stop(&d.read)
+		},
+	}
+	ctx = httptrace.WithClientTrace(ctx, trace)
+	ctx = context.WithValue(ctx, upstreamDeadlineKey{}, d)
+
+	return r.WithContext(ctx), func() {
+		d.mu.Lock()
+		d.stop(&d.total)
+		d.stop(&d.write)
+		d.stop(&d.read)
+		d.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+func deadlineFromContext(ctx context.Context) *upstreamDeadline {
+	d, _ := ctx.Value(upstreamDeadlineKey{}).(*upstreamDeadline)
+	return d
+}
+
+// start runs a timer canceling the attempt with cause, or returns nil when
+// the timeout is disabled; d.mu must be held
+func (d *upstreamDeadline) start(timeout time.Duration, cause error) *time.Timer {
+	if timeout == 0 {
+		return nil
+	}
+	return time.AfterFunc(timeout, func() { d.cancel(cause) })
+}
+
+// stop stops a timer; d.mu must be held
+func (d *upstreamDeadline) stop(timer **time.Timer) {
+	if *timer != nil {
+		(*timer).Stop()
+		*timer = nil
+	}
+}
+
+// responded applies the timeouts to the response body: streaming responses
+// are no longer bounded, others have each read of the body bounded by the
+// read timeout
+func (d *upstreamDeadline) responded(resp *http.Response) {
+	if d.streaming || resp.StatusCode == http.StatusSwitchingProtocols {
+		d.mu.Lock()
+		d.stop(&d.total)
+		d.mu.Unlock()
+		return
+	}
+	if d.timeouts.read > 0 {
+		resp.Body = &idleTimeoutBody{ReadCloser: resp.Body, deadline: d}
+	}
+}
+
+// idleTimeoutBody cancels the attempt when a read of the response body
+// takes longer than the read timeout
+type idleTimeoutBody struct {
+	io.ReadCloser
+	deadline *upstreamDeadline
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	d := b.deadline
+	d.mu.Lock()
+	d.stop(&d.read)
+	d.read = d.start(d.timeouts.read, errReadTimeout)
+	d.mu.Unlock()
+
+	n, err := b.ReadCloser.Read(p)
+
+	d.mu.Lock()
+	d.stop(&d.read)
+	d.mu.Unlock()
+	return n, err
+}
+
+// timeouts returns the timeouts of pools without their own
+func (rp *ReverseProxy) timeouts() upstreamTimeouts {
+	return upstreamTimeouts{total: rp.config.Limits.RequestTimeout}
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certificateStore selects the certificate for a TLS handshake by the SNI
+// name the client requested: ACME-managed domains first, then virtual host
+// certificates, falling back to the default certificate
+type certificateStore struct {
+	config      *config.TLSConfig
+	defaultCert *tls.Certificate
+	hosts       *hostTable[*tls.Certificate]
+	clientCAs   *x509.CertPool // verifies client certificates, if configured
+
+	acme        *autocert.Manager
+	acmeDomains map[string]bool
+	acmeHTTP    *http.Server   // answers HTTP-01 challenges, nil if disabled
+	acmeDNS     *acmeDNSIssuer // used instead of acme for DNS-01 challenges
+	logger      *slog.Logger
+}
+
+// newCertificateStore returns nil when TLS is disabled
+func newCertificateStore(cfg *config.Config, logger *slog.Logger) (*certificateStore, error) {
+	if cfg.TLS == nil || !cfg.TLS.Enabled {
+		return nil, nil
+	}
+
+	cs := &certificateStore{
+		config: cfg.TLS,
+		hosts:  newHostTable[*tls.Certificate](),
+		logger: logger,
+	}
+	if cfg.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		cs.defaultCert = &cert
+	}
+	if cfg.TLS.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS client_ca_file: %w", err)
+		}
+		cs.clientCAs = x509.NewCertPool()
+		if !cs.clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("TLS client_ca_file %s contains no certificates", cfg.TLS.ClientCAFile)
+		}
+	}
+	if cfg.TLS.ACME != nil && cfg.TLS.ACME.DNS != nil {
+		cs.acmeDNS = newACMEDNSIssuer(*cfg.TLS.ACME, logger)
+	} else if cfg.TLS.ACME != nil {
+		cs.setupACME(*cfg.TLS.ACME)
+	}
+
+	for _, vh := range cfg.VHosts {
+		if vh.TLS == nil {
+			continue
+		}
+		cert, err := tls.LoadX509KeyPair(vh.TLS.CertFile, vh.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate for %v: %w", vh.Hosts, err)
+		}
+		for _, host := range vh.Hosts {
+			cs.hosts.add(host, &cert)
+		}
+	}
+	return cs, nil
+}
+
+// setupACME has certificates for the configured domains issued and renewed
+// automatically, answering TLS-ALPN-01 challenges on the main listener and
+// HTTP-01 challenges on the HTTP address
+func (cs *certificateStore) setupACME(cfg config.ACMEConfig) {
+	cs.acme = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.CacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.Domains...),
+		Email:      cfg.Email,
+	}
+	if cfg.DirectoryURL != "" {
+		cs.acme.Client = &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	}
+
+	cs.acmeDomains = make(map[string]bool, len(cfg.Domains))
+	for _, d := range cfg.Domains {
+		cs.acmeDomains[config.NormalizeHost(d)] = true
+	}
+
+	if cfg.HTTPAddress != "" {
+		// Anything other than a challenge is redirected to HTTPS
+		cs.acmeHTTP = &http.Server{
+			Addr:              cfg.HTTPAddress,
+			Handler:           cs.acme.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+}
+
+func (cs *certificateStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cs.acmeDNS != nil {
+		if cert, ok, err := cs.acmeDNS.getCertificate(hello); ok {
+			return cert, err
+		}
+	}
+	if cs.acme != nil && (cs.acmeDomains[config.NormalizeHost(hello.ServerName)] || isACMEChallenge(hello)) {
+		return cs.acme.GetCertificate(hello)
+	}
+	if cert, ok := cs.hosts.lookup(hello.ServerName); ok {
+		return cert, nil
+	}
+	if cs.defaultCert == nil {
+		return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+	}
+	return cs.defaultCert, nil
+}
+
+// isACMEChallenge reports whether a handshake is a TLS-ALPN-01 validation
+func isACMEChallenge(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+func (cs *certificateStore) tlsConfig() *tls.Config {
+	minVersion, maxVersion := cs.config.Versions()
+	cfg := &tls.Config{
+		MinVersion:     minVersion,
+		MaxVersion:     maxVersion,
+		CipherSuites:   cs.config.CipherSuiteIDs(),
+		GetCertificate: cs.getCertificate,
+		ClientAuth:     cs.config.ClientAuthType(),
+		ClientCAs:      cs.clientCAs,
+	}
+	if cs.acme != nil {
+		cfg.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+		if cfg.ClientAuth == tls.RequireAnyClientCert || cfg.ClientAuth == tls.RequireAndVerifyClientCert {
+			// ACME validation connections present no certificate
+			challenge := cfg.Clone()
+			challenge.ClientAuth = tls.NoClientCert
+			cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				if isACMEChallenge(hello) {
+					return challenge, nil
+				}
+				return nil, nil
+			}
+		}
+	}
+	return cfg
+}
+
+// challengeHandler answers HTTP-01 challenges on a listener at addr, in
+// place of the dedicated challenge listener, passing other requests to next
+func (cs *certificateStore) challengeHandler(addr string, next http.Handler) http.Handler {
+	if cs.acmeHTTP == nil || cs.acmeHTTP.Addr != addr {
+		return next
+	}
+	cs.acmeHTTP = nil
+	return cs.acme.HTTPHandler(next)
+}
+
+// Start serves HTTP-01 challenges when ACME is configured, or starts
+// obtaining certificates with DNS-01 challenges
+func (cs *certificateStore) Start(sockets *socketRegistry) error {
+	if cs.acmeDNS != nil {
+		cs.acmeDNS.Start()
+	}
+	if cs.acmeHTTP == nil {
+		return nil
+	}
+	ln, err := sockets.listen(cs.acmeHTTP.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for ACME challenges: %w", err)
+	}
+	go func() {
+		cs.logger.Info("Serving ACME HTTP-01 challenges", "address", cs.acmeHTTP.Addr)
+		if err := cs.acmeHTTP.Serve(ln); err != nil && err != http.ErrServerClosed {
+			cs.logger.Error("ACME challenge listener failed", "error", err)
+		}
+	}()
+	return nil
+}
+
+func (cs *certificateStore) Shutdown(ctx context.Context) error {
+	if cs.acmeDNS != nil {
+		cs.acmeDNS.Stop()
+	}
+	if cs.acmeHTTP == nil {
+		return nil
+	}
+	return cs.acmeHTTP.Shutdown(ctx)
+}
+
+// newUpstreamTLSConfig builds the client TLS settings for one backend
+func newUpstreamTLSConfig(cfg *config.BackendTLSConfig) (*tls.Config, error) {
+	tc := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	return tc, nil
+}
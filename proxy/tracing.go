@@ -0,0 +1,398 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// B3 propagation headers, in their multi-header and single-header forms
+const (
+	b3TraceID      = "X-B3-TraceId"
+	b3SpanID       = "X-B3-SpanId"
+	b3ParentSpanID = "X-B3-ParentSpanId"
+	b3Sampled      = "X-B3-Sampled"
+	b3Flags        = "X-B3-Flags"
+	b3Single       = "B3"
+)
+
+// maxQueuedSpans bounds the spans waiting to be reported, in batches, so an
+// unreachable collector can't hold on to unbounded memory
+const maxQueuedSpans = 10
+
+// tracer joins requests to the B3 trace their client sent, or starts one,
+// and passes the trace on to backends with the proxy's span as parent.
+// Routes can switch it on or off regardless of the global setting.
+type tracer struct {
+	config   config.TracingConfig
+	enabled  bool
+	reporter *zipkinReporter
+}
+
+// span is the proxy's part of a trace: one request from the moment its
+// route is known until the response is written
+type span struct {
+	traceID  string
+	id       string
+	parentID string
+	sampled  bool
+	debug    bool
+	start    time.Time
+	backend  atomic.Pointer[Backend]
+}
+
+type spanKey struct{}
+
+func newTracer(cfg config.TracingConfig, logger *slog.Logger) *tracer {
+	t := &tracer{config: cfg, enabled: cfg.Enabled}
+	if cfg.ZipkinURL != "" {
+		t.reporter = newZipkinReporter(cfg, logger)
+	}
+	return t
+}
+
+// start begins the request's span when tracing is enabled for the route;
+// override is the route's setting, nil meaning the global one. The returned
+// function ends the span and must be called once the response is written.
+func (t *tracer) start(w http.ResponseWriter, r *http.Request, override *bool) (http.ResponseWriter, *http.Request, func()) {
+	enabled := t.enabled
+	if override != nil {
+		enabled = *override
+	}
+	if !enabled {
+		return w, r, func() {}
+	}
+
+	s := t.join(r.Header)
+	s.start = time.Now()
+	t.propagate(r.Header, s)
+
+	rw := newResponseWriter(w)
+	r = r.WithContext(context.WithValue(r.Context(), spanKey{}, s))
+	return rw, r, func() {
+		if s.sampled && t.reporter != nil {
+			t.reporter.add(t.zipkinSpan(s, r, rw.status))
+		}
+	}
+}
+
+// join returns a new span in the trace of the request's B3 headers. Without
+// a valid trace a new one is started, sampled at the configured rate unless
+// the client made the decision.
+func (t *tracer) join(h http.Header) *span {
+	s := &span{id: newTraceID(8)}
+	decided := false
+	if v := h.Get(b3Single); v != "" {
+		parts := strings.Split(v, "-")
+		if len(parts) == 1 {
+			s.sampled, s.debug, decided = parseB3Sampled(parts[0])
+		} else if validTraceID(parts[0]) && validSpanID(parts[1]) {
+			s.traceID, s.parentID = parts[0], parts[1]
+			if len(parts) > 2 {
+				s.sampled, s.debug, decided = parseB3Sampled(parts[2])
+			}
+		}
+	} else {
+		if id := h.Get(b3TraceID); validTraceID(id) && validSpanID(h.Get(b3SpanID)) {
+			s.traceID, s.parentID = id, h.Get(b3SpanID)
+		}
+		if h.Get(b3Flags) == "1" {
+			s.sampled, s.debug, decided = true, true, true
+		} else if v := h.Get(b3Sampled); v != "" {
+			s.sampled, _, decided = parseB3Sampled(v)
+		}
+	}
+
+	if s.traceID == "" {
+		s.traceID = newTraceID(16)
+		s.parentID = ""
+	}
+	if !decided {
+		s.sampled = mathrand.Float64() < t.config.SampleRate
+	}
+	return s
+}
+
+// propagate replaces the request's B3 headers with ones naming the proxy's
+// span as the parent of the backend's
+func (t *tracer) propagate(h http.Header, s *span) {
+	for _, k := range []string{b3TraceID, b3SpanID, b3ParentSpanID, b3Sampled, b3Flags, b3Single} {
+		h.Del(k)
+	}
+
+	sampled := "0"
+	if s.sampled {
+		sampled = "1"
+	}
+	if t.config.SingleHeader {
+		if s.debug {
+			sampled = "d"
+		}
+		v := s.traceID + "-" + s.id + "-" + sampled
+		if s.parentID != "" {
+			v += "-" + s.parentID
+		}
+		h.Set(b3Single, v)
+		return
+	}
+
+	h.Set(b3TraceID, s.traceID)
+	h.Set(b3SpanID, s.id)
+	if s.parentID != "" {
+		h.Set(b3ParentSpanID, s.parentID)
+	}
+	if s.debug {
+		h.Set(b3Flags, "1")
+	} else {
+		h.Set(b3Sampled, sampled)
+	}
+}
+
+// parseB3Sampled reads a sampling decision: 1 or d (debug) to sample, 0 not
+// to. Old clients send true and false.
+func parseB3Sampled(v string) (sampled, debug, ok bool) {
+	switch strings.ToLower(v) {
+	case "1", "true":
+		return true, false, true
+	case "d":
+		return true, true, true
+	case "0", "false":
+		return false, false, true
+	}
+	return false, false, false
+}
+
+// validTraceID reports whether id is a 64 or 128-bit hex trace ID
+func validTraceID(id string) bool {
+	return (len(id) == 16 || len(id) == 32) && validHexID(id)
+}
+
+func validSpanID(id string) bool {
+	return len(id) == 16 && validHexID(id)
+}
+
+// validHexID reports whether id is lowercase hex and not all zeros
+func validHexID(id string) bool {
+	zero := true
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+		if c != '0' {
+			zero = false
+		}
+	}
+	return !zero
+}
+
+// newTraceID returns n random bytes as hex
+func newTraceID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	// An all-zero ID is invalid
+	b[n-1] |= 1
+	return hex.EncodeToString(b)
+}
+
+// spanFromContext returns the request's span, or nil when it isn't traced
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// setBackend records the backend the request was last sent to
+func (s *span) setBackend(b *Backend) {
+	if s != nil {
+		s.backend.Store(b)
+	}
+}
+
+// zipkinSpan is a span in the Zipkin v2 JSON format
+type zipkinSpan struct {
+	TraceID       string            `json:"traceId"`
+	ID            string            `json:"id"`
+	ParentID      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name"`
+	Kind          string            `json:"kind"`
+	Timestamp     int64             `json:"timestamp"` // microseconds since the epoch
+	Duration      int64             `json:"duration"`  // microseconds
+	Debug         bool              `json:"debug,omitempty"`
+	LocalEndpoint zipkinEndpoint    `json:"localEndpoint"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+func (t *tracer) zipkinSpan(s *span, r *http.Request, status int) zipkinSpan {
+	name := strings.ToLower(r.Method)
+	if pattern, ok := r.Context().Value(routeKey{}).(string); ok {
+		name += " " + pattern
+	}
+	tags := map[string]string{
+		"http.method":      r.Method,
+		"http.path":        r.URL.Path,
+		"http.status_code": strconv.Itoa(status),
+	}
+	if b := s.backend.Load(); b != nil {
+		tags["backend"] = b.URL.String()
+	}
+	if status >= 500 {
+		tags["error"] = strconv.Itoa(status)
+	}
+	duration := time.Since(s.start).Microseconds()
+	if duration < 1 {
+		duration = 1
+	}
+	return zipkinSpan{
+		TraceID:       s.traceID,
+		ID:            s.id,
+		ParentID:      s.parentID,
+		Name:          name,
+		Kind:          "SERVER",
+		Timestamp:     s.start.UnixMicro(),
+		Duration:      duration,
+		Debug:         s.debug,
+		LocalEndpoint: zipkinEndpoint{ServiceName: t.config.ServiceName},
+		Tags:          tags,
+	}
+}
+
+func (t *tracer) Start() {
+	if t.reporter != nil {
+		t.reporter.Start()
+	}
+}
+
+func (t *tracer) Stop() {
+	if t.reporter != nil {
+		t.reporter.Stop()
+	}
+}
+
+// zipkinReporter posts spans to a Zipkin collector in batches, when a batch
+// fills and on every flush interval
+type zipkinReporter struct {
+	config config.TracingConfig
+	client *http.Client
+	logger *slog.Logger
+	full   chan struct{} // signals that a batch is ready
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu      sync.Mutex
+	spans   []zipkinSpan
+	dropped int
+}
+
+func newZipkinReporter(cfg config.TracingConfig, logger *slog.Logger) *zipkinReporter {
+	return &zipkinReporter{
+		config: cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		logger: logger,
+		full:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// add queues a span, dropping it when the collector has fallen too far behind
+func (zr *zipkinReporter) add(s zipkinSpan) {
+	zr.mu.Lock()
+	defer zr.mu.Unlock()
+	if len(zr.spans) >= maxQueuedSpans*zr.config.BatchSize {
+		zr.dropped++
+		return
+	}
+	zr.spans = append(zr.spans, s)
+	if len(zr.spans) >= zr.config.BatchSize {
+		select {
+		case zr.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (zr *zipkinReporter) Start() {
+	go func() {
+		defer close(zr.done)
+		ticker := time.NewTicker(zr.config.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-zr.full:
+			case <-zr.stop:
+				zr.flush()
+				return
+			}
+			zr.flush()
+		}
+	}()
+}
+
+// Stop reports the spans still queued
+func (zr *zipkinReporter) Stop() {
+	close(zr.stop)
+	<-zr.done
+}
+
+// flush reports the queued spans one batch at a time
+func (zr *zipkinReporter) flush() {
+	for {
+		zr.mu.Lock()
+		n := min(len(zr.spans), zr.config.BatchSize)
+		batch := zr.spans[:n:n]
+		zr.spans = zr.spans[n:]
+		dropped := zr.dropped
+		zr.dropped = 0
+		zr.mu.Unlock()
+
+		if dropped > 0 {
+			zr.logger.Warn("Dropped spans while the Zipkin collector was behind", "spans", dropped)
+		}
+		if n == 0 {
+			return
+		}
+		if err := zr.post(batch); err != nil {
+			zr.logger.Warn("Failed to report spans to Zipkin", "spans", n, "error", err)
+			return
+		}
+	}
+}
+
+func (zr *zipkinReporter) post(spans []zipkinSpan) error {
+	body, err := json.Marshal(spans)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, zr.config.ZipkinURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := zr.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("zipkin returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+	"golang.org/x/net/http2"
+)
+
+// transportBuilder holds the process-wide settings that shape every
+// connection made to a backend
+type transportBuilder struct {
+	policy   *egressPolicy
+	resolver *upstreamResolver
+	limits   config.LimitsConfig
+	config   config.TransportConfig
+	override http.RoundTripper // used for every backend when set
+	logger   *slog.Logger
+}
+
+func newTransportBuilder(cfg *config.Config, override http.RoundTripper, logger *slog.Logger) (*transportBuilder, error) {
+	policy, err := newEgressPolicy(cfg.Egress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transportBuilder{
+		policy:   policy,
+		resolver: newUpstreamResolver(cfg.DNS),
+		limits:   cfg.Limits,
+		config:   cfg.Transport,
+		override: override,
+		logger:   logger,
+	}, nil
+}
+
+// build creates the HTTP transport used to reach a single backend.
+// Every connection it opens is subject to the egress policy.
+func (tb *transportBuilder) build(b config.Backend) (http.RoundTripper, error) {
+	dial, err := tb.dialer(b)
+	if err != nil {
+		return nil, err
+	}
+	return tb.transport(b, dial)
+}
+
+// transport creates the HTTP transport of a backend that opens its
+// connections with dial
+func (tb *transportBuilder) transport(b config.Backend, dial dialFunc) (http.RoundTripper, error) {
+	if tb.override != nil {
+		return tb.override, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// The transport only ever reaches this backend, so the per-host and
+	// overall idle pools are the same
+	transport.MaxIdleConns = tb.limits.MaxIdleConns
+	transport.MaxIdleConnsPerHost = tb.limits.MaxIdleConns
+	transport.MaxConnsPerHost = tb.limits.MaxConnsPerHost
+	transport.IdleConnTimeout = tb.config.IdleConnTimeout
+	transport.TLSHandshakeTimeout = tb.config.TLSHandshakeTimeout
+
+	if b.TLS != nil {
+		tlsConfig, err := newUpstreamTLSConfig(b.TLS)
+		if err != nil {
+			return nil, err
+		}
+		if b.TLS.InsecureSkipVerify {
+			tb.logger.Warn("TLS certificate verification is disabled", "backend", b.URL)
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if b.EgressProxy != nil {
+		// Tunnelled connections must not also go through the environment proxy
+		transport.Proxy = nil
+	}
+	transport.DialContext = dial
+
+	switch b.Protocol {
+	case config.ProtocolHTTP1:
+		// A non-nil empty map stops the transport from negotiating h2
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	case config.ProtocolH2:
+		return tb.newHTTP2Transport(dial, transport.TLSClientConfig, false), nil
+	case config.ProtocolH2C:
+		return tb.newHTTP2Transport(dial, nil, true), nil
+	}
+	return transport, nil
+}
+
+// dialer returns the function that opens connections to a backend, through
+// its egress proxy if it has one, subject to the egress policy
+func (tb *transportBuilder) dialer(b config.Backend) (dialFunc, error) {
+	dialer := &net.Dialer{
+		Timeout:   tb.config.DialTimeout,
+		KeepAlive: tb.config.KeepAlive,
+	}
+
+	if b.EgressProxy != nil {
+		// The configured egress proxy itself is trusted; the policy applies
+		// to the destinations requested through it
+		egress, err := newEgressDialer(b.EgressProxy, dialer.DialContext)
+		if err != nil {
+			return nil, err
+		}
+		egress.policy = tb.policy
+		egress.resolver = tb.resolver
+		return tb.policy.wrap(egress.DialContext), nil
+	}
+
+	dialer.ControlContext = tb.policy.control
+	dial := dialFunc(dialer.DialContext)
+	if tb.resolver != nil || b.Dial != nil {
+		// Resolve names here so the configured resolver and address
+		// family preferences decide which addresses get dialled
+		lookup := systemLookup
+		if tb.resolver != nil {
+			lookup = tb.resolver.LookupIP
+		}
+		dial = newAddrDialer(dial, lookup, b.Dial).DialContext
+	}
+	return tb.policy.wrap(dial), nil
+}
+
+// newHTTP2Transport speaks HTTP/2 without falling back to HTTP/1.1, over TLS
+// or, for h2c, in cleartext. Requests to one backend are multiplexed over a
+// single connection, which is probed with pings while idle.
+func (tb *transportBuilder) newHTTP2Transport(dial dialFunc, tlsConfig *tls.Config, cleartext bool) *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP:       cleartext,
+		TLSClientConfig: tlsConfig,
+		ReadIdleTimeout: 30 * time.Second,
+		PingTimeout:     15 * time.Second,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil || cleartext {
+				return conn, err
+			}
+			tlsConn := tls.Client(conn, cfg)
+			hctx, cancel := context.WithTimeout(ctx, tb.config.TLSHandshakeTimeout)
+			defer cancel()
+			if err := tlsConn.HandshakeContext(hctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		},
+	}
+}
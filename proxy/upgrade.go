@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variables through which a process hands its listening
+// sockets and a readiness pipe to its replacement
+const (
+	envListenFDs = "REVERSE_PROXY_LISTEN_FDS" // comma-separated network:address keys, one per fd from 3
+	envReadyFD   = "REVERSE_PROXY_READY_FD"
+)
+
+// upgradeTimeout bounds how long a new process may take to become ready
+const upgradeTimeout = time.Minute
+
+// socketRegistry tracks the listening sockets of a proxy so a new binary
+// can take them over without refusing connections in between
+type socketRegistry struct {
+	once      sync.Once // adopts the sockets passed in on first use
+	mu        sync.Mutex
+	inherited map[string]*os.File // sockets passed in by the previous process
+	ready     *os.File            // closed to tell the previous process to drain
+	active    map[string]fileSocket
+	upgrading bool
+}
+
+// fileSocket is implemented by the TCP listeners and UDP connections that
+// can be duplicated for another process
+type fileSocket interface {
+	File() (*os.File, error)
+}
+
+func newSocketRegistry() *socketRegistry {
+	return &socketRegistry{
+		inherited: make(map[string]*os.File),
+		active:    make(map[string]fileSocket),
+	}
+}
+
+// inherit adopts the sockets and readiness pipe the previous process passed
+// in, if any. Only the first proxy of a process to listen gets them.
+func (s *socketRegistry) inherit() {
+	s.once.Do(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if keys := os.Getenv(envListenFDs); keys != "" {
+			for i, key := range strings.Split(keys, ",") {
+				s.inherited[key] = os.NewFile(uintptr(3+i), key)
+			}
+		}
+		if fd, err := strconv.Atoi(os.Getenv(envReadyFD)); err == nil {
+			s.ready = os.NewFile(uintptr(fd), "ready")
+		}
+		os.Unsetenv(envListenFDs)
+		os.Unsetenv(envReadyFD)
+	})
+}
+
+// listen opens a TCP listener on addr, reusing the socket inherited from
+// the previous process if there is one
+func (s *socketRegistry) listen(addr string) (net.Listener, error) {
+	s.inherit()
+	key := "tcp:" + addr
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ln net.Listener
+	var err error
+	if f, ok := s.inherited[key]; ok {
+		delete(s.inherited, key)
+		ln, err = net.FileListener(f)
+		f.Close()
+	} else {
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fs, ok := ln.(fileSocket); ok {
+		s.active[key] = fs
+		return &registeredListener{Listener: ln, release: s.releaser(key, fs)}, nil
+	}
+	return ln, nil
+}
+
+// listenPacket opens a UDP socket on addr, reusing the socket inherited
+// from the previous process if there is one
+func (s *socketRegistry) listenPacket(addr string) (net.PacketConn, error) {
+	s.inherit()
+	key := "udp:" + addr
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var conn net.PacketConn
+	var err error
+	if f, ok := s.inherited[key]; ok {
+		delete(s.inherited, key)
+		conn, err = net.FilePacketConn(f)
+		f.Close()
+	} else {
+		conn, err = net.ListenPacket("udp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if udp, ok := conn.(*net.UDPConn); ok {
+		s.active[key] = udp
+		return &registeredPacketConn{UDPConn: udp, release: s.releaser(key, udp)}, nil
+	}
+	return conn, nil
+}
+
+// releaser returns the function that removes the socket at key once it is
+// closed, so that an upgrade doesn't try to hand it over
+func (s *socketRegistry) releaser(key string, fs fileSocket) func() {
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// The address may have been opened again since
+		if s.active[key] == fs {
+			delete(s.active, key)
+		}
+	}
+}
+
+// registeredListener is a listener of the registry that leaves it on Close
+type registeredListener struct {
+	net.Listener
+	release func()
+}
+
+func (l *registeredListener) Close() error {
+	l.release()
+	return l.Listener.Close()
+}
+
+// registeredPacketConn is a UDP socket of the registry that leaves it on
+// Close. It embeds the *net.UDPConn so that QUIC still finds the methods
+// for its socket options.
+type registeredPacketConn struct {
+	*net.UDPConn
+	release func()
+}
+
+func (c *registeredPacketConn) Close() error {
+	c.release()
+	return c.UDPConn.Close()
+}
+
+// markReady closes inherited sockets the new configuration didn't use and
+// tells the previous process, if any, that it can drain and exit
+func (s *socketRegistry) markReady(logger *slog.Logger) {
+	s.inherit()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, f := range s.inherited {
+		logger.Info("Closing inherited socket, which is no longer configured", "socket", key)
+		f.Close()
+	}
+	s.inherited = make(map[string]*os.File)
+	if s.ready != nil {
+		s.ready.Write([]byte{1})
+		s.ready.Close()
+		s.ready = nil
+	}
+}
+
+// upgrade starts a new process of the current binary with the same
+// arguments, hands it the listening sockets and waits until it is ready
+func (s *socketRegistry) upgrade(logger *slog.Logger) error {
+	s.mu.Lock()
+	if s.upgrading {
+		s.mu.Unlock()
+		return errors.New("an upgrade is already in progress")
+	}
+	s.upgrading = true
+	keys := make([]string, 0, len(s.active))
+	for key := range s.active {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	files := make([]*os.File, 0, len(keys)+1)
+	var err error
+	for _, key := range keys {
+		var f *os.File
+		if f, err = s.active[key].File(); err != nil {
+			err = fmt.Errorf("failed to duplicate socket %s: %w", key, err)
+			break
+		}
+		files = append(files, f)
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+		s.mu.Lock()
+		s.upgrading = false
+		s.mu.Unlock()
+	}()
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		envListenFDs+"="+strings.Join(keys, ","),
+		envReadyFD+"="+strconv.Itoa(3+len(keys)),
+	)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start new process: %w", err)
+	}
+	// Only the child may hold the write end, so a crash reads as EOF
+	readyW.Close()
+	files = files[:len(files)-1]
+
+	ready := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		if _, err := io.ReadFull(readyR, b[:]); err != nil {
+			ready <- errors.New("new process exited before becoming ready")
+			return
+		}
+		ready <- nil
+	}()
+
+	timer := time.NewTimer(upgradeTimeout)
+	defer timer.Stop()
+	select {
+	case err = <-ready:
+	case <-timer.C:
+		err = fmt.Errorf("new process not ready after %s", upgradeTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+
+	logger.Info("New process has taken over the listening sockets", "pid", cmd.Process.Pid)
+	go cmd.Wait()
+	return nil
+}
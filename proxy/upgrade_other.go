@@ -0,0 +1,8 @@
+//go:build !unix
+
+package proxy
+
+import "os"
+
+// UpgradeSignal is nil where socket handover is not supported
+var UpgradeSignal os.Signal
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"os"
+	"testing"
+)
+
+func TestNewLeavesInheritedSocketsAlone(t *testing.T) {
+	t.Setenv(envListenFDs, "tcp:127.0.0.1:1")
+	newTestProxy(t, "server:\n  address: \":0\"\nbackends:\n  - url: \"http://127.0.0.1:1\"\n")
+
+	if got := os.Getenv(envListenFDs); got != "tcp:127.0.0.1:1" {
+		t.Errorf("%s = %q after New, want it untouched", envListenFDs, got)
+	}
+}
+
+func TestClosedSocketsLeaveTheRegistry(t *testing.T) {
+	s := newSocketRegistry()
+	ln, err := s.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := s.listenPacket("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.active) != 2 {
+		t.Fatalf("%d active sockets, want 2", len(s.active))
+	}
+
+	ln.Close()
+	conn.Close()
+	if len(s.active) != 0 {
+		t.Errorf("closed sockets still registered: %v", s.active)
+	}
+}
+
+func TestClosingAReplacedSocketKeepsItsSuccessor(t *testing.T) {
+	s := newSocketRegistry()
+	old, err := s.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := old.Addr().String()
+	old.Close()
+
+	// Reopened by a reload, which closes the old listener only afterwards
+	ln, err := s.listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	old.Close()
+	if _, ok := s.active["tcp:"+addr]; !ok {
+		t.Error("closing the old listener again unregistered the new one")
+	}
+}
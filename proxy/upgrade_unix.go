@@ -0,0 +1,11 @@
+//go:build unix
+
+package proxy
+
+import (
+	"os"
+	"syscall"
+)
+
+// UpgradeSignal asks the proxy to hand its sockets to a new binary
+var UpgradeSignal os.Signal = syscall.SIGUSR2
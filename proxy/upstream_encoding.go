@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+type decompressKey struct{}
+
+// withUpstreamEncoding prepares r for the route's upstream_encoding mode.
+// Identity replaces the client's Accept-Encoding; decompress marks requests
+// from clients that don't accept gzip so their responses are gunzipped.
+func withUpstreamEncoding(r *http.Request, mode string) *http.Request {
+	switch mode {
+	case config.UpstreamEncodingIdentity:
+		r.Header.Set("Accept-Encoding", "identity")
+	case config.UpstreamEncodingDecompress:
+		if !acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip") {
+			// A range of a gzip body can't be gunzipped, so the whole
+			// body is asked for
+			r.Header.Del("Range")
+			r.Header.Del("If-Range")
+			return r.WithContext(context.WithValue(r.Context(), decompressKey{}, true))
+		}
+	}
+	return r
+}
+
+// decompressResponse gunzips the body of a gzip response to a request
+// marked by withUpstreamEncoding
+func decompressResponse(resp *http.Response) error {
+	if marked, _ := resp.Request.Context().Value(decompressKey{}).(bool); !marked {
+		return nil
+	}
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+	if resp.Request.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{zr, resp.Body}
+	resp.ContentLength = -1
+	h := resp.Header
+	h.Del("Content-Encoding")
+	h.Del("Content-Length")
+	// The decoded bytes differ from the backend's, so a strong validator
+	// no longer holds
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	if !varies(h, "Accept-Encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	return nil
+}
+
+// varies reports whether the Vary header lists name
+func varies(h http.Header, name string) bool {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" || strings.EqualFold(f, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
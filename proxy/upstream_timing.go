@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// upstreamTiming records when the phases of one request to a backend
+// completed. The transport may report them from its own goroutines.
+type upstreamTiming struct {
+	start time.Time
+
+	mu        sync.Mutex
+	getConn   time.Time
+	connected time.Time // zero when an idle connection was reused
+	firstByte time.Time
+}
+
+type upstreamTimingKey struct{}
+
+// traceUpstream returns r with a trace that fills in the returned timing
+func traceUpstream(r *http.Request, start time.Time) (*http.Request, *upstreamTiming) {
+	t := &upstreamTiming{start: start}
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			t.mu.Lock()
+			t.getConn = time.Now()
+			t.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				return
+			}
+			t.mu.Lock()
+			t.connected = time.Now()
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.firstByte = time.Now()
+			t.mu.Unlock()
+		},
+	}
+	ctx := context.WithValue(httptrace.WithClientTrace(r.Context(), trace), upstreamTimingKey{}, t)
+	return r.WithContext(ctx), t
+}
+
+func timingFromContext(ctx context.Context) *upstreamTiming {
+	t, _ := ctx.Value(upstreamTimingKey{}).(*upstreamTiming)
+	return t
+}
+
+// durations returns the time taken to open a new connection and the time
+// to the first response byte; each is zero if it didn't happen
+func (t *upstreamTiming) durations() (connect, ttfb time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.connected.IsZero() && !t.getConn.IsZero() {
+		connect = t.connected.Sub(t.getConn)
+	}
+	if !t.firstByte.IsZero() {
+		ttfb = t.firstByte.Sub(t.start)
+	}
+	return connect, ttfb
+}
+
+// header formats the timing for the X-Upstream-Duration header
+func (t *upstreamTiming) header() string {
+	connect, ttfb := t.durations()
+	return "connect=" + formatMillis(connect) + ", ttfb=" + formatMillis(ttfb)
+}
+
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64) + "ms"
+}
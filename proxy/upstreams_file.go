@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// upstreamsReloadDelay lets a burst of writes to an upstreams file settle
+// before it is read, so a half-written file isn't applied
+const upstreamsReloadDelay = 250 * time.Millisecond
+
+// readUpstreams parses an upstreams file: one backend URL per line, with
+// blank lines and lines starting with # ignored. The backends are checked
+// with the pool's health check.
+func readUpstreams(path string, healthCheck *config.BackendHealthCheckConfig) (map[string]config.Backend, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	wanted := make(map[string]config.Backend)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		u, err := url.Parse(line)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%s:%d: invalid backend URL %q", path, n, line)
+		}
+		wanted[u.String()] = config.Backend{URL: u.String(), HealthCheck: healthCheck}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return wanted, nil
+}
+
+// watchFile adds the backends listed in the pool's upstreams file to pool
+// and reloads them whenever the file changes
+func (d *backendDiscovery) watchFile(pool *backendPool, cfg config.PoolConfig, newBackend backendFactory) error {
+	wanted, err := readUpstreams(cfg.UpstreamsFile, cfg.HealthCheck)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	var dp *discoveredPool
+	for _, p := range d.pools {
+		if p.pool == pool {
+			dp = p
+			break
+		}
+	}
+	if dp == nil {
+		dp = &discoveredPool{pool: pool, static: pool.backends(), newBackend: newBackend}
+		d.pools = append(d.pools, dp)
+	}
+	src := &resolvedBackend{
+		config:  config.Backend{HealthCheck: cfg.HealthCheck},
+		file:    cfg.UpstreamsFile,
+		targets: make(map[string]*Backend),
+	}
+	dp.mu.Lock()
+	dp.sources = append(dp.sources, src)
+	dp.mu.Unlock()
+	d.mu.Unlock()
+
+	d.update(dp, src, wanted)
+	return nil
+}
+
+// reloadFile applies the current contents of an upstreams file, keeping
+// the previous backends when the file can't be read or parsed
+func (d *backendDiscovery) reloadFile(dp *discoveredPool, src *resolvedBackend) {
+	wanted, err := readUpstreams(src.file, src.config.HealthCheck)
+	if err != nil {
+		d.rp.logger.Error("Failed to reload upstreams file", "file", src.file, "error", err)
+		return
+	}
+	d.update(dp, src, wanted)
+}
+
+// watchFiles reloads upstreams files on change. Their directories are
+// watched rather than the files so that files replaced by a rename, as
+// editors and most tooling do, keep being followed.
+func (d *backendDiscovery) watchFiles() {
+	type fileSource struct {
+		dp  *discoveredPool
+		src *resolvedBackend
+	}
+	files := make(map[string][]fileSource)
+	d.mu.Lock()
+	for _, dp := range d.pools {
+		for _, src := range dp.sources {
+			if src.file != "" {
+				path := filepath.Clean(src.file)
+				files[path] = append(files[path], fileSource{dp, src})
+			}
+		}
+	}
+	d.mu.Unlock()
+	if len(files) == 0 {
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		d.rp.logger.Error("Failed to watch upstreams files", "error", err)
+		return
+	}
+	for path := range files {
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			d.rp.logger.Error("Failed to watch upstreams file", "file", path, "error", err)
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+		pending := make(map[string]bool)
+		timer := time.NewTimer(upstreamsReloadDelay)
+		timer.Stop()
+		for {
+			select {
+			case event := <-watcher.Events:
+				path := filepath.Clean(event.Name)
+				if _, ok := files[path]; !ok || event.Op == fsnotify.Chmod {
+					continue
+				}
+				pending[path] = true
+				timer.Reset(upstreamsReloadDelay)
+			case err := <-watcher.Errors:
+				d.rp.logger.Warn("Upstreams file watch error", "error", err)
+			case <-timer.C:
+				for path := range pending {
+					for _, fs := range files[path] {
+						d.reloadFile(fs.dp, fs.src)
+					}
+				}
+				pending = make(map[string]bool)
+			case <-d.ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/cluster"
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// maxUserAgentBuckets bounds the clients tracked per rate_limit rule
+const maxUserAgentBuckets = 10000
+
+// knownBots matches the User-Agent of well-known crawlers, scrapers and HTTP
+// libraries, as well as a missing one
+var knownBots = regexp.MustCompile(`(?i)^$|bot\b|bot/|crawler|spider|slurp|` +
+	`facebookexternalhit|ia_archiver|bytespider|anthropic-ai|` +
+	`curl/|wget/|python-requests|python-urllib|aiohttp|go-http-client|java/|okhttp|` +
+	`scrapy|libwww-perl|httpclient|headlesschrome|phantomjs`)
+
+// userAgentFilter applies the first user agent rule matching each request
+type userAgentFilter struct {
+	rules     []*userAgentRule
+	tagHeader string
+}
+
+type userAgentRule struct {
+	patterns  []*regexp.Regexp
+	knownBots bool
+	action    string
+	tag       string
+
+	// rate_limit: a token bucket of rate requests per minute per client,
+	// or in cluster mode a limit per minute counted by the whole cluster
+	rate    float64
+	shared  *clusterLimit
+	key     string // prefix of the shared counters
+	mu      sync.Mutex
+	buckets map[netip.Addr]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newUserAgentFilter returns nil when there are no user agent rules. With a
+// cluster node, rate limits hold for the whole cluster.
+func newUserAgentFilter(cfg config.UserAgentConfig, node *cluster.Node) *userAgentFilter {
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+
+	uf := &userAgentFilter{tagHeader: cfg.TagHeader}
+	for i, c := range cfg.Rules {
+		rule := &userAgentRule{
+			knownBots: c.KnownBots,
+			action:    c.Action,
+			tag:       c.Tag,
+			rate:      float64(c.Rate),
+			buckets:   make(map[netip.Addr]*tokenBucket),
+		}
+		if node != nil && c.Action == "rate_limit" {
+			rule.shared = &clusterLimit{node: node, limit: int64(c.Rate), window: time.Minute}
+			rule.key = "user_agents/" + strconv.Itoa(i) + "/"
+		}
+		for _, p := range c.Patterns {
+			rule.patterns = append(rule.patterns, regexp.MustCompile("(?i)"+p))
+		}
+		uf.rules = append(uf.rules, rule)
+	}
+	return uf
+}
+
+func (rule *userAgentRule) matches(ua string) bool {
+	if rule.knownBots && knownBots.MatchString(ua) {
+		return true
+	}
+	for _, re := range rule.patterns {
+		if re.MatchString(ua) {
+			return true
+		}
+	}
+	return false
+}
+
+// allow takes a token from the client's bucket; when none is left it
+// returns false and how long until the next one
+func (rule *userAgentRule) allow(client netip.Addr, now time.Time) (bool, time.Duration) {
+	if rule.shared != nil {
+		return rule.shared.allow(rule.key+client.String(), now)
+	}
+
+	rule.mu.Lock()
+	defer rule.mu.Unlock()
+
+	perSecond := rule.rate / 60
+	b, ok := rule.buckets[client]
+	if !ok {
+		if len(rule.buckets) >= maxUserAgentBuckets {
+			rule.prune(now)
+		}
+		b = &tokenBucket{tokens: rule.rate, last: now}
+		rule.buckets[client] = b
+	}
+	b.tokens = math.Min(rule.rate, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune forgets clients whose buckets have refilled, and all of them if
+// that doesn't make room; rule.mu must be held
+func (rule *userAgentRule) prune(now time.Time) {
+	for client, b := range rule.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rule.rate/60 >= rule.rate {
+			delete(rule.buckets, client)
+		}
+	}
+	if len(rule.buckets) >= maxUserAgentBuckets {
+		rule.buckets = make(map[netip.Addr]*tokenBucket)
+	}
+}
+
+func (uf *userAgentFilter) middleware(next http.Handler) http.Handler {
+	if uf == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Tags only ever come from the proxy
+		r.Header.Del(uf.tagHeader)
+
+		ua := r.Header.Get("User-Agent")
+		for _, rule := range uf.rules {
+			if !rule.matches(ua) {
+				continue
+			}
+			switch rule.action {
+			case "block":
+				logRequest(r, slog.LevelDebug, "Blocked user agent", "user_agent", ua)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			case "rate_limit":
+				if ok, wait := rule.allow(clientAddr(r), time.Now()); !ok {
+					logRequest(r, slog.LevelDebug, "Rate limited user agent", "user_agent", ua)
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+					http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+					return
+				}
+			case "tag":
+				r.Header.Set(uf.tagHeader, rule.tag)
+			}
+			break
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/bunnydevv/reverse-proxy/cluster"
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+func TestUserAgentRateLimit(t *testing.T) {
+	node := cluster.New(config.ClusterConfig{Enabled: true, NodeName: "a"}, slog.Default())
+	cfg := config.UserAgentConfig{Rules: []config.UserAgentRule{{Patterns: []string{"curl"}, Action: "rate_limit", Rate: 2}}}
+	for _, tc := range []struct {
+		name string
+		node *cluster.Node
+	}{
+		{"local", nil},
+		{"cluster", node},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := newUserAgentFilter(cfg, tc.node).rules[0]
+			client := netip.MustParseAddr("192.0.2.1")
+			now := time.Now()
+			for i := 0; i < 2; i++ {
+				if ok, _ := rule.allow(client, now); !ok {
+					t.Fatalf("request %d refused under the rate", i+1)
+				}
+			}
+			ok, wait := rule.allow(client, now)
+			if ok {
+				t.Fatal("request over the rate allowed")
+			}
+			if wait <= 0 || wait > time.Minute {
+				t.Errorf("wait = %s, want within a minute", wait)
+			}
+			if ok, _ := rule.allow(netip.MustParseAddr("192.0.2.2"), now); !ok {
+				t.Error("another client shares the limit")
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bunnydevv/reverse-proxy/config"
+)
+
+// hostTable maps host names to values. Exact names take precedence over
+// wildcards, and longer wildcard suffixes over shorter ones.
+type hostTable[T any] struct {
+	exact    map[string]T
+	wildcard map[string]T // keyed by suffix including the leading dot
+}
+
+func newHostTable[T any]() *hostTable[T] {
+	return &hostTable[T]{
+		exact:    make(map[string]T),
+		wildcard: make(map[string]T),
+	}
+}
+
+// add registers value for pattern, either an exact name or *.domain
+func (t *hostTable[T]) add(pattern string, value T) {
+	pattern = config.NormalizeHost(pattern)
+	if strings.HasPrefix(pattern, "*.") {
+		t.wildcard[pattern[1:]] = value
+		return
+	}
+	t.exact[pattern] = value
+}
+
+func (t *hostTable[T]) lookup(host string) (T, bool) {
+	host = config.NormalizeHost(host)
+	if v, ok := t.exact[host]; ok {
+		return v, true
+	}
+	// Try each parent domain, nearest first
+	suffix := host
+	for {
+		i := strings.IndexByte(suffix, '.')
+		if i < 0 {
+			break
+		}
+		suffix = suffix[i:]
+		if v, ok := t.wildcard[suffix]; ok {
+			return v, true
+		}
+		suffix = suffix[1:]
+	}
+	var zero T
+	return zero, false
+}
+
+// vhostRouter selects the router for a request's Host header
+type vhostRouter struct {
+	hosts      *hostTable[*router]
+	containers *dockerProvider // hosts of labelled containers, after configured ones
+	fallback   *router         // nil when unknown hosts are answered directly
+	unknown    config.UnknownHostConfig
+}
+
+// route returns the router serving host, or nil if the host is unknown
+func (vr *vhostRouter) route(host string) *router {
+	if rt, ok := vr.hosts.lookup(host); ok {
+		return rt
+	}
+	if rt := vr.containers.route(host); rt != nil {
+		return rt
+	}
+	return vr.fallback
+}
+
+// serveUnknownHost answers a request for a host no virtual host serves
+func (vr *vhostRouter) serveUnknownHost(w http.ResponseWriter, r *http.Request) {
+	if vr.unknown.Redirect != "" {
+		target := strings.TrimSuffix(vr.unknown.Redirect, "/") + r.URL.RequestURI()
+		http.Redirect(w, r, target, vr.unknown.Status)
+		return
+	}
+	http.Error(w, http.StatusText(vr.unknown.Status), vr.unknown.Status)
+}
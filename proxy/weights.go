@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"gopkg.in/yaml.v3"
+)
+
+// weights returns the weight of every backend by URL
+func (rp *ReverseProxy) weights() map[string]int {
+	weights := make(map[string]int)
+	for _, b := range rp.backendList() {
+		weights[b.URL.String()] = b.GetWeight()
+	}
+	return weights
+}
+
+// weightsHandler serves GET /weights to inspect and PUT /weights to change
+// backend weights, e.g. {"http://10.0.0.5:8080": 5}. Backends not listed
+// keep their weight. The balancers apply a change from the next request on;
+// a weight of 0 drains the backend of all but its sticky sessions.
+func (rp *ReverseProxy) weightsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, rp.weights())
+
+	case http.MethodPut:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxAdminBodySize))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var weights map[string]int
+		if err := yaml.Unmarshal(body, &weights); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid weights: "+err.Error())
+			return
+		}
+		// A URL may be a member of several pools; all of them change
+		byURL := make(map[string][]*Backend)
+		for _, b := range rp.backendList() {
+			byURL[b.URL.String()] = append(byURL[b.URL.String()], b)
+		}
+		for u, weight := range weights {
+			if len(byURL[u]) == 0 {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("no backend with URL %q", u))
+				return
+			}
+			if weight < 0 {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("weight of %s must be non-negative", u))
+				return
+			}
+		}
+
+		for u, weight := range weights {
+			for _, b := range byURL[u] {
+				b.SetWeight(weight)
+			}
+			requestLogger(r).Info("Backend weight updated via admin API", "backend", u, "weight", weight)
+		}
+		writeJSON(w, http.StatusOK, rp.weights())
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bunnydevv/reverse-proxy/proxy"
+)
+
+// testRequest is one sample request of the test subcommand, with what the
+// proxy is expected to do with it
+type testRequest struct {
+	Method   string            `yaml:"method"` // default GET
+	Scheme   string            `yaml:"scheme"` // http or https, default http
+	Host     string            `yaml:"host"`
+	Path     string            `yaml:"path"`      // may include a query
+	ClientIP string            `yaml:"client_ip"` // default 192.0.2.1
+	Headers  map[string]string `yaml:"headers"`
+	Expect   *testExpectation  `yaml:"expect,omitempty"`
+}
+
+// testExpectation fails the test when the outcome differs in a field that
+// is set
+type testExpectation struct {
+	Status int    `yaml:"status"` // the proxy's own answer, e.g. 403; 0 expects the request to be forwarded
+	Pool   string `yaml:"pool"`
+	Route  string `yaml:"route"`
+	URI    string `yaml:"uri"` // path and query sent to the backend
+}
+
+// runTest implements the test subcommand: it evaluates sample requests
+// against the configuration's routes, rewrites, access rules and
+// authentication without listening or reaching a backend, prints what
+// would happen to each, and fails when an expectation doesn't hold
+func runTest(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	requestsPath := flags.String("requests", "requests.yaml", "Path to the sample requests, as YAML or JSON")
+	cfg, path, ok := commandConfig(flags, args)
+	if !ok {
+		return 1
+	}
+	defer cfg.Close()
+
+	requests, err := loadTestRequests(*requestsPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	rp, err := proxy.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+
+	failed := 0
+	for _, tr := range requests {
+		r, _ := tr.request()
+		// The proxy's handlers change the request in place
+		fmt.Printf("%s %s%s\n", r.Method, r.Host, r.URL.RequestURI())
+		sent := r.Header.Clone()
+		res := rp.DryRun(r)
+		printDryRun(sent, res)
+		problems := tr.Expect.check(res)
+		for _, problem := range problems {
+			fmt.Printf("  FAIL      %s\n", problem)
+		}
+		if len(problems) > 0 {
+			failed++
+		}
+		fmt.Println()
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d requests failed their expectations\n", failed, len(requests))
+		return 1
+	}
+	fmt.Printf("%d requests evaluated\n", len(requests))
+	return 0
+}
+
+// loadTestRequests reads a list of sample requests, as YAML or JSON, and
+// checks that each can be built
+func loadTestRequests(path string) ([]testRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var requests []testRequest
+	if err := yaml.Unmarshal(data, &requests); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, tr := range requests {
+		if _, err := tr.request(); err != nil {
+			return nil, fmt.Errorf("%s: request %d: %w", path, i, err)
+		}
+	}
+	return requests, nil
+}
+
+// request builds the sample as it would reach the proxy
+func (tr testRequest) request() (*http.Request, error) {
+	method := tr.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := tr.Path
+	if path == "" {
+		path = "/"
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path must start with /")
+	}
+	host := tr.Host
+	if host == "" {
+		host = "localhost"
+	}
+	clientIP := tr.ClientIP
+	if clientIP == "" {
+		clientIP = "192.0.2.1"
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid client_ip %q", tr.ClientIP)
+	}
+
+	r, err := http.NewRequest(method, "http://"+host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.RequestURI = path
+	r.RemoteAddr = net.JoinHostPort(ip.String(), "40000")
+	switch tr.Scheme {
+	case "", "http":
+	case "https":
+		r.TLS = &tls.ConnectionState{ServerName: host}
+	default:
+		return nil, fmt.Errorf("scheme must be http or https")
+	}
+	for k, v := range tr.Headers {
+		r.Header.Set(k, v)
+	}
+	return r, nil
+}
+
+// printDryRun describes the outcome of a sample request that was sent with
+// header
+func printDryRun(header http.Header, res proxy.DryRunResult) {
+	if !res.Forwarded {
+		fmt.Printf("  answered  %d %s\n", res.Status, http.StatusText(res.Status))
+		if res.Location != "" {
+			fmt.Printf("  location  %s\n", res.Location)
+		}
+		return
+	}
+
+	route := res.Route
+	if route == "" {
+		route = "(none)"
+	}
+	fmt.Printf("  route     %s\n", route)
+	if res.Static != "" {
+		fmt.Printf("  static    %s%s\n", res.Static, res.URI)
+		return
+	}
+	pool := res.Pool
+	if pool == "" {
+		pool = "(default)"
+	}
+	fmt.Printf("  pool      %s\n", pool)
+	if res.Backend != "" {
+		fmt.Printf("  backend   %s\n", res.Backend)
+	}
+	fmt.Printf("  upstream  %s%s\n", res.Host, res.URI)
+	for _, change := range headerChanges(header, res.Header) {
+		fmt.Printf("  header    %s\n", change)
+	}
+}
+
+// headerChanges lists the headers that differ between the sample request
+// and the request sent on, sorted by name
+func headerChanges(before, after http.Header) []string {
+	var changes []string
+	for name, values := range after {
+		if old, ok := before[name]; !ok {
+			changes = append(changes, "+ "+name+": "+strings.Join(values, ", "))
+		} else if strings.Join(old, ", ") != strings.Join(values, ", ") {
+			changes = append(changes, "~ "+name+": "+strings.Join(values, ", "))
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changes = append(changes, "- "+name)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i][2:] < changes[j][2:] })
+	return changes
+}
+
+// check returns the ways res falls short of the expectation
+func (e *testExpectation) check(res proxy.DryRunResult) []string {
+	if e == nil {
+		return nil
+	}
+	var problems []string
+	if !res.Forwarded {
+		if e.Status == 0 {
+			problems = append(problems, fmt.Sprintf("expected the request to be forwarded, got %d", res.Status))
+		} else if e.Status != res.Status {
+			problems = append(problems, fmt.Sprintf("expected status %d, got %d", e.Status, res.Status))
+		}
+		return problems
+	}
+	if e.Status != 0 {
+		problems = append(problems, fmt.Sprintf("expected status %d, but the request is forwarded", e.Status))
+	}
+	if e.Pool != "" && e.Pool != res.Pool {
+		problems = append(problems, fmt.Sprintf("expected pool %s, got %q", e.Pool, res.Pool))
+	}
+	if e.Route != "" && e.Route != res.Route {
+		problems = append(problems, fmt.Sprintf("expected route %s, got %q", e.Route, res.Route))
+	}
+	if e.URI != "" && e.URI != res.URI {
+		problems = append(problems, fmt.Sprintf("expected upstream %s, got %s", e.URI, res.URI))
+	}
+	return problems
+}